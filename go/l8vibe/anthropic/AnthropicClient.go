@@ -3,102 +3,153 @@ package anthropic
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/tls"
+	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
-	"time"
 
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
 	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
 	"github.com/saichler/vibe.with.layer8/go/types"
 )
 
+// AnthropicClient is the LLMProvider of the Anthropic Messages API
 type AnthropicClient struct {
 	httpClient *http.Client
+	baseURL    string
+	apiKey     string
 }
 
+// NewAnthropicClient returns the client of api.anthropic.com
 func NewAnthropicClient() *AnthropicClient {
-	httpClient := &http.Client{
-		Timeout: time.Second * 600,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-				ServerName:         consts.ANTHROPIC_HOST,
-			},
-		},
-	}
-	os.Mkdir("responses", 0777)
-	return &AnthropicClient{httpClient: httpClient}
+	return NewAnthropicClientFor(consts.ANTHROPIC_BASE_URL)
 }
 
-func (this *AnthropicClient) Do(text string, project *types.Project) error {
-	body := &types.ClaudeRequest{}
-	body.Model = consts.ANTHROPIC_MODEL
-	body.MaxTokens = 64000
-	body.Messages = project.Messages
-	body.Messages = append(body.Messages, &types.Message{Role: "user", Content: text})
-	project.Messages = body.Messages
-	jsonBody, err := json.Marshal(body)
+// NewAnthropicClientFor returns the client of the API at the base url, such as a gateway
+// or the fake server of the integration tests
+func NewAnthropicClientFor(baseURL string) *AnthropicClient {
+	// without files to load the configuration cannot fail
+	client, _ := NewAnthropicClientWith(&common.ProviderConfig{BaseURL: baseURL})
+	return client
+}
+
+// NewAnthropicClientWith returns the client of the provider configuration
+func NewAnthropicClientWith(config *common.ProviderConfig) (*AnthropicClient, error) {
+	httpClient, err := NewHTTPClient(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &AnthropicClient{httpClient: httpClient, baseURL: strings.TrimRight(config.BaseURL, "/"),
+		apiKey: config.APIKey}, nil
+}
+
+// UseCassette routes the requests through the cassette, which records or replays them
+func (this *AnthropicClient) UseCassette(cassette *Cassette) {
+	this.httpClient.Transport = cassette.Wrap(this.httpClient.Transport)
+}
+
+// Do sends the text as the next user turn. The model changes the workspace through
+// the file tools, so Do keeps executing tool calls and returning their results until
+// the model ends its turn. All exchanged messages are appended to the project.
+func (this *AnthropicClient) Do(text string, project *types.Project) error {
+	return this.Run(&Turn{Context: context.Background()}, text, project)
+}
+
+// Run is Do with the options of the turn
+func (this *AnthropicClient) Run(turn *Turn, text string, project *types.Project) error {
+	return NewGenerator(this).Run(turn, text, project)
+}
+
+func (this *AnthropicClient) Name() string {
+	return PROVIDER_ANTHROPIC
+}
+
+func (this *AnthropicClient) Capabilities() Capabilities {
+	return Capabilities{DefaultModel: consts.ANTHROPIC_MODEL, ContextWindow: consts.ANTHROPIC_CONTEXT_WINDOW,
+		Streaming: true, Tools: true, PromptCaching: true, Prefill: true, CountTokens: true, Metered: true}
+}
 
-	request, err := http.NewRequest("POST", consts.ANTHROPIC_API, bytes.NewReader(jsonBody))
+func (this *AnthropicClient) Send(ctx context.Context, body *types.ClaudeRequest, apiKey string) (*types.ClaudeResponse, error) {
+	request, err := this.newRequest(ctx, this.baseURL+consts.ANTHROPIC_MESSAGES_PATH, body, apiKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set(consts.ANTHROPIC_HEADER_VERSION, consts.ANTHROPIC_HEADER_VERSION_VALUE)
-	request.Header.Set(consts.ANTHROPIC_HEADER_API_KEY, project.ApiKey)
 
 	response, err := this.httpClient.Do(request)
 	if err != nil {
-		return err
+		return nil, networkError(ctx, err)
 	}
+	defer response.Body.Close()
 
-	var jsonBytes []byte
-	switch response.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, _ := gzip.NewReader(response.Body)
-		jsonBytes, _ = io.ReadAll(reader)
-		defer reader.Close()
-	default:
-		jsonBytes, _ = io.ReadAll(response.Body)
+	jsonBytes, err := readBody(response)
+	if err != nil {
+		return nil, err
 	}
+	return unmarshalResponse(jsonBytes)
+}
+
+type countTokensResponse struct {
+	InputTokens int64 `json:"input_tokens"`
+}
 
-	ok, err := is200(response.Status)
+// CountTokens asks the token counting endpoint for the input tokens of the request
+func (this *AnthropicClient) CountTokens(ctx context.Context, body *types.ClaudeRequest, apiKey string) (int64, error) {
+	// the endpoint takes the request without the output parameters
+	count := &types.ClaudeRequest{Model: body.Model, System: body.System, CacheSystem: body.CacheSystem,
+		Messages: body.Messages, Tools: body.Tools}
+	request, err := this.newRequest(ctx, this.baseURL+consts.ANTHROPIC_COUNT_TOKENS_PATH, count, apiKey)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	if !ok {
-		return errors.New("failed with status " + response.Status + ":" + string(jsonBytes))
+	response, err := this.httpClient.Do(request)
+	if err != nil {
+		return 0, networkError(ctx, err)
 	}
-
-	resp := &types.ClaudeResponse{}
-	err = json.Unmarshal(jsonBytes, resp)
+	defer response.Body.Close()
+	jsonBytes, err := readBody(response)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	counted := &countTokensResponse{}
+	err = json.Unmarshal(jsonBytes, counted)
+	return counted.InputTokens, err
+}
 
-	project.Messages = append(project.Messages,
-		&types.Message{Role: "assistant", Content: resp.Content[len(resp.Content)-1].Text})
+// newRequest builds the http request of the Messages API endpoint for the request body
+func (this *AnthropicClient) newRequest(ctx context.Context, url string, body *types.ClaudeRequest, apiKey string) (*http.Request, error) {
+	jsonBody, err := marshalRequest(body)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		apiKey = this.apiKey
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(consts.ANTHROPIC_HEADER_VERSION, consts.ANTHROPIC_HEADER_VERSION_VALUE)
+	request.Header.Set(consts.ANTHROPIC_HEADER_API_KEY, apiKey)
+	return request, nil
 }
 
-func is200(status string) (bool, error) {
-	index := strings.Index(status, " ")
-	stat, err := strconv.Atoi(status[0:index])
-	if err != nil {
-		return false, err
+// readBody reads the full response body and returns a classified APIError for non 2xx statuses.
+func readBody(response *http.Response) ([]byte, error) {
+	var jsonBytes []byte
+	switch response.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, _ := gzip.NewReader(response.Body)
+		jsonBytes, _ = io.ReadAll(reader)
+		defer reader.Close()
+	default:
+		jsonBytes, _ = io.ReadAll(response.Body)
 	}
-	if stat >= 200 && stat <= 299 {
-		return true, nil
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, newAPIError(response, jsonBytes)
 	}
-	return false, nil
+	return jsonBytes, nil
 }
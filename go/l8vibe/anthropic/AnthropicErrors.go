@@ -0,0 +1,144 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ERROR_RATE_LIMIT        = "rate_limit"
+	ERROR_OVERLOADED        = "overloaded"
+	ERROR_AUTH              = "auth"
+	ERROR_INVALID_REQUEST   = "invalid_request"
+	ERROR_CONTEXT_TOO_LONG  = "context_too_long"
+	ERROR_SERVER            = "server"
+	ERROR_NETWORK           = "network"
+	ERROR_CIRCUIT_OPEN      = "circuit_open"
+	HEADER_RETRY_AFTER      = "retry-after"
+	HEADER_RATELIMIT_PREFIX = "anthropic-ratelimit-"
+)
+
+// APIError is a classified failure of a Messages API call
+type APIError struct {
+	Kind       string
+	Status     int
+	Type       string
+	Message    string
+	RetryAfter time.Duration
+	// Partial is set when part of a streamed response was already delivered,
+	// such a call is not retried to not deliver the text twice
+	Partial bool
+}
+
+func (this *APIError) Error() string {
+	text := this.Kind
+	if this.Status != 0 {
+		text += " (" + strconv.Itoa(this.Status) + ")"
+	}
+	if this.Message != "" {
+		text += ": " + this.Message
+	}
+	return text
+}
+
+// Retryable reports whether the same request may succeed if sent again
+func (this *APIError) Retryable() bool {
+	if this.Partial {
+		return false
+	}
+	switch this.Kind {
+	case ERROR_RATE_LIMIT, ERROR_OVERLOADED, ERROR_SERVER, ERROR_NETWORK:
+		return true
+	}
+	return false
+}
+
+// upstreamFailure reports whether the error says the upstream is unhealthy,
+// client errors prove it is alive
+func (this *APIError) upstreamFailure() bool {
+	return this.Kind == ERROR_OVERLOADED || this.Kind == ERROR_SERVER || this.Kind == ERROR_NETWORK
+}
+
+type errorBody struct {
+	Error *streamError `json:"error"`
+}
+
+// newAPIError classifies a non 2xx response from its status and error body
+func newAPIError(response *http.Response, body []byte) *APIError {
+	apiErr := &APIError{Status: response.StatusCode, Message: strings.TrimSpace(string(body))}
+	parsed := &errorBody{}
+	if json.Unmarshal(body, parsed) == nil && parsed.Error != nil {
+		apiErr.Type = parsed.Error.Type
+		apiErr.Message = parsed.Error.Message
+	}
+	apiErr.Kind = classify(response.StatusCode, apiErr.Type, apiErr.Message)
+	apiErr.RetryAfter = retryAfter(response.Header, time.Now())
+	return apiErr
+}
+
+// streamAPIError classifies an error event of a streamed response
+func streamAPIError(event *streamError, partial bool) *APIError {
+	if event == nil {
+		return &APIError{Kind: ERROR_SERVER, Message: "stream error", Partial: partial}
+	}
+	return &APIError{Kind: classify(0, event.Type, event.Message), Type: event.Type, Message: event.Message,
+		Partial: partial}
+}
+
+func classify(status int, errorType, message string) string {
+	lower := strings.ToLower(message)
+	switch {
+	case status == 429 || errorType == "rate_limit_error":
+		return ERROR_RATE_LIMIT
+	case status == 529 || errorType == "overloaded_error":
+		return ERROR_OVERLOADED
+	case status == 401 || status == 403 || errorType == "authentication_error" || errorType == "permission_error":
+		return ERROR_AUTH
+	case status == 413 || strings.Contains(lower, "prompt is too long") || strings.Contains(lower, "context window") ||
+		strings.Contains(lower, "too many tokens") || strings.Contains(lower, "context length") ||
+		strings.Contains(lower, "maximum context"):
+		return ERROR_CONTEXT_TOO_LONG
+	case status >= 500 || errorType == "api_error":
+		return ERROR_SERVER
+	}
+	return ERROR_INVALID_REQUEST
+}
+
+// retryAfter returns how long the server asked to wait, from retry-after or else
+// from the latest reset time of an exhausted anthropic rate limit
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get(HEADER_RETRY_AFTER)
+	if value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		at, err := http.ParseTime(value)
+		if err == nil {
+			return at.Sub(now)
+		}
+	}
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		if header.Get(HEADER_RATELIMIT_PREFIX+limit+"-remaining") != "0" {
+			continue
+		}
+		reset, err := time.Parse(time.RFC3339, header.Get(HEADER_RATELIMIT_PREFIX+limit+"-reset"))
+		if err == nil && reset.Sub(now) > wait {
+			wait = reset.Sub(now)
+		}
+	}
+	return wait
+}
+
+// networkError classifies a failed round trip, errors of a cancelled context are left alone
+func networkError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &APIError{Kind: ERROR_NETWORK, Message: err.Error()}
+}
@@ -3,7 +3,6 @@ package anthropic
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -36,21 +35,86 @@ func ParseAndCreateFiles(resposeFilename string) ([]string, error) {
 	return result, nil
 }
 
+// ParseMessages re-applies every assistant message of the project to its workspace.
+// Rejected files do not stop the replay, they are returned together as WorkspaceErrors.
+// The tool calls are replayed as well and edits such as patch_file are not idempotent,
+// so the replay is meant for an empty workspace only.
 func ParseMessages(project *types.Project) error {
-	for i, message := range project.Messages {
+	return ReplayMessages(project, 0)
+}
+
+// ReplayMessages re-applies the assistant messages from the index on, the markdown files
+// and the tool calls, to a workspace that holds the files as they were before them.
+func ReplayMessages(project *types.Project, start int) error {
+	return applyMessages(project, start, true)
+}
+
+// ApplyTurn writes the files of the markdown assistant messages from the index on, the
+// tool calls of the turn changed the workspace already while it ran.
+func ApplyTurn(project *types.Project, start int) error {
+	return applyMessages(project, start, false)
+}
+
+func applyMessages(project *types.Project, start int, replayTools bool) error {
+	var rejected WorkspaceErrors
+	for i := start; i >= 0 && i < len(project.Messages); i++ {
+		message := project.Messages[i]
 		if message.Role == "assistant" {
+			if len(message.Blocks) > 0 {
+				// the workspace was changed through tool calls
+				if replayTools {
+					ExecuteTools(message.Blocks, project)
+				}
+				continue
+			}
 			fmt.Println("Parsing message #", i)
 			_, err := ParseMessage(message.Content, project)
-			if err != nil {
+			wsErrs, ok := err.(WorkspaceErrors)
+			if ok {
+				rejected = append(rejected, wsErrs...)
+			} else if err != nil {
 				return err
 			}
 		}
 	}
+	if len(rejected) > 0 {
+		return rejected
+	}
 	return nil
 }
 
+// ParseMessage extracts the files of a markdown assistant message and writes them to the
+// project workspace. Files the workspace rejects are reported as "Rejected file" lines and
+// returned together as WorkspaceErrors once the whole message was processed.
 func ParseMessage(text string, project *types.Project) ([]string, error) {
 	var result []string
+	var rejected WorkspaceErrors
+
+	create := func(filename, content, verb string) error {
+		err := createFileWithPath(filename, content, project)
+		if err == nil {
+			result = append(result, fmt.Sprintf("%s file: %s", verb, filename))
+			return nil
+		}
+		wsErr, ok := err.(*WorkspaceError)
+		if ok {
+			rejected = append(rejected, wsErr)
+			result = append(result, fmt.Sprintf("Rejected file: %s: %s", filename, wsErr.Reason))
+			return nil
+		}
+		return fmt.Errorf("failed to create file %s: %v", filename, err)
+	}
+
+	// a response cut off mid-file leaves its last code block open, that file is refused
+	// rather than written half-finished
+	cut := unclosedFence(text)
+	if cut >= 0 {
+		filename := lastLine(text[:cut])
+		rejected = append(rejected, &WorkspaceError{Op: "write", Path: filename,
+			Reason: "the code block was never closed, the response was cut off"})
+		result = append(result, fmt.Sprintf("Rejected file: %s: the code block was never closed", filename))
+		text = text[:cut]
+	}
 
 	// Regular expression to match code blocks with file names
 	// Matches: ## filename.ext followed by ```language and content until ```
@@ -74,11 +138,9 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 				filename = "index.html"
 			}
 			content := match[4]
-			if err := createFileWithPath(filename, content, project); err != nil {
-				return nil, fmt.Errorf("failed to create file %s: %v", filename, err)
+			if err := create(filename, content, "Updated"); err != nil {
+				return nil, err
 			}
-			basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-			result = append(result, fmt.Sprintf("Updated file: %s", filepath.Join(basePath, filename)))
 		}
 
 		if len(altMatches) == 0 {
@@ -99,11 +161,9 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 				// Only process if it looks like a valid filename
 				if strings.Contains(filename, ".") && !strings.Contains(filename, " ") && !strings.Contains(filename, "#") && len(filename) < 50 {
 					content := match[3]
-					if err := createFileWithPath(filename, content, project); err != nil {
-						return nil, fmt.Errorf("failed to create file %s: %v", filename, err)
+					if err := create(filename, content, "Created"); err != nil {
+						return nil, err
 					}
-					basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-					result = append(result, fmt.Sprintf("Created file: %s", filepath.Join(basePath, filename)))
 				}
 			}
 		}
@@ -143,19 +203,9 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 				continue
 			}
 
-			// Check if this is a replacement operation
-			isReplacement := strings.Contains(fullMatch, "Replace your entire") ||
-				strings.Contains(fullMatch, "replace your entire") ||
-				strings.Contains(fullMatch, "Replace the entire") ||
-				strings.Contains(fullMatch, "replace the entire") ||
-				strings.Contains(fullMatch, "Replace your") ||
-				strings.Contains(fullMatch, "replace your")
-
-			if err := createFileWithPath(filename, content, project, isReplacement); err != nil {
-				return nil, fmt.Errorf("failed to create file %s: %v", filename, err)
+			if err := create(filename, content, "Created"); err != nil {
+				return nil, err
 			}
-			basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-			result = append(result, fmt.Sprintf("Created file: %s", filepath.Join(basePath, filename)))
 		}
 	}
 
@@ -177,11 +227,9 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 			// Use "index" + extension as filename
 			filename := "index." + extension
 
-			if err := createFileWithPath(filename, content, project); err != nil {
-				return nil, fmt.Errorf("failed to create file %s: %v", filename, err)
+			if err := create(filename, content, "Created"); err != nil {
+				return nil, err
 			}
-			basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-			result = append(result, fmt.Sprintf("Created file: %s", filepath.Join(basePath, filename)))
 		}
 	}
 
@@ -210,11 +258,9 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 
 				// Save previous file if exists
 				if currentFile != "" && content.Len() > 0 {
-					if err := createFileWithPath(currentFile, content.String(), project); err != nil {
-						return nil, fmt.Errorf("failed to create file %s: %v", currentFile, err)
+					if err := create(currentFile, content.String(), "Created"); err != nil {
+						return nil, err
 					}
-					basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-					result = append(result, fmt.Sprintf("Created file: %s", filepath.Join(basePath, currentFile)))
 				}
 				// Clean filename by removing markdown formatting (asterisks, etc.)
 				cleanedFilename := strings.Trim(trimmedLine, "*")
@@ -241,310 +287,61 @@ func ParseMessage(text string, project *types.Project) ([]string, error) {
 
 		// Save the last file
 		if currentFile != "" && content.Len() > 0 {
-			if err := createFileWithPath(currentFile, content.String(), project); err != nil {
-				return nil, fmt.Errorf("failed to create file %s: %v", currentFile, err)
+			if err := create(currentFile, content.String(), "Created"); err != nil {
+				return nil, err
 			}
-			basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-			result = append(result, fmt.Sprintf("Created file: %s", filepath.Join(basePath, currentFile)))
 		}
 	}
 
-	return result, nil
-}
-
-func createFileWithPath(filename, content string, project *types.Project, forceReplace ...bool) error {
-
-	// Construct the path: ./web/workspace/{user}/{project_name}/{filename}
-	basePath := filepath.Join(".", "web", "workspace", project.User, project.Name)
-	fullPath := filepath.Join(basePath, filename)
-
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	// Check if this is a forced replacement
-	shouldReplace := len(forceReplace) > 0 && forceReplace[0]
-
-	// Check if file already exists
-	if _, err := os.Stat(fullPath); err == nil {
-		// File exists
-		if shouldReplace {
-			// Force complete replacement
-			return os.WriteFile(fullPath, []byte(content), 0644)
-		} else {
-			// Handle partial update
-			return updateFileWithPath(fullPath, content, filename)
-		}
+	if len(rejected) > 0 {
+		return result, rejected
 	}
-
-	// File doesn't exist - create new file
-	return os.WriteFile(fullPath, []byte(content), 0644)
+	return result, nil
 }
 
-func updateFileWithPath(fullPath, newContent, filename string) error {
-	// Read existing file content
-	existingData, err := os.ReadFile(fullPath)
+func createFileWithPath(filename, content string, project *types.Project) error {
+	workspace, err := NewWorkspace(project)
 	if err != nil {
 		return err
 	}
-	existingContent := string(existingData)
-
-	// Detect if this is a partial update or complete replacement
-	if isPartialUpdate(newContent, existingContent, filename) {
-		// Apply partial update
-		updatedContent := applyPartialUpdate(existingContent, newContent, filename)
-		return os.WriteFile(fullPath, []byte(updatedContent), 0644)
-	}
-
-	// Complete replacement
-	return os.WriteFile(fullPath, []byte(newContent), 0644)
-}
 
-func isPartialUpdate(newContent, existingContent, filename string) bool {
-	trimmedNew := strings.TrimSpace(newContent)
-
-	// For HTML files: detect if content is just a partial fragment
-	if strings.HasSuffix(filename, ".html") {
-		// If content starts with just a single tag and doesn't have DOCTYPE, html, head, or body tags, it's likely partial
-		if strings.HasPrefix(trimmedNew, "<nav") || strings.HasPrefix(trimmedNew, "<div") ||
-			strings.HasPrefix(trimmedNew, "<section") || strings.HasPrefix(trimmedNew, "<header") {
-			// Check if it's missing essential HTML document structure
-			if !strings.Contains(trimmedNew, "<!DOCTYPE") &&
-				!strings.Contains(trimmedNew, "<html") &&
-				!strings.Contains(trimmedNew, "<head") &&
-				!strings.Contains(trimmedNew, "<body") {
-				return true // This is a partial HTML fragment
-			}
+	// Partial updates must come as a unified diff or SEARCH/REPLACE blocks,
+	// anything else is the complete content of the file.
+	if IsPatch(content) {
+		existingData, err := workspace.ReadFile(filename)
+		if err != nil {
+			return err
 		}
-	}
-
-	// For CSS files: detect if content is just comments or partial rules
-	if strings.HasSuffix(filename, ".css") {
-		// If content is mostly comments or contains "Remove these sections", it's likely instructions, not actual CSS
-		if strings.Contains(trimmedNew, "Remove these sections") ||
-			strings.Contains(trimmedNew, "/* Remove") ||
-			(strings.Count(trimmedNew, "/*") > strings.Count(trimmedNew, "{")) {
-			return true // This is CSS instructions/comments, not actual stylesheet
-		}
-
-		// Check if this is a CSS addition (new styles for specific components)
-		if strings.Contains(trimmedNew, "/* Navigation Actions */") ||
-			strings.Contains(trimmedNew, "/* Add this to") ||
-			(strings.Contains(trimmedNew, ".") &&
-				len(strings.Split(trimmedNew, "\n")) < 100 &&
-				!strings.Contains(trimmedNew, ":root") &&
-				!strings.Contains(trimmedNew, "* {")) {
-			return true // This is a CSS addition that should be ignored
+		patched, err := ApplyPatch(string(existingData), content)
+		if err != nil {
+			return err
 		}
+		return workspace.WriteFile(filename, []byte(patched))
 	}
 
-	// Check if it's a JavaScript file with method updates
-	if strings.HasSuffix(filename, ".js") {
-		// Check if the new content is a single function that exists in the original file (replacement)
-		if strings.HasPrefix(trimmedNew, "function ") {
-			lines := strings.Split(trimmedNew, "\n")
-			if len(lines) > 0 {
-				firstLine := lines[0]
-				if strings.Contains(firstLine, "(") {
-					// Extract function name between "function " and "("
-					start := strings.Index(firstLine, "function ") + 9
-					end := strings.Index(firstLine, "(")
-					if start < end {
-						functionName := strings.TrimSpace(firstLine[start:end])
-						// Check if this function exists in the existing file
-						if strings.Contains(existingContent, "function "+functionName+"(") {
-							return true // This is a function replacement
-						}
-					}
-				}
-			}
-		}
-
-		// Check if this is a standalone function that should be added (not replaced)
-		if strings.HasPrefix(trimmedNew, "// Add this function") ||
-			strings.HasPrefix(trimmedNew, "// Add the following function") ||
-			(strings.Contains(trimmedNew, "function ") &&
-				len(strings.Split(trimmedNew, "\n")) < 100 &&
-				!strings.Contains(trimmedNew, "gymData") &&
-				!strings.Contains(trimmedNew, "locations:")) {
-			return true // This is a function addition that should be ignored or handled specially
-		}
-
-		// Look for method/function updates with specific indicators
-		if strings.Contains(newContent, "loadSampleData()") &&
-			strings.Contains(newContent, "only showing the modified") {
-			return true
-		}
-
-		// Look for partial function definitions
-		if strings.Contains(newContent, "function ") ||
-			strings.Contains(newContent, "() {") {
-			// Count lines - if significantly smaller than existing, likely partial
-			newLines := len(strings.Split(newContent, "\n"))
-			existingLines := len(strings.Split(existingContent, "\n"))
-			return newLines < existingLines/2
-		}
-	}
-
-	// Check for other file types with similar patterns
-	if strings.Contains(newContent, "Updated") ||
-		strings.Contains(newContent, "only showing") ||
-		strings.Contains(newContent, "modified") {
-		return true
-	}
-
-	return false
+	return workspace.WriteFile(filename, []byte(content))
 }
 
-func applyPartialUpdate(existingContent, newContent, filename string) string {
-	trimmedNew := strings.TrimSpace(newContent)
-
-	if strings.HasSuffix(filename, ".js") {
-		// Check if this is a function addition that should be ignored
-		if strings.HasPrefix(trimmedNew, "// Add this function") ||
-			strings.HasPrefix(trimmedNew, "// Add the following function") ||
-			(strings.Contains(trimmedNew, "function ") &&
-				len(strings.Split(trimmedNew, "\n")) < 100 &&
-				!strings.Contains(trimmedNew, "gymData") &&
-				!strings.Contains(trimmedNew, "locations:")) {
-			return existingContent // Keep existing content, ignore function additions
-		}
-		return applyJavaScriptUpdate(existingContent, newContent)
-	}
-
-	// For HTML and CSS partial updates that are just fragments or instructions, keep existing content
-	if strings.HasSuffix(filename, ".html") || strings.HasSuffix(filename, ".css") {
-		trimmedNew := strings.TrimSpace(newContent)
-
-		// If HTML content is just a fragment without document structure, ignore it
-		if strings.HasSuffix(filename, ".html") &&
-			!strings.Contains(trimmedNew, "<!DOCTYPE") &&
-			!strings.Contains(trimmedNew, "<html") &&
-			!strings.Contains(trimmedNew, "<head") &&
-			!strings.Contains(trimmedNew, "<body") {
-			return existingContent // Keep existing content, ignore fragment
-		}
-
-		// If CSS content is mostly comments, instructions, or partial additions, ignore it
-		if strings.HasSuffix(filename, ".css") &&
-			(strings.Contains(trimmedNew, "Remove these sections") ||
-				strings.Contains(trimmedNew, "/* Remove") ||
-				strings.Contains(trimmedNew, "/* Navigation Actions */") ||
-				strings.Contains(trimmedNew, "/* Add this to") ||
-				strings.Count(trimmedNew, "/*") > strings.Count(trimmedNew, "{") ||
-				(!strings.Contains(trimmedNew, ":root") && !strings.Contains(trimmedNew, "* {") && len(strings.Split(trimmedNew, "\n")) < 100)) {
-			return existingContent // Keep existing content, ignore partial additions/instructions
-		}
-	}
-
-	// For other file types, default to simple replacement
-	return newContent
-}
-
-func applyJavaScriptUpdate(existingContent, newContent string) string {
-	// Extract method/function updates from newContent
-	lines := strings.Split(newContent, "\n")
-	var methodStart, methodEnd int
-	var methodName string
-	var inMethod bool
-	var braceCount int
-
-	// Find the method being updated
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		// Look for method definition
-		if strings.Contains(trimmed, "() {") ||
-			(strings.Contains(trimmed, "function ") && strings.Contains(trimmed, "{")) {
-			methodStart = i
-			methodName = extractMethodName(trimmed)
-			inMethod = true
-			braceCount = strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
-			continue
-		}
-
-		if inMethod {
-			braceCount += strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
-			if braceCount == 0 {
-				methodEnd = i
-				break
+// unclosedFence returns the offset of the code fence line that opens a block which is
+// never closed, or -1 if all the code blocks are closed
+func unclosedFence(text string) int {
+	open := -1
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if open < 0 {
+				open = offset
+			} else {
+				open = -1
 			}
 		}
+		offset += len(line)
 	}
-
-	if methodName == "" {
-		return newContent // Fallback to complete replacement
-	}
-
-	// Extract the new method content
-	newMethodLines := lines[methodStart : methodEnd+1]
-	newMethodContent := strings.Join(newMethodLines, "\n")
-
-	// Replace the method in existing content
-	return replaceMethodInContent(existingContent, methodName, newMethodContent)
-}
-
-func extractMethodName(line string) string {
-	// Extract method name from line like "loadSampleData() {" or "function loadSampleData() {"
-	if strings.Contains(line, "function ") {
-		parts := strings.Split(line, "function ")
-		if len(parts) > 1 {
-			nameWithParams := strings.Split(parts[1], "(")[0]
-			return strings.TrimSpace(nameWithParams)
-		}
-	} else if strings.Contains(line, "() {") {
-		parts := strings.Split(line, "() {")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-	return ""
+	return open
 }
 
-func replaceMethodInContent(content, methodName, newMethodContent string) string {
-	lines := strings.Split(content, "\n")
-	var result []string
-	var inTargetMethod bool
-	var braceCount int
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		// Check if this is the start of our target method
-		if !inTargetMethod && strings.Contains(line, methodName+"()") && strings.Contains(line, "{") {
-			inTargetMethod = true
-			braceCount = strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
-
-			// If the opening brace is on the same line and closes immediately, handle single-line method
-			if braceCount == 0 {
-				inTargetMethod = false
-				// Replace the entire line with new method content
-				newLines := strings.Split(newMethodContent, "\n")
-				result = append(result, newLines...)
-				continue
-			}
-			continue
-		}
-
-		if inTargetMethod {
-			braceCount += strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
-			if braceCount == 0 {
-				inTargetMethod = false
-				// Insert the new method content at the method start position
-				newLines := strings.Split(newMethodContent, "\n")
-				result = append(result, newLines...)
-			}
-			// Skip old method content lines
-			continue
-		}
-
-		// Add non-target method lines
-		if !inTargetMethod {
-			result = append(result, line)
-		}
-	}
-
-	return strings.Join(result, "\n")
+// lastLine returns the last non empty line of the text, the header of a code block
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimRight(text, " \t\r\n"), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
 }
@@ -0,0 +1,156 @@
+package anthropic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// streamEvent is a single server-sent event of the Messages API streaming protocol
+type streamEvent struct {
+	Type         string                `json:"type"`
+	Index        int                   `json:"index"`
+	Message      *types.ClaudeResponse `json:"message"`
+	ContentBlock *wireContent          `json:"content_block"`
+	Delta        *streamDelta          `json:"delta"`
+	Usage        *types.Usage          `json:"usage"`
+	Error        *streamError          `json:"error"`
+}
+
+type streamDelta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	PartialJson string `json:"partial_json"`
+	StopReason  string `json:"stop_reason"`
+}
+
+type streamError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// DoStream is Do with streaming enabled. Each text delta is handed to onDelta as it
+// arrives, tool calls are executed between the streamed responses as in Do.
+func (this *AnthropicClient) DoStream(text string, project *types.Project, onDelta func(string)) error {
+	return this.Run(&Turn{Context: context.Background(), OnDelta: onDelta}, text, project)
+}
+
+// Stream sends the request with streaming enabled and hands each text delta to onDelta
+func (this *AnthropicClient) Stream(ctx context.Context, body *types.ClaudeRequest, apiKey string, onDelta func(string)) (*types.ClaudeResponse, error) {
+	body.Stream = true
+	request, err := this.newRequest(ctx, this.baseURL+consts.ANTHROPIC_MESSAGES_PATH, body, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+
+	response, err := this.httpClient.Do(request)
+	if err != nil {
+		return nil, networkError(ctx, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		_, err = readBody(response)
+		return nil, err
+	}
+	resp, err := readStream(bufio.NewReader(response.Body), onDelta)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// readStream consumes the event stream until message_stop and assembles the
+// equivalent non streaming response.
+func readStream(reader *bufio.Reader, onDelta func(string)) (*types.ClaudeResponse, error) {
+	resp := &types.ClaudeResponse{}
+	inputs := make(map[int]*strings.Builder)
+	var data strings.Builder
+	// once text was delivered a failed stream cannot be retried transparently
+	delivered := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, &APIError{Kind: ERROR_NETWORK, Message: "stream ended before message_stop: " + err.Error(),
+				Partial: delivered}
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimSpace(line[5:]))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			// event names, comments and keep-alives carry nothing we need,
+			// the event type is also part of the data payload.
+			continue
+		}
+
+		event := &streamEvent{}
+		er := json.Unmarshal([]byte(data.String()), event)
+		data.Reset()
+		if er != nil {
+			return nil, er
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				resp = event.Message
+				resp.Content = nil
+			}
+		case "content_block_start":
+			block := &types.Content{}
+			if event.ContentBlock != nil {
+				block = fromWireContent(event.ContentBlock)
+			}
+			for len(resp.Content) <= event.Index {
+				resp.Content = append(resp.Content, &types.Content{})
+			}
+			resp.Content[event.Index] = block
+			inputs[event.Index] = &strings.Builder{}
+		case "content_block_delta":
+			if event.Delta == nil || event.Index >= len(resp.Content) {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				resp.Content[event.Index].Text += event.Delta.Text
+				if onDelta != nil {
+					onDelta(event.Delta.Text)
+					delivered = true
+				}
+			case "input_json_delta":
+				input, ok := inputs[event.Index]
+				if ok {
+					input.WriteString(event.Delta.PartialJson)
+				}
+			}
+		case "content_block_stop":
+			// tool input arrives as partial json, the start event only carries an empty object
+			input, ok := inputs[event.Index]
+			if ok && input.Len() > 0 && event.Index < len(resp.Content) {
+				resp.Content[event.Index].Input = input.String()
+			}
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				resp.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				if resp.Usage == nil {
+					resp.Usage = &types.Usage{}
+				}
+				resp.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return resp, nil
+		case "error":
+			return nil, streamAPIError(event.Error, delivered)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package anthropic
+
+import (
+	"encoding/json"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// The Messages API uses polymorphic JSON (content is either a string or a list of
+// blocks, tool input is an arbitrary object), which the protobuf types cannot express
+// directly. The wire types below translate between the two.
+
+type wireRequest struct {
+	Model         string         `json:"model"`
+	MaxTokens     int64          `json:"max_tokens,omitempty"`
+	System        interface{}    `json:"system,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	StopSequences []string       `json:"stop_sequences,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	Messages      []*wireMessage `json:"messages"`
+	Tools         []*wireTool    `json:"tools,omitempty"`
+}
+
+type wireMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type wireContent struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	// CacheControl marks the end of a prefix the API caches
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+type cacheControl struct {
+	Type string `json:"type"`
+}
+
+var ephemeral = &cacheControl{Type: "ephemeral"}
+
+type wireTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type wireResponse struct {
+	Id         string         `json:"id"`
+	Type       string         `json:"type"`
+	Role       string         `json:"role"`
+	Content    []*wireContent `json:"content"`
+	Model      string         `json:"model"`
+	StopReason string         `json:"stop_reason"`
+	Usage      *types.Usage   `json:"usage"`
+}
+
+func marshalRequest(body *types.ClaudeRequest) ([]byte, error) {
+	req := &wireRequest{Model: body.Model, MaxTokens: body.MaxTokens, Stream: body.Stream,
+		Temperature: body.Temperature, TopP: body.TopP, StopSequences: body.StopSequences}
+	if body.System != "" {
+		req.System = body.System
+		if body.CacheSystem {
+			// the system prompt caches together with the tools in front of it
+			req.System = []*wireContent{{Type: "text", Text: body.System, CacheControl: ephemeral}}
+		}
+	}
+	req.Messages = make([]*wireMessage, 0, len(body.Messages))
+	for _, msg := range body.Messages {
+		wmsg := &wireMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.Blocks) > 0 {
+			blocks := make([]*wireContent, 0, len(msg.Blocks))
+			for _, block := range msg.Blocks {
+				blocks = append(blocks, toWireContent(block))
+			}
+			wmsg.Content = blocks
+		}
+		req.Messages = append(req.Messages, wmsg)
+	}
+	for _, tool := range body.Tools {
+		req.Tools = append(req.Tools, &wireTool{Name: tool.Name, Description: tool.Description,
+			InputSchema: json.RawMessage(tool.InputSchema)})
+	}
+	return json.Marshal(req)
+}
+
+func unmarshalResponse(data []byte) (*types.ClaudeResponse, error) {
+	wresp := &wireResponse{}
+	err := json.Unmarshal(data, wresp)
+	if err != nil {
+		return nil, err
+	}
+	resp := &types.ClaudeResponse{Id: wresp.Id, Type: wresp.Type, Role: wresp.Role, Model: wresp.Model,
+		StopReason: wresp.StopReason, Usage: wresp.Usage}
+	for _, block := range wresp.Content {
+		resp.Content = append(resp.Content, fromWireContent(block))
+	}
+	return resp, nil
+}
+
+func toWireContent(block *types.Content) *wireContent {
+	wc := &wireContent{Type: block.Type, Text: block.Text, Id: block.Id, Name: block.Name,
+		ToolUseId: block.ToolUseId, Content: block.Content, IsError: block.IsError}
+	if block.Cache {
+		wc.CacheControl = ephemeral
+	}
+	if block.Type == "tool_use" {
+		wc.Input = json.RawMessage(block.Input)
+		if block.Input == "" {
+			wc.Input = json.RawMessage("{}")
+		}
+	}
+	return wc
+}
+
+func fromWireContent(wc *wireContent) *types.Content {
+	return &types.Content{Type: wc.Type, Text: wc.Text, Id: wc.Id, Name: wc.Name, Input: string(wc.Input),
+		ToolUseId: wc.ToolUseId, Content: wc.Content, IsError: wc.IsError}
+}
+
+// assistantMessage converts a response to the assistant turn stored on the project.
+// Content holds the text for display and legacy parsing, Blocks the full response
+// so tool calls can be replayed and resent to the model.
+func assistantMessage(resp *types.ClaudeResponse) *types.Message {
+	msg := &types.Message{Role: "assistant", Blocks: resp.Content}
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			msg.Content += block.Text
+		}
+	}
+	return msg
+}
@@ -0,0 +1,210 @@
+package anthropic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+)
+
+const (
+	CASSETTE_OFF    = "off"
+	CASSETTE_RECORD = "record"
+	CASSETTE_REPLAY = "replay"
+	SCRUBBED        = "[scrubbed]"
+)
+
+// scrubbedHeaders carry credentials and are never written to a cassette
+var scrubbedHeaders = []string{consts.ANTHROPIC_HEADER_API_KEY, "Authorization", "Cookie", "Set-Cookie"}
+
+// Exchange is a recorded request and the response the provider returned for it
+type Exchange struct {
+	Hash            string      `json:"hash"`
+	Method          string      `json:"method"`
+	Url             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    string      `json:"response_body"`
+}
+
+// Cassette records the exchanges with the model providers to a file and replays them
+// offline. Exchanges are keyed by the hash of the method, url and body of the request,
+// identical requests replay their recorded responses in order.
+type Cassette struct {
+	file      string
+	mode      string
+	mtx       sync.Mutex
+	exchanges []*Exchange
+	replayed  map[string]int
+}
+
+// NewCassette opens the cassette file in the mode. Replaying needs the file, recording
+// appends to it if it exists.
+func NewCassette(file, mode string) (*Cassette, error) {
+	if mode != CASSETTE_RECORD && mode != CASSETTE_REPLAY {
+		return nil, errors.New("Unknown cassette mode " + mode)
+	}
+	cassette := &Cassette{file: file, mode: mode, replayed: make(map[string]int)}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) && mode == CASSETTE_RECORD {
+			return cassette, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &cassette.exchanges)
+	if err != nil {
+		return nil, errors.New("Cassette " + file + " is corrupt: " + err.Error())
+	}
+	return cassette, nil
+}
+
+// CassetteFromEnv returns the cassette the environment configures, or nil if it is off
+func CassetteFromEnv() (*Cassette, error) {
+	mode := os.Getenv(consts.CASSETTE_MODE_ENV)
+	if mode == "" || mode == CASSETTE_OFF {
+		return nil, nil
+	}
+	file := os.Getenv(consts.CASSETTE_FILE_ENV)
+	if file == "" {
+		file = consts.CASSETTE_FILE
+	}
+	return NewCassette(file, mode)
+}
+
+// Mode returns the mode of the cassette
+func (this *Cassette) Mode() string {
+	return this.mode
+}
+
+// Exchanges returns the recorded exchanges
+func (this *Cassette) Exchanges() []*Exchange {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	return append([]*Exchange(nil), this.exchanges...)
+}
+
+// Wrap returns the transport that records the exchanges of next, or replays them
+// without calling next at all. A recorded response is read completely before it is
+// handed on, so streamed text arrives at once while recording.
+func (this *Cassette) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &cassetteTransport{cassette: this, next: next}
+}
+
+type cassetteTransport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (this *cassetteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if request.Body != nil {
+		data, err := io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	hash := RequestHash(request.Method, request.URL.String(), body)
+	if this.cassette.mode == CASSETTE_REPLAY {
+		return this.cassette.replay(request, hash), nil
+	}
+	response, err := this.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	err = this.cassette.record(&Exchange{Hash: hash, Method: request.Method, Url: request.URL.String(),
+		RequestHeaders: scrub(request.Header), RequestBody: string(body), Status: response.StatusCode,
+		ResponseHeaders: scrub(response.Header), ResponseBody: string(data)})
+	if err != nil {
+		fmt.Println("Failed to record the exchange: ", err.Error())
+	}
+	return response, nil
+}
+
+// replay returns the next recorded response of the request. A request that was not
+// recorded is answered with an invalid request error, so it fails at once rather than
+// being retried.
+func (this *Cassette) replay(request *http.Request, hash string) *http.Response {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	seen := 0
+	for _, exchange := range this.exchanges {
+		if exchange.Hash != hash {
+			continue
+		}
+		if seen == this.replayed[hash] {
+			this.replayed[hash]++
+			return newResponse(request, exchange.Status, exchange.ResponseHeaders, exchange.ResponseBody)
+		}
+		seen++
+	}
+	message := "no recorded exchange for request " + hash
+	if seen > 0 {
+		message += " after " + strconv.Itoa(seen) + " replays"
+	}
+	body, _ := json.Marshal(&errorBody{Error: &streamError{Type: "invalid_request_error", Message: message}})
+	return newResponse(request, http.StatusNotFound, http.Header{"Content-Type": {"application/json"}}, string(body))
+}
+
+func (this *Cassette) record(exchange *Exchange) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.exchanges = append(this.exchanges, exchange)
+	data, err := json.MarshalIndent(this.exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteAtomic(this.file, data)
+}
+
+func newResponse(request *http.Request, status int, header http.Header, body string) *http.Response {
+	return &http.Response{Status: strconv.Itoa(status) + " " + http.StatusText(status), StatusCode: status,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: header.Clone(),
+		Body: io.NopCloser(strings.NewReader(body)), ContentLength: int64(len(body)), Request: request}
+}
+
+// RequestHash returns the key of a request in the cassette. The body is compacted when
+// it is json, so the key does not depend on its formatting.
+func RequestHash(method, url string, body []byte) string {
+	compact := &bytes.Buffer{}
+	if json.Compact(compact, body) != nil {
+		compact = bytes.NewBuffer(body)
+	}
+	sum := sha256.Sum256([]byte(method + " " + url + "\n" + compact.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func scrub(header http.Header) http.Header {
+	scrubbed := header.Clone()
+	for _, name := range scrubbedHeaders {
+		if scrubbed.Get(name) != "" {
+			scrubbed.Set(name, SCRUBBED)
+		}
+	}
+	return scrubbed
+}
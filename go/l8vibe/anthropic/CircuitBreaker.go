@@ -0,0 +1,63 @@
+package anthropic
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker fails the calls fast while the upstream is unhealthy. After threshold
+// consecutive upstream failures it opens for the cooldown, then lets a single trial call
+// through and closes again if the trial succeeds.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	open      bool
+	trialAt   time.Time
+	mtx       sync.Mutex
+}
+
+// NewCircuitBreaker returns a closed breaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow returns an APIError of kind circuit_open if the call should not be made
+func (this *CircuitBreaker) Allow() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if !this.open {
+		return nil
+	}
+	remaining := this.cooldown - time.Since(this.openedAt)
+	// a trial that never reported back, e.g. cancelled, is replaced after a cooldown
+	trialRemaining := this.cooldown - time.Since(this.trialAt)
+	if remaining <= 0 && trialRemaining > 0 {
+		remaining = trialRemaining
+	}
+	if remaining > 0 {
+		return &APIError{Kind: ERROR_CIRCUIT_OPEN, RetryAfter: remaining,
+			Message: "the model API is failing, calls are paused after " + strconv.Itoa(this.failures) + " failures"}
+	}
+	this.trialAt = time.Now()
+	return nil
+}
+
+// Record counts the outcome of a call, err is nil for a successful call
+func (this *CircuitBreaker) Record(err *APIError) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.trialAt = time.Time{}
+	if err == nil || !err.upstreamFailure() {
+		this.failures = 0
+		this.open = false
+		return
+	}
+	this.failures++
+	if this.open || this.failures >= this.threshold {
+		this.open = true
+		this.openedAt = time.Now()
+	}
+}
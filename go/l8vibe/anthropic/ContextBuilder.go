@@ -0,0 +1,335 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// ContextPolicy decides how the project conversation is fitted into the context window
+type ContextPolicy struct {
+	// Window is the context window of the model in tokens
+	Window int64
+	// KeepTurns is the number of most recent turns that are sent verbatim
+	KeepTurns int
+	// MaxFileTokens is the size above which a workspace file is summarized
+	MaxFileTokens int64
+	// ElideBytes is the size above which code in older turns is elided
+	ElideBytes int
+}
+
+// DefaultContextPolicy returns the policy of the consts
+func DefaultContextPolicy() *ContextPolicy {
+	return &ContextPolicy{Window: consts.ANTHROPIC_CONTEXT_WINDOW, KeepTurns: consts.CONTEXT_KEEP_TURNS,
+		MaxFileTokens: consts.CONTEXT_MAX_FILE_TOKENS, ElideBytes: consts.CONTEXT_ELIDE_BYTES}
+}
+
+var fencePattern = regexp.MustCompile("(?s)```[^\n]*\n.*?```")
+
+// elidedInputs are the tool input fields that carry file content
+var elidedInputs = []string{"content", "patch", "old_text", "new_text"}
+
+// EstimateTokens estimates the tokens of the text. Code tokenizes denser than prose,
+// three bytes per token errs on the side of sending less.
+func EstimateTokens(text string) int64 {
+	return int64(len(text)+2) / 3
+}
+
+func estimateMessage(message *types.Message) int64 {
+	tokens := EstimateTokens(message.Content)
+	for _, block := range message.Blocks {
+		tokens += EstimateTokens(block.Text) + EstimateTokens(block.Input) + EstimateTokens(block.Content)
+	}
+	return tokens
+}
+
+// BuildContext returns the messages to send for the project. The stored conversation is
+// never changed, the returned messages are copies where needed. The latest turns are sent
+// verbatim, the file content written by older turns is elided and replaced by the current
+// workspace files, and the oldest turns are dropped while the context is over the budget,
+// which is the window less the reserved tokens of the system prompt, tools and output.
+// The report records what was elided.
+func BuildContext(project *types.Project, policy *ContextPolicy, reserved int64) ([]*types.Message, *types.ContextReport) {
+	return buildContext(project, policy, reserved, make(workspaceSnapshots))
+}
+
+// workspaceSnapshots keeps the rendered workspace of a turn, so every round of the turn
+// sends the same prefix and the cached prefix is reused. The tool calls of the turn follow
+// the snapshot, so the model still sees its own changes.
+type workspaceSnapshots map[bool]*workspaceSnapshot
+
+type workspaceSnapshot struct {
+	text       string
+	files      int32
+	summarized int32
+}
+
+// buildContext is BuildContext with the workspace snapshots of the turn. The context is laid
+// out for prompt caching: the compacted older turns come first as they only grow at their
+// end, then the workspace snapshot and the verbatim turns. The end of the older turns, the
+// snapshot and the last message are marked as cache breakpoints.
+func buildContext(project *types.Project, policy *ContextPolicy, reserved int64, snapshots workspaceSnapshots) ([]*types.Message, *types.ContextReport) {
+	turns := splitTurns(project.Messages)
+	report := &types.ContextReport{Budget: policy.Window - reserved, Turns: int32(len(turns))}
+	keep := len(turns) - policy.KeepTurns
+	if keep < 0 {
+		keep = 0
+	}
+	for i := 0; i < keep; i++ {
+		turns[i] = compactTurn(turns[i], policy, report)
+		report.TurnsCompacted++
+	}
+	report.TurnsVerbatim = int32(len(turns) - keep)
+
+	var workspace *workspaceSnapshot
+	if report.ElidedBlocks > 0 {
+		workspace = snapshots.of(project, policy, true)
+	}
+	total := int64(0)
+	for _, turn := range turns {
+		for _, message := range turn {
+			total += estimateMessage(message)
+		}
+	}
+	total += workspace.tokens()
+
+	// drop the oldest compacted turns, then fall back to listing the workspace
+	dropped := 0
+	for total > report.Budget && dropped < keep {
+		for _, message := range turns[dropped] {
+			total -= estimateMessage(message)
+		}
+		report.Dropped = append(report.Dropped, firstLine(turns[dropped][0].Content))
+		report.TurnsCompacted--
+		dropped++
+	}
+	report.TurnsDropped = int32(dropped)
+	if total > report.Budget && workspace != nil {
+		total -= workspace.tokens()
+		workspace = snapshots.of(project, policy, false)
+		total += workspace.tokens()
+	}
+	if workspace != nil {
+		report.WorkspaceFiles = workspace.files
+		report.SummarizedFiles = workspace.summarized
+	}
+	compacted := keep - dropped
+	turns = turns[dropped:]
+
+	if len(report.Dropped) > 0 && len(turns) > 0 {
+		summary := "Earlier requests of this project, omitted for length:\n- " + strings.Join(report.Dropped, "\n- ")
+		turns[0][0] = withPrefix(turns[0][0], summary, false)
+		total += EstimateTokens(summary)
+	}
+	if workspace != nil && workspace.text != "" && compacted < len(turns) {
+		turns[compacted][0] = withPrefix(turns[compacted][0], workspace.text, true)
+	}
+
+	messages := make([]*types.Message, 0, len(project.Messages))
+	for i, turn := range turns {
+		messages = append(messages, turn...)
+		if i == compacted-1 {
+			messages[len(messages)-1] = withCache(messages[len(messages)-1])
+		}
+	}
+	if len(messages) > 0 {
+		messages[len(messages)-1] = withCache(messages[len(messages)-1])
+	}
+	report.EstimatedTokens = total + reserved
+	return messages, report
+}
+
+func (this workspaceSnapshots) of(project *types.Project, policy *ContextPolicy, contents bool) *workspaceSnapshot {
+	snapshot, ok := this[contents]
+	if !ok {
+		snapshot = workspaceContext(project, policy, contents)
+		this[contents] = snapshot
+	}
+	return snapshot
+}
+
+func (this *workspaceSnapshot) tokens() int64 {
+	if this == nil {
+		return 0
+	}
+	return EstimateTokens(this.text)
+}
+
+// withPrefix returns a copy of the request message with the text as a separate block in front
+func withPrefix(message *types.Message, text string, cache bool) *types.Message {
+	blocks := []*types.Content{{Type: "text", Text: text, Cache: cache}}
+	if len(message.Blocks) > 0 {
+		blocks = append(blocks, message.Blocks...)
+	} else if message.Content != "" {
+		blocks = append(blocks, &types.Content{Type: "text", Text: message.Content})
+	}
+	return &types.Message{Role: message.Role, Content: message.Content, Blocks: blocks}
+}
+
+// withCache returns a copy of the message whose last block is a cache breakpoint
+func withCache(message *types.Message) *types.Message {
+	blocks := message.Blocks
+	if len(blocks) == 0 {
+		if message.Content == "" {
+			return message
+		}
+		blocks = []*types.Content{{Type: "text", Text: message.Content}}
+	}
+	copied := &types.Message{Role: message.Role, Content: message.Content,
+		Blocks: append([]*types.Content{}, blocks...)}
+	last := blocks[len(blocks)-1]
+	copied.Blocks[len(blocks)-1] = &types.Content{Type: last.Type, Text: last.Text, Id: last.Id, Name: last.Name,
+		Input: last.Input, ToolUseId: last.ToolUseId, Content: last.Content, IsError: last.IsError, Cache: true}
+	return copied
+}
+
+// splitTurns splits the conversation at the user messages that carry a request,
+// tool results stay with the turn that asked for them
+func splitTurns(messages []*types.Message) [][]*types.Message {
+	turns := make([][]*types.Message, 0)
+	for _, message := range messages {
+		if len(turns) == 0 || (message.Role == "user" && len(message.Blocks) == 0) {
+			turns = append(turns, []*types.Message{message})
+			continue
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], message)
+	}
+	return turns
+}
+
+// compactTurn returns the turn with the file content of its tool calls and the code
+// blocks of its assistant text elided
+func compactTurn(turn []*types.Message, policy *ContextPolicy, report *types.ContextReport) []*types.Message {
+	compacted := make([]*types.Message, 0, len(turn))
+	for _, message := range turn {
+		if message.Role != "assistant" {
+			compacted = append(compacted, elideResults(message, policy, report))
+			continue
+		}
+		copied := &types.Message{Role: message.Role, Content: elideCode(message.Content, policy, report)}
+		for _, block := range message.Blocks {
+			copiedBlock := &types.Content{Type: block.Type, Text: elideCode(block.Text, policy, report), Id: block.Id,
+				Name: block.Name, Input: block.Input, ToolUseId: block.ToolUseId, Content: block.Content,
+				IsError: block.IsError}
+			if block.Type == "tool_use" {
+				copiedBlock.Input = elideInput(block.Input, policy, report)
+			}
+			copied.Blocks = append(copied.Blocks, copiedBlock)
+		}
+		compacted = append(compacted, copied)
+	}
+	return compacted
+}
+
+// elideResults returns the user message with long tool results, such as read files, elided
+func elideResults(message *types.Message, policy *ContextPolicy, report *types.ContextReport) *types.Message {
+	if len(message.Blocks) == 0 {
+		return message
+	}
+	copied := &types.Message{Role: message.Role, Content: message.Content}
+	for _, block := range message.Blocks {
+		copiedBlock := block
+		if block.Type == "tool_result" && len(block.Content) > policy.ElideBytes {
+			report.ElidedBlocks++
+			report.ElidedBytes += int64(len(block.Content))
+			copiedBlock = &types.Content{Type: block.Type, ToolUseId: block.ToolUseId, IsError: block.IsError,
+				Content: fmt.Sprintf("[%d bytes elided]", len(block.Content))}
+		}
+		copied.Blocks = append(copied.Blocks, copiedBlock)
+	}
+	return copied
+}
+
+func elideCode(text string, policy *ContextPolicy, report *types.ContextReport) string {
+	return fencePattern.ReplaceAllStringFunc(text, func(code string) string {
+		if len(code) <= policy.ElideBytes {
+			return code
+		}
+		report.ElidedBlocks++
+		report.ElidedBytes += int64(len(code))
+		return fmt.Sprintf("```\n[%d bytes elided, see the current workspace files]\n```", len(code))
+	})
+}
+
+func elideInput(input string, policy *ContextPolicy, report *types.ContextReport) string {
+	fields := make(map[string]interface{})
+	if json.Unmarshal([]byte(input), &fields) != nil {
+		return input
+	}
+	changed := false
+	for _, name := range elidedInputs {
+		value, ok := fields[name].(string)
+		if !ok || len(value) <= policy.ElideBytes {
+			continue
+		}
+		fields[name] = fmt.Sprintf("[%d bytes elided, see the current workspace files]", len(value))
+		report.ElidedBlocks++
+		report.ElidedBytes += int64(len(value))
+		changed = true
+	}
+	if !changed {
+		return input
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return input
+	}
+	return string(data)
+}
+
+// workspaceContext renders the current workspace files, files above the policy size and
+// binary files are summarized. Without contents only the paths and sizes are listed.
+func workspaceContext(project *types.Project, policy *ContextPolicy, contents bool) *workspaceSnapshot {
+	snapshot := &workspaceSnapshot{}
+	workspace, err := NewWorkspace(project)
+	if err != nil {
+		return snapshot
+	}
+	files, err := workspace.List()
+	if err != nil || len(files) == 0 {
+		return snapshot
+	}
+	snapshot.files = int32(len(files))
+	text := &strings.Builder{}
+	text.WriteString("The files of the workspace at the start of this request:\n")
+	for _, path := range files {
+		data, er := workspace.ReadFile(path)
+		if er != nil {
+			continue
+		}
+		if contents && utf8.Valid(data) && EstimateTokens(string(data)) <= policy.MaxFileTokens {
+			text.WriteString("<file path=\"" + path + "\">\n" + string(data) + "\n</file>\n")
+			continue
+		}
+		snapshot.summarized++
+		fmt.Fprintf(text, "<file path=\"%s\" bytes=\"%d\">\n%s</file>\n", path, len(data), summarize(data, contents))
+	}
+	snapshot.text = strings.TrimRight(text.String(), "\n")
+	return snapshot
+}
+
+// summarize returns the head of a text file, the model reads the rest with read_file
+func summarize(data []byte, contents bool) string {
+	if !contents || !utf8.Valid(data) {
+		return ""
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 20 {
+		lines = append(lines[:20], "... (truncated)\n")
+	}
+	return strings.Join(lines, "")
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	runes := []rune(line)
+	if len(runes) > 120 {
+		return string(runes[:120]) + "..."
+	}
+	return line
+}
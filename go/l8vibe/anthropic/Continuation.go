@@ -0,0 +1,112 @@
+package anthropic
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	STOP_TOOL_USE   = "tool_use"
+	STOP_MAX_TOKENS = "max_tokens"
+)
+
+var toolPathPattern = regexp.MustCompile(`"path"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+
+// maxContinuations returns the continuation cap of the project, zero selects the default
+// and a negative value turns continuations off
+func maxContinuations(settings *types.GenerationSettings) int {
+	if settings == nil || settings.MaxContinuations == 0 {
+		return consts.ANTHROPIC_MAX_CONTINUATIONS
+	}
+	if settings.MaxContinuations < 0 {
+		return 0
+	}
+	return int(settings.MaxContinuations)
+}
+
+// continueText continues a text response that stopped at max_tokens. The text so far is
+// sent back as a prefilled assistant message, the model picks up where it stopped and its
+// continuation is stitched to the text. Every continuation counts against the cap.
+func (this *Generator) continueText(backend *backend, turn *Turn, project *types.Project, resp *types.ClaudeResponse, used *int, limit int) (*types.ClaudeResponse, error) {
+	for resp.StopReason == STOP_MAX_TOKENS && *used < limit && onlyText(resp.Content) {
+		last := resp.Content[len(resp.Content)-1]
+		// the API rejects a prefill that ends with white space
+		last.Text = strings.TrimRight(last.Text, " \t\r\n")
+		if last.Text == "" {
+			return resp, nil
+		}
+		*used++
+		project.Messages = append(project.Messages, &types.Message{Role: "assistant", Blocks: resp.Content})
+		next, err := this.call(backend, turn, project)
+		project.Messages = project.Messages[:len(project.Messages)-1]
+		if err != nil {
+			return nil, err
+		}
+		if turn.OnResponse != nil {
+			turn.OnResponse(next)
+		}
+		blocks := next.Content
+		if len(blocks) > 0 && blocks[0].Type == "text" {
+			last.Text += blocks[0].Text
+			blocks = blocks[1:]
+		}
+		resp.Content = append(resp.Content, blocks...)
+		resp.StopReason = next.StopReason
+	}
+	return resp, nil
+}
+
+func onlyText(blocks []*types.Content) bool {
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return false
+		}
+	}
+	return len(blocks) > 0
+}
+
+// truncationNotice handles a max_tokens response that called tools, which cannot be
+// continued by prefilling. The tool call it was cut off in is removed as its input is
+// incomplete and must not be executed. It returns the notice that asks the model to
+// continue, or "" if the response is not such a response.
+func truncationNotice(resp *types.ClaudeResponse) string {
+	if resp.StopReason != STOP_MAX_TOKENS || onlyText(resp.Content) {
+		return ""
+	}
+	last := resp.Content[len(resp.Content)-1]
+	if last.Type != "tool_use" {
+		return "Your response was cut off at the output token limit, continue from where it stopped."
+	}
+	resp.Content = resp.Content[:len(resp.Content)-1]
+	if len(resp.Content) == 0 {
+		resp.Content = []*types.Content{{Type: "text", Text: "(The response was cut off at the output limit.)"}}
+	}
+	target := "a " + last.Name + " call"
+	match := toolPathPattern.FindStringSubmatch(last.Input)
+	if match != nil {
+		target = last.Name + " of " + match[1]
+	}
+	return "Your response was cut off at the output token limit in " + target + ", that call was not executed. " +
+		"Continue from there. Split large files: write a first part with write_file and add the rest with patch_file."
+}
+
+// continueTool returns the tool results of a response whose last tool call was cut off,
+// with the notice that asks the model to continue, counting against the cap
+func continueTool(resp *types.ClaudeResponse, notice string, project *types.Project, used *int, limit int) ([]*types.Content, error) {
+	if *used >= limit {
+		return nil, truncatedError(limit)
+	}
+	*used++
+	results := ExecuteTools(resp.Content, project)
+	return append(results, &types.Content{Type: "text", Text: notice}), nil
+}
+
+func truncatedError(limit int) error {
+	return errors.New("the response was still cut off at the output token limit after " + strconv.Itoa(limit) +
+		" continuations")
+}
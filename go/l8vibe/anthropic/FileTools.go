@@ -0,0 +1,184 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	TOOL_WRITE_FILE  = "write_file"
+	TOOL_PATCH_FILE  = "patch_file"
+	TOOL_DELETE_FILE = "delete_file"
+	TOOL_RENAME_FILE = "rename_file"
+	TOOL_READ_FILE   = "read_file"
+)
+
+// FileToolInput is the union of the inputs of the file tools
+type FileToolInput struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	OldText string `json:"old_text"`
+	NewText string `json:"new_text"`
+	Patch   string `json:"patch"`
+	NewPath string `json:"new_path"`
+}
+
+// FileTools returns the tools the model uses to change the project workspace
+func FileTools() []*types.Tool {
+	return []*types.Tool{
+		{Name: TOOL_WRITE_FILE,
+			Description: "Create a file in the project, or replace its entire content. Always provide the complete file content.",
+			InputSchema: `{"type":"object","properties":{` +
+				`"path":{"type":"string","description":"File path relative to the project root, e.g. index.html or js/app.js"},` +
+				`"content":{"type":"string","description":"The complete content of the file"}},` +
+				`"required":["path","content"]}`},
+		{Name: TOOL_PATCH_FILE,
+			Description: "Change part of an existing file. Either provide patch, a unified diff (@@ hunks with context lines) " +
+				"or one or more SEARCH/REPLACE blocks in the form\n<<<<<<< SEARCH\nlines to find\n=======\nreplacement lines\n>>>>>>> REPLACE\n" +
+				"or replace a single exact, unique occurrence of old_text with new_text. " +
+				"If some hunks fail nothing is changed and the failed hunks are reported back.",
+			InputSchema: `{"type":"object","properties":{` +
+				`"path":{"type":"string","description":"File path relative to the project root"},` +
+				`"patch":{"type":"string","description":"A unified diff or SEARCH/REPLACE blocks for this file"},` +
+				`"old_text":{"type":"string","description":"The exact text to replace, must occur once in the file"},` +
+				`"new_text":{"type":"string","description":"The replacement text"}},` +
+				`"required":["path"]}`},
+		{Name: TOOL_DELETE_FILE,
+			Description: "Delete a file from the project.",
+			InputSchema: `{"type":"object","properties":{` +
+				`"path":{"type":"string","description":"File path relative to the project root"}},` +
+				`"required":["path"]}`},
+		{Name: TOOL_RENAME_FILE,
+			Description: "Rename or move a file within the project.",
+			InputSchema: `{"type":"object","properties":{` +
+				`"path":{"type":"string","description":"Current file path relative to the project root"},` +
+				`"new_path":{"type":"string","description":"New file path relative to the project root"}},` +
+				`"required":["path","new_path"]}`},
+		{Name: TOOL_READ_FILE,
+			Description: "Read the current content of a file in the project. Earlier file content in the conversation " +
+				"may be elided, read a file before patching it if its current content is not shown.",
+			InputSchema: `{"type":"object","properties":{` +
+				`"path":{"type":"string","description":"File path relative to the project root"}},` +
+				`"required":["path"]}`},
+	}
+}
+
+// ExecuteTools runs every tool_use block of an assistant response against the
+// project workspace and returns the matching tool_result blocks.
+func ExecuteTools(blocks []*types.Content, project *types.Project) []*types.Content {
+	results := make([]*types.Content, 0)
+	workspace, wsErr := NewWorkspace(project)
+	for _, block := range blocks {
+		if block.Type != "tool_use" {
+			continue
+		}
+		result := &types.Content{Type: "tool_result", ToolUseId: block.Id}
+		err := wsErr
+		msg := ""
+		if err == nil {
+			msg, err = ExecuteTool(block.Name, block.Input, workspace)
+		}
+		if err != nil {
+			result.Content = err.Error()
+			result.IsError = true
+		} else {
+			result.Content = msg
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// ToolPaths returns the workspace paths the file tool calls of the blocks touch
+func ToolPaths(blocks []*types.Content) []string {
+	paths := make([]string, 0)
+	for _, block := range blocks {
+		if block.Type != "tool_use" || block.Name == TOOL_READ_FILE {
+			continue
+		}
+		in := &FileToolInput{}
+		if json.Unmarshal([]byte(block.Input), in) != nil {
+			continue
+		}
+		for _, path := range []string{in.Path, in.NewPath} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// ExecuteTool validates the input of a single tool call and applies it to the workspace
+func ExecuteTool(name, input string, workspace *Workspace) (string, error) {
+	in := &FileToolInput{}
+	err := json.Unmarshal([]byte(input), in)
+	if err != nil {
+		return "", errors.New("invalid input for " + name + ": " + err.Error())
+	}
+
+	switch name {
+	case TOOL_WRITE_FILE:
+		err = workspace.WriteFile(in.Path, []byte(in.Content))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote %s (%d bytes)", in.Path, len(in.Content)), nil
+	case TOOL_PATCH_FILE:
+		data, er := workspace.ReadFile(in.Path)
+		if er != nil {
+			if os.IsNotExist(er) {
+				return "", errors.New(in.Path + " does not exist, use " + TOOL_WRITE_FILE + " to create it")
+			}
+			return "", er
+		}
+		patched := ""
+		if in.Patch != "" {
+			patched, err = ApplyPatch(string(data), in.Patch)
+			if err != nil {
+				return "", err
+			}
+		} else {
+			count := strings.Count(string(data), in.OldText)
+			if in.OldText == "" || count == 0 {
+				return "", errors.New("old_text was not found in " + in.Path)
+			}
+			if count > 1 {
+				return "", fmt.Errorf("old_text occurs %d times in %s, include more context to make it unique", count, in.Path)
+			}
+			patched = strings.Replace(string(data), in.OldText, in.NewText, 1)
+		}
+		err = workspace.WriteFile(in.Path, []byte(patched))
+		if err != nil {
+			return "", err
+		}
+		return "Patched " + in.Path, nil
+	case TOOL_DELETE_FILE:
+		err = workspace.Remove(in.Path)
+		if err != nil {
+			return "", err
+		}
+		return "Deleted " + in.Path, nil
+	case TOOL_RENAME_FILE:
+		err = workspace.Rename(in.Path, in.NewPath)
+		if err != nil {
+			return "", err
+		}
+		return "Renamed " + in.Path + " to " + in.NewPath, nil
+	case TOOL_READ_FILE:
+		data, er := workspace.ReadFile(in.Path)
+		if er != nil {
+			if os.IsNotExist(er) {
+				return "", errors.New(in.Path + " does not exist")
+			}
+			return "", er
+		}
+		return string(data), nil
+	}
+	return "", errors.New("unknown tool " + name)
+}
@@ -0,0 +1,294 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// Turn carries the options of a single conversation turn. Cancelling the Context
+// aborts the in-flight request, OnDelta turns on streaming and receives the text as it
+// arrives, OnResponse observes every model response of the turn and OnRetry is told
+// about a failed call that is retried after the wait. OnContext receives the report of
+// how the conversation was fitted into the context window for every call. ApiKey is the
+// key the provider is called with, without it the key of the project or else the key the
+// provider was configured with.
+type Turn struct {
+	Context    context.Context
+	ApiKey     string
+	OnDelta    func(string)
+	OnResponse func(*types.ClaudeResponse)
+	OnRetry    func(err *APIError, wait time.Duration)
+	OnContext  func(*types.ContextReport)
+	snapshots  workspaceSnapshots
+}
+
+func (this *Turn) apiKey(project *types.Project) string {
+	if this.ApiKey != "" {
+		return this.ApiKey
+	}
+	return project.ApiKey
+}
+
+// Generator runs the turns of the projects against the provider each project selects.
+// Every provider has its own rate limiter and circuit breaker, so a failing local server
+// does not stop the projects that use another provider.
+type Generator struct {
+	providers map[string]*backend
+}
+
+type backend struct {
+	provider LLMProvider
+	limiter  *RateLimiter
+	breaker  *CircuitBreaker
+}
+
+// NewGenerator returns a generator of the providers, the first one is the default
+func NewGenerator(providers ...LLMProvider) *Generator {
+	generator := &Generator{providers: make(map[string]*backend)}
+	for _, provider := range providers {
+		generator.Add(provider, consts.ANTHROPIC_RPM)
+	}
+	return generator
+}
+
+// NewGeneratorFromConfig returns a generator of the Anthropic provider and, if it is
+// configured, the OpenAI compatible provider. If a cassette is configured the providers
+// record their exchanges to it or replay them from it.
+func NewGeneratorFromConfig(config *common.ServiceConfig) (*Generator, error) {
+	cassette, err := CassetteFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	generator := &Generator{providers: make(map[string]*backend)}
+	client, err := NewAnthropicClientWith(config.Anthropic)
+	if err != nil {
+		return nil, err
+	}
+	if cassette != nil {
+		fmt.Println("Model providers use the cassette ", cassette.file, " to ", cassette.Mode())
+		client.UseCassette(cassette)
+	}
+	generator.Add(client, config.Anthropic.RPM)
+	if config.OpenAI != nil {
+		openai, er := NewOpenAIProviderWith(config.OpenAI)
+		if er != nil {
+			return nil, er
+		}
+		if cassette != nil {
+			openai.UseCassette(cassette)
+		}
+		generator.Add(openai, config.OpenAI.RPM)
+	}
+	return generator, nil
+}
+
+// Add adds the provider, limited to rpm requests per minute
+func (this *Generator) Add(provider LLMProvider, rpm int) {
+	this.providers[provider.Name()] = &backend{provider: provider,
+		limiter: NewRateLimiter(rpm, consts.ANTHROPIC_BURST),
+		breaker: NewCircuitBreaker(consts.ANTHROPIC_BREAKER_THRESHOLD, consts.ANTHROPIC_BREAKER_COOLDOWN)}
+}
+
+// Provider returns the provider the settings select, Anthropic by default
+func (this *Generator) Provider(settings *types.GenerationSettings) (LLMProvider, error) {
+	backend, err := this.backend(settings)
+	if err != nil {
+		return nil, err
+	}
+	return backend.provider, nil
+}
+
+func (this *Generator) backend(settings *types.GenerationSettings) (*backend, error) {
+	name := PROVIDER_ANTHROPIC
+	if settings != nil && settings.Provider != "" {
+		name = settings.Provider
+	}
+	backend, ok := this.providers[name]
+	if !ok {
+		return nil, errors.New("Provider " + name + " is not configured")
+	}
+	return backend, nil
+}
+
+// Run sends the text as the next user turn. The model changes the workspace through
+// the file tools, so Run keeps executing tool calls and returning their results until
+// the model ends its turn. All exchanged messages are appended to the project.
+func (this *Generator) Run(turn *Turn, text string, project *types.Project) error {
+	backend, err := this.backend(project.Settings)
+	if err != nil {
+		return err
+	}
+	project.Messages = append(project.Messages, &types.Message{Role: "user", Content: text})
+	// the workspace is rendered once per turn, so all its rounds share the cached prefix
+	turn.snapshots = make(workspaceSnapshots)
+	// responses cut off at max_tokens are continued up to the cap for the whole turn
+	continuations, limit := 0, maxContinuations(project.Settings)
+	for i := 0; i < consts.ANTHROPIC_MAX_TOOL_ROUNDS; i++ {
+		resp, err := this.call(backend, turn, project)
+		if err != nil {
+			return err
+		}
+		if len(resp.Content) == 0 {
+			return errors.New("response has no content")
+		}
+		if turn.OnResponse != nil {
+			turn.OnResponse(resp)
+		}
+		if backend.provider.Capabilities().Prefill {
+			resp, err = this.continueText(backend, turn, project, resp, &continuations, limit)
+			if err != nil {
+				return err
+			}
+		}
+		notice := truncationNotice(resp)
+		if notice != "" {
+			project.Messages = append(project.Messages, assistantMessage(resp))
+			results, er := continueTool(resp, notice, project, &continuations, limit)
+			if er != nil {
+				return er
+			}
+			project.Messages = append(project.Messages, &types.Message{Role: "user", Blocks: results})
+			continue
+		}
+		if resp.StopReason == STOP_MAX_TOKENS {
+			return truncatedError(limit)
+		}
+		message := assistantMessage(resp)
+		if !backend.provider.Capabilities().Tools {
+			// the files are in the code blocks of the text, they are applied by parsing it
+			message.Blocks = nil
+		}
+		project.Messages = append(project.Messages, message)
+		if resp.StopReason != STOP_TOOL_USE {
+			return nil
+		}
+		project.Messages = append(project.Messages,
+			&types.Message{Role: "user", Blocks: ExecuteTools(resp.Content, project)})
+	}
+	return errors.New("model did not finish its turn within " + strconv.Itoa(consts.ANTHROPIC_MAX_TOOL_ROUNDS) + " tool rounds")
+}
+
+// call sends the conversation, retrying the retryable failures with exponential backoff
+// and jitter, or after the wait the server asked for if that is longer
+func (this *Generator) call(backend *backend, turn *Turn, project *types.Project) (*types.ClaudeResponse, error) {
+	for attempt := 0; ; attempt++ {
+		err := backend.breaker.Allow()
+		if err != nil {
+			return nil, err
+		}
+		err = backend.limiter.Wait(turn.Context)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := this.send(backend.provider, turn, project)
+		apiErr := &APIError{}
+		if !errors.As(err, &apiErr) {
+			// success, or a failure that says nothing about the upstream
+			if err == nil {
+				backend.breaker.Record(nil)
+			}
+			return resp, err
+		}
+		backend.breaker.Record(apiErr)
+		if apiErr.Kind == ERROR_RATE_LIMIT && apiErr.RetryAfter > 0 {
+			backend.limiter.Hold(time.Now().Add(apiErr.RetryAfter))
+		}
+		if !apiErr.Retryable() || attempt >= consts.ANTHROPIC_MAX_RETRIES {
+			return nil, err
+		}
+		wait := backoff(attempt, apiErr.RetryAfter)
+		if turn.OnRetry != nil {
+			turn.OnRetry(apiErr, wait)
+		}
+		err = sleep(turn.Context, wait)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send builds the request of the conversation and sends it once, streaming if the turn
+// asks for it. A provider that cannot stream delivers the text when the response is complete.
+func (this *Generator) send(provider LLMProvider, turn *Turn, project *types.Project) (*types.ClaudeResponse, error) {
+	caps := provider.Capabilities()
+	request, err := this.buildRequest(provider, turn, project)
+	if err != nil {
+		return nil, err
+	}
+	if turn.OnDelta == nil {
+		return provider.Send(turn.Context, request, turn.apiKey(project))
+	}
+	if caps.Streaming {
+		request.Stream = true
+		return provider.Stream(turn.Context, request, turn.apiKey(project), turn.OnDelta)
+	}
+	resp, err := provider.Send(turn.Context, request, turn.apiKey(project))
+	if err == nil {
+		for _, block := range resp.Content {
+			if block.Type == "text" && block.Text != "" {
+				turn.OnDelta(block.Text)
+			}
+		}
+	}
+	return resp, err
+}
+
+// buildRequest builds the request from the project settings and conversation, fitted
+// into the context window of the provider by the context builder. A context that is
+// estimated close to the window is counted by the provider and refused if it does not fit.
+func (this *Generator) buildRequest(provider LLMProvider, turn *Turn, project *types.Project) (*types.ClaudeRequest, error) {
+	caps := provider.Capabilities()
+	request := &types.ClaudeRequest{}
+	err := applySettings(request, project.Settings, caps)
+	if err != nil {
+		return nil, err
+	}
+	request.CacheSystem = caps.PromptCaching
+	if caps.Tools {
+		request.Tools = FileTools()
+	}
+	reserved := request.MaxTokens + EstimateTokens(request.System)
+	for _, tool := range request.Tools {
+		reserved += EstimateTokens(tool.Description) + EstimateTokens(tool.InputSchema)
+	}
+	policy := DefaultContextPolicy()
+	policy.Window = caps.ContextWindow
+	var report *types.ContextReport
+	request.Messages, report = buildContext(project, policy, reserved, turn.snapshots)
+	if caps.CountTokens && report.EstimatedTokens > policy.Window*9/10 {
+		counted, er := provider.CountTokens(turn.Context, request, turn.apiKey(project))
+		if er == nil {
+			report.CountedTokens = counted
+		}
+	}
+	if turn.OnContext != nil {
+		turn.OnContext(report)
+	}
+	if report.CountedTokens > 0 && report.CountedTokens+request.MaxTokens > policy.Window {
+		return nil, &APIError{Kind: ERROR_CONTEXT_TOO_LONG, Message: "the conversation needs " +
+			strconv.FormatInt(report.CountedTokens, 10) + " input tokens, more than the context window allows"}
+	}
+	return request, nil
+}
+
+// backoff returns a random wait of up to the exponential backoff of the attempt,
+// but at least what the server asked for
+func backoff(attempt int, retryAfter time.Duration) time.Duration {
+	ceiling := consts.ANTHROPIC_RETRY_BASE << attempt
+	if ceiling > consts.ANTHROPIC_RETRY_MAX || ceiling <= 0 {
+		ceiling = consts.ANTHROPIC_RETRY_MAX
+	}
+	wait := time.Duration(rand.Int63n(int64(ceiling)))
+	if retryAfter > wait {
+		return retryAfter
+	}
+	return wait
+}
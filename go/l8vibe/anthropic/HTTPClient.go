@@ -0,0 +1,108 @@
+package anthropic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+)
+
+// NewHTTPClient returns the http client of a model provider. Servers are verified against
+// the system roots and the CA bundle of the configuration, the client certificate is
+// presented when one is configured and requests go through the configured proxy unless
+// the host is in the no proxy list.
+func NewHTTPClient(config *common.ProviderConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CAFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("No certificates found in " + config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	proxy, err := proxyFunc(config.Proxy, config.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	return &http.Client{Timeout: time.Second * 600, Transport: transport}, nil
+}
+
+// proxyFunc returns the proxy selection of the configuration, the environment when no
+// proxy is configured. The no proxy list of the configuration applies to both.
+func proxyFunc(proxy, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	next := http.ProxyFromEnvironment
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		next = http.ProxyURL(proxyURL)
+	}
+	return func(request *http.Request) (*url.URL, error) {
+		if bypassProxy(request.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return next(request)
+	}, nil
+}
+
+// bypassProxy reports whether the host is in the no proxy list. Entries are host names,
+// which match their subdomains as well, IP addresses, CIDRs and * for all hosts.
+func bypassProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, "*")
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
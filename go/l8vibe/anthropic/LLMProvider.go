@@ -0,0 +1,53 @@
+package anthropic
+
+import (
+	"context"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	PROVIDER_ANTHROPIC = "anthropic"
+	PROVIDER_OPENAI    = "openai"
+)
+
+// ProviderNames are the providers a project can select in its settings
+var ProviderNames = []string{PROVIDER_ANTHROPIC, PROVIDER_OPENAI}
+
+// Capabilities describe what a provider supports, the generator adapts the requests to them
+type Capabilities struct {
+	// DefaultModel is used when the project does not choose a model
+	DefaultModel string
+	// ContextWindow is the context window of the models in tokens
+	ContextWindow int64
+	// Streaming is set when Stream delivers the text as it is generated
+	Streaming bool
+	// Tools is set when the model calls the file tools, otherwise it writes the files as
+	// markdown code blocks that are parsed from the response
+	Tools bool
+	// PromptCaching is set when cache breakpoints are honored
+	PromptCaching bool
+	// Prefill is set when the model continues a trailing assistant message
+	Prefill bool
+	// CountTokens is set when CountTokens asks the provider rather than estimating
+	CountTokens bool
+	// Metered is set when the usage is billed at the list prices of the models
+	Metered bool
+}
+
+// LLMProvider is a model backend. The request is the provider neutral ClaudeRequest the
+// generator built from the project conversation, the provider translates it to its own
+// protocol and the response back. Failures are reported as classified APIErrors so the
+// generator can retry them. An empty apiKey selects the key the provider was configured with.
+type LLMProvider interface {
+	// Name returns the name projects select the provider with
+	Name() string
+	// Send sends the request and returns the complete response
+	Send(ctx context.Context, request *types.ClaudeRequest, apiKey string) (*types.ClaudeResponse, error)
+	// Stream sends the request and hands the text to onDelta as it arrives
+	Stream(ctx context.Context, request *types.ClaudeRequest, apiKey string, onDelta func(string)) (*types.ClaudeResponse, error)
+	// CountTokens returns the input tokens of the request
+	CountTokens(ctx context.Context, request *types.ClaudeRequest, apiKey string) (int64, error)
+	// Capabilities returns what the provider supports
+	Capabilities() Capabilities
+}
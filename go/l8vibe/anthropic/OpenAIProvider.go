@@ -0,0 +1,215 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// OpenAIProvider is the LLMProvider of an OpenAI compatible chat completions API, such as
+// the self hosted vLLM, llama.cpp and Ollama servers
+type OpenAIProvider struct {
+	httpClient    *http.Client
+	baseURL       string
+	apiKey        string
+	model         string
+	contextWindow int64
+	tools         bool
+}
+
+// NewOpenAIProvider returns the provider of the chat completions API under baseURL,
+// e.g. http://localhost:8000/v1. Models that cannot call tools write the files as
+// markdown code blocks instead.
+func NewOpenAIProvider(baseURL, apiKey, model string, contextWindow int64, tools bool) *OpenAIProvider {
+	// without files to load the configuration cannot fail
+	provider, _ := NewOpenAIProviderWith(&common.ProviderConfig{BaseURL: baseURL, APIKey: apiKey, Model: model,
+		ContextWindow: contextWindow, Tools: &tools})
+	return provider
+}
+
+// NewOpenAIProviderWith returns the provider of the provider configuration
+func NewOpenAIProviderWith(config *common.ProviderConfig) (*OpenAIProvider, error) {
+	httpClient, err := NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	contextWindow := config.ContextWindow
+	if contextWindow <= 0 {
+		contextWindow = consts.OPENAI_CONTEXT_WINDOW
+	}
+	return &OpenAIProvider{httpClient: httpClient, baseURL: strings.TrimRight(config.BaseURL, "/"),
+		apiKey: config.APIKey, model: config.Model, contextWindow: contextWindow, tools: config.ToolsEnabled()}, nil
+}
+
+// UseCassette routes the requests through the cassette, which records or replays them
+func (this *OpenAIProvider) UseCassette(cassette *Cassette) {
+	this.httpClient.Transport = cassette.Wrap(this.httpClient.Transport)
+}
+
+func (this *OpenAIProvider) Name() string {
+	return PROVIDER_OPENAI
+}
+
+func (this *OpenAIProvider) Capabilities() Capabilities {
+	return Capabilities{DefaultModel: this.model, ContextWindow: this.contextWindow, Streaming: true, Tools: this.tools}
+}
+
+func (this *OpenAIProvider) Send(ctx context.Context, body *types.ClaudeRequest, apiKey string) (*types.ClaudeResponse, error) {
+	response, err := this.post(ctx, body, apiKey, false)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	jsonBytes, err := readBody(response)
+	if err != nil {
+		return nil, err
+	}
+	completion := &openaiCompletion{}
+	err = json.Unmarshal(jsonBytes, completion)
+	if err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 {
+		return nil, &APIError{Kind: ERROR_SERVER, Message: "completion has no choices"}
+	}
+	choice := completion.Choices[0]
+	return fromOpenAI(completion, choice.Message, choice.FinishReason), nil
+}
+
+// Stream reads the server-sent chunks of the completion until [DONE] and assembles
+// the equivalent complete response
+func (this *OpenAIProvider) Stream(ctx context.Context, body *types.ClaudeRequest, apiKey string, onDelta func(string)) (*types.ClaudeResponse, error) {
+	response, err := this.post(ctx, body, apiKey, true)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		_, err = readBody(response)
+		return nil, err
+	}
+	resp, err := readOpenAIStream(bufio.NewReader(response.Body), onDelta)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// CountTokens estimates the input tokens, the chat completions API has no counting endpoint
+func (this *OpenAIProvider) CountTokens(ctx context.Context, body *types.ClaudeRequest, apiKey string) (int64, error) {
+	tokens := EstimateTokens(body.System)
+	for _, msg := range body.Messages {
+		tokens += EstimateTokens(msg.Content)
+		for _, block := range msg.Blocks {
+			tokens += EstimateTokens(block.Text) + EstimateTokens(block.Input) + EstimateTokens(block.Content)
+		}
+	}
+	for _, tool := range body.Tools {
+		tokens += EstimateTokens(tool.Description) + EstimateTokens(tool.InputSchema)
+	}
+	return tokens, nil
+}
+
+func (this *OpenAIProvider) post(ctx context.Context, body *types.ClaudeRequest, apiKey string, stream bool) (*http.Response, error) {
+	jsonBody, err := json.Marshal(toOpenAI(body, stream))
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", this.baseURL+"/chat/completions", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		apiKey = this.apiKey
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if stream {
+		request.Header.Set("Accept", "text/event-stream")
+	}
+	response, err := this.httpClient.Do(request)
+	if err != nil {
+		return nil, networkError(ctx, err)
+	}
+	return response, nil
+}
+
+// readOpenAIStream consumes the chunks of a streamed completion. Tool call arguments
+// arrive in pieces keyed by the index of the call.
+func readOpenAIStream(reader *bufio.Reader, onDelta func(string)) (*types.ClaudeResponse, error) {
+	completion := &openaiCompletion{}
+	message := &openaiMessage{}
+	var text strings.Builder
+	finish := ""
+	delivered := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, &APIError{Kind: ERROR_NETWORK, Message: "stream ended before [DONE]: " + err.Error(),
+				Partial: delivered}
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(line[5:])
+		if data == "[DONE]" {
+			if text.Len() > 0 {
+				message.Content = text.String()
+			}
+			return fromOpenAI(completion, message, finish), nil
+		}
+		chunk := &openaiCompletion{}
+		er := json.Unmarshal([]byte(data), chunk)
+		if er != nil {
+			return nil, er
+		}
+		if chunk.Error != nil {
+			return nil, streamAPIError(chunk.Error, delivered)
+		}
+		completion.Id, completion.Model = chunk.Id, chunk.Model
+		if chunk.Usage != nil {
+			completion.Usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finish = choice.FinishReason
+		}
+		if choice.Delta == nil {
+			continue
+		}
+		content, _ := choice.Delta.Content.(string)
+		if content != "" {
+			text.WriteString(content)
+			if onDelta != nil {
+				onDelta(content)
+				delivered = true
+			}
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			for len(message.ToolCalls) <= call.Index {
+				message.ToolCalls = append(message.ToolCalls, &openaiToolCall{Type: "function", Function: &openaiFunction{}})
+			}
+			existing := message.ToolCalls[call.Index]
+			if call.Id != "" {
+				existing.Id = call.Id
+			}
+			if call.Function != nil {
+				existing.Function.Name += call.Function.Name
+				existing.Function.Arguments += call.Function.Arguments
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// The chat completions API carries the system prompt as the first message, the tool
+// calls on the assistant message and every tool result as a message of its own. The
+// wire types below translate the Messages API conversation to it and the completion back.
+
+type openaiRequest struct {
+	Model         string           `json:"model,omitempty"`
+	Messages      []*openaiMessage `json:"messages"`
+	MaxTokens     int64            `json:"max_tokens,omitempty"`
+	Temperature   *float64         `json:"temperature,omitempty"`
+	TopP          *float64         `json:"top_p,omitempty"`
+	Stop          []string         `json:"stop,omitempty"`
+	Stream        bool             `json:"stream,omitempty"`
+	StreamOptions *streamOptions   `json:"stream_options,omitempty"`
+	Tools         []*openaiTool    `json:"tools,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openaiMessage struct {
+	Role       string            `json:"role,omitempty"`
+	Content    interface{}       `json:"content"`
+	ToolCalls  []*openaiToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string            `json:"tool_call_id,omitempty"`
+}
+
+type openaiToolCall struct {
+	Index    int             `json:"index"`
+	Id       string          `json:"id,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Function *openaiFunction `json:"function"`
+}
+
+type openaiFunction struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Arguments   string          `json:"arguments,omitempty"`
+}
+
+type openaiTool struct {
+	Type     string          `json:"type"`
+	Function *openaiFunction `json:"function"`
+}
+
+type openaiCompletion struct {
+	Id      string          `json:"id"`
+	Model   string          `json:"model"`
+	Choices []*openaiChoice `json:"choices"`
+	Usage   *openaiUsage    `json:"usage"`
+	Error   *streamError    `json:"error"`
+}
+
+type openaiChoice struct {
+	Message      *openaiMessage `json:"message"`
+	Delta        *openaiMessage `json:"delta"`
+	FinishReason string         `json:"finish_reason"`
+}
+
+type openaiUsage struct {
+	PromptTokens        int32 `json:"prompt_tokens"`
+	CompletionTokens    int32 `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int32 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// finishReasons maps the finish reasons to the stop reasons of the Messages API
+var finishReasons = map[string]string{
+	"stop":           "end_turn",
+	"length":         STOP_MAX_TOKENS,
+	"tool_calls":     STOP_TOOL_USE,
+	"function_call":  STOP_TOOL_USE,
+	"content_filter": "refusal",
+}
+
+func toOpenAI(body *types.ClaudeRequest, stream bool) *openaiRequest {
+	req := &openaiRequest{Model: body.Model, MaxTokens: body.MaxTokens, Temperature: body.Temperature,
+		TopP: body.TopP, Stop: body.StopSequences, Stream: stream}
+	if stream {
+		req.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	if body.System != "" {
+		req.Messages = append(req.Messages, &openaiMessage{Role: "system", Content: body.System})
+	}
+	for _, msg := range body.Messages {
+		req.Messages = append(req.Messages, toOpenAIMessages(msg)...)
+	}
+	for _, tool := range body.Tools {
+		req.Tools = append(req.Tools, &openaiTool{Type: "function", Function: &openaiFunction{Name: tool.Name,
+			Description: tool.Description, Parameters: json.RawMessage(tool.InputSchema)}})
+	}
+	return req
+}
+
+// toOpenAIMessages translates a message, the tool results of a user message become
+// tool messages that precede its text
+func toOpenAIMessages(msg *types.Message) []*openaiMessage {
+	if len(msg.Blocks) == 0 {
+		return []*openaiMessage{{Role: msg.Role, Content: msg.Content}}
+	}
+	messages := make([]*openaiMessage, 0)
+	out := &openaiMessage{Role: msg.Role}
+	var text []string
+	for _, block := range msg.Blocks {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			arguments := block.Input
+			if arguments == "" {
+				arguments = "{}"
+			}
+			out.ToolCalls = append(out.ToolCalls, &openaiToolCall{Id: block.Id, Type: "function",
+				Function: &openaiFunction{Name: block.Name, Arguments: arguments}})
+		case "tool_result":
+			content := block.Content
+			if block.IsError {
+				content = "Error: " + content
+			}
+			messages = append(messages, &openaiMessage{Role: "tool", ToolCallId: block.ToolUseId, Content: content})
+		}
+	}
+	if len(text) > 0 {
+		out.Content = strings.Join(text, "\n\n")
+	}
+	if out.Content != nil || len(out.ToolCalls) > 0 {
+		messages = append(messages, out)
+	}
+	return messages
+}
+
+func fromOpenAI(completion *openaiCompletion, message *openaiMessage, finish string) *types.ClaudeResponse {
+	resp := &types.ClaudeResponse{Id: completion.Id, Type: "message", Role: "assistant", Model: completion.Model,
+		StopReason: finishReasons[finish]}
+	if resp.StopReason == "" {
+		resp.StopReason = "end_turn"
+	}
+	if message != nil {
+		text, _ := message.Content.(string)
+		if text != "" {
+			resp.Content = append(resp.Content, &types.Content{Type: "text", Text: text})
+		}
+		for _, call := range message.ToolCalls {
+			if call.Function == nil {
+				continue
+			}
+			resp.Content = append(resp.Content, &types.Content{Type: "tool_use", Id: call.Id,
+				Name: call.Function.Name, Input: call.Function.Arguments})
+		}
+	}
+	if completion.Usage != nil {
+		// the cached tokens are part of the prompt tokens, the Messages API counts them apart
+		resp.Usage = &types.Usage{InputTokens: completion.Usage.PromptTokens,
+			OutputTokens: completion.Usage.CompletionTokens}
+		if completion.Usage.PromptTokensDetails != nil {
+			cached := completion.Usage.PromptTokensDetails.CachedTokens
+			resp.Usage.InputTokens -= cached
+			resp.Usage.CacheReadInputTokens = cached
+		}
+	}
+	return resp
+}
@@ -0,0 +1,290 @@
+package anthropic
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Hunk is a single change of a patch, the lines to locate in the file and the
+// lines that replace them. Leading and Trailing count the unchanged context lines
+// at the edges of Old, which may be dropped when the hunk does not match exactly.
+// Hint is the line number of the unified diff header when Numbered, 0 inserts at the top.
+type Hunk struct {
+	Index    int
+	Hint     int
+	Numbered bool
+	Old      []string
+	New      []string
+	Leading  int
+	Trailing int
+}
+
+// HunkFailure describes a hunk that could not be applied
+type HunkFailure struct {
+	Hunk   int
+	Reason string
+	Search string
+}
+
+// PatchError is returned by ApplyPatch when any hunk fails. The file is left unchanged,
+// the message lists the failed hunks so it can be sent back to the model for a retry.
+type PatchError struct {
+	Hunks    int
+	Failures []*HunkFailure
+}
+
+func (this *PatchError) Error() string {
+	msg := strings.Builder{}
+	msg.WriteString(fmt.Sprintf("%d of %d hunks failed, no changes were applied.", len(this.Failures), this.Hunks))
+	for _, failure := range this.Failures {
+		msg.WriteString(fmt.Sprintf("\nHunk #%d: %s", failure.Hunk, failure.Reason))
+		if failure.Search != "" {
+			msg.WriteString("\n" + failure.Search)
+		}
+	}
+	msg.WriteString("\nRe-read the file content and resend the failed hunks with context that matches it exactly.")
+	return msg.String()
+}
+
+var (
+	hunkHeaderPattern = regexp.MustCompile(`^@@\s*(?:-(\d+)(?:,\d+)?\s+\+\d+(?:,\d+)?\s*)?@@`)
+	searchPattern     = regexp.MustCompile(`^<{5,}\s*SEARCH\s*$`)
+	dividerPattern    = regexp.MustCompile(`^={5,}\s*$`)
+	replacePattern    = regexp.MustCompile(`^>{5,}\s*REPLACE\s*$`)
+)
+
+// IsPatch reports whether the text is a unified diff or SEARCH/REPLACE blocks
+// rather than complete file content.
+func IsPatch(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if hunkHeaderPattern.MatchString(line) || searchPattern.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePatch parses a unified diff or a sequence of SEARCH/REPLACE blocks
+func ParsePatch(text string) ([]*Hunk, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, line := range strings.Split(text, "\n") {
+		if searchPattern.MatchString(strings.TrimSpace(line)) {
+			return parseSearchReplace(text)
+		}
+	}
+	return parseUnifiedDiff(text)
+}
+
+func parseUnifiedDiff(text string) ([]*Hunk, error) {
+	hunks := make([]*Hunk, 0)
+	var hunk *Hunk
+	inContext := true
+	for _, line := range strings.Split(text, "\n") {
+		match := hunkHeaderPattern.FindStringSubmatch(line)
+		if match != nil {
+			hunk = &Hunk{Index: len(hunks) + 1}
+			if match[1] != "" {
+				hunk.Hint, _ = strconv.Atoi(match[1])
+				hunk.Numbered = true
+			}
+			hunks = append(hunks, hunk)
+			inContext = true
+			continue
+		}
+		if hunk == nil || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") ||
+			strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "index ") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file"
+		case strings.HasPrefix(line, "-"):
+			hunk.Old = append(hunk.Old, line[1:])
+			hunk.Trailing = 0
+			inContext = false
+		case strings.HasPrefix(line, "+"):
+			hunk.New = append(hunk.New, line[1:])
+			hunk.Trailing = 0
+			inContext = false
+		default:
+			// context line, models often drop the leading space of empty lines
+			if strings.HasPrefix(line, " ") {
+				line = line[1:]
+			}
+			hunk.Old = append(hunk.Old, line)
+			hunk.New = append(hunk.New, line)
+			if inContext {
+				hunk.Leading++
+			} else {
+				hunk.Trailing++
+			}
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, errors.New("patch has no hunks")
+	}
+	for _, h := range hunks {
+		// the split leaves an empty context line for the final newline of the patch
+		if h.Trailing > 0 && h.Old[len(h.Old)-1] == "" && h.New[len(h.New)-1] == "" {
+			h.Old = h.Old[:len(h.Old)-1]
+			h.New = h.New[:len(h.New)-1]
+			h.Trailing--
+		}
+		if h.Leading == len(h.Old) && len(h.Old) == len(h.New) {
+			h.Leading = 0
+			h.Trailing = 0
+		}
+	}
+	return hunks, nil
+}
+
+func parseSearchReplace(text string) ([]*Hunk, error) {
+	hunks := make([]*Hunk, 0)
+	var hunk *Hunk
+	inSearch := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case searchPattern.MatchString(trimmed):
+			hunk = &Hunk{Index: len(hunks) + 1, Old: []string{}, New: []string{}}
+			inSearch = true
+		case hunk != nil && inSearch && dividerPattern.MatchString(trimmed):
+			inSearch = false
+		case hunk != nil && !inSearch && replacePattern.MatchString(trimmed):
+			hunks = append(hunks, hunk)
+			hunk = nil
+		case hunk != nil && inSearch:
+			hunk.Old = append(hunk.Old, line)
+		case hunk != nil:
+			hunk.New = append(hunk.New, line)
+		}
+	}
+	if hunk != nil {
+		return nil, fmt.Errorf("SEARCH/REPLACE block #%d is not terminated", hunk.Index)
+	}
+	if len(hunks) == 0 {
+		return nil, errors.New("patch has no SEARCH/REPLACE blocks")
+	}
+	return hunks, nil
+}
+
+// ApplyPatch applies a unified diff or SEARCH/REPLACE blocks to the original content.
+// Hunks are located exactly first, then ignoring trailing whitespace, then ignoring
+// indentation and finally with up to two context lines dropped from each edge.
+// If any hunk fails a *PatchError is returned and the original content is kept.
+func ApplyPatch(original, patch string) (string, error) {
+	hunks, err := ParsePatch(patch)
+	if err != nil {
+		return original, err
+	}
+	lines := strings.Split(strings.ReplaceAll(original, "\r\n", "\n"), "\n")
+	patchErr := &PatchError{Hunks: len(hunks)}
+	drift := 0
+	for _, hunk := range hunks {
+		hint := -1
+		if hunk.Numbered {
+			hint = hunk.Hint - 1 + drift
+		}
+		start, old, replacement, reason := locateHunk(lines, hunk, hint)
+		if reason != "" {
+			patchErr.Failures = append(patchErr.Failures, &HunkFailure{Hunk: hunk.Index, Reason: reason,
+				Search: strings.Join(hunk.Old, "\n")})
+			continue
+		}
+		updated := make([]string, 0, len(lines)-old+len(replacement))
+		updated = append(updated, lines[:start]...)
+		updated = append(updated, replacement...)
+		updated = append(updated, lines[start+old:]...)
+		lines = updated
+		drift += len(replacement) - old
+	}
+	if len(patchErr.Failures) > 0 {
+		return original, patchErr
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// locateHunk returns the position and length of the matched lines and the lines to put instead,
+// or the reason the hunk could not be located.
+func locateHunk(lines []string, hunk *Hunk, hint int) (int, int, []string, string) {
+	if len(hunk.Old) == 0 {
+		// pure insertion, only possible for a unified diff with a line number or an empty file.
+		// The lines go after the line of the hint, -0,0 inserts at the top.
+		if hunk.Numbered {
+			if hint+1 < 0 || hint+1 > len(lines) {
+				return 0, 0, nil, fmt.Sprintf("line %d is beyond the end of the file", hunk.Hint)
+			}
+			return hint + 1, 0, hunk.New, ""
+		}
+		if len(lines) == 1 && lines[0] == "" {
+			return 0, 1, hunk.New, ""
+		}
+		return 0, 0, nil, "hunk has no lines to search for"
+	}
+
+	normalizers := []func(string) string{
+		func(s string) string { return s },
+		func(s string) string { return strings.TrimRight(s, " \t") },
+		strings.TrimSpace,
+	}
+	for _, normalize := range normalizers {
+		for fuzz := 0; fuzz <= 2; fuzz++ {
+			leading := min(fuzz, hunk.Leading)
+			trailing := min(fuzz, hunk.Trailing)
+			if fuzz > 0 && leading+trailing == 0 {
+				break
+			}
+			old := hunk.Old[leading : len(hunk.Old)-trailing]
+			if len(old) == 0 {
+				break
+			}
+			matches := findLines(lines, old, normalize)
+			if len(matches) == 0 {
+				continue
+			}
+			if len(matches) > 1 && hint < 0 {
+				return 0, 0, nil, fmt.Sprintf("matches %d locations, include more surrounding lines to make it unique", len(matches))
+			}
+			start := closest(matches, hint+leading)
+			return start, len(old), hunk.New[leading : len(hunk.New)-trailing], ""
+		}
+	}
+	return 0, 0, nil, "the lines to replace were not found in the file"
+}
+
+func findLines(lines, search []string, normalize func(string) string) []int {
+	matches := make([]int, 0)
+	for i := 0; i+len(search) <= len(lines); i++ {
+		found := true
+		for j, line := range search {
+			if normalize(lines[i+j]) != normalize(line) {
+				found = false
+				break
+			}
+		}
+		if found {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+func closest(matches []int, hint int) int {
+	best := matches[0]
+	for _, m := range matches[1:] {
+		if abs(m-hint) < abs(best-hint) {
+			best = m
+		}
+	}
+	return best
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
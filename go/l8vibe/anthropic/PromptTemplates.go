@@ -0,0 +1,173 @@
+package anthropic
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	TEMPLATE_STATIC_SITE    = "static-site"
+	TEMPLATE_LAYER8_SERVICE = "layer8-service"
+
+	DEFAULT_MAX_TOKENS   = 64000
+	MAX_STOP_SEQUENCES   = 4
+	MAX_SYSTEM_PROMPT    = 32 * 1024
+	MAX_STOP_SEQUENCE_LN = 256
+	MAX_CONTINUATIONS    = 10
+)
+
+// basePrompt is the part of the system prompt every request carries, it describes
+// the workspace and the tools the model changes it with
+const basePrompt = `You are building a project inside a workspace that is served as a web application.
+The workspace root is the web root, the entry page is index.html and every other file
+is referenced relative to it. Change the workspace only through the file tools:
+write_file creates or replaces a whole file, patch_file changes part of an existing file,
+delete_file and rename_file remove and move files and read_file returns the current content
+of a file. File content of earlier turns may be elided from the conversation, read a file
+before patching it when its current content is not shown. Keep the changes focused on the
+request, do not rewrite files that do not need to change and do not describe the file content in
+the reply, the files are applied as you write them.`
+
+// markdownPrompt replaces basePrompt for the providers whose models do not call tools,
+// the files are parsed from the code blocks of the reply
+const markdownPrompt = `You are building a project inside a workspace that is served as a web application.
+The workspace root is the web root, the entry page is index.html and every other file
+is referenced relative to it. Write every file you create or change as a level two heading
+with the file path followed by a code block with the complete file content, for example
+## js/app.js
+` + "```javascript\n...\n```" + `
+Files that do not change are not repeated, always write the whole file and never a part of it.`
+
+const staticSitePrompt = `The project is a static web site, plain HTML, CSS and JavaScript without a build step.
+Put styles under css/ and scripts under js/, load them from index.html with relative paths
+and prefer small, dependency free modules over frameworks.`
+
+const layer8ServicePrompt = `The project is a Layer 8 Go service. Services implement ifs.IServiceHandler with
+Activate, DeActivate, Post, Put, Patch, Delete, Get, GetCopy, Failed, TransactionConfig and
+WebService, are activated on a vnic and return their results and errors through the
+object.New and object.NewError elements. The data model is defined in .proto files and the
+Go types are generated from them. Method receivers are named this and the web UI that
+drives the service lives in index.html next to the sources.`
+
+var promptTemplates = []*types.PromptTemplate{
+	{Name: TEMPLATE_STATIC_SITE, Description: "Static site", System: staticSitePrompt},
+	{Name: TEMPLATE_LAYER8_SERVICE, Description: "Layer 8 Go service", System: layer8ServicePrompt},
+}
+
+// PromptTemplates returns the server side prompt templates a project can select
+func PromptTemplates() []*types.PromptTemplate {
+	return promptTemplates
+}
+
+func promptTemplate(name string) (*types.PromptTemplate, bool) {
+	for _, template := range promptTemplates {
+		if template.Name == name {
+			return template, true
+		}
+	}
+	return nil, false
+}
+
+// ValidateSettings checks the generation settings of a project, nil settings are valid
+func ValidateSettings(settings *types.GenerationSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.Provider != "" && !contains(ProviderNames, settings.Provider) {
+		return errors.New("Unknown provider " + settings.Provider)
+	}
+	if settings.Template != "" {
+		_, ok := promptTemplate(settings.Template)
+		if !ok {
+			return errors.New("Unknown prompt template " + settings.Template)
+		}
+	}
+	if len(settings.System) > MAX_SYSTEM_PROMPT {
+		return errors.New("System prompt is longer than " + strconv.Itoa(MAX_SYSTEM_PROMPT) + " bytes")
+	}
+	if settings.MaxTokens < 0 {
+		return errors.New("max_tokens cannot be negative")
+	}
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > 1) {
+		return errors.New("temperature must be between 0 and 1")
+	}
+	if settings.TopP != nil && (*settings.TopP < 0 || *settings.TopP > 1) {
+		return errors.New("top_p must be between 0 and 1")
+	}
+	if len(settings.StopSequences) > MAX_STOP_SEQUENCES {
+		return errors.New("At most " + strconv.Itoa(MAX_STOP_SEQUENCES) + " stop sequences are allowed")
+	}
+	if settings.MaxContinuations < -1 || settings.MaxContinuations > MAX_CONTINUATIONS {
+		return errors.New("max_continuations must be between -1 and " + strconv.Itoa(MAX_CONTINUATIONS))
+	}
+	for _, stop := range settings.StopSequences {
+		if strings.TrimSpace(stop) == "" || len(stop) > MAX_STOP_SEQUENCE_LN {
+			return errors.New("Stop sequence \"" + stop + "\" is invalid")
+		}
+	}
+	return nil
+}
+
+// ComposeSystemPrompt composes the base prompt, the template and the project's own
+// system prompt, in that order
+func ComposeSystemPrompt(settings *types.GenerationSettings) (string, error) {
+	return composeSystemPrompt(settings, basePrompt)
+}
+
+func composeSystemPrompt(settings *types.GenerationSettings, base string) (string, error) {
+	parts := []string{base}
+	if settings != nil {
+		if settings.Template != "" {
+			template, ok := promptTemplate(settings.Template)
+			if !ok {
+				return "", errors.New("Unknown prompt template " + settings.Template)
+			}
+			parts = append(parts, template.System)
+		}
+		if strings.TrimSpace(settings.System) != "" {
+			parts = append(parts, settings.System)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// applySettings sets the model, the system prompt and the sampling parameters of
+// the request from the project settings and the capabilities of the provider
+func applySettings(body *types.ClaudeRequest, settings *types.GenerationSettings, caps Capabilities) error {
+	base := basePrompt
+	if !caps.Tools {
+		base = markdownPrompt
+	}
+	system, err := composeSystemPrompt(settings, base)
+	if err != nil {
+		return err
+	}
+	body.System = system
+	body.Model = caps.DefaultModel
+	body.MaxTokens = DEFAULT_MAX_TOKENS
+	if settings == nil {
+		return nil
+	}
+	if settings.Model != "" {
+		body.Model = settings.Model
+	}
+	if settings.MaxTokens > 0 {
+		body.MaxTokens = settings.MaxTokens
+	}
+	body.Temperature = settings.Temperature
+	body.TopP = settings.TopP
+	body.StopSequences = settings.StopSequences
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package anthropic
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by all the projects of a client, it spaces the
+// requests to the configured rate and holds all of them while the upstream rate limit
+// is exhausted
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time
+	mtx    sync.Mutex
+}
+
+// NewRateLimiter returns a limiter of perMinute requests, allowing bursts of burst requests
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst),
+		last: time.Now()}
+}
+
+// Wait blocks until a request may be sent or the context is done
+func (this *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := this.reserve(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		err := sleep(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Hold stops all the requests until the time, used when the upstream reports its
+// rate limit as exhausted
+func (this *RateLimiter) Hold(until time.Time) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if until.After(this.until) {
+		this.until = until
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait for one
+func (this *RateLimiter) reserve(now time.Time) time.Duration {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if now.Before(this.until) {
+		return this.until.Sub(now)
+	}
+	this.tokens += now.Sub(this.last).Seconds() * this.rate
+	if this.tokens > this.burst {
+		this.tokens = this.burst
+	}
+	this.last = now
+	if this.tokens >= 1 {
+		this.tokens--
+		return 0
+	}
+	return time.Duration((1 - this.tokens) / this.rate * float64(time.Second))
+}
+
+// sleep waits for the duration or until the context is done
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,253 @@
+package anthropic
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// Workspace confines all file operations of a project to its directory under
+// consts.WORKSPACE_ROOT. Paths are canonicalized and rejected if they are absolute,
+// escape the project root, traverse a symlink or use a reserved name, and writes
+// are bounded by a per-file and per-project size limit.
+type Workspace struct {
+	root           string
+	maxFileSize    int64
+	maxProjectSize int64
+}
+
+// WorkspaceError is a rejected workspace operation
+type WorkspaceError struct {
+	Op     string
+	Path   string
+	Reason string
+}
+
+func (this *WorkspaceError) Error() string {
+	return this.Op + " " + this.Path + ": " + this.Reason
+}
+
+// WorkspaceErrors are all the operations rejected while applying a message
+type WorkspaceErrors []*WorkspaceError
+
+func (this WorkspaceErrors) Error() string {
+	msgs := make([]string, len(this))
+	for i, err := range this {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// NewWorkspace returns the workspace of the project, the project user and name
+// are validated as they are client supplied and become path elements.
+func NewWorkspace(project *types.Project) (*Workspace, error) {
+	for _, name := range []string{project.User, project.Name} {
+		reason := persist.CheckName(name)
+		if reason != "" {
+			return nil, &WorkspaceError{Op: "open", Path: name, Reason: "invalid project: " + reason}
+		}
+	}
+	base, err := filepath.Abs(consts.WORKSPACE_ROOT)
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{root: filepath.Join(base, project.User, project.Name),
+		maxFileSize:    consts.WORKSPACE_MAX_FILE_SIZE,
+		maxProjectSize: consts.WORKSPACE_MAX_PROJECT_SIZE}, nil
+}
+
+// MoveLegacyWorkspaces moves the workspaces of a deployment that kept them in the web UI
+// directory, where they were served to anyone, to consts.WORKSPACE_ROOT
+func MoveLegacyWorkspaces() error {
+	if _, err := os.Stat(consts.WORKSPACE_ROOT); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(consts.LEGACY_WORKSPACE_ROOT); err != nil {
+		return nil
+	}
+	return os.Rename(consts.LEGACY_WORKSPACE_ROOT, consts.WORKSPACE_ROOT)
+}
+
+// Root returns the absolute directory of the workspace
+func (this *Workspace) Root() string {
+	return this.root
+}
+
+// Resolve validates a workspace relative path and returns its absolute location
+func (this *Workspace) Resolve(op, path string) (string, error) {
+	if path == "" {
+		return "", &WorkspaceError{Op: op, Path: path, Reason: "path is required"}
+	}
+	if strings.ContainsRune(path, 0) {
+		return "", &WorkspaceError{Op: op, Path: path, Reason: "path contains NUL"}
+	}
+	slashed := strings.ReplaceAll(path, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return "", &WorkspaceError{Op: op, Path: path, Reason: "absolute paths are not allowed"}
+	}
+	clean := filepath.Clean(filepath.FromSlash(slashed))
+	if !filepath.IsLocal(clean) {
+		return "", &WorkspaceError{Op: op, Path: path, Reason: "path escapes the project root"}
+	}
+
+	full := this.root
+	for _, element := range strings.Split(clean, string(filepath.Separator)) {
+		reason := persist.CheckName(element)
+		if reason != "" {
+			return "", &WorkspaceError{Op: op, Path: path, Reason: reason}
+		}
+		full = filepath.Join(full, element)
+		info, err := os.Lstat(full)
+		if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", &WorkspaceError{Op: op, Path: path, Reason: "symbolic links are not allowed"}
+		}
+	}
+	return full, nil
+}
+
+// ReadFile reads a workspace file
+func (this *Workspace) ReadFile(path string) ([]byte, error) {
+	full, err := this.Resolve("read", path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(full)
+}
+
+// WriteFile creates or replaces a workspace file within the size limits
+func (this *Workspace) WriteFile(path string, data []byte) error {
+	full, err := this.Resolve("write", path)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > this.maxFileSize {
+		return &WorkspaceError{Op: "write", Path: path,
+			Reason: fmt.Sprintf("file size %d exceeds the limit of %d bytes", size, this.maxFileSize)}
+	}
+	existing := int64(0)
+	info, err := os.Lstat(full)
+	if err == nil {
+		if info.IsDir() {
+			return &WorkspaceError{Op: "write", Path: path, Reason: "path is a directory"}
+		}
+		existing = info.Size()
+	}
+	total := this.Size() - existing + size
+	if total > this.maxProjectSize {
+		return &WorkspaceError{Op: "write", Path: path,
+			Reason: fmt.Sprintf("project size %d would exceed the limit of %d bytes", total, this.maxProjectSize)}
+	}
+	err = os.MkdirAll(filepath.Dir(full), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, 0644)
+}
+
+// Remove deletes a workspace file
+func (this *Workspace) Remove(path string) error {
+	full, err := this.Resolve("delete", path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(full)
+}
+
+// RemoveAll deletes the workspace with all its files
+func (this *Workspace) RemoveAll() error {
+	return os.RemoveAll(this.root)
+}
+
+// MoveTo moves the workspace with all its files and its repository to another workspace,
+// which must not exist. A workspace that was never written is not an error.
+func (this *Workspace) MoveTo(to *Workspace) error {
+	_, err := os.Stat(to.root)
+	if err == nil {
+		return &WorkspaceError{Op: "move", Path: to.root, Reason: "workspace already exists"}
+	}
+	err = os.MkdirAll(filepath.Dir(to.root), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(this.root, to.root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Rename moves a workspace file to another workspace path
+func (this *Workspace) Rename(path, newPath string) error {
+	full, err := this.Resolve("rename", path)
+	if err != nil {
+		return err
+	}
+	newFull, err := this.Resolve("rename", newPath)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(newFull), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(full, newFull)
+}
+
+// isReservedDir reports a reserved directory inside the workspace, such as the git
+// repository, which is not part of the project files
+func (this *Workspace) isReservedDir(path string, d fs.DirEntry) bool {
+	return d.IsDir() && path != this.root && persist.IsReserved(d.Name())
+}
+
+// Size returns the total size of the project files in the workspace
+func (this *Workspace) Size() int64 {
+	size := int64(0)
+	filepath.WalkDir(this.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if this.isReservedDir(path, d) {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			info, er := d.Info()
+			if er == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// List returns the workspace relative paths of all the project files in the workspace
+func (this *Workspace) List() ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(this.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == this.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if this.isReservedDir(path, d) {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			rel, er := filepath.Rel(this.root, path)
+			if er != nil {
+				return er
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
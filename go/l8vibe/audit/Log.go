@@ -0,0 +1,353 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The operations of the audit records
+const (
+	OP_GET      = "get"
+	OP_POST     = "post"
+	OP_PUT      = "put"
+	OP_PATCH    = "patch"
+	OP_TURN     = "turn"
+	OP_DELETE   = "delete"
+	OP_RESTORE  = "restore"
+	OP_PURGE    = "purge"
+	OP_SHARE    = "share"
+	OP_UNSHARE  = "unshare"
+	OP_TRANSFER = "transfer"
+	OP_ROLLBACK = "rollback"
+	OP_CHECKOUT = "checkout"
+	OP_CANCEL   = "cancel"
+	OP_EXPORT   = "export"
+	// OP_CHAIN_BREAK records a break of the chain found in the records of other processes
+	OP_CHAIN_BREAK = "chain-break"
+)
+
+// Log is the append only audit log of the operations on the projects. Every record is
+// chained to the previous one by its hash, the sha256 of the record with the hash of the
+// previous record in it, so changing, removing or inserting a record breaks the chain of the
+// records after it. The records are appended to the file as json lines and synced one by one.
+// The web server and the project node append to the same file, so a record is appended under
+// the file lock and chained to the last record in the file, whichever process wrote it. The
+// records of the other processes are read from where the log last read the file and checked
+// to chain, a break of the chain among them is recorded as a chain-break record.
+type Log struct {
+	fileName string
+	file     *os.File
+	records  []*types.AuditRecord
+	size     int64
+	mtx      sync.Mutex
+}
+
+// ChainError reports the first record of the log that does not chain to the previous one
+type ChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (this *ChainError) Error() string {
+	return fmt.Sprintf("audit record %d %s", this.Seq, this.Reason)
+}
+
+// LogFile returns the file of the audit log, L8VIBE_AUDIT_LOG overrides the default
+func LogFile() string {
+	fileName := os.Getenv(consts.AUDIT_LOG_ENV)
+	if fileName == "" {
+		return consts.AUDIT_LOG_FILE
+	}
+	return fileName
+}
+
+// Open loads and verifies the log of the file, a missing file is an empty log. A log with
+// a broken chain is returned with a ChainError, so the service can report it and go on,
+// the new records chain to the last record of the file.
+func Open(fileName string) (*Log, error) {
+	unlock, err := persist.LockFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	records, data, broken := read(fileName)
+	if _, chain := broken.(*ChainError); broken != nil && !chain {
+		return nil, broken
+	}
+	log := &Log{fileName: fileName, records: records, size: int64(len(data))}
+	log.file, err = os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// the last line of a write that was cut short is left alone
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		_, err = log.file.Write([]byte("\n"))
+		if err != nil {
+			log.file.Close()
+			return nil, err
+		}
+		log.size++
+	}
+	return log, broken
+}
+
+// Append chains the record to the last record, writes it and returns a copy of it
+func (this *Log) Append(record *types.AuditRecord) (*types.AuditRecord, error) {
+	record = proto.Clone(record).(*types.AuditRecord)
+	if record.Time == 0 {
+		record.Time = time.Now().Unix()
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := persist.LockFile(this.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	err = this.refresh()
+	if err != nil {
+		return nil, err
+	}
+	err = this.write(record)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(record).(*types.AuditRecord), nil
+}
+
+// Records returns copies of the records in the order they were appended, by any process
+func (this *Log) Records() []*types.AuditRecord {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := persist.LockFile(this.fileName)
+	if err == nil {
+		err = this.refresh()
+		unlock()
+	}
+	if err != nil {
+		fmt.Println("Failed to read the audit records of other processes: ", err.Error())
+	}
+	records := make([]*types.AuditRecord, len(this.records))
+	for i, record := range this.records {
+		records[i] = proto.Clone(record).(*types.AuditRecord)
+	}
+	return records
+}
+
+// Verify reads the file again and checks the chain of its records
+func (this *Log) Verify() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	_, _, err := read(this.fileName)
+	return err
+}
+
+// Close closes the file of the log
+func (this *Log) Close() error {
+	return this.file.Close()
+}
+
+// refresh adds the records other processes appended to the file since it was last read,
+// streamed from where it was last read. A corrupt line, a record that does not chain to the
+// one before it and a file shorter than was read break the chain, which is recorded unless a
+// chain-break record after it shows another process recorded it. Called with mtx and the
+// file lock held.
+func (this *Log) refresh() error {
+	file, err := os.Open(this.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var broken error
+	if info.Size() < this.size {
+		broken = &ChainError{Seq: this.nextSeq(), Reason: fmt.Sprintf("is missing, the log was cut to %d bytes",
+			info.Size())}
+		this.size = info.Size()
+	}
+	_, err = file.Seek(this.size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, er := reader.ReadBytes('\n')
+		// a line that is not complete yet is read with the next refresh
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return er
+		}
+		this.size += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record := &types.AuditRecord{}
+		er = protojson.Unmarshal(line, record)
+		if er != nil {
+			if broken == nil {
+				broken = &ChainError{Seq: this.nextSeq(), Reason: "is corrupt: " + er.Error()}
+			}
+			continue
+		}
+		er = this.chains(record)
+		if er != nil && broken == nil {
+			broken = er
+		} else if er == nil && record.Operation == OP_CHAIN_BREAK {
+			broken = nil
+		}
+		this.records = append(this.records, record)
+	}
+	if broken == nil {
+		return nil
+	}
+	fmt.Println("The audit log was tampered with, " + broken.Error())
+	return this.write(&types.AuditRecord{Time: time.Now().Unix(), Operation: OP_CHAIN_BREAK,
+		Outcome: types.AuditOutcome_AUDIT_FAILED, Error: broken.Error()})
+}
+
+// write chains the record to the last record and appends it to the file, called with mtx
+// and the file lock held
+func (this *Log) write(record *types.AuditRecord) error {
+	record.Seq = this.nextSeq()
+	record.PrevHash = ""
+	if len(this.records) > 0 {
+		record.PrevHash = this.records[len(this.records)-1].Hash
+	}
+	var err error
+	record.Hash, err = Hash(record)
+	if err != nil {
+		return err
+	}
+	line, err := protojson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = this.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = this.file.Sync()
+	if err != nil {
+		return err
+	}
+	this.size += int64(len(line) + 1)
+	this.records = append(this.records, record)
+	return nil
+}
+
+// nextSeq returns the sequence number of the record after the last one
+func (this *Log) nextSeq() int64 {
+	if len(this.records) == 0 {
+		return 1
+	}
+	return this.records[len(this.records)-1].Seq + 1
+}
+
+// chains checks the record chains to the last record
+func (this *Log) chains(record *types.AuditRecord) error {
+	prevHash := ""
+	if len(this.records) > 0 {
+		prevHash = this.records[len(this.records)-1].Hash
+	}
+	return check(record, this.nextSeq(), prevHash)
+}
+
+// Hash returns the hash of the record, which covers the hash of the previous record
+func Hash(record *types.AuditRecord) (string, error) {
+	unhashed := proto.Clone(record).(*types.AuditRecord)
+	unhashed.Hash = ""
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// PromptHash returns the hash the audit records keep instead of the prompt
+func PromptHash(prompt string) string {
+	if prompt == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// read parses the records of the file and verifies their chain, a record that cannot be
+// parsed is skipped and reported as the break of the chain with a ChainError
+func read(fileName string) ([]*types.AuditRecord, []byte, error) {
+	records := make([]*types.AuditRecord, 0)
+	data, err := os.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	var broken error
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record := &types.AuditRecord{}
+		er := protojson.Unmarshal(line, record)
+		if er != nil {
+			if broken == nil {
+				broken = &ChainError{Seq: int64(len(records) + 1),
+					Reason: fmt.Sprintf("on line %d is corrupt: %s", i+1, er.Error())}
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	if broken == nil {
+		broken = verify(records)
+	}
+	return records, data, broken
+}
+
+// verify checks the records are numbered in order and each one chains to the previous one
+func verify(records []*types.AuditRecord) error {
+	prevHash := ""
+	for i, record := range records {
+		err := check(record, int64(i+1), prevHash)
+		if err != nil {
+			return err
+		}
+		prevHash = record.Hash
+	}
+	return nil
+}
+
+// check checks the record has the sequence number, chains to the previous hash and is not modified
+func check(record *types.AuditRecord, seq int64, prevHash string) error {
+	if record.Seq != seq {
+		return &ChainError{Seq: seq, Reason: fmt.Sprintf("is numbered %d", record.Seq)}
+	}
+	if record.PrevHash != prevHash {
+		return &ChainError{Seq: record.Seq, Reason: "does not chain to the previous record"}
+	}
+	hash, err := Hash(record)
+	if err != nil {
+		return &ChainError{Seq: record.Seq, Reason: err.Error()}
+	}
+	if hash != record.Hash {
+		return &ChainError{Seq: record.Seq, Reason: "was modified"}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrInvalidCredentials is returned for an unknown user, a wrong password and a disabled
+// account alike, so a failed login does not tell which of them it was
+var ErrInvalidCredentials = errors.New("Invalid user or password")
+
+// unknownUserHash is compared against for unknown users, so they take as long as known ones
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("l8vibe unknown user"), bcrypt.DefaultCost)
+
+// Accounts are the local accounts of the users, with their bcrypt password hashes, and
+// the accounts of the users of the identity provider, which have no password. The
+// generation of an account changes with its password, which invalidates the tokens issued
+// before.
+type Accounts struct {
+	fileName string
+	accounts map[string]*types.Account
+	mtx      sync.Mutex
+}
+
+// AccountsFile returns the file of the accounts, L8VIBE_ACCOUNTS overrides the default
+func AccountsFile() string {
+	fileName := os.Getenv(consts.ACCOUNTS_FILE_ENV)
+	if fileName == "" {
+		return consts.ACCOUNTS_FILE
+	}
+	return fileName
+}
+
+// NewAccounts loads the accounts from the file, a missing file has no accounts
+func NewAccounts(fileName string) (*Accounts, error) {
+	accounts := &Accounts{fileName: fileName, accounts: make(map[string]*types.Account)}
+	err := accounts.load()
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// NormalizeUser returns the user name accounts are keyed by, the lower case email
+func NormalizeUser(user string) string {
+	return strings.ToLower(strings.TrimSpace(user))
+}
+
+// Create creates the account of the user
+func (this *Accounts) Create(user, password string) error {
+	user = NormalizeUser(user)
+	if user == "" {
+		return errors.New("User is required")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, exists := this.accounts[user]; exists {
+		return errors.New("Account " + user + " already exists")
+	}
+	now := time.Now().Unix()
+	this.accounts[user] = &types.Account{User: user, PasswordHash: string(hash), Created: now,
+		PasswordChanged: now, Generation: 1}
+	err = this.save()
+	if err != nil {
+		delete(this.accounts, user)
+	}
+	return err
+}
+
+// SetPassword replaces the password of the user, the tokens issued before stop working
+func (this *Accounts) SetPassword(user, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return this.update(user, func(account *types.Account) {
+		account.PasswordHash = string(hash)
+		account.PasswordChanged = time.Now().Unix()
+		account.Generation++
+	})
+}
+
+// Revoke invalidates the tokens of the user issued so far
+func (this *Accounts) Revoke(user string) error {
+	return this.update(user, func(account *types.Account) {
+		account.Generation++
+	})
+}
+
+// Disable disables the account of the user and invalidates its tokens
+func (this *Accounts) Disable(user string) error {
+	return this.update(user, func(account *types.Account) {
+		account.Disabled = time.Now().Unix()
+		account.Generation++
+	})
+}
+
+// Federate returns the account of a user the identity provider authenticated, creating it
+// on the first login. The roles of the account follow the groups of the latest login. An
+// account of another provider or subject is not taken over, and an account is neither
+// created nor linked to the provider unless the provider verified the email.
+func (this *Accounts) Federate(identity *Identity) (*types.Account, error) {
+	user := NormalizeUser(identity.User)
+	if user == "" || identity.Issuer == "" || identity.Subject == "" {
+		return nil, errors.New("Identity is missing its user, issuer or subject")
+	}
+	if !identity.EmailVerified {
+		return nil, errors.New("Provider did not verify the email of " + user)
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	account, ok := this.accounts[user]
+	if ok && account.Disabled != 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if ok && account.Issuer != "" && (account.Issuer != identity.Issuer || account.Subject != identity.Subject) {
+		return nil, errors.New("Account " + user + " belongs to another identity")
+	}
+	changed := &types.Account{User: user, Created: time.Now().Unix(), Generation: 1}
+	if ok {
+		changed = proto.Clone(account).(*types.Account)
+	}
+	changed.Issuer = identity.Issuer
+	changed.Subject = identity.Subject
+	changed.Roles = identity.Roles
+	this.accounts[user] = changed
+	err = this.save()
+	if err != nil {
+		if ok {
+			this.accounts[user] = account
+		} else {
+			delete(this.accounts, user)
+		}
+		return nil, err
+	}
+	return proto.Clone(changed).(*types.Account), nil
+}
+
+// Roles returns the roles of the user
+func (this *Accounts) Roles(user string) []string {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.reload()
+	account, ok := this.accounts[NormalizeUser(user)]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), account.Roles...)
+}
+
+// Verify returns the account of the user if the password is its password
+func (this *Accounts) Verify(user, password string) (*types.Account, error) {
+	user = NormalizeUser(user)
+	this.mtx.Lock()
+	this.reload()
+	account, ok := this.accounts[user]
+	hash := unknownUserHash
+	if ok {
+		hash = []byte(account.PasswordHash)
+	}
+	this.mtx.Unlock()
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !ok || err != nil || account.Disabled != 0 {
+		return nil, ErrInvalidCredentials
+	}
+	return proto.Clone(account).(*types.Account), nil
+}
+
+// Generation returns the generation of an active account of the user
+func (this *Accounts) Generation(user string) (int64, bool) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.reload()
+	account, ok := this.accounts[user]
+	if !ok || account.Disabled != 0 {
+		return 0, false
+	}
+	return account.Generation, true
+}
+
+// Exists reports whether the user has an account
+func (this *Accounts) Exists(user string) bool {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.reload()
+	_, ok := this.accounts[NormalizeUser(user)]
+	return ok
+}
+
+func (this *Accounts) update(user string, change func(*types.Account)) error {
+	user = NormalizeUser(user)
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	account, ok := this.accounts[user]
+	if !ok {
+		return errors.New("Account " + user + " was not found")
+	}
+	changed := proto.Clone(account).(*types.Account)
+	change(changed)
+	this.accounts[user] = changed
+	err = this.save()
+	if err != nil {
+		this.accounts[user] = account
+	}
+	return err
+}
+
+// load replaces the accounts with the ones in the file, which the web server and the
+// project node both write. A missing file has no accounts. Called with mtx held.
+func (this *Accounts) load() error {
+	data, err := os.ReadFile(this.fileName)
+	if os.IsNotExist(err) {
+		this.accounts = make(map[string]*types.Account)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := &types.AccountList{}
+	err = proto.Unmarshal(data, list)
+	if err != nil {
+		return fmt.Errorf("accounts %s are corrupt: %w", this.fileName, err)
+	}
+	this.accounts = make(map[string]*types.Account)
+	for _, account := range list.List {
+		this.accounts[account.User] = account
+	}
+	return nil
+}
+
+// reload loads the accounts before a read, so a password changed or an account disabled
+// by another process applies at once. On failure the accounts loaded before are kept.
+func (this *Accounts) reload() {
+	err := this.load()
+	if err != nil {
+		fmt.Println("Failed to load the accounts: ", err.Error())
+	}
+}
+
+// lockAndLoad takes the file lock of the accounts and loads them, so a change starts from
+// the accounts the other processes saved. Called with mtx held, it returns the function
+// that releases the file lock.
+func (this *Accounts) lockAndLoad() (func(), error) {
+	unlock, err := persist.LockFile(this.fileName)
+	if err != nil {
+		return nil, err
+	}
+	err = this.load()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// save writes the accounts, readable by the owner only, called with mtx and the file lock held
+func (this *Accounts) save() error {
+	list := &types.AccountList{List: make([]*types.Account, 0, len(this.accounts))}
+	for _, account := range this.accounts {
+		list.List = append(list.List, account)
+	}
+	sort.Slice(list.List, func(i, j int) bool {
+		return list.List[i].User < list.List[j].User
+	})
+	data, err := proto.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return persist.WriteAtomicMode(this.fileName, data, 0600)
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < consts.AUTH_MIN_PASSWORD {
+		return nil, fmt.Errorf("Password must have at least %d characters", consts.AUTH_MIN_PASSWORD)
+	}
+	// bcrypt only uses the first 72 bytes, longer passwords would match on their prefix
+	if len(password) > 72 {
+		return nil, errors.New("Password must have at most 72 bytes")
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+)
+
+// Authenticator logs the users in with the passwords of their local accounts and
+// authenticates their requests by the bearer tokens it issued. Repeated failed logins
+// lock the user out for a while.
+type Authenticator struct {
+	accounts *Accounts
+	tokens   *Tokens
+	failures map[string]*failure
+	mtx      sync.Mutex
+}
+
+type failure struct {
+	count int
+	until time.Time
+}
+
+// NewAuthenticator returns the authenticator of the accounts and the tokens
+func NewAuthenticator(accounts *Accounts, tokens *Tokens) *Authenticator {
+	return &Authenticator{accounts: accounts, tokens: tokens, failures: make(map[string]*failure)}
+}
+
+// OpenAuthenticator opens the accounts and the token key of the deployment. If
+// L8VIBE_ADMIN_USER and L8VIBE_ADMIN_PASSWORD are set and the account does not exist
+// yet, it is created, so a new deployment has a first account to log in with.
+func OpenAuthenticator(ttl time.Duration) (*Authenticator, error) {
+	accounts, err := NewAccounts(AccountsFile())
+	if err != nil {
+		return nil, err
+	}
+	keyFile := os.Getenv(consts.TOKEN_KEY_FILE_ENV)
+	if keyFile == "" {
+		keyFile = consts.TOKEN_KEY_FILE
+	}
+	key, err := secrets.LoadKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	admin := os.Getenv(consts.AUTH_ADMIN_USER_ENV)
+	if admin != "" && !accounts.Exists(admin) {
+		err = accounts.Create(admin, os.Getenv(consts.AUTH_ADMIN_PASSWORD_ENV))
+		if err != nil {
+			return nil, errors.New("Failed to create the account of " + admin + ": " + err.Error())
+		}
+		fmt.Println("Created the account of ", admin)
+	}
+	return NewAuthenticator(accounts, NewTokens(key, ttl)), nil
+}
+
+// Accounts returns the accounts of the authenticator
+func (this *Authenticator) Accounts() *Accounts {
+	return this.accounts
+}
+
+// Login verifies the password of the user and returns a token and its expiry
+func (this *Authenticator) Login(user, password string) (string, time.Time, error) {
+	user = NormalizeUser(user)
+	err := this.checkLockout(user)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	account, err := this.accounts.Verify(user, password)
+	if err != nil {
+		this.recordFailure(user)
+		return "", time.Time{}, err
+	}
+	this.mtx.Lock()
+	delete(this.failures, user)
+	this.mtx.Unlock()
+	token, expires := this.tokens.Issue(account.User, account.Generation)
+	return token, expires, nil
+}
+
+// Signup creates the account of the user and logs it in
+func (this *Authenticator) Signup(user, password string) (string, time.Time, error) {
+	err := this.accounts.Create(user, password)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return this.Login(user, password)
+}
+
+// Federate logs in the user the identity provider authenticated, the token is the same
+// kind of token a local login returns
+func (this *Authenticator) Federate(identity *Identity) (string, time.Time, error) {
+	account, err := this.accounts.Federate(identity)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token, expires := this.tokens.Issue(account.User, account.Generation)
+	return token, expires, nil
+}
+
+// Validate returns the user of the token. Tokens of disabled accounts and tokens issued
+// before the password changed are not valid.
+func (this *Authenticator) Validate(token string) (string, error) {
+	return this.ValidateScoped(token, "")
+}
+
+// Scoped returns a token of the user of the bearer token valid only for the scope and
+// for ttl, so a page that must keep a token, such as in a cookie, does not keep the
+// bearer token itself
+func (this *Authenticator) Scoped(token, scope string, ttl time.Duration) (string, time.Time, error) {
+	user, generation, err := this.tokens.Parse(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	current, ok := this.accounts.Generation(user)
+	if !ok || current != generation {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	scoped, expires := this.tokens.IssueScoped(user, generation, scope, ttl)
+	return scoped, expires, nil
+}
+
+// ValidateScoped returns the user of the token of the scope, with the same checks as Validate
+func (this *Authenticator) ValidateScoped(token, scope string) (string, error) {
+	user, generation, err := this.tokens.ParseScoped(token, scope)
+	if err != nil {
+		return "", err
+	}
+	current, ok := this.accounts.Generation(user)
+	if !ok || current != generation {
+		return "", ErrInvalidToken
+	}
+	return user, nil
+}
+
+// Logout revokes the token and the other tokens of its user, by a new generation of the
+// account, so the tokens are revoked in every process and across restarts
+func (this *Authenticator) Logout(token string) error {
+	user, generation, err := this.tokens.Parse(token)
+	if err != nil {
+		return err
+	}
+	current, ok := this.accounts.Generation(user)
+	if !ok || current != generation {
+		return ErrInvalidToken
+	}
+	return this.accounts.Revoke(user)
+}
+
+func (this *Authenticator) checkLockout(user string) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	failed, ok := this.failures[user]
+	if ok && failed.count >= consts.AUTH_MAX_FAILURES && time.Now().Before(failed.until) {
+		return errors.New("Too many failed logins for " + user + ", try again in " +
+			time.Until(failed.until).Round(time.Second).String())
+	}
+	return nil
+}
+
+func (this *Authenticator) recordFailure(user string) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	// the failures of the users who are no longer locked out are forgotten
+	now := time.Now()
+	for other, failed := range this.failures {
+		if now.After(failed.until) {
+			delete(this.failures, other)
+		}
+	}
+	failed, ok := this.failures[user]
+	if !ok {
+		failed = &failure{}
+		this.failures[user] = failed
+	}
+	failed.count++
+	failed.until = now.Add(consts.AUTH_LOCKOUT)
+}
+
+// BearerToken returns the bearer token of the Authorization header of the request
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// Directory are the organizations and teams of the users. Projects are shared with
+// principals, which are a user, org:{name} for the members of an organization or
+// team:{name} for the members of a team. The members are configured, or federated
+// users whose groups at the identity provider map to the organization or team.
+type Directory struct {
+	groups      map[string]map[string]bool
+	memberships map[string][]string
+	accounts    *Accounts
+}
+
+// NewDirectory returns the directory of the organizations and the teams
+func NewDirectory(orgs, teams map[string][]string) *Directory {
+	directory := &Directory{groups: make(map[string]map[string]bool), memberships: make(map[string][]string)}
+	directory.add(consts.PRINCIPAL_ORG, orgs)
+	directory.add(consts.PRINCIPAL_TEAM, teams)
+	return directory
+}
+
+func (this *Directory) add(prefix string, groups map[string][]string) {
+	for name, members := range groups {
+		principal := prefix + strings.ToLower(strings.TrimSpace(name))
+		if this.groups[principal] == nil {
+			this.groups[principal] = make(map[string]bool)
+		}
+		for _, member := range members {
+			user := NormalizeUser(member)
+			if user != "" && !this.groups[principal][user] {
+				this.groups[principal][user] = true
+				this.memberships[user] = append(this.memberships[user], principal)
+			}
+		}
+	}
+}
+
+// Federate adds the organizations and teams the groups of the identity provider map to,
+// the federated accounts are members of the ones of the roles of their latest login
+func (this *Directory) Federate(accounts *Accounts, roles map[string]string) {
+	this.accounts = accounts
+	for _, role := range roles {
+		principal := NormalizeUser(role)
+		if this.groups[principal] == nil {
+			this.groups[principal] = make(map[string]bool)
+		}
+	}
+}
+
+// Principals returns the user and the organizations and teams it is a member of
+func (this *Directory) Principals(user string) []string {
+	user = NormalizeUser(user)
+	principals := append([]string{user}, this.memberships[user]...)
+	if this.accounts == nil {
+		return principals
+	}
+	for _, role := range this.accounts.Roles(user) {
+		role = NormalizeUser(role)
+		if _, ok := this.groups[role]; ok && !contains(principals, role) {
+			principals = append(principals, role)
+		}
+	}
+	return principals
+}
+
+// NormalizePrincipal returns the principal a project is shared with, org:{name} and
+// team:{name} have to be known to the directory
+func (this *Directory) NormalizePrincipal(principal string) (string, error) {
+	principal = NormalizeUser(principal)
+	if strings.HasPrefix(principal, consts.PRINCIPAL_ORG) || strings.HasPrefix(principal, consts.PRINCIPAL_TEAM) {
+		if _, ok := this.groups[principal]; !ok {
+			return "", errors.New("No organization or team " + principal)
+		}
+		return principal, nil
+	}
+	if principal == "" || strings.Contains(principal, ":") {
+		return "", errors.New("Principal " + principal + " is not a user, org: or team:")
+	}
+	return principal, nil
+}
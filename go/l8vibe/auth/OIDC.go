@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// Identity is a user the identity provider authenticated
+type Identity struct {
+	User          string
+	Issuer        string
+	Subject       string
+	EmailVerified bool
+	Roles         []string
+}
+
+// RelyingParty logs the users in with an OpenID Connect provider, by the authorization
+// code flow with PKCE. The endpoints of the provider are discovered from its issuer and
+// its signing keys are cached, they are fetched again when they expire or when a token is
+// signed by a key that is not cached.
+type RelyingParty struct {
+	config    *common.OIDCConfig
+	client    *http.Client
+	discovery *discovery
+	found     time.Time
+	keys      *keySet
+	pending   map[string]*pendingLogin
+	mtx       sync.Mutex
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+type tokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewRelyingParty returns the relying party of the provider, which it reaches with the client
+func NewRelyingParty(config *common.OIDCConfig, client *http.Client) *RelyingParty {
+	return &RelyingParty{config: config, client: client, pending: make(map[string]*pendingLogin)}
+}
+
+// AuthCodeURL starts a login and returns its state and the url of the provider to send
+// the browser to. The login has to complete within OIDC_LOGIN_TTL.
+func (this *RelyingParty) AuthCodeURL() (string, string, error) {
+	provider, err := this.discover()
+	if err != nil {
+		return "", "", err
+	}
+	state, nonce, verifier := randomString(), randomString(), randomString()
+	now := time.Now()
+	this.mtx.Lock()
+	for key, login := range this.pending {
+		if now.After(login.expires) {
+			delete(this.pending, key)
+		}
+	}
+	if len(this.pending) >= consts.OIDC_MAX_PENDING {
+		this.mtx.Unlock()
+		return "", "", errors.New("Too many logins in progress, try again later")
+	}
+	this.pending[state] = &pendingLogin{nonce: nonce, verifier: verifier, expires: now.Add(consts.OIDC_LOGIN_TTL)}
+	this.mtx.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	scopes := this.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", this.config.ClientId)
+	query.Set("redirect_uri", this.config.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Exchange completes the login of the state with the authorization code the provider
+// redirected back with, and returns the identity of the verified id token. A state can
+// only be completed once.
+func (this *RelyingParty) Exchange(code, state string) (*Identity, error) {
+	this.mtx.Lock()
+	login, ok := this.pending[state]
+	delete(this.pending, state)
+	this.mtx.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, errors.New("Login is unknown or expired, start it again")
+	}
+	if code == "" {
+		return nil, errors.New("Provider returned no authorization code")
+	}
+	provider, err := this.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", this.config.RedirectURL)
+	form.Set("code_verifier", login.verifier)
+	if this.config.ClientSecret == "" {
+		form.Set("client_id", this.config.ClientId)
+	}
+	request, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if this.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(this.config.ClientId), url.QueryEscape(this.config.ClientSecret))
+	}
+	response := &tokenResponse{}
+	status, err := this.fetch(request, response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || response.IdToken == "" {
+		return nil, fmt.Errorf("Provider rejected the authorization code: %d %s %s", status, response.Error,
+			response.ErrorDescription)
+	}
+	return this.verify(response.IdToken, provider, login.nonce)
+}
+
+// verify verifies the signature and the claims of the id token and maps its groups to roles
+func (this *RelyingParty) verify(idToken string, provider *discovery, nonce string) (*Identity, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Id token is malformed")
+	}
+	header := &jwtHeader{}
+	err := decodeSegment(parts[0], header)
+	if err != nil {
+		return nil, err
+	}
+	key, err := this.key(provider, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Id token signature is malformed")
+	}
+	err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claimString(claims, "iss") != provider.Issuer {
+		return nil, errors.New("Id token was issued by " + claimString(claims, "iss"))
+	}
+	audiences := claimStrings(claims, "aud")
+	if !contains(audiences, this.config.ClientId) {
+		return nil, errors.New("Id token is not for this client")
+	}
+	if len(audiences) > 1 && claimString(claims, "azp") != this.config.ClientId {
+		return nil, errors.New("Id token was not authorized for this client")
+	}
+	expires, ok := claims["exp"].(float64)
+	if !ok || now.Add(-consts.OIDC_CLOCK_SKEW).Unix() >= int64(expires) {
+		return nil, errors.New("Id token is expired")
+	}
+	if issued, ok := claims["iat"].(float64); ok && int64(issued) > now.Add(consts.OIDC_CLOCK_SKEW).Unix() {
+		return nil, errors.New("Id token is issued in the future")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errors.New("Id token is not for this login")
+	}
+	identity := &Identity{User: NormalizeUser(claimString(claims, "email")), Issuer: provider.Issuer,
+		Subject: claimString(claims, "sub")}
+	if identity.Subject == "" || identity.User == "" {
+		return nil, errors.New("Id token has no subject or email")
+	}
+	verified, ok := claims["email_verified"]
+	if (ok && verified != true && verified != "true") || (!ok && !this.config.TrustEmail) {
+		return nil, errors.New("Provider did not verify the email " + identity.User)
+	}
+	identity.EmailVerified = true
+	identity.Roles = this.roles(claims)
+	return identity, nil
+}
+
+// roles maps the groups of the claims to the configured organizations and teams
+func (this *RelyingParty) roles(claims map[string]interface{}) []string {
+	claim := this.config.GroupsClaim
+	if claim == "" {
+		claim = consts.OIDC_GROUPS_CLAIM
+	}
+	roles := make([]string, 0)
+	for _, group := range claimStrings(claims, claim) {
+		role, ok := this.config.Roles[group]
+		if ok && !contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// discover returns the endpoints of the provider, discovered again once they are stale
+func (this *RelyingParty) discover() (*discovery, error) {
+	this.mtx.Lock()
+	if this.discovery != nil && time.Since(this.found) < consts.OIDC_DISCOVERY_TTL {
+		provider := this.discovery
+		this.mtx.Unlock()
+		return provider, nil
+	}
+	this.mtx.Unlock()
+
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(this.config.Issuer, "/")+consts.OIDC_DISCOVERY_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+	provider := &discovery{}
+	status, err := this.fetch(request, provider)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Discovery of %s failed: %d", this.config.Issuer, status)
+	}
+	if provider.Issuer != this.config.Issuer {
+		return nil, errors.New("Discovery of " + this.config.Issuer + " returned the issuer " + provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksURI == "" {
+		return nil, errors.New("Discovery of " + this.config.Issuer + " is missing endpoints")
+	}
+	this.mtx.Lock()
+	this.discovery = provider
+	this.found = time.Now()
+	this.mtx.Unlock()
+	return provider, nil
+}
+
+// key returns the signing key of the provider with the id. Keys are fetched again once
+// they expire, or for an unknown id if they were not fetched within OIDC_JWKS_MIN_REFRESH.
+func (this *RelyingParty) key(provider *discovery, kid string) (interface{}, error) {
+	this.mtx.Lock()
+	keys := this.keys
+	this.mtx.Unlock()
+	if keys != nil && time.Since(keys.fetched) < consts.OIDC_JWKS_TTL {
+		key, ok := keys.find(kid)
+		if ok {
+			return key, nil
+		}
+		if time.Since(keys.fetched) < consts.OIDC_JWKS_MIN_REFRESH {
+			return nil, errors.New("Id token is signed by an unknown key " + kid)
+		}
+	}
+	request, err := http.NewRequest(http.MethodGet, provider.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	set := &jwkSet{}
+	status, err := this.fetch(request, set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Keys of %s could not be fetched: %d", this.config.Issuer, status)
+	}
+	keys = set.parse()
+	this.mtx.Lock()
+	this.keys = keys
+	this.mtx.Unlock()
+	key, ok := keys.find(kid)
+	if !ok {
+		return nil, errors.New("Id token is signed by an unknown key " + kid)
+	}
+	return key, nil
+}
+
+// fetch sends the request and decodes the json response, returning its status
+func (this *RelyingParty) fetch(request *http.Request, value interface{}) (int, error) {
+	response, err := this.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil && response.StatusCode == http.StatusOK {
+		return 0, errors.New("Response of " + request.URL.String() + " is not valid json")
+	}
+	return response.StatusCode, nil
+}
+
+func randomString() string {
+	data := make([]byte, 32)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings returns a claim that is a string or a list of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				result = append(result, text)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
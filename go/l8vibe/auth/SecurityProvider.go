@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// SecurityProvider is the security provider loaded into the resources, with the
+// authentication of the web server hooks replaced by the local accounts. The web server
+// logs the users in through Authenticate and hands the user ValidateToken returns to
+// the services as the identity of the request.
+type SecurityProvider struct {
+	ifs.ISecurityProvider
+	authenticator *Authenticator
+}
+
+// NewSecurityProvider wraps the provider with the authenticator
+func NewSecurityProvider(provider ifs.ISecurityProvider, authenticator *Authenticator) *SecurityProvider {
+	return &SecurityProvider{ISecurityProvider: provider, authenticator: authenticator}
+}
+
+// Authenticate returns a token of the user, empty if the password is not valid
+func (this *SecurityProvider) Authenticate(user, password string) string {
+	token, _, err := this.authenticator.Login(user, password)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// ValidateToken returns the user of the token and whether it is valid
+func (this *SecurityProvider) ValidateToken(token string) (string, bool) {
+	user, err := this.authenticator.Validate(token)
+	return user, err == nil
+}
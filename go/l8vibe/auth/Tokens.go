@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for a token that is malformed, forged, expired or revoked
+var ErrInvalidToken = errors.New("Invalid or expired token")
+
+// Tokens issues and verifies the bearer tokens of the users. A token is its claims signed
+// with HMAC-SHA256, so tokens survive a restart of the web server. A token carries the
+// generation of the account, a token of an older generation is revoked. A scoped token is
+// valid only for its scope, never as a bearer token of the user.
+type Tokens struct {
+	key []byte
+	ttl time.Duration
+}
+
+type claims struct {
+	User       string `json:"u"`
+	Generation int64  `json:"g"`
+	Expires    int64  `json:"e"`
+	Nonce      string `json:"n"`
+	Scope      string `json:"s,omitempty"`
+}
+
+// NewTokens returns the tokens signed with the key, valid for ttl
+func NewTokens(key []byte, ttl time.Duration) *Tokens {
+	return &Tokens{key: key, ttl: ttl}
+}
+
+// Issue returns a token of the user at the generation of the account and its expiry
+func (this *Tokens) Issue(user string, generation int64) (string, time.Time) {
+	return this.issue(user, generation, "", this.ttl)
+}
+
+// IssueScoped returns a token of the user valid only for the scope, and its expiry
+func (this *Tokens) IssueScoped(user string, generation int64, scope string, ttl time.Duration) (string, time.Time) {
+	return this.issue(user, generation, scope, ttl)
+}
+
+// Parse verifies the token and returns its user and generation
+func (this *Tokens) Parse(token string) (string, int64, error) {
+	return this.ParseScoped(token, "")
+}
+
+// ParseScoped verifies the token of the scope and returns its user and generation
+func (this *Tokens) ParseScoped(token, scope string) (string, int64, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(this.sign(encoded))) {
+		return "", 0, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", 0, ErrInvalidToken
+	}
+	c := &claims{}
+	err = json.Unmarshal(payload, c)
+	if err != nil || c.User == "" || c.Scope != scope || time.Now().Unix() >= c.Expires {
+		return "", 0, ErrInvalidToken
+	}
+	return c.User, c.Generation, nil
+}
+
+func (this *Tokens) issue(user string, generation int64, scope string, ttl time.Duration) (string, time.Time) {
+	expires := time.Now().Add(ttl)
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	payload, _ := json.Marshal(&claims{User: user, Generation: generation, Expires: expires.Unix(),
+		Nonce: hex.EncodeToString(nonce), Scope: scope})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + this.sign(encoded), expires
+}
+
+func (this *Tokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, this.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// ServiceConfig is the configuration of the services, loaded from a json file
+type ServiceConfig struct {
+	// Anthropic is the connection to the Anthropic Messages API
+	Anthropic *ProviderConfig `json:"anthropic"`
+	// OpenAI is the connection to an OpenAI compatible server, nil if there is none
+	OpenAI *ProviderConfig `json:"openai,omitempty"`
+	// Orgs are the members of the organizations, who share the provider keys of the organization
+	Orgs map[string][]string `json:"orgs,omitempty"`
+	// Teams are the members of the teams, projects can be shared with an org or a team
+	Teams map[string][]string `json:"teams,omitempty"`
+	// Auditors are the users who may read the whole audit log, the others read the records
+	// of their own requests and of the projects they own
+	Auditors []string `json:"auditors,omitempty"`
+	// Auth is the authentication of the web server and the services
+	Auth *AuthConfig `json:"auth,omitempty"`
+}
+
+// AuthConfig is the authentication of the users. It is required unless it is disabled,
+// which leaves the services trusting the user a request names.
+type AuthConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// TokenTTL is how long a bearer token is valid, e.g. 8h
+	TokenTTL string `json:"token_ttl,omitempty"`
+	// Signup lets anyone create a local account, otherwise the accounts are created by an admin
+	Signup bool `json:"signup,omitempty"`
+	// OIDC is the identity provider of the enterprise users, nil for local accounts only
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+}
+
+// OIDCConfig is an OpenID Connect provider the users log in with. The endpoints and keys
+// of the provider are discovered from the issuer. The client secret can be left out of the
+// file and set in L8VIBE_OIDC_CLIENT_SECRET, without one the client is a public client.
+type OIDCConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// RedirectURL is the callback registered with the provider, .../l8vibe/0/oidc/callback
+	RedirectURL string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes,omitempty"`
+	// GroupsClaim is the claim of the id token with the groups of the user, groups by default
+	GroupsClaim string `json:"groups_claim,omitempty"`
+	// Roles maps the groups of the provider to the organizations and teams of the users,
+	// org:{name} or team:{name}, which the projects shared with them grant access to
+	Roles map[string]string `json:"roles,omitempty"`
+	// TrustEmail accepts the emails of a provider that leaves out email_verified, for a
+	// provider that only has verified emails. Without it the email has to be verified.
+	TrustEmail bool `json:"trust_email,omitempty"`
+	// AfterLogin is the page of the web UI the users return to, / by default
+	AfterLogin string `json:"after_login,omitempty"`
+	CAFile     string `json:"ca_file,omitempty"`
+	Proxy      string `json:"proxy,omitempty"`
+}
+
+// ProviderConfig is the connection to a model provider. TLS connections are verified
+// against the system roots and the certificates of CAFile. Proxy is an http or https
+// proxy url, without it the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment applies.
+type ProviderConfig struct {
+	BaseURL string `json:"base_url,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+	// CAFile is a pem bundle of the CAs trusted in addition to the system roots
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are the pem client certificate and key, for mutual TLS
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	Proxy    string `json:"proxy,omitempty"`
+	// NoProxy is a comma separated list of hosts, domains and CIDRs reached directly
+	NoProxy       string `json:"no_proxy,omitempty"`
+	Model         string `json:"model,omitempty"`
+	ContextWindow int64  `json:"context_window,omitempty"`
+	// Tools is false for models that cannot call tools
+	Tools *bool `json:"tools,omitempty"`
+	RPM   int   `json:"rpm,omitempty"`
+}
+
+// ConfigFile returns the configuration file of the deployment
+func ConfigFile() string {
+	fileName := os.Getenv(consts.CONFIG_ENV)
+	if fileName == "" {
+		fileName = consts.CONFIG_FILE
+	}
+	return fileName
+}
+
+// LoadServiceConfig loads the configuration file, a missing file leaves the defaults.
+// The provider settings of the environment override the file.
+func LoadServiceConfig() (*ServiceConfig, error) {
+	return LoadServiceConfigFile(ConfigFile())
+}
+
+// LoadServiceConfigFile loads the configuration from the file
+func LoadServiceConfigFile(fileName string) (*ServiceConfig, error) {
+	config := &ServiceConfig{}
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		err = json.Unmarshal(data, config)
+		if err != nil {
+			return nil, errors.New("Configuration " + fileName + " is invalid: " + err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if config.Anthropic == nil {
+		config.Anthropic = &ProviderConfig{}
+	}
+	applyEnv(config.Anthropic, consts.ANTHROPIC_BASE_URL_ENV, consts.ANTHROPIC_ENV, "", consts.ANTHROPIC_RPM_ENV)
+	if config.Anthropic.BaseURL == "" {
+		config.Anthropic.BaseURL = consts.ANTHROPIC_BASE_URL
+	}
+	if config.Anthropic.RPM <= 0 {
+		config.Anthropic.RPM = consts.ANTHROPIC_RPM
+	}
+	if config.OpenAI == nil && os.Getenv(consts.OPENAI_BASE_URL_ENV) != "" {
+		config.OpenAI = &ProviderConfig{}
+	}
+	if config.OpenAI != nil {
+		applyEnv(config.OpenAI, consts.OPENAI_BASE_URL_ENV, consts.OPENAI_API_KEY_ENV, consts.OPENAI_MODEL_ENV,
+			consts.OPENAI_RPM_ENV)
+		window, er := strconv.ParseInt(os.Getenv(consts.OPENAI_CONTEXT_WINDOW_ENV), 10, 64)
+		if er == nil && window > 0 {
+			config.OpenAI.ContextWindow = window
+		}
+		tools := os.Getenv(consts.OPENAI_TOOLS_ENV)
+		if tools != "" {
+			enabled := tools != "false"
+			config.OpenAI.Tools = &enabled
+		}
+		if config.OpenAI.RPM <= 0 {
+			config.OpenAI.RPM = consts.OPENAI_RPM
+		}
+	}
+	if config.Auth == nil {
+		config.Auth = &AuthConfig{}
+	}
+	if disabled := os.Getenv(consts.AUTH_DISABLED_ENV); disabled != "" {
+		config.Auth.Disabled = disabled == "true"
+	}
+	if config.Auth.TokenTTL != "" {
+		ttl, er := time.ParseDuration(config.Auth.TokenTTL)
+		if er != nil || ttl <= 0 {
+			return nil, errors.New("Configuration of auth is invalid: token_ttl " + config.Auth.TokenTTL + " is not a duration")
+		}
+	}
+	if config.Auth.OIDC != nil {
+		if secret := os.Getenv(consts.OIDC_CLIENT_SECRET_ENV); secret != "" {
+			config.Auth.OIDC.ClientSecret = secret
+		}
+		err = config.Auth.OIDC.validate()
+		if err != nil {
+			return nil, errors.New("Configuration of oidc is invalid: " + err.Error())
+		}
+	}
+	err = config.Anthropic.validate()
+	if err != nil {
+		return nil, errors.New("Configuration of anthropic is invalid: " + err.Error())
+	}
+	if config.OpenAI != nil {
+		err = config.OpenAI.validate()
+		if err != nil {
+			return nil, errors.New("Configuration of openai is invalid: " + err.Error())
+		}
+	}
+	return config, nil
+}
+
+func applyEnv(provider *ProviderConfig, baseURLEnv, apiKeyEnv, modelEnv, rpmEnv string) {
+	if value := os.Getenv(baseURLEnv); value != "" {
+		provider.BaseURL = value
+	}
+	if value := os.Getenv(apiKeyEnv); value != "" {
+		provider.APIKey = value
+	}
+	if modelEnv != "" {
+		if value := os.Getenv(modelEnv); value != "" {
+			provider.Model = value
+		}
+	}
+	if value, err := strconv.Atoi(os.Getenv(rpmEnv)); err == nil && value > 0 {
+		provider.RPM = value
+	}
+}
+
+func (this *ProviderConfig) validate() error {
+	err := validateURLs(this.BaseURL, this.Proxy)
+	if err != nil {
+		return err
+	}
+	if this.BaseURL == "" {
+		return errors.New("base_url is required")
+	}
+	if (this.CertFile == "") != (this.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+func (this *OIDCConfig) validate() error {
+	err := validateURLs(this.Issuer, this.RedirectURL, this.Proxy)
+	if err != nil {
+		return err
+	}
+	if this.Issuer == "" || this.ClientId == "" || this.RedirectURL == "" {
+		return errors.New("issuer, client_id and redirect_url are required")
+	}
+	for group, role := range this.Roles {
+		if !strings.HasPrefix(role, consts.PRINCIPAL_ORG) && !strings.HasPrefix(role, consts.PRINCIPAL_TEAM) {
+			return errors.New("role " + role + " of group " + group + " is not an org: or a team:")
+		}
+	}
+	return nil
+}
+
+// validateURLs checks the urls that are set are http or https urls
+func validateURLs(addresses ...string) error {
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return err
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.New(address + " is not an http or https url")
+		}
+	}
+	return nil
+}
+
+// TTL returns how long a bearer token is valid
+func (this *AuthConfig) TTL() time.Duration {
+	ttl, err := time.ParseDuration(this.TokenTTL)
+	if err != nil || ttl <= 0 {
+		return consts.AUTH_TOKEN_TTL
+	}
+	return ttl
+}
+
+// ToolsEnabled returns whether the models of the provider call tools, true by default
+func (this *ProviderConfig) ToolsEnabled() bool {
+	return this.Tools == nil || *this.Tools
+}
@@ -1,15 +1,112 @@
 package consts
 
+import "time"
+
 const (
 	VNET_PORT                      = uint32(23333)
 	WEBSITE_PORT                   = 1443
 	WEBSITE_PREFIX                 = "/l8vibe/"
 	WEBSITE_CERT                   = "/data/l8vibe"
+	STREAM_PATH                    = "0/stream"
+	BUNDLE_PATH                    = "0/bundle"
+	PREVIEW_PATH                   = "0/preview/"
+	PREVIEW_COOKIE                 = "l8vibe_preview"
+	PREVIEW_TOKEN_TTL              = 15 * time.Minute
+	AUTH_PATH                      = "0/auth"
+	OIDC_LOGIN_PATH                = "0/oidc/login"
+	OIDC_CALLBACK_PATH             = "0/oidc/callback"
 	ANTHROPIC_HOST                 = "api.anthropic.com"
-	ANTHROPIC_API                  = "https://" + ANTHROPIC_HOST + "/v1/messages"
+	ANTHROPIC_BASE_URL             = "https://" + ANTHROPIC_HOST
+	ANTHROPIC_BASE_URL_ENV         = "L8VIBE_ANTHROPIC_BASE_URL"
+	ANTHROPIC_MESSAGES_PATH        = "/v1/messages"
+	ANTHROPIC_COUNT_TOKENS_PATH    = "/v1/messages/count_tokens"
 	ANTHROPIC_HEADER_API_KEY       = "x-api-key"
 	ANTHROPIC_HEADER_VERSION       = "anthropic-version"
 	ANTHROPIC_HEADER_VERSION_VALUE = "2023-06-01"
 	ANTHROPIC_MODEL                = "claude-sonnet-4-20250514"
 	ANTHROPIC_ENV                  = "ANTHROPIC_API_KEY"
+	ANTHROPIC_MAX_TOOL_ROUNDS      = 25
+	ANTHROPIC_MAX_RETRIES          = 5
+	ANTHROPIC_MAX_CONTINUATIONS    = 3
+	ANTHROPIC_RETRY_BASE           = time.Second
+	ANTHROPIC_RETRY_MAX            = time.Minute
+	ANTHROPIC_RPM_ENV              = "L8VIBE_ANTHROPIC_RPM"
+	ANTHROPIC_RPM                  = 50
+	ANTHROPIC_BURST                = 5
+	ANTHROPIC_BREAKER_THRESHOLD    = 5
+	ANTHROPIC_BREAKER_COOLDOWN     = 30 * time.Second
+	ANTHROPIC_CONTEXT_WINDOW       = int64(200000)
+	OPENAI_BASE_URL_ENV            = "L8VIBE_OPENAI_BASE_URL"
+	OPENAI_API_KEY_ENV             = "L8VIBE_OPENAI_API_KEY"
+	OPENAI_MODEL_ENV               = "L8VIBE_OPENAI_MODEL"
+	OPENAI_CONTEXT_WINDOW_ENV      = "L8VIBE_OPENAI_CONTEXT_WINDOW"
+	OPENAI_TOOLS_ENV               = "L8VIBE_OPENAI_TOOLS"
+	OPENAI_RPM_ENV                 = "L8VIBE_OPENAI_RPM"
+	OPENAI_RPM                     = 600
+	OPENAI_CONTEXT_WINDOW          = int64(128000)
+	CONFIG_ENV                     = "L8VIBE_CONFIG"
+	CONFIG_FILE                    = "/data/l8vibe.json"
+	CASSETTE_MODE_ENV              = "L8VIBE_CASSETTE_MODE"
+	CASSETTE_FILE_ENV              = "L8VIBE_CASSETTE"
+	CASSETTE_FILE                  = "./resources/cassette.json"
+	CONTEXT_KEEP_TURNS             = 3
+	CONTEXT_MAX_FILE_TOKENS        = int64(8000)
+	CONTEXT_ELIDE_BYTES            = 256
+	WORKSPACE_ROOT                 = "./workspace"
+	LEGACY_WORKSPACE_ROOT          = "./web/workspace"
+	WORKSPACE_MAX_FILE_SIZE        = int64(2 * 1024 * 1024)
+	WORKSPACE_MAX_PROJECT_SIZE     = int64(50 * 1024 * 1024)
+	GIT_EMAIL_DOMAIN               = "l8vibe.local"
+	TRASH_RETENTION_ENV            = "L8VIBE_TRASH_RETENTION"
+	TRASH_RETENTION                = "168h"
+	TRASH_PURGE_INTERVAL           = time.Hour
+	JOB_RETENTION                  = 24 * time.Hour
+	JOB_RETENTION_ENV              = "L8VIBE_JOB_RETENTION"
+	PROJECT_STORE_ENV              = "L8VIBE_PROJECT_STORE"
+	PROJECT_STORE_PATH_ENV         = "L8VIBE_PROJECT_STORE_PATH"
+	PROJECT_STORE_FILE             = "file"
+	PROJECT_STORE_BOLT             = "bolt"
+	PROJECT_STORE_ORM              = "orm"
+	PROJECT_STORE_DIR              = "/data"
+	PROJECT_STORE_BOLT_FILE        = "/data/projects.db"
+	ORM_SERVICE_NAME               = "orm"
+	ORM_SERVICE_AREA               = byte(0)
+	USAGE_LEDGER_ENV               = "L8VIBE_USAGE_LEDGER"
+	USAGE_LEDGER_FILE              = "/data/usage.dat"
+	USAGE_DAILY_BUDGET_ENV         = "L8VIBE_DAILY_BUDGET"
+	USAGE_MONTHLY_BUDGET_ENV       = "L8VIBE_MONTHLY_BUDGET"
+	USAGE_USER_BUDGETS_ENV         = "L8VIBE_USER_BUDGETS"
+	USAGE_LEDGER_RETENTION_DAYS    = 400
+	USAGE_PROJECT_HISTORY          = 200
+	SECRETS_FILE_ENV               = "L8VIBE_SECRETS"
+	SECRETS_FILE                   = "/data/secrets.dat"
+	MASTER_KEY_ENV                 = "L8VIBE_MASTER_KEY"
+	MASTER_KEY_PREVIOUS_ENV        = "L8VIBE_MASTER_KEY_PREVIOUS"
+	MASTER_KEY_FILE_ENV            = "L8VIBE_MASTER_KEY_FILE"
+	MASTER_KEY_FILE                = "/data/master.key"
+	AUTH_DISABLED_ENV              = "L8VIBE_AUTH_DISABLED"
+	AUTH_TOKEN_TTL                 = 12 * time.Hour
+	AUTH_MIN_PASSWORD              = 10
+	AUTH_MAX_FAILURES              = 5
+	AUTH_LOCKOUT                   = time.Minute
+	AUTH_ADMIN_USER_ENV            = "L8VIBE_ADMIN_USER"
+	AUTH_ADMIN_PASSWORD_ENV        = "L8VIBE_ADMIN_PASSWORD"
+	ACCOUNTS_FILE_ENV              = "L8VIBE_ACCOUNTS"
+	ACCOUNTS_FILE                  = "/data/accounts.dat"
+	TOKEN_KEY_FILE_ENV             = "L8VIBE_TOKEN_KEY_FILE"
+	TOKEN_KEY_FILE                 = "/data/token.key"
+	OIDC_CLIENT_SECRET_ENV         = "L8VIBE_OIDC_CLIENT_SECRET"
+	OIDC_DISCOVERY_PATH            = "/.well-known/openid-configuration"
+	OIDC_DISCOVERY_TTL             = time.Hour
+	OIDC_JWKS_TTL                  = time.Hour
+	OIDC_JWKS_MIN_REFRESH          = time.Minute
+	OIDC_LOGIN_TTL                 = 10 * time.Minute
+	OIDC_MAX_PENDING               = 10000
+	OIDC_CLOCK_SKEW                = time.Minute
+	OIDC_STATE_COOKIE              = "l8vibe_oidc"
+	OIDC_GROUPS_CLAIM              = "groups"
+	PRINCIPAL_ORG                  = "org:"
+	PRINCIPAL_TEAM                 = "team:"
+	AUDIT_LOG_ENV                  = "L8VIBE_AUDIT_LOG"
+	AUDIT_LOG_FILE                 = "/data/audit.log"
 )
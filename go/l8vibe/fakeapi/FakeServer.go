@@ -0,0 +1,288 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// Server is a fake of the Anthropic Messages API for the integration tests. It answers
+// /v1/messages with the scripted replies in order, as a single response or as server-sent
+// events when the request streams, and /v1/messages/count_tokens with an estimate.
+type Server struct {
+	server   *httptest.Server
+	mtx      sync.Mutex
+	replies  []*Reply
+	requests []*Request
+	latency  time.Duration
+	ids      int
+	// Respond answers the requests once the script is exhausted, by default with "Done"
+	Respond func(*Request) *Reply
+}
+
+// Reply is a scripted response of the fake server
+type Reply struct {
+	// Status other than 200 answers with an error of ErrorType and Message
+	Status     int
+	ErrorType  string
+	Message    string
+	RetryAfter int
+	// Delay is the latency before the response starts, ChunkDelay the pause between
+	// the streamed events
+	Delay      time.Duration
+	ChunkDelay time.Duration
+	Text       string
+	Tools      []*ToolCall
+	// StopReason is end_turn, or tool_use if the reply calls tools, by default
+	StopReason string
+	// StreamError is the type of an error event that ends the stream after the content
+	StreamError  string
+	InputTokens  int
+	OutputTokens int
+}
+
+// ToolCall is a tool_use block of a reply, the input is marshaled to json
+type ToolCall struct {
+	Name  string
+	Input interface{}
+}
+
+// Request is a request the fake server received
+type Request struct {
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+	Stream bool
+}
+
+// NewServer starts a fake server on a local port
+func NewServer() *Server {
+	fake := &Server{}
+	fake.server = httptest.NewServer(fake)
+	return fake
+}
+
+// URL returns the base url of the fake server
+func (this *Server) URL() string {
+	return this.server.URL
+}
+
+// Close stops the fake server
+func (this *Server) Close() {
+	this.server.Close()
+}
+
+// Script queues the replies of the next requests
+func (this *Server) Script(replies ...*Reply) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.replies = append(this.replies, replies...)
+}
+
+// SetLatency adds the latency to every reply
+func (this *Server) SetLatency(latency time.Duration) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.latency = latency
+}
+
+// Requests returns the requests received so far
+func (this *Server) Requests() []*Request {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	return append([]*Request(nil), this.requests...)
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &Reply{Status: http.StatusBadRequest, ErrorType: "invalid_request_error", Message: err.Error()})
+		return
+	}
+	request := &Request{Path: r.URL.Path, Header: r.Header.Clone(), Body: make(map[string]interface{})}
+	err = json.Unmarshal(data, &request.Body)
+	if err != nil || r.Method != http.MethodPost {
+		writeError(w, &Reply{Status: http.StatusBadRequest, ErrorType: "invalid_request_error",
+			Message: "expected a POST of a json body"})
+		return
+	}
+	request.Stream, _ = request.Body["stream"].(bool)
+
+	switch {
+	case r.Header.Get(consts.ANTHROPIC_HEADER_API_KEY) == "":
+		writeError(w, &Reply{Status: http.StatusUnauthorized, ErrorType: "authentication_error",
+			Message: "x-api-key header is required"})
+		return
+	case r.Header.Get(consts.ANTHROPIC_HEADER_VERSION) == "":
+		writeError(w, &Reply{Status: http.StatusBadRequest, ErrorType: "invalid_request_error",
+			Message: "anthropic-version header is required"})
+		return
+	case r.URL.Path == consts.ANTHROPIC_COUNT_TOKENS_PATH:
+		writeJson(w, map[string]int{"input_tokens": len(data) / 3})
+		return
+	case r.URL.Path != consts.ANTHROPIC_MESSAGES_PATH:
+		writeError(w, &Reply{Status: http.StatusNotFound, ErrorType: "not_found_error", Message: r.URL.Path})
+		return
+	}
+
+	reply, latency, id := this.next(request)
+	time.Sleep(latency + reply.Delay)
+	if reply.Status != 0 && reply.Status != http.StatusOK {
+		writeError(w, reply)
+		return
+	}
+	model, _ := request.Body["model"].(string)
+	message := newMessage(id, model, reply, len(data))
+	if request.Stream {
+		writeStream(w, message, reply)
+		return
+	}
+	writeJson(w, message)
+}
+
+// next records the request and returns its reply
+func (this *Server) next(request *Request) (*Reply, time.Duration, string) {
+	this.mtx.Lock()
+	this.requests = append(this.requests, request)
+	this.ids++
+	id := strconv.Itoa(this.ids)
+	var reply *Reply
+	if len(this.replies) > 0 {
+		reply = this.replies[0]
+		this.replies = this.replies[1:]
+	}
+	respond, latency := this.Respond, this.latency
+	this.mtx.Unlock()
+	if reply == nil && respond != nil {
+		reply = respond(request)
+	}
+	if reply == nil {
+		reply = &Reply{Text: "Done"}
+	}
+	return reply, latency, id
+}
+
+type message struct {
+	Id         string                   `json:"id"`
+	Type       string                   `json:"type"`
+	Role       string                   `json:"role"`
+	Model      string                   `json:"model"`
+	Content    []map[string]interface{} `json:"content"`
+	StopReason string                   `json:"stop_reason"`
+	Usage      map[string]int           `json:"usage"`
+}
+
+func newMessage(id, model string, reply *Reply, requestSize int) *message {
+	msg := &message{Id: "msg_" + id, Type: "message", Role: "assistant", Model: model,
+		StopReason: reply.StopReason, Content: make([]map[string]interface{}, 0)}
+	if reply.Text != "" {
+		msg.Content = append(msg.Content, map[string]interface{}{"type": "text", "text": reply.Text})
+	}
+	for i, tool := range reply.Tools {
+		msg.Content = append(msg.Content, map[string]interface{}{"type": "tool_use",
+			"id": "toolu_" + id + "_" + strconv.Itoa(i), "name": tool.Name, "input": tool.Input})
+	}
+	if msg.StopReason == "" {
+		msg.StopReason = "end_turn"
+		if len(reply.Tools) > 0 {
+			msg.StopReason = "tool_use"
+		}
+	}
+	input, output := reply.InputTokens, reply.OutputTokens
+	if input == 0 {
+		input = requestSize / 4
+	}
+	if output == 0 {
+		output = len(reply.Text)/4 + 20*len(reply.Tools) + 1
+	}
+	msg.Usage = map[string]int{"input_tokens": input, "output_tokens": output}
+	return msg
+}
+
+// writeStream sends the message as the events of the streaming protocol, the text and
+// the tool input in chunks
+func writeStream(w http.ResponseWriter, msg *message, reply *Reply) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data interface{}) {
+		jsonData, _ := json.Marshal(data)
+		fmt.Fprint(w, "event: "+event+"\ndata: "+string(jsonData)+"\n\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+		time.Sleep(reply.ChunkDelay)
+	}
+
+	start := *msg
+	start.Content = []map[string]interface{}{}
+	start.StopReason = ""
+	start.Usage = map[string]int{"input_tokens": msg.Usage["input_tokens"], "output_tokens": 1}
+	send("message_start", map[string]interface{}{"type": "message_start", "message": &start})
+	for index, block := range msg.Content {
+		switch block["type"] {
+		case "text":
+			send("content_block_start", map[string]interface{}{"type": "content_block_start", "index": index,
+				"content_block": map[string]interface{}{"type": "text", "text": ""}})
+			for _, chunk := range chunks(block["text"].(string)) {
+				send("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": index,
+					"delta": map[string]interface{}{"type": "text_delta", "text": chunk}})
+			}
+		case "tool_use":
+			send("content_block_start", map[string]interface{}{"type": "content_block_start", "index": index,
+				"content_block": map[string]interface{}{"type": "tool_use", "id": block["id"], "name": block["name"],
+					"input": map[string]interface{}{}}})
+			input, _ := json.Marshal(block["input"])
+			for _, chunk := range chunks(string(input)) {
+				send("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": index,
+					"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": chunk}})
+			}
+		}
+		send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+	}
+	if reply.StreamError != "" {
+		send("error", map[string]interface{}{"type": "error",
+			"error": map[string]string{"type": reply.StreamError, "message": reply.Message}})
+		return
+	}
+	send("message_delta", map[string]interface{}{"type": "message_delta",
+		"delta": map[string]string{"stop_reason": msg.StopReason},
+		"usage": map[string]int{"output_tokens": msg.Usage["output_tokens"]}})
+	send("message_stop", map[string]string{"type": "message_stop"})
+}
+
+// chunks splits the text into the pieces it is streamed in
+func chunks(text string) []string {
+	result := make([]string, 0)
+	runes := []rune(text)
+	for len(runes) > 16 {
+		result = append(result, string(runes[:16]))
+		runes = runes[16:]
+	}
+	if len(runes) > 0 {
+		result = append(result, string(runes))
+	}
+	return result
+}
+
+func writeError(w http.ResponseWriter, reply *Reply) {
+	if reply.RetryAfter > 0 {
+		w.Header().Set("retry-after", strconv.Itoa(reply.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": "error",
+		"error": map[string]string{"type": reply.ErrorType, "message": reply.Message}})
+}
+
+func writeJson(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,274 @@
+package gitrepo
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// ProjectRepo is the git repository of a project workspace. Every assistant turn
+// is committed with the user prompt as the message, authored as the project user.
+// The repository lives in the .git directory of the workspace, which the workspace
+// reserves so generated files can never reach it.
+type ProjectRepo struct {
+	user      string
+	name      string
+	workspace *anthropic.Workspace
+	repo      *git.Repository
+}
+
+// ErrNoHistory is returned for a read of the repository of a project nothing was committed to
+var ErrNoHistory = errors.New("Project has no history yet")
+
+// OpenProjectRepo opens the repository of the project workspace, creating it if needed
+func OpenProjectRepo(project *types.Project) (*ProjectRepo, error) {
+	return openProjectRepo(project, true)
+}
+
+// ReadProjectRepo opens the repository of the project workspace to read it, a workspace
+// without a repository is left alone and reported with ErrNoHistory
+func ReadProjectRepo(project *types.Project) (*ProjectRepo, error) {
+	return openProjectRepo(project, false)
+}
+
+func openProjectRepo(project *types.Project, create bool) (*ProjectRepo, error) {
+	workspace, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpen(workspace.Root())
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if !create {
+			return nil, ErrNoHistory
+		}
+		repo, err = git.PlainInit(workspace.Root(), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectRepo{user: project.User, name: project.Name, workspace: workspace, repo: repo}, nil
+}
+
+// Commit stages all the changes of the workspace and commits them with the message.
+// It returns nil when there is nothing to commit.
+func (this *ProjectRepo) Commit(message string) (*types.ProjectCommit, error) {
+	worktree, err := this.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	err = worktree.AddWithOptions(&git.AddOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.IsClean() {
+		return nil, nil
+	}
+	if message == "" {
+		message = "Workspace changes"
+	}
+	// the users are their emails, a user that is not gets an email of the domain
+	email := this.user
+	if !strings.Contains(email, "@") {
+		email += "@" + consts.GIT_EMAIL_DOMAIN
+	}
+	signature := &object.Signature{Name: this.user, Email: email, When: time.Now()}
+	hash, err := worktree.Commit(message, &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		return nil, err
+	}
+	commit, err := this.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return this.toProjectCommit(commit), nil
+}
+
+// Log returns the commits of the project, newest first, at most limit when limit > 0
+func (this *ProjectRepo) Log(limit int) ([]*types.ProjectCommit, error) {
+	list := make([]*types.ProjectCommit, 0)
+	head, err := this.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	commits, err := this.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+	for limit <= 0 || len(list) < limit {
+		commit, er := commits.Next()
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return nil, er
+		}
+		list = append(list, this.toProjectCommit(commit))
+	}
+	return list, nil
+}
+
+// Diff returns the per file differences between two commits. An empty from diffs
+// to against its parent and an empty to means the current HEAD.
+func (this *ProjectRepo) Diff(from, to string) ([]*types.FileDiff, error) {
+	toCommit, err := this.commit(to)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := toCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var fromTree *object.Tree
+	if from != "" {
+		fromCommit, er := this.commit(from)
+		if er != nil {
+			return nil, er
+		}
+		fromTree, err = fromCommit.Tree()
+	} else if toCommit.NumParents() > 0 {
+		parent, er := toCommit.Parent(0)
+		if er != nil {
+			return nil, er
+		}
+		fromTree, err = parent.Tree()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]*types.FileDiff, 0, len(changes))
+	for _, change := range changes {
+		action, er := change.Action()
+		if er != nil {
+			return nil, er
+		}
+		patch, er := change.Patch()
+		if er != nil {
+			return nil, er
+		}
+		fileDiff := &types.FileDiff{Path: change.To.Name, Diff: patch.String()}
+		switch action {
+		case merkletrie.Insert:
+			fileDiff.Status = "added"
+		case merkletrie.Delete:
+			fileDiff.Status = "deleted"
+			fileDiff.Path = change.From.Name
+		default:
+			fileDiff.Status = "modified"
+		}
+		diffs = append(diffs, fileDiff)
+	}
+	return diffs, nil
+}
+
+// Checkout restores the workspace to the files of the commit and records that as a
+// new commit on top of the history, so no commit is ever lost by a checkout.
+func (this *ProjectRepo) Checkout(hash string) (*types.ProjectCommit, error) {
+	commit, err := this.commit(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string][]byte)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		data, er := file.Contents()
+		if er != nil {
+			return er
+		}
+		contents[file.Name] = []byte(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	paths, err := this.workspace.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		_, ok := contents[path]
+		if !ok {
+			err = this.workspace.Remove(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for path, data := range contents {
+		err = this.workspace.WriteFile(path, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	checkout, err := this.Commit("Checkout of " + commit.Hash.String()[:7] + ": " +
+		strings.SplitN(commit.Message, "\n", 2)[0])
+	if checkout == nil && err == nil {
+		// the workspace already has the content of the commit
+		return this.toProjectCommit(commit), nil
+	}
+	return checkout, err
+}
+
+// Bundle writes the repository as a git bundle (v2) of the current branch,
+// which can be cloned with "git clone <file>".
+func (this *ProjectRepo) Bundle(w io.Writer) error {
+	head, err := this.repo.Head()
+	if err != nil {
+		return errors.New("project " + this.name + " has no commits")
+	}
+	hashes, err := revlist.Objects(this.repo.Storer, []plumbing.Hash{head.Hash()}, nil)
+	if err != nil {
+		return err
+	}
+	header := "# v2 git bundle\n" + head.Hash().String() + " " + head.Name().String() + "\n" +
+		head.Hash().String() + " HEAD\n\n"
+	_, err = io.WriteString(w, header)
+	if err != nil {
+		return err
+	}
+	_, err = packfile.NewEncoder(w, this.repo.Storer, false).Encode(hashes, 10)
+	return err
+}
+
+// commit resolves a revision, the current HEAD if it is empty
+func (this *ProjectRepo) commit(revision string) (*object.Commit, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	hash, err := this.repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, errors.New("revision " + revision + " of " + this.name + " does not exist")
+	}
+	return this.repo.CommitObject(*hash)
+}
+
+func (this *ProjectRepo) toProjectCommit(commit *object.Commit) *types.ProjectCommit {
+	return &types.ProjectCommit{User: this.user, Name: this.name, Hash: commit.Hash.String(),
+		Message: commit.Message, Author: commit.Author.Name, Created: commit.Author.When.Unix()}
+}
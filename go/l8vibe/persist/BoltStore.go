@@ -0,0 +1,98 @@
+package persist
+
+import (
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+var projectsBucket = []byte("projects")
+
+// BoltStore keeps the projects in an embedded bbolt database, one record per
+// user/name key. bbolt locks the database file, so every process needs its own path.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the database at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, er := tx.CreateBucketIfNotExists(projectsBucket)
+		return er
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Save creates or replaces the project in a single transaction
+func (this *BoltStore) Save(project *types.Project) error {
+	key, err := recordKey(project.User, project.Name)
+	if err != nil {
+		return err
+	}
+	record, err := encodeRecord(project)
+	if err != nil {
+		return err
+	}
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(projectsBucket).Put(key, record)
+	})
+}
+
+// Delete removes the project
+func (this *BoltStore) Delete(user, name string) error {
+	key, err := recordKey(user, name)
+	if err != nil {
+		return err
+	}
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(projectsBucket).Delete(key)
+	})
+}
+
+// recordKey returns the key of the record of the project, the names are validated like
+// the names of the file store so a project can move between the stores
+func recordKey(user, name string) ([]byte, error) {
+	err := CheckProject(user, name)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(projectKey(user, name)), nil
+}
+
+// Load reads all the records, reporting the ones that fail validation
+func (this *BoltStore) Load() ([]*types.Project, error) {
+	projects := make([]*types.Project, 0)
+	corrupt := CorruptRecords{}
+	err := this.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(projectsBucket).ForEach(func(key, record []byte) error {
+			project, er := decodeRecord(record)
+			if er != nil {
+				corrupt = append(corrupt, &CorruptRecord{Key: string(key), Reason: er.Error()})
+				return nil
+			}
+			projects = append(projects, project)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(corrupt) > 0 {
+		return projects, corrupt
+	}
+	return projects, nil
+}
+
+// Close closes the database
+func (this *BoltStore) Close() error {
+	return this.db.Close()
+}
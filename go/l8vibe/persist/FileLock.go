@@ -0,0 +1,31 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockFile takes the exclusive lock of a file that more than one process reads, changes
+// and writes back, such as the usage ledger of the web server and the project node.
+// The lock is held on a .lock file next to it, so the file itself can still be replaced
+// with WriteAtomic. It returns the function that releases the lock.
+func LockFile(fileName string) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fileName+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,183 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// FileStore keeps every project in {dir}/{user}/{name}.dat. Writes go to a temporary
+// file that is synced and renamed over the record, so a crash never leaves a partial
+// record behind, and every record carries a checksum that is validated on load.
+type FileStore struct {
+	dir   string
+	locks map[string]*sync.Mutex
+	mtx   sync.Mutex
+}
+
+// NewFileStore returns a file store rooted at dir
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir, locks: make(map[string]*sync.Mutex)}
+}
+
+// fileName returns the record file of the project, the user and the name are rejected
+// if they would place it outside of its user directory
+func (this *FileStore) fileName(user, name string) (string, error) {
+	err := CheckProject(user, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(this.dir, user, name+".dat"), nil
+}
+
+// lock serializes the writers of a record
+func (this *FileStore) lock(user, name string) *sync.Mutex {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	key := projectKey(user, name)
+	lock, ok := this.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		this.locks[key] = lock
+	}
+	lock.Lock()
+	return lock
+}
+
+// Save atomically replaces the record of the project
+func (this *FileStore) Save(project *types.Project) error {
+	record, err := encodeRecord(project)
+	if err != nil {
+		return err
+	}
+	fileName, err := this.fileName(project.User, project.Name)
+	if err != nil {
+		return err
+	}
+	defer this.lock(project.User, project.Name).Unlock()
+	return WriteAtomic(fileName, record)
+}
+
+// Delete removes the record of the project
+func (this *FileStore) Delete(user, name string) error {
+	fileName, err := this.fileName(user, name)
+	if err != nil {
+		return err
+	}
+	defer this.lock(user, name).Unlock()
+	err = os.Remove(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Join(this.dir, user))
+}
+
+// Load reads all the records, reporting the ones that fail validation
+func (this *FileStore) Load() ([]*types.Project, error) {
+	projects := make([]*types.Project, 0)
+	corrupt := CorruptRecords{}
+	users, err := os.ReadDir(this.dir)
+	if os.IsNotExist(err) {
+		return projects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		entries, er := os.ReadDir(filepath.Join(this.dir, user.Name()))
+		if er != nil {
+			corrupt = append(corrupt, &CorruptRecord{Key: user.Name(), Reason: er.Error()})
+			continue
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".dat") || entry.IsDir() {
+				continue
+			}
+			fileName := filepath.Join(this.dir, user.Name(), entry.Name())
+			record, er := os.ReadFile(fileName)
+			if er != nil {
+				corrupt = append(corrupt, &CorruptRecord{Key: fileName, Reason: er.Error()})
+				continue
+			}
+			project, er := decodeRecord(record)
+			if er != nil {
+				corrupt = append(corrupt, &CorruptRecord{Key: fileName, Reason: er.Error()})
+				continue
+			}
+			if projectKey(project.User, project.Name) != projectKey(user.Name(), strings.TrimSuffix(entry.Name(), ".dat")) {
+				corrupt = append(corrupt, &CorruptRecord{Key: fileName,
+					Reason: "record belongs to " + projectKey(project.User, project.Name)})
+				continue
+			}
+			projects = append(projects, project)
+		}
+	}
+	if len(corrupt) > 0 {
+		return projects, corrupt
+	}
+	return projects, nil
+}
+
+// Close has nothing to release
+func (this *FileStore) Close() error {
+	return nil
+}
+
+// WriteAtomic replaces the file with data through a synced temporary file that is
+// renamed over it, so readers see either the old or the new content
+func WriteAtomic(fileName string, data []byte) error {
+	return WriteAtomicMode(fileName, data, 0644)
+}
+
+// WriteAtomicMode is WriteAtomic with the permissions of the file
+func WriteAtomicMode(fileName string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(fileName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), fileName)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename or remove in the directory durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
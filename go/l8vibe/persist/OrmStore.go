@@ -0,0 +1,82 @@
+package persist
+
+import (
+	"errors"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// OrmStore keeps the projects in the l8orm service of the vnet, which maps them
+// to the database tables of the deployment.
+type OrmStore struct {
+	vnic ifs.IVNic
+}
+
+// NewOrmStore returns a store that persists through the l8orm service
+func NewOrmStore(vnic ifs.IVNic) *OrmStore {
+	return &OrmStore{vnic: vnic}
+}
+
+func (this *OrmStore) request(action ifs.Action, element interface{}) (ifs.IElements, error) {
+	resp := this.vnic.Proximity(consts.ORM_SERVICE_NAME, consts.ORM_SERVICE_AREA, action, element)
+	if resp == nil {
+		return nil, errors.New("no response from the orm service")
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return resp, nil
+}
+
+// Save creates or replaces the project
+func (this *OrmStore) Save(project *types.Project) error {
+	err := CheckProject(project.User, project.Name)
+	if err != nil {
+		return err
+	}
+	_, err = this.request(ifs.POST, project)
+	return err
+}
+
+// Delete removes the project
+func (this *OrmStore) Delete(user, name string) error {
+	err := CheckProject(user, name)
+	if err != nil {
+		return err
+	}
+	_, err = this.request(ifs.DELETE, &types.Project{User: user, Name: name})
+	return err
+}
+
+// Load queries all the projects, reporting the ones without a key
+func (this *OrmStore) Load() ([]*types.Project, error) {
+	resp, err := this.request(ifs.GET, "select * from project")
+	if err != nil {
+		return nil, err
+	}
+	projects := make([]*types.Project, 0)
+	corrupt := CorruptRecords{}
+	for _, elem := range resp.Elements() {
+		project, ok := elem.(*types.Project)
+		if !ok {
+			continue
+		}
+		if project.User == "" || project.Name == "" {
+			corrupt = append(corrupt, &CorruptRecord{Key: projectKey(project.User, project.Name),
+				Reason: "record has no project user or name"})
+			continue
+		}
+		projects = append(projects, project)
+	}
+	if len(corrupt) > 0 {
+		return projects, corrupt
+	}
+	return projects, nil
+}
+
+// Close has nothing to release
+func (this *OrmStore) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package persist
+
+import (
+	"strings"
+)
+
+// reservedNames may not be used as a file or directory name of a project
+var reservedNames = map[string]bool{
+	".git": true, ".l8vibe": true,
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// InvalidNameError is a user or project name that cannot be used as a file or directory name
+type InvalidNameError struct {
+	Name   string
+	Reason string
+}
+
+func (this *InvalidNameError) Error() string {
+	return "invalid project " + this.Name + ": " + this.Reason
+}
+
+// CheckName returns why the name cannot be a file or directory name, empty if it can
+func CheckName(name string) string {
+	switch {
+	case name == "":
+		return "empty name"
+	case name == "." || name == "..":
+		return "relative path element"
+	case len(name) > 255:
+		return "name is too long"
+	case strings.ContainsAny(name, "/\\\x00"):
+		return "name contains a path separator or NUL"
+	case IsReserved(strings.SplitN(name, ".", 2)[0]) || IsReserved(name):
+		return "reserved name"
+	}
+	return ""
+}
+
+// IsReserved reports a reserved name, such as the directory of the git repository
+func IsReserved(name string) bool {
+	return reservedNames[strings.ToLower(name)]
+}
+
+// CheckProject validates the user and the name of a project, which are client supplied
+// and become path elements of the workspace, the snapshots, the stream and the record
+func CheckProject(user, name string) error {
+	for _, element := range []string{user, name} {
+		reason := CheckName(element)
+		if reason != "" {
+			return &InvalidNameError{Name: element, Reason: reason}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProjectStore persists the projects of the ProjectService.
+// The implementation is selected with the consts.PROJECT_STORE_ENV environment variable.
+type ProjectStore interface {
+	// Save creates or replaces the project
+	Save(project *types.Project) error
+	// Delete removes the project, it is not an error if it does not exist
+	Delete(user, name string) error
+	// Load returns all the readable projects. Records that could not be read are
+	// returned as CorruptRecords together with the readable projects.
+	Load() ([]*types.Project, error)
+	// Close releases the store
+	Close() error
+}
+
+// CorruptRecord is a persisted project that could not be read
+type CorruptRecord struct {
+	Key    string
+	Reason string
+}
+
+func (this *CorruptRecord) Error() string {
+	return this.Key + ": " + this.Reason
+}
+
+// CorruptRecords are all the records that failed to load
+type CorruptRecords []*CorruptRecord
+
+func (this CorruptRecords) Error() string {
+	msgs := make([]string, len(this))
+	for i, err := range this {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// NewProjectStore returns the store configured for the deployment, the vnic is
+// used by the l8orm store to reach the orm service.
+func NewProjectStore(vnic ifs.IVNic) (ProjectStore, error) {
+	kind := os.Getenv(consts.PROJECT_STORE_ENV)
+	path := os.Getenv(consts.PROJECT_STORE_PATH_ENV)
+	switch kind {
+	case "", consts.PROJECT_STORE_FILE:
+		if path == "" {
+			path = consts.PROJECT_STORE_DIR
+		}
+		return NewFileStore(path), nil
+	case consts.PROJECT_STORE_BOLT:
+		if path == "" {
+			path = consts.PROJECT_STORE_BOLT_FILE
+		}
+		return NewBoltStore(path)
+	case consts.PROJECT_STORE_ORM:
+		if vnic == nil {
+			return nil, errors.New("the l8orm project store requires a vnic")
+		}
+		return NewOrmStore(vnic), nil
+	}
+	return nil, errors.New("unknown project store " + kind)
+}
+
+// DataDir returns the directory the project data next to the records is kept in, such as
+// the streams and the snapshots: the directory of the file store, the directory of the
+// bolt file, or the default store directory.
+func DataDir() string {
+	path := os.Getenv(consts.PROJECT_STORE_PATH_ENV)
+	if path == "" {
+		return consts.PROJECT_STORE_DIR
+	}
+	switch os.Getenv(consts.PROJECT_STORE_ENV) {
+	case "", consts.PROJECT_STORE_FILE:
+		return path
+	case consts.PROJECT_STORE_BOLT:
+		return filepath.Dir(path)
+	}
+	return consts.PROJECT_STORE_DIR
+}
+
+func projectKey(user, name string) string {
+	return user + "/" + name
+}
+
+// recordMagic prefixes a record written with a checksum, records without it
+// are raw protobuf written before checksums were introduced
+var recordMagic = []byte("L8VP")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecord marshals the project prefixed with the magic and a crc32c of the data
+func encodeRecord(project *types.Project) ([]byte, error) {
+	data, err := proto.Marshal(project)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, len(recordMagic)+4+len(data))
+	copy(record, recordMagic)
+	binary.BigEndian.PutUint32(record[len(recordMagic):], crc32.Checksum(data, crcTable))
+	copy(record[len(recordMagic)+4:], data)
+	return record, nil
+}
+
+// decodeRecord validates the checksum of a record and unmarshals the project
+func decodeRecord(record []byte) (*types.Project, error) {
+	data := record
+	if bytes.HasPrefix(record, recordMagic) {
+		if len(record) < len(recordMagic)+4 {
+			return nil, errors.New("record is truncated")
+		}
+		sum := binary.BigEndian.Uint32(record[len(recordMagic):])
+		data = record[len(recordMagic)+4:]
+		if crc32.Checksum(data, crcTable) != sum {
+			return nil, errors.New("checksum mismatch")
+		}
+	}
+	project := &types.Project{}
+	err := proto.Unmarshal(data, project)
+	if err != nil {
+		return nil, err
+	}
+	if project.User == "" || project.Name == "" {
+		return nil, errors.New("record has no project user or name")
+	}
+	return project, nil
+}
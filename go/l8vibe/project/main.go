@@ -19,9 +19,10 @@ func main() {
 	nic.Start()
 	nic.WaitForConnection()
 
-	nic.Resources().Registry().Register(&service.ProjectService{})
-	nic.Resources().Services().Activate(service.ServiceType, service.ServiceName, service.ServiceArea,
-		resources, nic)
+	_, err := service.ActivateServices(resources, nic)
+	if err != nil {
+		panic(err)
+	}
 
 	resources.Logger().Info("Project started!")
 	resources.Logger().SetLogLevel(ifs.Error_Level)
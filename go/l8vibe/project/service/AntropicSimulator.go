@@ -7,14 +7,12 @@ import (
 	"strconv"
 	"strings"
 
-	strings2 "github.com/saichler/l8utils/go/utils/strings"
 	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
 	"github.com/saichler/vibe.with.layer8/go/types"
 )
 
 type AntropicSimulator struct {
 	steps []*RequestResponse
-	ai    *anthropic.AnthropicClient
 }
 
 type RequestResponse struct {
@@ -25,7 +23,6 @@ type RequestResponse struct {
 func NewAnthropicSimulator() *AntropicSimulator {
 	sim := &AntropicSimulator{}
 	sim.load()
-	sim.ai = anthropic.NewAnthropicClient()
 	return sim
 }
 
@@ -52,32 +49,24 @@ func (this *AntropicSimulator) load() {
 	}
 }
 
+// Do replays the recorded turn that follows the turns of the project. The simulator
+// replays legacy markdown responses by their turn index only, exchanges with the model
+// are recorded and replayed with the anthropic.Cassette.
 func (this *AntropicSimulator) Do(text string, project *types.Project) error {
 	if project.Messages == nil {
 		project.Messages = make([]*types.Message, 0)
 	}
 	step := len(project.Messages) / 2
-	if step < len(this.steps) {
-		msg := &types.Message{}
-		msg.Role = "user"
-		msg.Content = this.steps[step].request
-		project.Messages = append(project.Messages, msg)
-		msg = &types.Message{}
-		msg.Role = "assistant"
-		msg.Content = this.steps[step].respond
-		project.Messages = append(project.Messages, msg)
-		return anthropic.ParseMessages(project)
+	if step >= len(this.steps) {
+		return errors.New("End of Simulation")
 	}
-	return errors.New("End of Simulation")
-	err := this.ai.Do(text, project)
-	if err != nil {
-		return err
-	}
-	reqFileName := strings2.New("./resources/", step+2, ".request").String()
-	resFileName := strings2.New("./resources/", step+2, ".respond").String()
-	os.WriteFile(reqFileName, []byte(text), 0777)
-	os.WriteFile(resFileName, []byte(project.Messages[len(project.Messages)-1].Content), 0777)
-
-	anthropic.ParseMessages(project)
-	return nil
+	msg := &types.Message{}
+	msg.Role = "user"
+	msg.Content = this.steps[step].request
+	project.Messages = append(project.Messages, msg)
+	msg = &types.Message{}
+	msg.Role = "assistant"
+	msg.Content = this.steps[step].respond
+	project.Messages = append(project.Messages, msg)
+	return anthropic.ApplyTurn(project, len(project.Messages)-1)
 }
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/reflect/go/reflect/introspecting"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	AuditServiceType = "AuditService"
+	AuditServiceName = "audit"
+	AuditServiceArea = byte(0)
+)
+
+// AuditService exposes the audit log of the project operations for compliance reviews.
+// GET returns a record by seq, the records of a project, or the records matching a query.
+// The records are written by the project service only, the log cannot be changed through
+// the service.
+type AuditService struct {
+}
+
+// Activate activates the AuditService
+func (this *AuditService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.AuditRecord{})
+	resources.Registry().Register(&types.AuditRecordList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	node, _ := resources.Introspector().Inspect(&types.AuditRecord{})
+	introspecting.AddPrimaryKeyDecorator(node, "Seq")
+	return nil
+}
+
+// DeActivate deactivates the AuditService
+func (this *AuditService) DeActivate() error {
+	return nil
+}
+
+// Post is not supported, the audit log is append only
+func (this *AuditService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("The audit log is written by the project service only")
+}
+
+// Put is not supported, the audit log is append only
+func (this *AuditService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("The audit log cannot be modified")
+}
+
+// Patch is not supported, the audit log is append only
+func (this *AuditService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("The audit log cannot be modified")
+}
+
+// Delete is not supported, the audit log is append only
+func (this *AuditService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("The audit log cannot be modified")
+}
+
+// GetCopy handles GET requests for copies, the records are copies already
+func (this *AuditService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return this.Get(elements, vnic)
+}
+
+// Get returns the records of the audit log the caller may read
+func (this *AuditService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	records := projects.AuditRecords(viewer)
+	if elements.IsFilterMode() {
+		filter, isRecord := elements.Element().(*types.AuditRecord)
+		if isRecord && filter.Seq != 0 {
+			for _, record := range records {
+				if record.Seq == filter.Seq {
+					return object.New(nil, record)
+				}
+			}
+			return object.NewError("Audit record " + strconv.FormatInt(filter.Seq, 10) + " was not found")
+		}
+		if isRecord && filter.Name != "" {
+			list := &types.AuditRecordList{List: make([]*types.AuditRecord, 0)}
+			for _, record := range records {
+				if record.Name == filter.Name && (filter.User == "" || record.User == filter.User) {
+					list.List = append(list.List, record)
+				}
+			}
+			return object.New(nil, list)
+		}
+	}
+
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	for _, record := range records {
+		if query.Match(record) {
+			result = append(result, record)
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *AuditService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *AuditService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *AuditService) WebService() ifs.IWebService {
+	ws := web.New(AuditServiceName, AuditServiceArea, nil,
+		nil, nil, nil, nil, nil, nil, nil,
+		&l8api.L8Query{}, &types.AuditRecordList{})
+	return ws
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/usage"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// generationJob is a turn of a project running in the background
+type generationJob struct {
+	job        *types.GenerationJob
+	key        string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	actor      string
+	promptHash string
+}
+
+func newJobId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func isJobDone(job *types.GenerationJob) bool {
+	return job.State == types.JobState_JOB_SUCCEEDED || job.State == types.JobState_JOB_FAILED ||
+		job.State == types.JobState_JOB_CANCELLED
+}
+
+// submit validates the patch request and queues a generation job for it. A project has
+// at most one job that is not done, the job waits for the project lock before it runs.
+// When streaming, the assistant text is streamed through the returned stream. The actor
+// is the user the turn is audited as.
+func (this *ProjectService) submit(project *types.Project, streaming bool, actor string) (*types.GenerationJob, *ProjectStream, error) {
+	if project.Name == "" || project.User == "" || project.Messages == nil || len(project.Messages) == 0 {
+		return nil, nil, errors.New("Patch request for project is invalid")
+	}
+	current, _ := this.cache.Get(project)
+	currentProj, ok := current.(*types.Project)
+	if !ok || currentProj.DeletedAt != 0 {
+		return nil, nil, errors.New("Project " + project.Name + " was not found")
+	}
+	err := checkRevision(project, currentProj)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = this.ledger.Check(project.User)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := streamKey(project)
+	this.jobsMtx.Lock()
+	this.pruneJobs()
+	for _, active := range this.jobs {
+		if active.key == key && !isJobDone(active.job) {
+			this.jobsMtx.Unlock()
+			return nil, nil, errors.New("A generation is already in progress for " + project.Name)
+		}
+	}
+	// the stream replaces the persisted stream of the previous turn only once the job is accepted
+	var stream *ProjectStream
+	if streaming {
+		stream, err = newProjectStream(project)
+		if err != nil {
+			this.jobsMtx.Unlock()
+			return nil, nil, err
+		}
+		this.streamsMtx.Lock()
+		this.streams[key] = stream
+		this.streamsMtx.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &generationJob{key: key, ctx: ctx, cancel: cancel, actor: actor, promptHash: promptHash(project),
+		job: &types.GenerationJob{Id: newJobId(), User: project.User, Name: project.Name,
+			State: types.JobState_JOB_QUEUED, Progress: "Queued", Created: time.Now().Unix()}}
+	this.jobs[job.job.Id] = job
+	queued := proto.Clone(job.job).(*types.GenerationJob)
+	this.jobsMtx.Unlock()
+
+	go this.runJob(job, project, stream)
+	return queued, stream, nil
+}
+
+// runJob runs the turn of the job and records its outcome
+func (this *ProjectService) runJob(job *generationJob, project *types.Project, stream *ProjectStream) {
+	defer job.cancel()
+	working, unlock, err := this.beginTurn(project)
+	if err != nil {
+		this.finishJob(job, nil, err, stream)
+		return
+	}
+	defer unlock()
+	if job.ctx.Err() != nil {
+		this.finishJob(job, nil, job.ctx.Err(), stream)
+		return
+	}
+	this.updateJob(job, func(j *types.GenerationJob) {
+		j.State = types.JobState_JOB_RUNNING
+		j.Started = time.Now().Unix()
+		j.Progress = "Waiting for the model"
+	})
+
+	provider, err := this.generator.Provider(working.Settings)
+	if err != nil {
+		this.finishJob(job, nil, err, stream)
+		return
+	}
+	apiKey, err := this.turnKey(project, working, job.actor)
+	if err != nil {
+		this.finishJob(job, nil, err, stream)
+		return
+	}
+	// self hosted models are not billed, their turns count tokens but cost nothing
+	metered := provider.Capabilities().Metered
+	start := len(working.Messages)
+	ensureBaseline(working)
+	turn := &anthropic.Turn{Context: job.ctx, ApiKey: apiKey, OnResponse: func(resp *types.ClaudeResponse) {
+		this.updateJob(job, func(j *types.GenerationJob) {
+			j.Rounds++
+			if resp.Usage != nil {
+				j.InputTokens += int64(resp.Usage.InputTokens)
+				j.OutputTokens += int64(resp.Usage.OutputTokens)
+				j.CacheCreationTokens += int64(resp.Usage.CacheCreationInputTokens)
+				j.CacheReadTokens += int64(resp.Usage.CacheReadInputTokens)
+				if metered {
+					j.Cost += usage.EstimateCost(resp.Model, resp.Usage)
+					j.Saved += usage.EstimateSavings(resp.Model, resp.Usage)
+				}
+			}
+			if resp.Model != "" {
+				j.Model = resp.Model
+			}
+			for _, path := range anthropic.ToolPaths(resp.Content) {
+				if !containsString(j.Files, path) {
+					j.Files = append(j.Files, path)
+				}
+			}
+			j.Progress = "Model round " + strconv.Itoa(int(j.Rounds)) + ", " + strconv.Itoa(len(j.Files)) + " files touched"
+		})
+	}}
+	turn.OnContext = func(report *types.ContextReport) {
+		working.Context = report
+		this.updateJob(job, func(j *types.GenerationJob) {
+			j.Context = report
+		})
+	}
+	turn.OnRetry = func(err *anthropic.APIError, wait time.Duration) {
+		this.updateJob(job, func(j *types.GenerationJob) {
+			j.Progress = "Model call failed with " + err.Kind + ", retrying in " + wait.Round(time.Second).String()
+		})
+	}
+	if stream != nil {
+		turn.OnDelta = stream.write
+	}
+	err = this.generator.Run(turn, project.Messages[0].Content, working)
+	if err != nil {
+		fmt.Println("Generation failed for ", project.Name, ": ", secrets.Mask(err.Error()))
+		this.finishJob(job, nil, err, stream)
+		return
+	}
+	this.updateJob(job, func(j *types.GenerationJob) {
+		j.Progress = "Applying the changes"
+	})
+	appendUsage(working, this.turnUsage(job))
+	err = this.completeTurn(project, working, start)
+	if err != nil {
+		fmt.Println("Failed to complete the turn of ", project.Name, ": ", err.Error())
+		this.finishJob(job, nil, err, stream)
+		return
+	}
+	project.JobId = job.job.Id
+	this.finishJob(job, project, nil, stream)
+}
+
+// finishJob records the result or the error of the job, a job whose context was
+// cancelled is cancelled rather than failed
+func (this *ProjectService) finishJob(job *generationJob, result *types.Project, err error, stream *ProjectStream) {
+	if err != nil {
+		// provider errors may echo the key the call was made with
+		err = errors.New(secrets.Mask(err.Error()))
+	}
+	this.updateJob(job, func(j *types.GenerationJob) {
+		j.Finished = time.Now().Unix()
+		switch {
+		case err == nil:
+			j.State = types.JobState_JOB_SUCCEEDED
+			j.Progress = "Done"
+			j.Result = result
+		case job.ctx.Err() != nil:
+			j.State = types.JobState_JOB_CANCELLED
+			j.Progress = "Cancelled"
+			err = errors.New("generation was cancelled")
+			j.Error = err.Error()
+		default:
+			j.State = types.JobState_JOB_FAILED
+			j.Progress = "Failed"
+			j.Error = err.Error()
+		}
+	})
+	// the tokens of failed and cancelled turns were spent as well
+	this.recordUsage(job)
+	this.auditTurn(job, result, err)
+	if stream != nil {
+		stream.finish(result, err)
+	}
+}
+
+func (this *ProjectService) updateJob(job *generationJob, update func(*types.GenerationJob)) {
+	this.jobsMtx.Lock()
+	defer this.jobsMtx.Unlock()
+	update(job.job)
+}
+
+// jobRetention returns how long the jobs that are done are kept for their clients to poll,
+// taken from the consts.JOB_RETENTION_ENV environment variable
+func jobRetention() time.Duration {
+	value := os.Getenv(consts.JOB_RETENTION_ENV)
+	if value == "" {
+		return consts.JOB_RETENTION
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		fmt.Println("Invalid job retention ", value, ", using ", consts.JOB_RETENTION.String())
+		return consts.JOB_RETENTION
+	}
+	return retention
+}
+
+// pruneJobs drops the jobs that are done for longer than the job retention,
+// called with jobsMtx held
+func (this *ProjectService) pruneJobs() {
+	deadline := time.Now().Add(-this.jobRetention).Unix()
+	for id, job := range this.jobs {
+		if isJobDone(job.job) && job.job.Finished < deadline {
+			delete(this.jobs, id)
+		}
+	}
+}
+
+// Job returns a copy of the job with the id
+func (this *ProjectService) Job(id string) (*types.GenerationJob, bool) {
+	this.jobsMtx.Lock()
+	defer this.jobsMtx.Unlock()
+	job, ok := this.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return proto.Clone(job.job).(*types.GenerationJob), true
+}
+
+// Jobs returns copies of all the known jobs
+func (this *ProjectService) Jobs() []*types.GenerationJob {
+	this.jobsMtx.Lock()
+	defer this.jobsMtx.Unlock()
+	list := make([]*types.GenerationJob, 0, len(this.jobs))
+	for _, job := range this.jobs {
+		list = append(list, proto.Clone(job.job).(*types.GenerationJob))
+	}
+	return list
+}
+
+// CancelJob aborts the job, the in-flight model request is cancelled through its context
+func (this *ProjectService) CancelJob(id string) (*types.GenerationJob, error) {
+	this.jobsMtx.Lock()
+	job, ok := this.jobs[id]
+	this.jobsMtx.Unlock()
+	if !ok {
+		return nil, errors.New("Job " + id + " was not found")
+	}
+	job.cancel()
+	this.updateJob(job, func(j *types.GenerationJob) {
+		// a queued job is done right away, it only waits for the project lock to exit
+		if j.State == types.JobState_JOB_QUEUED {
+			j.State = types.JobState_JOB_CANCELLED
+			j.Progress = "Cancelled"
+			j.Finished = time.Now().Unix()
+		}
+	})
+	result, _ := this.Job(id)
+	return result, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/gitrepo"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	GitServiceType = "GitService"
+	GitServiceName = "pgit"
+	GitServiceArea = byte(0)
+)
+
+// GitService exposes the git history of the project workspaces.
+// GET lists the commits, POST of a CommitDiff returns the differences between two
+// commits and PUT of a ProjectCommit checks the workspace out at that commit.
+type GitService struct {
+}
+
+// Activate activates the GitService
+func (this *GitService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.ProjectCommit{})
+	resources.Registry().Register(&types.ProjectCommitList{})
+	resources.Registry().Register(&types.CommitDiff{})
+	resources.Registry().Register(&l8api.L8Query{})
+	resources.Introspector().Inspect(&types.ProjectCommit{})
+	return nil
+}
+
+// DeActivate deactivates the GitService
+func (this *GitService) DeActivate() error {
+	return nil
+}
+
+// Post returns the differences between the From and To commits of a CommitDiff
+func (this *GitService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	diff, ok := elements.Element().(*types.CommitDiff)
+	if !ok {
+		return object.NewError("Commit diff request is invalid")
+	}
+	err := accessWith(vnic, elements, &diff.User, diff.Name, types.ProjectRole_ROLE_VIEWER)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	repo, err := gitrepo.ReadProjectRepo(&types.Project{User: diff.User, Name: diff.Name})
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	diff.Files, err = repo.Diff(diff.From, diff.To)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	return object.New(nil, diff)
+}
+
+// Put checks the project workspace out at the commit, recorded as a new commit
+func (this *GitService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	commit, ok := elements.Element().(*types.ProjectCommit)
+	if !ok || commit.Hash == "" {
+		return object.NewError("Checkout request is invalid")
+	}
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	project := &types.Project{User: commit.User, Name: commit.Name}
+	return projects.audited(elements, audit.OP_CHECKOUT, project, types.ProjectRole_ROLE_EDITOR, func(record *types.AuditRecord) ifs.IElements {
+		record.Detail = "commit " + commit.Hash
+		checkout, err := projects.Checkout(project.User, project.Name, commit.Hash)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, checkout)
+	})
+}
+
+// Patch is not supported, commits are immutable
+func (this *GitService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Commits are immutable")
+}
+
+// Delete is not supported, commits are immutable
+func (this *GitService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Commits are immutable")
+}
+
+// GetCopy handles GET requests for copies
+func (this *GitService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return nil
+}
+
+// Get returns the log of a project in filter mode, or the commits matching a query
+func (this *GitService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	if elements.IsFilterMode() {
+		commit, ok := elements.Element().(*types.ProjectCommit)
+		if ok {
+			err := accessWith(vnic, elements, &commit.User, commit.Name, types.ProjectRole_ROLE_VIEWER)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			repo, err := gitrepo.ReadProjectRepo(&types.Project{User: commit.User, Name: commit.Name})
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			list, err := repo.Log(0)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			return object.New(nil, &types.ProjectCommitList{List: list})
+		}
+	}
+
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	dirs, _ := filepath.Glob(filepath.Join(consts.WORKSPACE_ROOT, "*", "*", ".git"))
+	for _, dir := range dirs {
+		workspace := filepath.Dir(dir)
+		user := filepath.Base(filepath.Dir(workspace))
+		name := filepath.Base(workspace)
+		if !projects.canView(user, name, viewer) {
+			continue
+		}
+		repo, er := gitrepo.ReadProjectRepo(&types.Project{User: user, Name: name})
+		if er != nil {
+			continue
+		}
+		list, er := repo.Log(0)
+		if er != nil {
+			fmt.Println("Failed to read the log of ", name, ": ", er.Error())
+			continue
+		}
+		for _, commit := range list {
+			if query.Match(commit) {
+				result = append(result, commit)
+			}
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *GitService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *GitService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *GitService) WebService() ifs.IWebService {
+	ws := web.New(GitServiceName, GitServiceArea, &types.CommitDiff{},
+		&types.CommitDiff{}, &types.ProjectCommit{}, &types.ProjectCommit{}, nil, nil, nil, nil,
+		&l8api.L8Query{}, &types.ProjectCommitList{})
+	return ws
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+)
+
+// ErrUnauthenticated is returned for a request without an authenticated user
+var ErrUnauthenticated = errors.New("Authentication is required")
+
+// Authorize binds a request of the authenticated identity to the user it names. A request
+// that names another user is rejected and one that names no user acts as the identity.
+// Without an identity the named user is trusted only if authentication is disabled.
+func (this *ProjectService) Authorize(identity string, user *string) error {
+	if identity == "" {
+		if this.authRequired {
+			return ErrUnauthenticated
+		}
+		return nil
+	}
+	if *user != "" && auth.NormalizeUser(*user) != identity {
+		return errors.New("User " + identity + " cannot act as " + *user)
+	}
+	*user = identity
+	return nil
+}
+
+// identify authorizes the request with the identity the web server authenticated it as
+func (this *ProjectService) identify(elements ifs.IElements, user *string) error {
+	return this.Authorize(elements.AAAId(), user)
+}
+
+// viewer returns the user whose elements a query may return, empty for all of them
+// when authentication is disabled
+func (this *ProjectService) viewer(elements ifs.IElements) (string, error) {
+	user := ""
+	err := this.identify(elements, &user)
+	return user, err
+}
+
+// identifyWith authorizes a request of another service through the project service
+func identifyWith(vnic ifs.IVNic, elements ifs.IElements, user *string) error {
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return errors.New("Project service is not available")
+	}
+	return projects.identify(elements, user)
+}
+
+// viewerWith returns the viewer of a query of another service
+func viewerWith(vnic ifs.IVNic, elements ifs.IElements) (string, error) {
+	user := ""
+	err := identifyWith(vnic, elements, &user)
+	return user, err
+}
+
+// projectsOf returns the project service of the vnic, which holds the authentication
+func projectsOf(vnic ifs.IVNic) (*ProjectService, bool) {
+	handler, ok := vnic.Resources().Services().ServiceHandler(ServiceName, ServiceArea)
+	if !ok {
+		return nil, false
+	}
+	projects, ok := handler.(*ProjectService)
+	return projects, ok
+}
+
+// ownedBy reports whether an element of the owner is visible to the viewer
+func ownedBy(owner, viewer string) bool {
+	return viewer == "" || auth.NormalizeUser(owner) == viewer
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/reflect/go/reflect/introspecting"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	JobServiceType = "JobService"
+	JobServiceName = "job"
+	JobServiceArea = byte(0)
+)
+
+// JobService exposes the generation jobs of the projects.
+// GET returns a job by id or the jobs matching a query and DELETE cancels a job,
+// which stays queryable in the cancelled state.
+type JobService struct {
+}
+
+// Activate activates the JobService
+func (this *JobService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.GenerationJob{})
+	resources.Registry().Register(&types.GenerationJobList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	node, _ := resources.Introspector().Inspect(&types.GenerationJob{})
+	introspecting.AddPrimaryKeyDecorator(node, "Id")
+	return nil
+}
+
+// DeActivate deactivates the JobService
+func (this *JobService) DeActivate() error {
+	return nil
+}
+
+func (this *JobService) projects(vnic ifs.IVNic) (*ProjectService, bool) {
+	handler, ok := vnic.Resources().Services().ServiceHandler(ServiceName, ServiceArea)
+	if !ok {
+		return nil, false
+	}
+	projects, ok := handler.(*ProjectService)
+	return projects, ok
+}
+
+// Post is not supported, jobs are created by patching a project
+func (this *JobService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Jobs are created by patching a project")
+}
+
+// Put is not supported
+func (this *JobService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Jobs cannot be modified")
+}
+
+// Patch is not supported
+func (this *JobService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Jobs cannot be modified")
+}
+
+// Delete cancels the job
+func (this *JobService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	job, ok := elements.Element().(*types.GenerationJob)
+	if !ok || job.Id == "" {
+		return object.NewError("Cancel request for job is invalid")
+	}
+	projects, ok := this.projects(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	found, exists := projects.Job(job.Id)
+	if !exists {
+		return object.NewError("Job " + job.Id + " was not found")
+	}
+	// the job of a project the user may not change is not found, as one of another user
+	denied := true
+	result := projects.audited(elements, audit.OP_CANCEL, &types.Project{User: found.User, Name: found.Name},
+		types.ProjectRole_ROLE_EDITOR, func(record *types.AuditRecord) ifs.IElements {
+			denied = false
+			record.JobId = job.Id
+			cancelled, err := projects.CancelJob(job.Id)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			return object.New(nil, cancelled)
+		})
+	if denied {
+		return object.NewError("Job " + job.Id + " was not found")
+	}
+	return result
+}
+
+// GetCopy handles GET requests for copies
+func (this *JobService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return this.Get(elements, vnic)
+}
+
+// Get returns the job with the id in filter mode, or the jobs matching a query
+func (this *JobService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	projects, ok := this.projects(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	if elements.IsFilterMode() {
+		job, isJob := elements.Element().(*types.GenerationJob)
+		if isJob {
+			found, exists := projects.Job(job.Id)
+			if !exists || !projects.canView(found.User, found.Name, viewer) {
+				return object.NewError("Job " + job.Id + " was not found")
+			}
+			return object.New(nil, found)
+		}
+	}
+
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	for _, job := range projects.Jobs() {
+		if projects.canView(job.User, job.Name, viewer) && query.Match(job) {
+			result = append(result, job)
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *JobService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *JobService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *JobService) WebService() ifs.IWebService {
+	ws := web.New(JobServiceName, JobServiceArea, nil,
+		nil, nil, nil, nil, nil, &types.GenerationJob{}, &types.GenerationJob{},
+		&l8api.L8Query{}, &types.GenerationJobList{})
+	return ws
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// roleOf returns the role of the user on the project. The user of the project owns it,
+// the other users have the highest role the project is shared with them, or with an
+// organization or a team of theirs. A member is at most an editor, even one shared as
+// owner before that was rejected.
+func (this *ProjectService) roleOf(project *types.Project, user string) types.ProjectRole {
+	if user == "" {
+		return types.ProjectRole_ROLE_NONE
+	}
+	if auth.NormalizeUser(project.User) == user {
+		return types.ProjectRole_ROLE_OWNER
+	}
+	role := types.ProjectRole_ROLE_NONE
+	if len(project.Members) == 0 {
+		return role
+	}
+	principals := this.directory.Principals(user)
+	for _, member := range project.Members {
+		if member.Role > role && containsString(principals, member.Principal) {
+			role = member.Role
+		}
+	}
+	if role > types.ProjectRole_ROLE_EDITOR {
+		return types.ProjectRole_ROLE_EDITOR
+	}
+	return role
+}
+
+// Access checks the identity has the role on the project of the user. A request that
+// names no user is for a project of the identity, a new project can only be created by
+// its owner. Without an identity the request is trusted only if authentication is
+// disabled. Projects the identity has no role on are reported as not found.
+func (this *ProjectService) Access(identity string, user *string, name string, required types.ProjectRole) error {
+	if identity == "" {
+		if this.authRequired {
+			return ErrUnauthenticated
+		}
+		return nil
+	}
+	if *user == "" || auth.NormalizeUser(*user) == identity {
+		*user = identity
+	}
+	current, _ := this.cache.Get(&types.Project{User: *user, Name: name})
+	project, exists := current.(*types.Project)
+	if !exists {
+		if *user == identity {
+			return nil
+		}
+		return errors.New("Project " + name + " was not found")
+	}
+	role := this.roleOf(project, identity)
+	if role == types.ProjectRole_ROLE_NONE {
+		return errors.New("Project " + name + " was not found")
+	}
+	if role < required {
+		return errors.New("User " + identity + " is a " + roleName(role) + " of project " + name + ", the " +
+			roleName(required) + " role is required")
+	}
+	return nil
+}
+
+// access checks the authenticated user of the request has the role on the project
+func (this *ProjectService) access(elements ifs.IElements, user *string, name string, required types.ProjectRole) error {
+	return this.Access(elements.AAAId(), user, name, required)
+}
+
+// canView reports whether the viewer may see the project of the user, a viewer of all
+// the projects when authentication is disabled is empty
+func (this *ProjectService) canView(user, name, viewer string) bool {
+	if viewer == "" {
+		return true
+	}
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	project, exists := current.(*types.Project)
+	if !exists {
+		return ownedBy(user, viewer)
+	}
+	return this.roleOf(project, viewer) >= types.ProjectRole_ROLE_VIEWER
+}
+
+// accessWith checks the role of a request of another service through the project service
+func accessWith(vnic ifs.IVNic, elements ifs.IElements, user *string, name string, required types.ProjectRole) error {
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return errors.New("Project service is not available")
+	}
+	return projects.access(elements, user, name, required)
+}
+
+func roleName(role types.ProjectRole) string {
+	return strings.ToLower(strings.TrimPrefix(role.String(), "ROLE_"))
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// audited runs the request of the authenticated user on the project once the user has the
+// required role, and records who did what and how it ended in the audit log. The handler
+// may add the details of the operation to the record.
+func (this *ProjectService) audited(elements ifs.IElements, operation string, project *types.Project,
+	required types.ProjectRole, handler func(*types.AuditRecord) ifs.IElements) ifs.IElements {
+	record := &types.AuditRecord{Actor: elements.AAAId(), Operation: operation}
+	var result ifs.IElements
+	err := this.access(elements, &project.User, project.Name, required)
+	if err != nil {
+		record.Outcome = types.AuditOutcome_AUDIT_DENIED
+		result = object.NewError(err.Error())
+	} else {
+		result = handler(record)
+	}
+	record.User = project.User
+	record.Name = project.Name
+	if record.Outcome == types.AuditOutcome_AUDIT_UNKNOWN {
+		record.Outcome = types.AuditOutcome_AUDIT_SUCCEEDED
+		if result.Error() != nil {
+			record.Outcome = types.AuditOutcome_AUDIT_FAILED
+		}
+	}
+	if result.Error() != nil {
+		record.Error = secrets.Mask(result.Error().Error())
+	} else if done, ok := result.Element().(*types.Project); ok {
+		record.Revision = done.Revision
+	}
+	this.audit(record)
+	return result
+}
+
+// auditQuery records a query of the projects, which names no project, with the number of
+// elements it returned
+func (this *ProjectService) auditQuery(record *types.AuditRecord, count int, err error) {
+	switch {
+	case err == ErrUnauthenticated:
+		record.Outcome = types.AuditOutcome_AUDIT_DENIED
+	case err != nil:
+		record.Outcome = types.AuditOutcome_AUDIT_FAILED
+	default:
+		record.Outcome = types.AuditOutcome_AUDIT_SUCCEEDED
+		record.Detail = fmt.Sprint(count, " results")
+	}
+	if err != nil {
+		record.Error = secrets.Mask(err.Error())
+	}
+	this.audit(record)
+}
+
+// auditTurn records the outcome of a generation job, with the model, the files it touched
+// and the tokens it spent
+func (this *ProjectService) auditTurn(job *generationJob, result *types.Project, err error) {
+	turn := this.turnUsage(job)
+	this.jobsMtx.Lock()
+	record := &types.AuditRecord{Actor: job.actor, Operation: audit.OP_TURN, User: job.job.User,
+		Name: job.job.Name, JobId: job.job.Id, PromptHash: job.promptHash, Files: append([]string(nil), job.job.Files...),
+		Model: job.job.Model, Usage: turn, Outcome: types.AuditOutcome_AUDIT_SUCCEEDED}
+	if job.job.State == types.JobState_JOB_CANCELLED {
+		record.Outcome = types.AuditOutcome_AUDIT_CANCELLED
+	} else if err != nil {
+		record.Outcome = types.AuditOutcome_AUDIT_FAILED
+	}
+	this.jobsMtx.Unlock()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if result != nil {
+		record.Revision = result.Revision
+	}
+	this.audit(record)
+}
+
+// promptHash returns the hash of the prompt of a patch request
+func promptHash(project *types.Project) string {
+	if len(project.Messages) == 0 {
+		return ""
+	}
+	return audit.PromptHash(project.Messages[0].Content)
+}
+
+// audit appends the record to the audit log, a record that cannot be written is reported
+// but does not fail the operation that already took place
+func (this *ProjectService) audit(record *types.AuditRecord) {
+	if this.auditLog == nil {
+		return
+	}
+	_, err := this.auditLog.Append(record)
+	if err != nil {
+		fmt.Println("Failed to write the audit record of ", record.Operation, " on ", record.Name, ": ", err.Error())
+	}
+}
+
+// Audit records an operation a web handler ran on a project, which succeeded unless the
+// record has another outcome
+func (this *ProjectService) Audit(record *types.AuditRecord) {
+	if record.Outcome == types.AuditOutcome_AUDIT_UNKNOWN {
+		record.Outcome = types.AuditOutcome_AUDIT_SUCCEEDED
+	}
+	record.Error = secrets.Mask(record.Error)
+	this.audit(record)
+}
+
+// AuditRecords returns the records of the audit log the viewer may read. Auditors and,
+// when authentication is disabled, everyone read all of them, the other users read the
+// records of their own requests and of the projects they own.
+func (this *ProjectService) AuditRecords(viewer string) []*types.AuditRecord {
+	if this.auditLog == nil {
+		return []*types.AuditRecord{}
+	}
+	records := this.auditLog.Records()
+	if viewer == "" || containsString(this.auditors, viewer) {
+		return records
+	}
+	result := make([]*types.AuditRecord, 0)
+	for _, record := range records {
+		if record.Actor == viewer || this.owns(record.User, record.Name, viewer) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// owns reports whether the viewer owns the project of the user, the owner of a project
+// that no longer exists is its user
+func (this *ProjectService) owns(user, name, viewer string) bool {
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	project, exists := current.(*types.Project)
+	if !exists {
+		return auth.NormalizeUser(user) == viewer
+	}
+	return this.roleOf(project, viewer) == types.ProjectRole_ROLE_OWNER
+}
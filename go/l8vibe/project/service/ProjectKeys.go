@@ -0,0 +1,97 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// Vault returns the vault of the provider keys
+func (this *ProjectService) Vault() *secrets.Vault {
+	return this.vault
+}
+
+// intakeKey moves the API key a client sent with the project into the vault and refers
+// to it by id instead, so the key is never cached, persisted or returned. A project that
+// refers to a secret must refer to one the user may use. The keys are in the vault of the
+// owner, so only the owner of the current project may change its key.
+func (this *ProjectService) intakeKey(project, current *types.Project, actor string) error {
+	if project.ApiKey == "" && (project.SecretId == "" || current != nil && project.SecretId == current.SecretId) {
+		return nil
+	}
+	if current != nil && !this.ownedBy(current, actor) {
+		project.ApiKey = ""
+		return errors.New("Only the owner of project " + project.Name + " can change its key")
+	}
+	if project.ApiKey != "" {
+		stored, err := this.storeKey(project, project.ApiKey)
+		project.ApiKey = ""
+		if err != nil {
+			return err
+		}
+		project.SecretId = stored.Id
+		return nil
+	}
+	if project.SecretId != "" {
+		return this.vault.Authorize(project.SecretId, project.User)
+	}
+	return nil
+}
+
+// turnKey returns the provider key of a turn on the working copy of the project. A key
+// the owner sent with the request is stored in the vault and becomes the key of a project
+// that has none, the key of another member is only used for the turn. Otherwise the
+// secret of the project is resolved. Without either the provider uses the key it was
+// configured with.
+func (this *ProjectService) turnKey(request, working *types.Project, actor string) (string, error) {
+	if request.ApiKey != "" {
+		value := request.ApiKey
+		request.ApiKey = ""
+		if !this.ownedBy(working, actor) {
+			return value, nil
+		}
+		stored, err := this.storeKey(working, value)
+		if err != nil {
+			return "", err
+		}
+		if working.SecretId == "" {
+			working.SecretId = stored.Id
+		}
+		return value, nil
+	}
+	if working.SecretId == "" {
+		return "", nil
+	}
+	return this.vault.Resolve(working.SecretId, working.User)
+}
+
+func (this *ProjectService) storeKey(project *types.Project, value string) (*types.ProviderSecret, error) {
+	provider := anthropic.PROVIDER_ANTHROPIC
+	if project.Settings != nil && project.Settings.Provider != "" {
+		provider = project.Settings.Provider
+	}
+	return this.vault.Store(&types.ProviderSecret{Provider: provider, Name: project.Name, Value: value}, project.User)
+}
+
+// migrateKey moves the key of a project persisted before the vault into the vault and
+// saves the project without it
+func (this *ProjectService) migrateKey(project *types.Project) {
+	if project.ApiKey == "" {
+		return
+	}
+	err := this.intakeKey(project, nil, "")
+	if err != nil {
+		fmt.Println("Failed to move the key of project ", project.Name, " to the vault: ", err.Error())
+		return
+	}
+	this.saveProject(project)
+}
+
+// ownedBy reports whether the actor owns the project, a request without an actor is
+// trusted as authentication is disabled
+func (this *ProjectService) ownedBy(project *types.Project, actor string) bool {
+	return actor == "" || this.roleOf(project, actor) == types.ProjectRole_ROLE_OWNER
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// RevisionConflictError is returned when a client modifies a project from a stale revision
+type RevisionConflictError struct {
+	Name     string
+	Revision int64
+	Current  int64
+}
+
+func (this *RevisionConflictError) Error() string {
+	return "Project " + this.Name + " was modified by another request, revision " +
+		strconv.FormatInt(this.Revision, 10) + " is stale, the current revision is " +
+		strconv.FormatInt(this.Current, 10)
+}
+
+// Lock serializes the modifications of a project, keyed by its User and Name primary key.
+// It returns the function that releases the project.
+func (this *ProjectService) Lock(user, name string) func() {
+	key := streamKey(&types.Project{User: user, Name: name})
+	this.locksMtx.Lock()
+	lock, ok := this.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		this.locks[key] = lock
+	}
+	this.locksMtx.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// checkRevision fails if the request carries a revision other than the current one,
+// a request without a revision is not checked.
+func checkRevision(requested, current *types.Project) error {
+	if requested.Revision != 0 && requested.Revision != current.Revision {
+		return &RevisionConflictError{Name: current.Name, Revision: requested.Revision, Current: current.Revision}
+	}
+	return nil
+}
+
+// beginTurn locks the project for a turn and returns a working copy of it, so a failed
+// turn leaves the cached project untouched. The caller releases the project with unlock.
+func (this *ProjectService) beginTurn(project *types.Project) (*types.Project, func(), error) {
+	if project.Name == "" || project.User == "" || project.Messages == nil || len(project.Messages) == 0 {
+		return nil, nil, errors.New("Patch request for project is invalid")
+	}
+	unlock := this.Lock(project.User, project.Name)
+	current, _ := this.cache.Get(project)
+	currentProj, ok := current.(*types.Project)
+	if !ok || currentProj.DeletedAt != 0 {
+		unlock()
+		return nil, nil, errors.New("Project " + project.Name + " was not found")
+	}
+	err := checkRevision(project, currentProj)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return proto.Clone(currentProj).(*types.Project), unlock, nil
+}
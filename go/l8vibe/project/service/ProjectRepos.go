@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/gitrepo"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// commitWorkspace commits the changes of the project workspace to its git repository,
+// nothing is committed when the workspace is unchanged.
+func commitWorkspace(project *types.Project, message string) {
+	repo, err := gitrepo.OpenProjectRepo(project)
+	if err != nil {
+		fmt.Println("Failed to open repository of ", project.Name, ": ", err.Error())
+		return
+	}
+	_, err = repo.Commit(message)
+	if err != nil {
+		fmt.Println("Failed to commit ", project.Name, ": ", err.Error())
+	}
+}
+
+// Checkout checks the workspace of the project out at the commit, under the lock of the
+// project, and records it as a new commit. The workspace changes, so the revision of the
+// project does, it is saved first and put back if the checkout fails.
+func (this *ProjectService) Checkout(user, name, hash string) (*types.ProjectCommit, error) {
+	defer this.Lock(user, name)()
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	currentProj, ok := current.(*types.Project)
+	if !ok {
+		return nil, errors.New("Project " + name + " was not found")
+	}
+	if currentProj.DeletedAt != 0 {
+		return nil, errors.New("Project " + name + " is in the trash")
+	}
+	repo, err := gitrepo.ReadProjectRepo(currentProj)
+	if err != nil {
+		return nil, err
+	}
+	project := proto.Clone(currentProj).(*types.Project)
+	project.Revision++
+	err = this.replaceProject(project)
+	if err != nil {
+		return nil, err
+	}
+	checkout, err := repo.Checkout(hash)
+	if err != nil {
+		er := this.replaceProject(currentProj)
+		if er != nil {
+			fmt.Println("Failed to put back project ", name, ": ", er.Error())
+		}
+		return nil, err
+	}
+	// the latest snapshot is what the workspace is restored from on load
+	takeSnapshot(project, checkout.Message)
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	return checkout, nil
+}
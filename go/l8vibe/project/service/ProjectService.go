@@ -1,20 +1,24 @@
 package service
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	strings2 "strings"
+	"sync"
 	"time"
 
 	"github.com/saichler/l8services/go/services/dcache"
 	"github.com/saichler/l8srlz/go/serialize/object"
 	"github.com/saichler/l8types/go/ifs"
 	"github.com/saichler/l8types/go/types/l8api"
-	"github.com/saichler/l8utils/go/utils/strings"
 	"github.com/saichler/l8utils/go/utils/web"
 	"github.com/saichler/reflect/go/reflect/introspecting"
 	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
 	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/usage"
 	"github.com/saichler/vibe.with.layer8/go/types"
 	"google.golang.org/protobuf/proto"
 )
@@ -27,9 +31,25 @@ const (
 
 // ProjectService implements ifs.IServiceHandler interface
 type ProjectService struct {
-	cache ifs.IDistributedCache
-	//simulator *AntropicSimulator
-	anthropicClinet *anthropic.AnthropicClient
+	cache        ifs.IDistributedCache
+	generator    *anthropic.Generator
+	streams      map[string]*ProjectStream
+	streamsMtx   sync.Mutex
+	locks        map[string]*sync.Mutex
+	locksMtx     sync.Mutex
+	jobs         map[string]*generationJob
+	jobsMtx      sync.Mutex
+	store        persist.ProjectStore
+	corrupt      persist.CorruptRecords
+	retention    time.Duration
+	jobRetention time.Duration
+	purgeStop    chan struct{}
+	ledger       *usage.Ledger
+	vault        *secrets.Vault
+	authRequired bool
+	directory    *auth.Directory
+	auditLog     *audit.Log
+	auditors     []string
 }
 
 // Activate activates the ProjectService
@@ -39,47 +59,97 @@ func (this *ProjectService) Activate(serviceName string, serviceArea byte, resou
 	resources.Registry().Register(&l8api.L8Query{})
 	node, _ := resources.Introspector().Inspect(&types.Project{})
 	introspecting.AddPrimaryKeyDecorator(node, "User", "Name")
+	resources.Registry().Register(&types.UsageReport{})
+	resources.Registry().Register(&types.UsageReportList{})
+	resources.Introspector().Inspect(&types.UsageReport{})
+	budgets, err := usage.LoadBudgets()
+	if err != nil {
+		return err
+	}
+	this.ledger, err = usage.NewLedger(usage.LedgerFile(), budgets)
+	if err != nil {
+		return err
+	}
+	config, err := common.LoadServiceConfig()
+	if err != nil {
+		return err
+	}
+	this.vault, err = secrets.OpenVault()
+	if err != nil {
+		return err
+	}
+	this.vault.SetOrgs(config.Orgs)
+	this.authRequired = !config.Auth.Disabled
+	this.directory = auth.NewDirectory(config.Orgs, config.Teams)
+	if config.Auth.OIDC != nil {
+		accounts, err := auth.NewAccounts(auth.AccountsFile())
+		if err != nil {
+			return err
+		}
+		this.directory.Federate(accounts, config.Auth.OIDC.Roles)
+	}
+	this.auditors = make([]string, 0, len(config.Auditors))
+	for _, auditor := range config.Auditors {
+		this.auditors = append(this.auditors, auth.NormalizeUser(auditor))
+	}
+	this.auditLog, err = audit.Open(audit.LogFile())
+	if _, broken := err.(*audit.ChainError); broken {
+		resources.Logger().Error("The audit log was tampered with, " + err.Error())
+	} else if err != nil {
+		return err
+	}
+	err = anthropic.MoveLegacyWorkspaces()
+	if err != nil {
+		return err
+	}
+	store, err := persist.NewProjectStore(vnicOf(listener))
+	if err != nil {
+		return err
+	}
+	this.store = store
 	initData := this.load(resources)
 	this.cache = dcache.NewDistributedCacheNoSync(ServiceName, ServiceArea, &types.Project{}, initData,
 		listener, resources)
-	this.anthropicClinet = anthropic.NewAnthropicClient()
-	//this.simulator = NewAnthropicSimulator()
+	this.generator, err = anthropic.NewGeneratorFromConfig(config)
+	if err != nil {
+		return err
+	}
+	this.streams = make(map[string]*ProjectStream)
+	this.locks = make(map[string]*sync.Mutex)
+	this.jobs = make(map[string]*generationJob)
+	this.retention = trashRetention()
+	this.jobRetention = jobRetention()
+	this.purgeStop = make(chan struct{})
+	// the projects that expired while the service was down go first
+	this.purgeExpired()
+	go this.purgeJob()
 	return nil
 }
 
 func (this *ProjectService) load(resources ifs.IResources) []interface{} {
 	result := make([]interface{}, 0)
-	users, err := os.ReadDir("/data")
-	if err != nil {
-		resources.Logger().Error("Failed to load users")
+	projects, err := this.store.Load()
+	corrupt, isCorrupt := err.(persist.CorruptRecords)
+	if err != nil && !isCorrupt {
+		resources.Logger().Error("Failed to load projects: " + err.Error())
 		return result
 	}
-	for _, user := range users {
-		projects, err := os.ReadDir("/data/" + user.Name())
-		if err != nil {
-			resources.Logger().Error("Failed to load projects for " + user.Name())
+	this.corrupt = corrupt
+	for _, record := range corrupt {
+		resources.Logger().Error("Corrupt project record " + record.Error())
+	}
+	for _, proj := range projects {
+		resources.Logger().Info("Loaded project "+proj.Name+" with ", len(proj.Messages))
+		this.migrateKey(proj)
+		result = append(result, proj)
+		if proj.DeletedAt != 0 {
 			continue
 		}
-		for _, project := range projects {
-			if strings2.Contains(project.Name(), ".dat") {
-				data, er := os.ReadFile("/data/" + user.Name() + "/" + project.Name())
-				if er != nil {
-					resources.Logger().Error("#1 Failed to load project " + project.Name())
-					continue
-				}
-				proj := &types.Project{}
-				er = proto.Unmarshal(data, proj)
-				if er != nil {
-					resources.Logger().Error("#2 Failed to load project " + project.Name())
-					continue
-				}
-
-				resources.Logger().Info("Loaded project "+proj.Name+" with ", len(proj.Messages))
-				result = append(result, proj)
-				anthropic.ParseMessages(proj)
-				resources.Logger().Info("Loaded project " + proj.Name)
-			}
+		er := materialize(proj)
+		if er != nil {
+			resources.Logger().Error("Project " + proj.Name + " has rejected files: " + er.Error())
 		}
+		resources.Logger().Info("Loaded project " + proj.Name)
 	}
 	go func() {
 		time.Sleep(time.Second * 10)
@@ -94,6 +164,15 @@ func (this *ProjectService) load(resources ifs.IResources) []interface{} {
 
 // DeActivate deactivates the ProjectService
 func (this *ProjectService) DeActivate() error {
+	if this.purgeStop != nil {
+		close(this.purgeStop)
+	}
+	if this.auditLog != nil {
+		this.auditLog.Close()
+	}
+	if this.store != nil {
+		return this.store.Close()
+	}
 	return nil
 }
 
@@ -101,47 +180,124 @@ func (this *ProjectService) DeActivate() error {
 func (this *ProjectService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
 	fmt.Println("Post")
 	project, ok := elements.Element().(*types.Project)
-	if ok {
+	if !ok {
+		return object.NewError("Post request for project is invalid")
+	}
+	return this.audited(elements, audit.OP_POST, project, types.ProjectRole_ROLE_EDITOR, func(*types.AuditRecord) ifs.IElements {
 		numMsg := 0
 		if project.Messages != nil {
 			numMsg = len(project.Messages)
 		}
 		fmt.Println("Post OK ", numMsg)
+		// the user and the name become file and directory names
+		err := persist.CheckProject(project.User, project.Name)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		err = anthropic.ValidateSettings(project.Settings)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		defer this.Lock(project.User, project.Name)()
+		_, inTrash := this.trashed(project)
+		if inTrash {
+			return object.NewError("Project " + project.Name + " is in the trash, restore or delete it first")
+		}
+		project.Revision = 1
+		// the members of a project are only changed by sharing it
+		project.Members = nil
+		current, _ := this.cache.Get(project)
+		currentProj, exists := current.(*types.Project)
+		if !exists {
+			currentProj = nil
+		}
+		err = this.intakeKey(project, currentProj, elements.AAAId())
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		if exists {
+			project.Revision = currentProj.Revision + 1
+			project.Members = currentProj.Members
+		}
 		this.cache.Post(project, elements.Notification())
-		anthropic.ParseMessages(project)
-		saveProject(project)
-		common.WebServer.LoadWebUI()
-		pb := saveProject(project)
+		materialize(project)
+		pb := this.saveProject(project)
+		if common.WebServer != nil {
+			common.WebServer.LoadWebUI()
+		}
 		if pb != nil {
 			return pb
 		}
-	}
-	return object.New(nil, project)
+		return object.New(nil, project)
+	})
 }
 
 // Put handles PUT requests
 func (this *ProjectService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
 	fmt.Println("Put")
 	project, ok := elements.Element().(*types.Project)
-	if ok {
+	if !ok {
+		return object.NewError("Put request for project is invalid")
+	}
+	return this.audited(elements, audit.OP_PUT, project, types.ProjectRole_ROLE_EDITOR, func(*types.AuditRecord) ifs.IElements {
 		numMsg := 0
 		if project.Messages != nil {
 			numMsg = len(project.Messages)
 		}
 		fmt.Println("Put OK ", numMsg)
+		// the user and the name become file and directory names
+		err := persist.CheckProject(project.User, project.Name)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		err = anthropic.ValidateSettings(project.Settings)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		defer this.Lock(project.User, project.Name)()
+		current, _ := this.cache.Get(project)
+		currentProj, exists := current.(*types.Project)
+		if exists && currentProj.DeletedAt != 0 {
+			return object.NewError("Project " + project.Name + " is in the trash")
+		}
+		if !exists {
+			currentProj = nil
+		}
+		err = this.intakeKey(project, currentProj, elements.AAAId())
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		if exists {
+			err := checkRevision(project, currentProj)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+		}
+		project.Revision = 1
+		project.Members = nil
+		if exists {
+			project.Revision = currentProj.Revision + 1
+			// a client that did not send the key keeps the key of the project
+			if project.SecretId == "" {
+				project.SecretId = currentProj.SecretId
+			}
+			project.Members = currentProj.Members
+		}
 		this.cache.Put(project, elements.Notification())
-		anthropic.ParseMessages(project)
-		saveProject(project)
-		common.WebServer.LoadWebUI()
-		pb := saveProject(project)
+		materialize(project)
+		pb := this.saveProject(project)
+		if common.WebServer != nil {
+			common.WebServer.LoadWebUI()
+		}
 		if pb != nil {
 			return pb
 		}
-	}
-	return object.New(nil, project)
+		return object.New(nil, project)
+	})
 }
 
-// Patch handles PATCH requests
+// Patch handles PATCH requests, the turn runs as a generation job in the background.
+// The project is returned right away with the id of the job to poll through the job service.
 func (this *ProjectService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
 	fmt.Println("Patch ", elements.Notification())
 
@@ -149,51 +305,110 @@ func (this *ProjectService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IE
 	if !ok {
 		return object.NewError(vnic.Resources().Logger().Error("Patch Error 1:").Error())
 	}
-	if project.Name == "" || project.User == "" || project.Messages == nil || len(project.Messages) == 0 {
-		return object.NewError("Patch request for project is invalid")
+	// the prompt of the turn is echoed back with the job
+	if len(project.Messages) == 0 {
+		return object.NewError("Patch request for project " + project.Name + " has no prompt")
 	}
-	current, _ := this.cache.Get(project)
-	currentProj := current.(*types.Project)
-	err := this.anthropicClinet.Do(project.Messages[0].Content, currentProj)
-	//err := this.simulator.Do(project.Messages[0].Content, currentProj)
-	if err == nil {
-		numMsg := 0
-		if project.Messages != nil {
-			numMsg = len(currentProj.Messages)
-		}
-		anthropic.ParseMessages(currentProj)
-		fmt.Println("Patch put in cache ", numMsg)
-		notif, er := this.cache.Put(currentProj, elements.Notification())
-		if er != nil {
-			panic(er.Error())
-		}
-		if notif == nil {
-			fmt.Println("No Notification found in cache")
-		} else {
-			fmt.Println("Notification of ", notif.Type.String())
+	return this.audited(elements, audit.OP_PATCH, project, types.ProjectRole_ROLE_EDITOR, func(record *types.AuditRecord) ifs.IElements {
+		record.PromptHash = promptHash(project)
+		job, _, err := this.submit(project, false, elements.AAAId())
+		if err != nil {
+			return object.NewError(err.Error())
 		}
-		saveProject(currentProj)
+		record.JobId = job.Id
+		return object.New(nil, &types.Project{User: project.User, Name: project.Name, JobId: job.Id,
+			Revision: project.Revision, Messages: project.Messages[:1]})
+	})
+}
+
+// completeTurn applies and persists a successful assistant turn, run on a working copy
+// of the project, and returns the user prompt at index start, the final assistant reply
+// and the new revision to the caller. A turn that cannot be persisted fails.
+func (this *ProjectService) completeTurn(project, currentProj *types.Project, start int) error {
+	// the tool calls of the turn changed the workspace while it ran
+	anthropic.ApplyTurn(currentProj, start)
+	takeSnapshot(currentProj, currentProj.Messages[start].Content)
+	commitWorkspace(currentProj, currentProj.Messages[start].Content)
+	fmt.Println("Patch put in cache ", len(currentProj.Messages))
+	currentProj.Revision++
+	err := this.replaceProject(currentProj)
+	if err != nil {
+		return err
+	}
+	if common.WebServer != nil {
 		common.WebServer.LoadWebUI()
-		project.Messages = make([]*types.Message, 2)
-		project.Messages[0] = currentProj.Messages[len(currentProj.Messages)-2]
-		project.Messages[1] = currentProj.Messages[len(currentProj.Messages)-1]
-		return object.New(nil, project)
 	}
+	project.Messages = make([]*types.Message, 2)
+	project.Messages[0] = currentProj.Messages[start]
+	project.Messages[1] = currentProj.Messages[len(currentProj.Messages)-1]
+	project.Revision = currentProj.Revision
+	return nil
+}
 
-	this.appendMessage(project)
-	project.Messages = append(project.Messages, &types.Message{Role: "assistant", Content: "End of simulation"})
-	this.appendMessage(project)
-	return object.New(nil, project)
+// PatchStream submits a generation job for the patch request of the actor and returns
+// immediately with the stream the assistant text is delivered through.
+// The job is not tied to the caller, so it completes and is persisted
+// even if the client goes away.
+func (this *ProjectService) PatchStream(project *types.Project, actor string) (*ProjectStream, error) {
+	job, stream, err := this.submit(project, true, actor)
+	record := &types.AuditRecord{Actor: actor, Operation: audit.OP_PATCH, User: project.User, Name: project.Name,
+		Revision: project.Revision, PromptHash: promptHash(project), Outcome: types.AuditOutcome_AUDIT_SUCCEEDED}
+	if err != nil {
+		record.Outcome = types.AuditOutcome_AUDIT_FAILED
+		record.Error = secrets.Mask(err.Error())
+	} else {
+		record.JobId = job.Id
+	}
+	this.audit(record)
+	return stream, err
 }
 
-// Delete handles DELETE requests
+// Stream returns the active or last persisted stream of the project,
+// used by clients resuming a dropped connection.
+func (this *ProjectService) Stream(user, name string) (*ProjectStream, error) {
+	this.streamsMtx.Lock()
+	stream, ok := this.streams[streamKey(&types.Project{User: user, Name: name})]
+	this.streamsMtx.Unlock()
+	if ok {
+		return stream, nil
+	}
+	return loadProjectStream(user, name)
+}
+
+// Delete handles DELETE requests, the project is moved to the trash and
+// deleted for good by the purge job once the retention expires
 func (this *ProjectService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
-	return nil
+	project, ok := elements.Element().(*types.Project)
+	if !ok {
+		return object.NewError("Delete request for project is invalid")
+	}
+	return this.audited(elements, audit.OP_DELETE, project, types.ProjectRole_ROLE_OWNER, func(*types.AuditRecord) ifs.IElements {
+		if project.Name == "" || project.User == "" {
+			return object.NewError("Delete request for project is invalid")
+		}
+		deleted, err := this.Trash(project.User, project.Name)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, deleted)
+	})
 }
 
-// GetCopy handles GET requests for copies
+// GetCopy handles GET requests for copies, the projects are cloned so the
+// caller can modify them without affecting the cache
 func (this *ProjectService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
-	return nil
+	result := this.Get(elements, vnic)
+	if result.Error() != nil {
+		return result
+	}
+	copies := make([]interface{}, 0)
+	for _, elem := range result.Elements() {
+		project, ok := elem.(*types.Project)
+		if ok {
+			copies = append(copies, proto.Clone(project))
+		}
+	}
+	return object.New(nil, copies)
 }
 
 // Get handles GET requests
@@ -201,35 +416,53 @@ func (this *ProjectService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IEle
 	if elements.IsFilterMode() {
 		project, ok := elements.Element().(*types.Project)
 		if ok {
-			elem, _ := this.cache.Get(project)
-			return object.New(nil, elem)
+			return this.audited(elements, audit.OP_GET, project, types.ProjectRole_ROLE_VIEWER, func(*types.AuditRecord) ifs.IElements {
+				elem, _ := this.cache.Get(project)
+				proj, isProj := elem.(*types.Project)
+				if isProj && proj.DeletedAt != 0 {
+					return object.NewError("Project " + project.Name + " is in the trash")
+				}
+				return object.New(nil, elem)
+			})
 		}
 	}
 
+	record := &types.AuditRecord{Actor: elements.AAAId(), Operation: audit.OP_GET}
+	viewer, err := this.viewer(elements)
+	if err != nil {
+		this.auditQuery(record, 0, err)
+		return object.NewError(err.Error())
+	}
 	query, err := elements.Query(vnic.Resources())
 	if err != nil {
+		this.auditQuery(record, 0, err)
 		return object.NewError(err.Error())
 	}
-	elems := this.GetQuery(query)
+	elems := this.GetQuery(query, viewer)
+	elems = append(elems, this.usageReports(query, viewer)...)
 	vnic.Resources().Logger().Info("Get Completed with ", len(elems), " elements for query:")
+	this.auditQuery(record, len(elems), nil)
 	return object.New(nil, elems)
 }
 
-func (this *ProjectService) GetQuery(query ifs.IQuery) []interface{} {
+// GetQuery returns the projects the viewer may see that match the query, the projects
+// it owns and the projects shared with it
+func (this *ProjectService) GetQuery(query ifs.IQuery, viewer string) []interface{} {
 	result := make([]interface{}, 0)
 	this.cache.Collect(func(elem interface{}) (bool, interface{}) {
-		match := query.Match(elem)
+		proj, ok := elem.(*types.Project)
+		match := ok && proj.DeletedAt == 0 && (viewer == "" || this.roleOf(proj, viewer) >= types.ProjectRole_ROLE_VIEWER) &&
+			query.Match(elem)
 		if match {
 			result = append(result, elem)
-			proj, ok := elem.(*types.Project)
-			if ok {
-				fmt.Println("Parsing messages for ", proj.Name, " ", len(proj.Messages))
-				anthropic.ParseMessages(proj)
-			}
+			fmt.Println("Parsing messages for ", proj.Name, " ", len(proj.Messages))
+			materialize(proj)
 		}
 		return match, elem
 	})
-	common.WebServer.LoadWebUI()
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
 	return result
 }
 
@@ -246,35 +479,38 @@ func (this *ProjectService) TransactionConfig() ifs.ITransactionConfig {
 // WebService returns the web service
 func (this *ProjectService) WebService() ifs.IWebService {
 	ws := web.New(ServiceName, ServiceArea, &types.Project{},
-		&types.Project{}, nil, nil, &types.Project{}, &types.Project{}, nil, nil, &l8api.L8Query{}, &types.ProjectList{})
+		&types.Project{}, nil, nil, &types.Project{}, &types.Project{}, &types.Project{}, &types.Project{}, &l8api.L8Query{}, &types.ProjectList{})
 	return ws
 }
 
-func (this *ProjectService) appendMessage(p *types.Project) *types.Project {
-	prj, _ := this.cache.Get(p)
-	proj := prj.(*types.Project)
-	proj.Messages = append(proj.Messages, p.Messages[len(p.Messages)-1])
-	this.cache.Put(proj, true)
-	saveProject(proj)
-	return proj
-}
-
-func saveProject(project *types.Project) ifs.IElements {
-	data, err := proto.Marshal(project)
+// saveProject persists the project in the configured project store
+func (this *ProjectService) saveProject(project *types.Project) ifs.IElements {
+	err := this.store.Save(project)
 	if err != nil {
-		return object.NewError("Post Error 1:" + err.Error())
+		fmt.Println("Failed to save project ", project.Name, ": ", err.Error())
+		return object.NewError("Failed to save project " + project.Name + ": " + err.Error())
 	}
+	return nil
+}
 
-	projectPath := strings.New("/data/", project.User, "/").String()
-	err = os.MkdirAll(projectPath, 0777)
+// replaceProject saves the changed copy of a cached project and puts it in the cache in
+// place of the cached one, a copy that fails to save leaves the cached project as it was
+func (this *ProjectService) replaceProject(project *types.Project) error {
+	err := this.store.Save(project)
 	if err != nil {
-		return object.NewError("Post Error 0:" + err.Error())
+		return errors.New("Failed to save project " + project.Name + ": " + err.Error())
 	}
+	_, err = this.cache.Put(project, true)
+	return err
+}
 
-	projectFileName := strings.New(projectPath, project.Name, ".dat").String()
-	err = os.WriteFile(projectFileName, data, 0777)
-	if err != nil {
-		return object.NewError("Post Error 2:" + err.Error())
-	}
-	return nil
+// CorruptRecords returns the persisted projects that failed to load
+func (this *ProjectService) CorruptRecords() persist.CorruptRecords {
+	return this.corrupt
+}
+
+// vnicOf returns the vnic the service was activated with, it is passed as the listener
+func vnicOf(listener ifs.IServiceCacheListener) ifs.IVNic {
+	vnic, _ := listener.(ifs.IVNic)
+	return vnic
 }
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Share gives the principal, a user, org:{name} or team:{name}, the role on the project.
+// A principal the project is already shared with gets the new role. A project has one
+// owner, it is shared as viewer or editor and handed over with Transfer.
+func (this *ProjectService) Share(user, name, principal string, role types.ProjectRole, by string) (*types.Project, error) {
+	if role == types.ProjectRole_ROLE_OWNER {
+		return nil, errors.New("Project " + name + " cannot be shared as owner, transfer it instead")
+	}
+	if role <= types.ProjectRole_ROLE_NONE || role > types.ProjectRole_ROLE_OWNER {
+		return nil, errors.New("Role of the share is invalid")
+	}
+	principal, err := this.directory.NormalizePrincipal(principal)
+	if err != nil {
+		return nil, err
+	}
+	return this.updateMembers(user, name, func(project *types.Project) error {
+		if principal == auth.NormalizeUser(project.User) {
+			return errors.New("User " + principal + " owns project " + name)
+		}
+		for _, member := range project.Members {
+			if member.Principal == principal {
+				member.Role = role
+				return nil
+			}
+		}
+		project.Members = append(project.Members, &types.ProjectMember{Principal: principal, Role: role,
+			AddedBy: by, Added: time.Now().Unix()})
+		return nil
+	})
+}
+
+// Unshare removes the principal from the members of the project
+func (this *ProjectService) Unshare(user, name, principal string) (*types.Project, error) {
+	principal = auth.NormalizeUser(principal)
+	return this.updateMembers(user, name, func(project *types.Project) error {
+		for i, member := range project.Members {
+			if member.Principal == principal {
+				project.Members = append(project.Members[:i], project.Members[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("Project " + name + " is not shared with " + principal)
+	})
+}
+
+// updateMembers changes the members of the project under its lock
+func (this *ProjectService) updateMembers(user, name string, change func(*types.Project) error) (*types.Project, error) {
+	defer this.Lock(user, name)()
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	currentProj, ok := current.(*types.Project)
+	if !ok || currentProj.DeletedAt != 0 {
+		return nil, errors.New("Project " + name + " was not found")
+	}
+	project := proto.Clone(currentProj).(*types.Project)
+	err := change(project)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(project.Members, func(i, j int) bool {
+		return project.Members[i].Principal < project.Members[j].Principal
+	})
+	project.Revision++
+	err = this.replaceProject(project)
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// Transfer makes the new owner the user of the project. The project, its workspace with
+// the repository, its snapshots and its stream move to the new owner, who must not have a
+// project with the same name. The previous owner stays an editor. A key of the previous
+// owner the new owner may not use is dropped from the project. A transfer that fails moves
+// the files back to the previous owner.
+func (this *ProjectService) Transfer(user, name, newOwner string) (*types.Project, error) {
+	user = auth.NormalizeUser(user)
+	newOwner = auth.NormalizeUser(newOwner)
+	principal, err := this.directory.NormalizePrincipal(newOwner)
+	if err != nil || principal != newOwner || newOwner == user {
+		return nil, errors.New("New owner " + newOwner + " of project " + name + " is invalid")
+	}
+	// both keys are locked in order, so two opposite transfers cannot deadlock
+	first, second := user, newOwner
+	if second < first {
+		first, second = second, first
+	}
+	defer this.Lock(first, name)()
+	defer this.Lock(second, name)()
+
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	currentProj, ok := current.(*types.Project)
+	if !ok || currentProj.DeletedAt != 0 {
+		return nil, errors.New("Project " + name + " was not found")
+	}
+	existing, _ := this.cache.Get(&types.Project{User: newOwner, Name: name})
+	if _, exists := existing.(*types.Project); exists {
+		return nil, errors.New("User " + newOwner + " already has a project named " + name)
+	}
+	err = this.checkIdle(currentProj)
+	if err != nil {
+		return nil, err
+	}
+
+	moved := proto.Clone(currentProj).(*types.Project)
+	moved.User = newOwner
+	moved.Revision++
+	members := make([]*types.ProjectMember, 0, len(moved.Members)+1)
+	for _, member := range moved.Members {
+		if member.Principal != newOwner && member.Principal != user {
+			members = append(members, member)
+		}
+	}
+	moved.Members = append(members, &types.ProjectMember{Principal: user, Role: types.ProjectRole_ROLE_EDITOR,
+		AddedBy: user, Added: time.Now().Unix()})
+	sort.Slice(moved.Members, func(i, j int) bool {
+		return moved.Members[i].Principal < moved.Members[j].Principal
+	})
+	if moved.SecretId != "" && this.vault.Authorize(moved.SecretId, newOwner) != nil {
+		moved.SecretId = ""
+	}
+
+	err = moveFiles(currentProj, moved)
+	if err != nil {
+		return nil, err
+	}
+	pb := this.saveProject(moved)
+	if pb != nil {
+		moveBack(moved, currentProj)
+		return nil, pb.Error()
+	}
+	err = this.store.Delete(user, name)
+	if err != nil && !os.IsNotExist(err) {
+		er := this.store.Delete(newOwner, name)
+		if er != nil && !os.IsNotExist(er) {
+			fmt.Println("Failed to delete the record of the transfer of project ", name, ": ", er.Error())
+		}
+		moveBack(moved, currentProj)
+		return nil, errors.New("Failed to delete the record of project " + name + " of " + user + ": " + err.Error())
+	}
+	_, err = this.cache.Delete(currentProj, true)
+	if err != nil {
+		return nil, err
+	}
+	this.cache.Post(moved, true)
+	this.streamsMtx.Lock()
+	delete(this.streams, streamKey(currentProj))
+	this.streamsMtx.Unlock()
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	return moved, nil
+}
+
+// moveFiles moves the workspace, the snapshots and the stream of the project, the files
+// moved before a move that failed are moved back
+func moveFiles(from, to *types.Project) error {
+	workspace, err := anthropic.NewWorkspace(from)
+	if err != nil {
+		return err
+	}
+	target, err := anthropic.NewWorkspace(to)
+	if err != nil {
+		return err
+	}
+	snapshots, err := snapshot.NewSnapshotStore(from)
+	if err != nil {
+		return err
+	}
+	targetSnapshots, err := snapshot.NewSnapshotStore(to)
+	if err != nil {
+		return err
+	}
+	fromStream, err := streamFileName(from.User, from.Name)
+	if err != nil {
+		return err
+	}
+	toStream, err := streamFileName(to.User, to.Name)
+	if err != nil {
+		return err
+	}
+	err = workspace.MoveTo(target)
+	if err != nil {
+		return err
+	}
+	err = snapshots.MoveTo(targetSnapshots)
+	if err != nil {
+		undoMove(from, target.MoveTo(workspace))
+		return err
+	}
+	err = os.Rename(fromStream, toStream)
+	if err != nil && !os.IsNotExist(err) {
+		undoMove(from, targetSnapshots.MoveTo(snapshots))
+		undoMove(from, target.MoveTo(workspace))
+		return err
+	}
+	return nil
+}
+
+// moveBack moves the files of a project that was moved back to the project it was moved from
+func moveBack(moved, from *types.Project) {
+	undoMove(from, moveFiles(moved, from))
+}
+
+// undoMove reports a move back that failed, the operation that moved the files failed already
+func undoMove(from *types.Project, err error) {
+	if err != nil {
+		fmt.Println("Failed to move the files of project ", from.Name, " back to ", from.User, ": ", err.Error())
+	}
+}
+
+// sharesOf returns the owner and the members of the project as shares
+func sharesOf(project *types.Project) []*types.ProjectShare {
+	shares := []*types.ProjectShare{{User: project.User, Name: project.Name, Principal: project.User,
+		Role: types.ProjectRole_ROLE_OWNER}}
+	for _, member := range project.Members {
+		shares = append(shares, &types.ProjectShare{User: project.User, Name: project.Name,
+			Principal: member.Principal, Role: member.Role})
+	}
+	return shares
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// materialize writes the project files to an empty workspace, from the latest snapshot and
+// the turns after it when there is one, otherwise by replaying the assistant messages.
+// A workspace with files holds the result of the turns already, replaying the tool calls
+// over it would apply the edits that are not idempotent, such as patch_file, twice.
+func materialize(project *types.Project) error {
+	workspace, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		return err
+	}
+	paths, err := workspace.List()
+	if err != nil || len(paths) > 0 {
+		return err
+	}
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		return err
+	}
+	latest, err := store.Latest()
+	if err != nil || latest == nil || int(latest.MessageIndex) > len(project.Messages) {
+		return anthropic.ParseMessages(project)
+	}
+	err = store.Restore(workspace, latest)
+	if err != nil {
+		return err
+	}
+	return anthropic.ReplayMessages(project, int(latest.MessageIndex))
+}
+
+// takeSnapshot records the workspace after a turn, tied to the current message count
+func takeSnapshot(project *types.Project, prompt string) {
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		fmt.Println("Failed to open snapshots of ", project.Name, ": ", err.Error())
+		return
+	}
+	workspace, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		fmt.Println("Failed to open workspace of ", project.Name, ": ", err.Error())
+		return
+	}
+	_, err = store.Take(workspace, len(project.Messages), prompt)
+	if err != nil {
+		fmt.Println("Failed to snapshot ", project.Name, ": ", err.Error())
+	}
+}
+
+// ensureBaseline snapshots the workspace before the first snapshotted turn,
+// so a project can always be rolled back to how it was before that turn, and
+// commits whatever changed in the workspace since the last turn.
+func ensureBaseline(project *types.Project) {
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		return
+	}
+	latest, err := store.Latest()
+	if err == nil && latest == nil {
+		takeSnapshot(project, "")
+	}
+	// changes made to the workspace outside of a turn are kept apart from the turn commit
+	commitWorkspace(project, "Workspace changes outside of a turn")
+}
+
+// Rollback restores the workspace of the project to the snapshot with the index and
+// truncates the message history to the turn the snapshot was taken at. The rollback
+// itself is recorded as a new snapshot so the latest snapshot matches the workspace.
+func (this *ProjectService) Rollback(user, name string, index int32) (*types.ProjectSnapshot, error) {
+	defer this.Lock(user, name)()
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	currentProj, ok := current.(*types.Project)
+	if !ok {
+		return nil, errors.New("Project " + name + " was not found")
+	}
+	if currentProj.DeletedAt != 0 {
+		return nil, errors.New("Project " + name + " is in the trash")
+	}
+	// the cached project is only replaced once the rolled back copy is saved
+	project := proto.Clone(currentProj).(*types.Project)
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		return nil, err
+	}
+	target, err := store.Get(index)
+	if err != nil {
+		return nil, err
+	}
+	if int(target.MessageIndex) > len(project.Messages) {
+		return nil, errors.New("Snapshot " + strconv.Itoa(int(index)) + " is ahead of the project history")
+	}
+	workspace, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		return nil, err
+	}
+	// a rollback that is cut short or not saved puts the files of the workspace back
+	files, err := snapshot.Capture(workspace)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Restore(workspace, target)
+	if err == nil {
+		project.Messages = project.Messages[:target.MessageIndex]
+		project.Revision++
+		err = this.replaceProject(project)
+	}
+	if err != nil {
+		er := snapshot.RestoreFiles(workspace, files)
+		if er != nil {
+			fmt.Println("Failed to put back the workspace of ", name, ": ", er.Error())
+		}
+		return nil, err
+	}
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	prompt := "Rollback to snapshot #" + strconv.Itoa(int(index))
+	commitWorkspace(project, prompt)
+	return store.Copy(target, prompt)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	strings2 "github.com/saichler/l8utils/go/utils/strings"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// ProjectStream holds the partial assistant text of a streaming Patch.
+// Deltas are kept in memory and appended to {data dir}/{user}/{name}.stream so a
+// client that dropped its connection can resume from the offset it last rendered.
+type ProjectStream struct {
+	cond   *sync.Cond
+	text   []byte
+	done   bool
+	err    error
+	result *types.Project
+	file   *os.File
+}
+
+func streamKey(project *types.Project) string {
+	return strings2.New(project.User, "/", project.Name).String()
+}
+
+// streamFileName returns the file of the persisted stream, the user and the name are
+// client supplied and must not lead out of the data directory
+func streamFileName(user, name string) (string, error) {
+	err := persist.CheckProject(user, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(persist.DataDir(), user, name+".stream"), nil
+}
+
+// newProjectStream returns the stream of the turn, a stream that cannot be persisted
+// is kept in memory only
+func newProjectStream(project *types.Project) (*ProjectStream, error) {
+	fileName, err := streamFileName(project.User, project.Name)
+	if err != nil {
+		return nil, err
+	}
+	stream := &ProjectStream{cond: sync.NewCond(&sync.Mutex{})}
+	os.MkdirAll(filepath.Dir(fileName), 0755)
+	file, err := os.Create(fileName)
+	if err == nil {
+		stream.file = file
+	}
+	return stream, nil
+}
+
+// loadProjectStream restores a completed stream from its persisted deltas
+func loadProjectStream(user, name string) (*ProjectStream, error) {
+	fileName, err := streamFileName(user, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectStream{cond: sync.NewCond(&sync.Mutex{}), text: data, done: true}, nil
+}
+
+func (this *ProjectStream) write(delta string) {
+	this.cond.L.Lock()
+	defer this.cond.L.Unlock()
+	this.text = append(this.text, delta...)
+	if this.file != nil {
+		this.file.WriteString(delta)
+	}
+	this.cond.Broadcast()
+}
+
+func (this *ProjectStream) finish(result *types.Project, err error) {
+	this.cond.L.Lock()
+	defer this.cond.L.Unlock()
+	this.done = true
+	this.result = result
+	this.err = err
+	if this.file != nil {
+		this.file.Sync()
+		this.file.Close()
+		this.file = nil
+	}
+	this.cond.Broadcast()
+}
+
+// Next blocks until there is text beyond offset or the stream is done.
+// It returns the text from offset, whether the stream is done, and on completion
+// the resulting project turn or the generation error.
+func (this *ProjectStream) Next(offset int) (string, bool, *types.Project, error) {
+	this.cond.L.Lock()
+	defer this.cond.L.Unlock()
+	if offset < 0 || offset > len(this.text) {
+		return "", true, nil, errors.New("invalid stream offset")
+	}
+	for offset == len(this.text) && !this.done {
+		this.cond.Wait()
+	}
+	return string(this.text[offset:]), this.done, this.result, this.err
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// trashRetention returns how long a deleted project stays recoverable, taken from
+// the consts.TRASH_RETENTION_ENV environment variable. Zero deletes projects immediately.
+func trashRetention() time.Duration {
+	value := os.Getenv(consts.TRASH_RETENTION_ENV)
+	if value == "" {
+		value = consts.TRASH_RETENTION
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		fmt.Println("Invalid trash retention ", value, ", using ", consts.TRASH_RETENTION)
+		retention, _ = time.ParseDuration(consts.TRASH_RETENTION)
+	}
+	return retention
+}
+
+// trashed returns the cached project, if it exists and is in the trash
+func (this *ProjectService) trashed(project *types.Project) (*types.Project, bool) {
+	current, _ := this.cache.Get(project)
+	currentProj, ok := current.(*types.Project)
+	if !ok || currentProj.DeletedAt == 0 {
+		return nil, false
+	}
+	return currentProj, true
+}
+
+// Trash moves the project to the trash, or deletes it for good when the retention is
+// zero or the project is already in the trash.
+func (this *ProjectService) Trash(user, name string) (*types.Project, error) {
+	defer this.Lock(user, name)()
+	current, _ := this.cache.Get(&types.Project{User: user, Name: name})
+	currentProj, ok := current.(*types.Project)
+	if !ok {
+		return nil, errors.New("Project " + name + " was not found")
+	}
+	if this.retention == 0 || currentProj.DeletedAt != 0 {
+		return currentProj, this.purge(currentProj)
+	}
+	err := this.checkIdle(currentProj)
+	if err != nil {
+		return nil, err
+	}
+	project := proto.Clone(currentProj).(*types.Project)
+	project.DeletedAt = time.Now().Unix()
+	project.Revision++
+	err = this.replaceProject(project)
+	if err != nil {
+		return nil, err
+	}
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	return project, nil
+}
+
+// Restore takes the project out of the trash
+func (this *ProjectService) Restore(user, name string) (*types.Project, error) {
+	defer this.Lock(user, name)()
+	currentProj, ok := this.trashed(&types.Project{User: user, Name: name})
+	if !ok {
+		return nil, errors.New("Project " + name + " is not in the trash")
+	}
+	project := proto.Clone(currentProj).(*types.Project)
+	project.DeletedAt = 0
+	project.Revision++
+	err := this.replaceProject(project)
+	if err != nil {
+		return nil, err
+	}
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	return project, nil
+}
+
+// Purge deletes the project for good, from the cache and from the disk:
+// the .dat and .stream files, the snapshots and the workspace with its repository.
+func (this *ProjectService) Purge(project *types.Project) error {
+	defer this.Lock(project.User, project.Name)()
+	return this.purge(project)
+}
+
+func (this *ProjectService) purge(project *types.Project) error {
+	err := this.checkIdle(project)
+	if err != nil {
+		return err
+	}
+	workspace, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		return err
+	}
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		return err
+	}
+	_, err = this.cache.Delete(project, true)
+	if err != nil {
+		return err
+	}
+	this.streamsMtx.Lock()
+	delete(this.streams, streamKey(project))
+	this.streamsMtx.Unlock()
+
+	for _, remove := range []func() error{
+		func() error { return this.store.Delete(project.User, project.Name) },
+		func() error {
+			fileName, er := streamFileName(project.User, project.Name)
+			if er != nil {
+				return er
+			}
+			return os.Remove(fileName)
+		},
+		store.Remove,
+		workspace.RemoveAll,
+	} {
+		er := remove()
+		if er != nil && !os.IsNotExist(er) {
+			fmt.Println("Failed to purge ", project.Name, ": ", er.Error())
+			err = er
+		}
+	}
+	if common.WebServer != nil {
+		common.WebServer.LoadWebUI()
+	}
+	return err
+}
+
+// checkIdle fails if a generation job of the project is not done
+func (this *ProjectService) checkIdle(project *types.Project) error {
+	key := streamKey(project)
+	this.jobsMtx.Lock()
+	defer this.jobsMtx.Unlock()
+	for _, job := range this.jobs {
+		if job.key == key && !isJobDone(job.job) {
+			return errors.New("A generation is in progress for " + project.Name)
+		}
+	}
+	return nil
+}
+
+// purgeExpired deletes the projects that have been in the trash longer than the retention
+func (this *ProjectService) purgeExpired() {
+	expired := make([]*types.Project, 0)
+	deadline := time.Now().Add(-this.retention).Unix()
+	this.cache.Collect(func(elem interface{}) (bool, interface{}) {
+		project, ok := elem.(*types.Project)
+		if ok && project.DeletedAt != 0 && project.DeletedAt <= deadline {
+			expired = append(expired, project)
+		}
+		return false, elem
+	})
+	for _, project := range expired {
+		fmt.Println("Purging expired project ", project.Name)
+		err := this.Purge(project)
+		if err != nil {
+			fmt.Println("Failed to purge ", project.Name, ": ", err.Error())
+		}
+	}
+}
+
+// purgeJob reaps the trash periodically
+func (this *ProjectService) purgeJob() {
+	ticker := time.NewTicker(consts.TRASH_PURGE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.purgeExpired()
+		case <-this.purgeStop:
+			return
+		}
+	}
+}
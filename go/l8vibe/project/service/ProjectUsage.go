@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// turnUsage returns the usage the job accumulated so far
+func (this *ProjectService) turnUsage(job *generationJob) *types.TurnUsage {
+	this.jobsMtx.Lock()
+	defer this.jobsMtx.Unlock()
+	return &types.TurnUsage{JobId: job.job.Id, Model: job.job.Model, InputTokens: job.job.InputTokens,
+		OutputTokens: job.job.OutputTokens, CacheCreationTokens: job.job.CacheCreationTokens,
+		CacheReadTokens: job.job.CacheReadTokens, Cost: job.job.Cost, Saved: job.job.Saved, Created: job.job.Created}
+}
+
+// appendUsage records the usage of a turn on the project, keeping the latest turns only,
+// and adds it to the prompt cache statistics of the project
+func appendUsage(project *types.Project, turn *types.TurnUsage) {
+	project.Usage = append(project.Usage, turn)
+	if len(project.Usage) > consts.USAGE_PROJECT_HISTORY {
+		project.Usage = project.Usage[len(project.Usage)-consts.USAGE_PROJECT_HISTORY:]
+	}
+	if project.CacheStats == nil {
+		project.CacheStats = &types.CacheStats{}
+	}
+	stats := project.CacheStats
+	stats.Turns++
+	stats.InputTokens += turn.InputTokens
+	stats.CacheCreationTokens += turn.CacheCreationTokens
+	stats.CacheReadTokens += turn.CacheReadTokens
+	stats.Saved += turn.Saved
+	// the share of the prompt tokens that were read from the cache
+	prompt := stats.InputTokens + stats.CacheCreationTokens + stats.CacheReadTokens
+	if prompt > 0 {
+		stats.HitRatio = float64(stats.CacheReadTokens) / float64(prompt)
+	}
+}
+
+// recordUsage adds the usage of the job to the ledger of its user
+func (this *ProjectService) recordUsage(job *generationJob) {
+	turn := this.turnUsage(job)
+	if turn.InputTokens == 0 && turn.OutputTokens == 0 {
+		return
+	}
+	err := this.ledger.Record(job.job.User, turn)
+	if err != nil {
+		fmt.Println("Failed to record the usage of job ", turn.JobId, ": ", err.Error())
+	}
+}
+
+// usageReports returns the daily and monthly usage reports of the viewer matching the
+// query, a query on UsageReport selects these rather than projects
+func (this *ProjectService) usageReports(query ifs.IQuery, viewer string) []interface{} {
+	result := make([]interface{}, 0)
+	for _, report := range this.ledger.Reports() {
+		if ownedBy(report.User, viewer) && query.Match(report) {
+			result = append(result, report)
+		}
+	}
+	return result
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/reflect/go/reflect/introspecting"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	SecretServiceType = "SecretService"
+	SecretServiceName = "key"
+	SecretServiceArea = byte(0)
+)
+
+// SecretService manages the provider API keys in the vault of the project service.
+// POST stores a key of the user, or of an organization of the user, PUT rotates the value
+// of a key and DELETE revokes it. The keys are never returned, only their metadata and
+// the last characters of the value.
+type SecretService struct {
+}
+
+// Activate activates the SecretService
+func (this *SecretService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.ProviderSecret{})
+	resources.Registry().Register(&types.ProviderSecretList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	node, _ := resources.Introspector().Inspect(&types.ProviderSecret{})
+	introspecting.AddPrimaryKeyDecorator(node, "Id")
+	return nil
+}
+
+// DeActivate deactivates the SecretService
+func (this *SecretService) DeActivate() error {
+	return nil
+}
+
+func (this *SecretService) vault(vnic ifs.IVNic) (*secrets.Vault, bool) {
+	handler, ok := vnic.Resources().Services().ServiceHandler(ServiceName, ServiceArea)
+	if !ok {
+		return nil, false
+	}
+	projects, ok := handler.(*ProjectService)
+	if !ok || projects.Vault() == nil {
+		return nil, false
+	}
+	return projects.Vault(), true
+}
+
+// Post stores the key
+func (this *SecretService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	secret, ok := elements.Element().(*types.ProviderSecret)
+	if !ok {
+		return object.NewError("Store request for key is invalid")
+	}
+	vault, ok := this.vault(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	err := identifyWith(vnic, elements, &secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	if secret.User == "" {
+		return object.NewError("Store request for key is invalid")
+	}
+	stored, err := vault.Store(secret, secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	return object.New(nil, stored)
+}
+
+// Put rotates the value of the key
+func (this *SecretService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	secret, ok := elements.Element().(*types.ProviderSecret)
+	if !ok {
+		return object.NewError("Rotate request for key is invalid")
+	}
+	vault, ok := this.vault(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	err := identifyWith(vnic, elements, &secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	if secret.Id == "" || secret.User == "" {
+		return object.NewError("Rotate request for key is invalid")
+	}
+	rotated, err := vault.Rotate(secret.Id, secret.Value, secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	return object.New(nil, rotated)
+}
+
+// Patch is not supported
+func (this *SecretService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Keys are rotated with PUT")
+}
+
+// Delete revokes the key
+func (this *SecretService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	secret, ok := elements.Element().(*types.ProviderSecret)
+	if !ok {
+		return object.NewError("Revoke request for key is invalid")
+	}
+	vault, ok := this.vault(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	err := identifyWith(vnic, elements, &secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	if secret.Id == "" || secret.User == "" {
+		return object.NewError("Revoke request for key is invalid")
+	}
+	revoked, err := vault.Revoke(secret.Id, secret.User)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	return object.New(nil, revoked)
+}
+
+// GetCopy handles GET requests for copies
+func (this *SecretService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return this.Get(elements, vnic)
+}
+
+// Get returns the metadata of the keys matching the query
+func (this *SecretService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	vault, ok := this.vault(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := viewerWith(vnic, elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	for _, secret := range vault.List() {
+		if (viewer == "" || vault.Allowed(secret, viewer)) && query.Match(secret) {
+			result = append(result, secret)
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *SecretService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *SecretService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *SecretService) WebService() ifs.IWebService {
+	ws := web.New(SecretServiceName, SecretServiceArea, &types.ProviderSecret{},
+		&types.ProviderSecret{}, &types.ProviderSecret{}, &types.ProviderSecret{}, nil, nil,
+		&types.ProviderSecret{}, &types.ProviderSecret{}, &l8api.L8Query{}, &types.ProviderSecretList{})
+	return ws
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// ActivateServices activates the project service and the services that work on its
+// projects on the vnic and returns the project service
+func ActivateServices(resources ifs.IResources, nic ifs.IVNic) (*ProjectService, error) {
+	nic.Resources().Registry().Register(&ProjectService{})
+	projects, err := nic.Resources().Services().Activate(ServiceType, ServiceName, ServiceArea,
+		resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&SnapshotService{})
+	_, err = nic.Resources().Services().Activate(SnapshotServiceType, SnapshotServiceName,
+		SnapshotServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&GitService{})
+	_, err = nic.Resources().Services().Activate(GitServiceType, GitServiceName,
+		GitServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&TrashService{})
+	_, err = nic.Resources().Services().Activate(TrashServiceType, TrashServiceName,
+		TrashServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&JobService{})
+	_, err = nic.Resources().Services().Activate(JobServiceType, JobServiceName,
+		JobServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&TemplateService{})
+	_, err = nic.Resources().Services().Activate(TemplateServiceType, TemplateServiceName,
+		TemplateServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+	nic.Resources().Registry().Register(&SecretService{})
+	_, err = nic.Resources().Services().Activate(SecretServiceType, SecretServiceName,
+		SecretServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&ShareService{})
+	_, err = nic.Resources().Services().Activate(ShareServiceType, ShareServiceName,
+		ShareServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	nic.Resources().Registry().Register(&AuditService{})
+	_, err = nic.Resources().Services().Activate(AuditServiceType, AuditServiceName,
+		AuditServiceArea, resources, nic)
+	if err != nil {
+		return nil, err
+	}
+	return projects.(*ProjectService), nil
+}
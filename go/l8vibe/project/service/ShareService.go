@@ -0,0 +1,181 @@
+package service
+
+import (
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	ShareServiceType = "ShareService"
+	ShareServiceName = "share"
+	ShareServiceArea = byte(0)
+)
+
+// ShareService shares the projects. POST of a ProjectShare gives a user, org:{name} or
+// team:{name} the viewer, editor or owner role on a project, DELETE removes it and PUT
+// with NewOwner transfers the project. Only owners share and transfer, members can remove
+// themselves. GET lists the owner and the members of the projects the caller can see.
+type ShareService struct {
+}
+
+// Activate activates the ShareService
+func (this *ShareService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.ProjectShare{})
+	resources.Registry().Register(&types.ProjectShareList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	resources.Introspector().Inspect(&types.ProjectShare{})
+	return nil
+}
+
+// DeActivate deactivates the ShareService
+func (this *ShareService) DeActivate() error {
+	return nil
+}
+
+// Post shares the project with the principal
+func (this *ShareService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	share, ok := elements.Element().(*types.ProjectShare)
+	if !ok || share.Name == "" || share.Principal == "" {
+		return object.NewError("Share request for project is invalid")
+	}
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	target := &types.Project{User: share.User, Name: share.Name}
+	return projects.audited(elements, audit.OP_SHARE, target, types.ProjectRole_ROLE_OWNER, func(record *types.AuditRecord) ifs.IElements {
+		record.Detail = share.Principal + " as " + roleName(share.Role)
+		project, err := projects.Share(target.User, share.Name, share.Principal, share.Role, elements.AAAId())
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, &types.ProjectShareList{List: sharesOf(project)})
+	})
+}
+
+// Put transfers the project to the new owner
+func (this *ShareService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	share, ok := elements.Element().(*types.ProjectShare)
+	if !ok || share.Name == "" || share.NewOwner == "" {
+		return object.NewError("Transfer request for project is invalid")
+	}
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	target := &types.Project{User: share.User, Name: share.Name}
+	return projects.audited(elements, audit.OP_TRANSFER, target, types.ProjectRole_ROLE_OWNER, func(record *types.AuditRecord) ifs.IElements {
+		record.Detail = "to " + share.NewOwner
+		project, err := projects.Transfer(target.User, share.Name, share.NewOwner)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, &types.ProjectShareList{List: sharesOf(project)})
+	})
+}
+
+// Patch is not supported
+func (this *ShareService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Shares are changed with POST")
+}
+
+// Delete removes the principal from the members of the project
+func (this *ShareService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	share, ok := elements.Element().(*types.ProjectShare)
+	if !ok || share.Name == "" || share.Principal == "" {
+		return object.NewError("Unshare request for project is invalid")
+	}
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	// a member leaving the project only needs to see it
+	required := types.ProjectRole_ROLE_OWNER
+	if identity := elements.AAAId(); identity != "" && auth.NormalizeUser(share.Principal) == identity {
+		required = types.ProjectRole_ROLE_VIEWER
+	}
+	target := &types.Project{User: share.User, Name: share.Name}
+	return projects.audited(elements, audit.OP_UNSHARE, target, required, func(record *types.AuditRecord) ifs.IElements {
+		record.Detail = share.Principal
+		project, err := projects.Unshare(target.User, share.Name, share.Principal)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, &types.ProjectShareList{List: sharesOf(project)})
+	})
+}
+
+// GetCopy handles GET requests for copies
+func (this *ShareService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return this.Get(elements, vnic)
+}
+
+// Get returns the shares of a project in filter mode, or the shares matching a query
+func (this *ShareService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	if elements.IsFilterMode() {
+		share, isShare := elements.Element().(*types.ProjectShare)
+		if isShare {
+			err := projects.access(elements, &share.User, share.Name, types.ProjectRole_ROLE_VIEWER)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			current, _ := projects.cache.Get(&types.Project{User: share.User, Name: share.Name})
+			project, exists := current.(*types.Project)
+			if !exists || project.DeletedAt != 0 {
+				return object.NewError("Project " + share.Name + " was not found")
+			}
+			return object.New(nil, &types.ProjectShareList{List: sharesOf(project)})
+		}
+	}
+
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	projects.cache.Collect(func(elem interface{}) (bool, interface{}) {
+		project, isProj := elem.(*types.Project)
+		if !isProj || project.DeletedAt != 0 ||
+			(viewer != "" && projects.roleOf(project, viewer) < types.ProjectRole_ROLE_VIEWER) {
+			return false, elem
+		}
+		for _, share := range sharesOf(project) {
+			if query.Match(share) {
+				result = append(result, share)
+			}
+		}
+		return false, elem
+	})
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *ShareService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *ShareService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *ShareService) WebService() ifs.IWebService {
+	ws := web.New(ShareServiceName, ShareServiceArea, &types.ProjectShare{},
+		&types.ProjectShareList{}, &types.ProjectShare{}, &types.ProjectShareList{}, nil, nil,
+		&types.ProjectShare{}, &types.ProjectShareList{}, &l8api.L8Query{}, &types.ProjectShareList{})
+	return ws
+}
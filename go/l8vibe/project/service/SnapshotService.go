@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	SnapshotServiceType = "SnapshotService"
+	SnapshotServiceName = "snap"
+	SnapshotServiceArea = byte(0)
+)
+
+// SnapshotService exposes the per turn snapshots of the projects.
+// GET lists snapshots, POST of a SnapshotDiff returns the differences between two
+// snapshots and PUT of a ProjectSnapshot rolls the project back to it.
+type SnapshotService struct {
+}
+
+// Activate activates the SnapshotService
+func (this *SnapshotService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.ProjectSnapshot{})
+	resources.Registry().Register(&types.ProjectSnapshotList{})
+	resources.Registry().Register(&types.SnapshotDiff{})
+	resources.Registry().Register(&l8api.L8Query{})
+	resources.Introspector().Inspect(&types.ProjectSnapshot{})
+	return nil
+}
+
+// DeActivate deactivates the SnapshotService
+func (this *SnapshotService) DeActivate() error {
+	return nil
+}
+
+// Post returns the differences between the From and To snapshots of a SnapshotDiff
+func (this *SnapshotService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	diff, ok := elements.Element().(*types.SnapshotDiff)
+	if !ok {
+		return object.NewError("Snapshot diff request is invalid")
+	}
+	err := accessWith(vnic, elements, &diff.User, diff.Name, types.ProjectRole_ROLE_VIEWER)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	store, err := snapshot.NewSnapshotStore(&types.Project{User: diff.User, Name: diff.Name})
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	diff.Files, err = store.Diff(diff.From, diff.To)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	return object.New(nil, diff)
+}
+
+// Put rolls the project back to the snapshot
+func (this *SnapshotService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	snap, ok := elements.Element().(*types.ProjectSnapshot)
+	if !ok {
+		return object.NewError("Snapshot rollback request is invalid")
+	}
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	project := &types.Project{User: snap.User, Name: snap.Name}
+	return projects.audited(elements, audit.OP_ROLLBACK, project, types.ProjectRole_ROLE_EDITOR, func(record *types.AuditRecord) ifs.IElements {
+		record.Detail = fmt.Sprint("snapshot ", snap.Index)
+		rollback, err := projects.Rollback(project.User, project.Name, snap.Index)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, rollback)
+	})
+}
+
+// Patch is not supported, snapshots are immutable
+func (this *SnapshotService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Snapshots are immutable")
+}
+
+// Delete is not supported, snapshots are immutable
+func (this *SnapshotService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Snapshots are immutable")
+}
+
+// GetCopy handles GET requests for copies
+func (this *SnapshotService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return nil
+}
+
+// Get lists the snapshots of a project in filter mode, or the snapshots matching a query
+func (this *SnapshotService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	if elements.IsFilterMode() {
+		snap, ok := elements.Element().(*types.ProjectSnapshot)
+		if ok {
+			err := accessWith(vnic, elements, &snap.User, snap.Name, types.ProjectRole_ROLE_VIEWER)
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			store, err := snapshot.NewSnapshotStore(&types.Project{User: snap.User, Name: snap.Name})
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			list, err := store.List()
+			if err != nil {
+				return object.NewError(err.Error())
+			}
+			return object.New(nil, &types.ProjectSnapshotList{List: list})
+		}
+	}
+
+	projects, ok := projectsOf(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	dirs, _ := filepath.Glob(filepath.Join(persist.DataDir(), "*", "*.snapshots"))
+	for _, dir := range dirs {
+		user := filepath.Base(filepath.Dir(dir))
+		name := strings.TrimSuffix(filepath.Base(dir), ".snapshots")
+		if !projects.canView(user, name, viewer) {
+			continue
+		}
+		store, er := snapshot.NewSnapshotStore(&types.Project{User: user, Name: name})
+		if er != nil {
+			continue
+		}
+		list, er := store.List()
+		if er != nil {
+			fmt.Println("Failed to list snapshots of ", name, ": ", er.Error())
+			continue
+		}
+		for _, snap := range list {
+			if query.Match(snap) {
+				result = append(result, snap)
+			}
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *SnapshotService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *SnapshotService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *SnapshotService) WebService() ifs.IWebService {
+	ws := web.New(SnapshotServiceName, SnapshotServiceArea, &types.SnapshotDiff{},
+		&types.SnapshotDiff{}, &types.ProjectSnapshot{}, &types.ProjectSnapshot{}, nil, nil, nil, nil,
+		&l8api.L8Query{}, &types.ProjectSnapshotList{})
+	return ws
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/reflect/go/reflect/introspecting"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	TemplateServiceType = "TemplateService"
+	TemplateServiceName = "tmpl"
+	TemplateServiceArea = byte(0)
+)
+
+// TemplateService exposes the server side prompt templates a project can select
+// in its generation settings. The templates are read only.
+type TemplateService struct {
+}
+
+// Activate activates the TemplateService
+func (this *TemplateService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.PromptTemplate{})
+	resources.Registry().Register(&types.PromptTemplateList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	node, _ := resources.Introspector().Inspect(&types.PromptTemplate{})
+	introspecting.AddPrimaryKeyDecorator(node, "Name")
+	return nil
+}
+
+// DeActivate deactivates the TemplateService
+func (this *TemplateService) DeActivate() error {
+	return nil
+}
+
+// Post is not supported, the templates are read only
+func (this *TemplateService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Prompt templates are read only")
+}
+
+// Put is not supported, the templates are read only
+func (this *TemplateService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Prompt templates are read only")
+}
+
+// Patch is not supported, the templates are read only
+func (this *TemplateService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Prompt templates are read only")
+}
+
+// Delete is not supported, the templates are read only
+func (this *TemplateService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Prompt templates are read only")
+}
+
+// GetCopy handles GET requests for copies
+func (this *TemplateService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return this.Get(elements, vnic)
+}
+
+// Get returns the templates matching the query
+func (this *TemplateService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	for _, template := range anthropic.PromptTemplates() {
+		if query.Match(template) {
+			result = append(result, template)
+		}
+	}
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *TemplateService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *TemplateService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *TemplateService) WebService() ifs.IWebService {
+	ws := web.New(TemplateServiceName, TemplateServiceArea, nil,
+		nil, nil, nil, nil, nil, nil, nil,
+		&l8api.L8Query{}, &types.PromptTemplateList{})
+	return ws
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/web"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	TrashServiceType = "TrashService"
+	TrashServiceName = "trash"
+	TrashServiceArea = byte(0)
+)
+
+// TrashService exposes the deleted projects that are still within the retention.
+// GET lists them, PUT of a Project restores it and DELETE of a Project deletes it for good.
+type TrashService struct {
+}
+
+// Activate activates the TrashService
+func (this *TrashService) Activate(serviceName string, serviceArea byte, resources ifs.IResources, listener ifs.IServiceCacheListener, args ...interface{}) error {
+	resources.Registry().Register(&types.Project{})
+	resources.Registry().Register(&types.ProjectList{})
+	resources.Registry().Register(&l8api.L8Query{})
+	return nil
+}
+
+// DeActivate deactivates the TrashService
+func (this *TrashService) DeActivate() error {
+	return nil
+}
+
+func (this *TrashService) projects(vnic ifs.IVNic) (*ProjectService, bool) {
+	handler, ok := vnic.Resources().Services().ServiceHandler(ServiceName, ServiceArea)
+	if !ok {
+		return nil, false
+	}
+	projects, ok := handler.(*ProjectService)
+	return projects, ok
+}
+
+// Post is not supported, projects get to the trash by deleting them
+func (this *TrashService) Post(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Delete the project to move it to the trash")
+}
+
+// Put restores the project from the trash
+func (this *TrashService) Put(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	project, ok := elements.Element().(*types.Project)
+	if !ok {
+		return object.NewError("Restore request for project is invalid")
+	}
+	projects, ok := this.projects(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	return projects.audited(elements, audit.OP_RESTORE, project, types.ProjectRole_ROLE_OWNER, func(*types.AuditRecord) ifs.IElements {
+		restored, err := projects.Restore(project.User, project.Name)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, restored)
+	})
+}
+
+// Patch is not supported
+func (this *TrashService) Patch(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return object.NewError("Trashed projects cannot be modified")
+}
+
+// Delete deletes the project from the trash for good
+func (this *TrashService) Delete(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	project, ok := elements.Element().(*types.Project)
+	if !ok {
+		return object.NewError("Delete request for project is invalid")
+	}
+	projects, ok := this.projects(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	return projects.audited(elements, audit.OP_PURGE, project, types.ProjectRole_ROLE_OWNER, func(*types.AuditRecord) ifs.IElements {
+		trashed, ok := projects.trashed(project)
+		if !ok {
+			return object.NewError("Project " + project.Name + " is not in the trash")
+		}
+		err := projects.Purge(trashed)
+		if err != nil {
+			return object.NewError(err.Error())
+		}
+		return object.New(nil, trashed)
+	})
+}
+
+// GetCopy handles GET requests for copies
+func (this *TrashService) GetCopy(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	return nil
+}
+
+// Get lists the projects in the trash that match the query
+func (this *TrashService) Get(elements ifs.IElements, vnic ifs.IVNic) ifs.IElements {
+	projects, ok := this.projects(vnic)
+	if !ok {
+		return object.NewError("Project service is not available")
+	}
+	viewer, err := projects.viewer(elements)
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	query, err := elements.Query(vnic.Resources())
+	if err != nil {
+		return object.NewError(err.Error())
+	}
+	result := make([]interface{}, 0)
+	projects.cache.Collect(func(elem interface{}) (bool, interface{}) {
+		project, isProj := elem.(*types.Project)
+		match := isProj && project.DeletedAt != 0 &&
+			(viewer == "" || projects.roleOf(project, viewer) == types.ProjectRole_ROLE_OWNER) && query.Match(elem)
+		if match {
+			result = append(result, elem)
+		}
+		return match, elem
+	})
+	return object.New(nil, result)
+}
+
+// Failed handles failed requests
+func (this *TrashService) Failed(elements ifs.IElements, vnic ifs.IVNic, message *ifs.Message) ifs.IElements {
+	return nil
+}
+
+// TransactionConfig returns the transaction configuration
+func (this *TrashService) TransactionConfig() ifs.ITransactionConfig {
+	return nil
+}
+
+// WebService returns the web service
+func (this *TrashService) WebService() ifs.IWebService {
+	ws := web.New(TrashServiceName, TrashServiceArea, nil,
+		nil, &types.Project{}, &types.Project{}, nil, nil, &types.Project{}, &types.Project{},
+		&l8api.L8Query{}, &types.ProjectList{})
+	return ws
+}
@@ -0,0 +1,24 @@
+package secrets
+
+import "regexp"
+
+const MASKED = "[redacted]"
+
+// maskPatterns match the provider keys and credentials that error messages may echo
+var maskPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-*]{8,}`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/\-]{8,}=*`),
+	regexp.MustCompile(`(?i)(x-api-key["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+}
+
+// Mask replaces the API keys in the text, for text that is logged or returned to clients
+func Mask(text string) string {
+	for _, pattern := range maskPatterns {
+		if pattern.NumSubexp() == 0 {
+			text = pattern.ReplaceAllString(text, MASKED)
+			continue
+		}
+		text = pattern.ReplaceAllString(text, "${1}"+MASKED)
+	}
+	return text
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+)
+
+// KEY_SIZE is the size of the master key and of the data keys, AES-256
+const KEY_SIZE = 32
+
+// LoadMasterKey returns the master key of L8VIBE_MASTER_KEY, or else of the master key file
+func LoadMasterKey() ([]byte, error) {
+	text := os.Getenv(consts.MASTER_KEY_ENV)
+	if text != "" {
+		return DecodeMasterKey(text)
+	}
+	fileName := os.Getenv(consts.MASTER_KEY_FILE_ENV)
+	if fileName == "" {
+		fileName = consts.MASTER_KEY_FILE
+	}
+	return LoadKeyFile(fileName)
+}
+
+// LoadKeyFile returns the base64 key of the file, a missing file is created with a new
+// random key, readable by the owner only
+func LoadKeyFile(fileName string) ([]byte, error) {
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		return DecodeMasterKey(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key := randomBytes(KEY_SIZE)
+	err = persist.WriteAtomicMode(fileName, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Created the key ", fileName, " with id ", KeyId(key))
+	return key, nil
+}
+
+// LoadPreviousMasterKey returns the master key of L8VIBE_MASTER_KEY_PREVIOUS, nil if it
+// is not set. The secrets it sealed are sealed again with the current key on startup.
+func LoadPreviousMasterKey() ([]byte, error) {
+	text := os.Getenv(consts.MASTER_KEY_PREVIOUS_ENV)
+	if text == "" {
+		return nil, nil
+	}
+	return DecodeMasterKey(text)
+}
+
+// DecodeMasterKey decodes a base64 master key
+func DecodeMasterKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, errors.New("Master key is not base64: " + err.Error())
+	}
+	if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("Master key must be %d bytes, it is %d", KEY_SIZE, len(key))
+	}
+	return key, nil
+}
+
+// KeyId identifies a master key without revealing it
+func KeyId(key []byte) string {
+	sum := sha256.Sum256(append([]byte("l8vibe master key "), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encrypt seals the plain text with AES-GCM, the nonce is prepended to the cipher text.
+// The additional data binds the cipher text to the secret it belongs to.
+func encrypt(key, plain, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := randomBytes(gcm.NonceSize())
+	return gcm.Seal(nonce, nonce, plain, additional), nil
+}
+
+func decrypt(key, sealed, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("cipher text is truncated")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return data
+}
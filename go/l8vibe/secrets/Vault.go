@@ -0,0 +1,454 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Vault keeps the provider API keys of the users and the organizations encrypted at rest.
+// Every key is encrypted with its own random data key, which is stored wrapped by the
+// master key, so rotating the master key only wraps the data keys again. Projects refer
+// to the keys by id and the plain keys never leave the vault except to call a provider.
+type Vault struct {
+	fileName string
+	master   []byte
+	masterId string
+	previous map[string][]byte
+	orgs     map[string][]string
+	secrets  map[string]*types.ProviderSecret
+	mtx      sync.Mutex
+}
+
+// VaultFile returns the file of the vault, L8VIBE_SECRETS overrides the default
+func VaultFile() string {
+	fileName := os.Getenv(consts.SECRETS_FILE_ENV)
+	if fileName == "" {
+		return consts.SECRETS_FILE
+	}
+	return fileName
+}
+
+// OpenVault opens the vault file with the master key of the environment
+func OpenVault() (*Vault, error) {
+	master, err := LoadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := LoadPreviousMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewVault(VaultFile(), master, previous)
+}
+
+// NewVault loads the vault from the file, a missing file is an empty vault. The secrets
+// sealed with a previous master key are sealed again with the master key.
+func NewVault(fileName string, master []byte, previous ...[]byte) (*Vault, error) {
+	if len(master) != KEY_SIZE {
+		return nil, fmt.Errorf("Master key must be %d bytes", KEY_SIZE)
+	}
+	vault := &Vault{fileName: fileName, master: master, masterId: KeyId(master),
+		previous: make(map[string][]byte), orgs: make(map[string][]string),
+		secrets: make(map[string]*types.ProviderSecret)}
+	for _, key := range previous {
+		if key != nil {
+			vault.previous[KeyId(key)] = key
+		}
+	}
+	unlock, err := persist.LockFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	err = vault.load()
+	if err != nil {
+		return nil, err
+	}
+	resealed := 0
+	for _, secret := range vault.secrets {
+		if secret.Revoked != 0 || secret.MasterKeyId == vault.masterId {
+			continue
+		}
+		er := vault.reseal(secret)
+		if er != nil {
+			fmt.Println("Secret ", secret.Id, " cannot be unsealed: ", er.Error())
+			continue
+		}
+		resealed++
+	}
+	if resealed > 0 {
+		fmt.Println("Sealed ", resealed, " secrets with the master key ", vault.masterId)
+		err = vault.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vault, nil
+}
+
+// SetOrgs sets the members of the organizations, who share the keys of the organization
+func (this *Vault) SetOrgs(orgs map[string][]string) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.orgs = make(map[string][]string)
+	for org, members := range orgs {
+		this.orgs[org] = append([]string(nil), members...)
+	}
+}
+
+// Store encrypts the value of the secret for its user or organization and returns the
+// stored secret without its key material. Storing a key the owner already stored for
+// the provider returns the existing secret.
+func (this *Vault) Store(secret *types.ProviderSecret, user string) (*types.ProviderSecret, error) {
+	if secret.Value == "" {
+		return nil, errors.New("Secret value is required")
+	}
+	if secret.Provider == "" {
+		return nil, errors.New("Secret provider is required")
+	}
+	if secret.Org == "" {
+		secret.User = user
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !this.allowed(secret, user) {
+		return nil, errors.New("User " + user + " is not a member of " + secret.Org)
+	}
+	fingerprint := this.fingerprint(secret, secret.Value)
+	for _, existing := range this.secrets {
+		if existing.Revoked == 0 && existing.User == secret.User && existing.Org == secret.Org &&
+			existing.Provider == secret.Provider && hmac.Equal(existing.Fingerprint, fingerprint) {
+			return Redact(existing), nil
+		}
+	}
+	stored := &types.ProviderSecret{Id: "key-" + hex.EncodeToString(randomBytes(12)), User: secret.User,
+		Org: secret.Org, Provider: secret.Provider, Name: secret.Name, Version: 1, Created: time.Now().Unix()}
+	err = this.seal(stored, secret.Value)
+	if err != nil {
+		return nil, err
+	}
+	this.secrets[stored.Id] = stored
+	err = this.save()
+	if err != nil {
+		delete(this.secrets, stored.Id)
+		return nil, err
+	}
+	return Redact(stored), nil
+}
+
+// Rotate replaces the value of the secret with a new version, the projects that refer
+// to the secret use the new value from their next turn on
+func (this *Vault) Rotate(id, value, user string) (*types.ProviderSecret, error) {
+	if value == "" {
+		return nil, errors.New("Secret value is required")
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	secret, err := this.usable(id, user)
+	if err != nil {
+		return nil, err
+	}
+	rotated := proto.Clone(secret).(*types.ProviderSecret)
+	rotated.Version++
+	rotated.Rotated = time.Now().Unix()
+	err = this.seal(rotated, value)
+	if err != nil {
+		return nil, err
+	}
+	this.secrets[id] = rotated
+	err = this.save()
+	if err != nil {
+		this.secrets[id] = secret
+		return nil, err
+	}
+	return Redact(rotated), nil
+}
+
+// Revoke revokes the secret and destroys its key material, the metadata is kept so the
+// projects that refer to it report the revocation
+func (this *Vault) Revoke(id, user string) (*types.ProviderSecret, error) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	secret, ok := this.secrets[id]
+	if !ok || !this.allowed(secret, user) {
+		return nil, errors.New("Secret " + id + " was not found")
+	}
+	if secret.Revoked != 0 {
+		return Redact(secret), nil
+	}
+	revoked := proto.Clone(secret).(*types.ProviderSecret)
+	revoked.Revoked = time.Now().Unix()
+	revoked.WrappedKey, revoked.Ciphertext, revoked.Fingerprint = nil, nil, nil
+	this.secrets[id] = revoked
+	err = this.save()
+	if err != nil {
+		this.secrets[id] = secret
+		return nil, err
+	}
+	return Redact(revoked), nil
+}
+
+// Authorize fails unless the user may use the secret
+func (this *Vault) Authorize(id, user string) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.load()
+	if err != nil {
+		return err
+	}
+	_, err = this.usable(id, user)
+	return err
+}
+
+// Resolve returns the plain value of the secret for a call of the user to its provider
+func (this *Vault) Resolve(id, user string) (string, error) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.load()
+	if err != nil {
+		return "", err
+	}
+	secret, err := this.usable(id, user)
+	if err != nil {
+		return "", err
+	}
+	return this.unseal(secret)
+}
+
+// List returns the secrets without their key material, ordered by id
+func (this *Vault) List() []*types.ProviderSecret {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.load()
+	if err != nil {
+		fmt.Println("Failed to load the vault: ", err.Error())
+	}
+	result := make([]*types.ProviderSecret, 0, len(this.secrets))
+	for _, secret := range this.secrets {
+		result = append(result, Redact(secret))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+// Allowed reports whether the user may see and use the secret
+func (this *Vault) Allowed(secret *types.ProviderSecret, user string) bool {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	return this.allowed(secret, user)
+}
+
+// RotateMasterKey wraps the data keys of all the secrets with the new master key, the
+// previous key keeps unsealing them until the vault is saved
+func (this *Vault) RotateMasterKey(master []byte) error {
+	if len(master) != KEY_SIZE {
+		return fmt.Errorf("Master key must be %d bytes", KEY_SIZE)
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := this.lockAndLoad()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	this.previous[this.masterId] = this.master
+	this.master, this.masterId = master, KeyId(master)
+	for _, secret := range this.secrets {
+		if secret.Revoked != 0 || secret.MasterKeyId == this.masterId {
+			continue
+		}
+		err := this.reseal(secret)
+		if err != nil {
+			return errors.New("Secret " + secret.Id + " cannot be unsealed: " + err.Error())
+		}
+	}
+	return this.save()
+}
+
+// MasterKeyId returns the id of the master key the secrets are sealed with
+func (this *Vault) MasterKeyId() string {
+	return this.masterId
+}
+
+// Redact returns a copy of the secret without its value and key material
+func Redact(secret *types.ProviderSecret) *types.ProviderSecret {
+	redacted := proto.Clone(secret).(*types.ProviderSecret)
+	redacted.Value = ""
+	redacted.WrappedKey, redacted.Ciphertext, redacted.Fingerprint = nil, nil, nil
+	return redacted
+}
+
+// usable returns the secret if it exists, is not revoked and the user may use it,
+// called with mtx held
+func (this *Vault) usable(id, user string) (*types.ProviderSecret, error) {
+	secret, ok := this.secrets[id]
+	if !ok || !this.allowed(secret, user) {
+		return nil, errors.New("Secret " + id + " was not found")
+	}
+	if secret.Revoked != 0 {
+		return nil, errors.New("Secret " + id + " was revoked on " + time.Unix(secret.Revoked, 0).UTC().Format(time.RFC3339))
+	}
+	return secret, nil
+}
+
+// allowed reports whether the secret belongs to the user or to an organization of the user
+func (this *Vault) allowed(secret *types.ProviderSecret, user string) bool {
+	if secret.Org == "" {
+		return user != "" && secret.User == user
+	}
+	for _, member := range this.orgs[secret.Org] {
+		if member == user {
+			return true
+		}
+	}
+	return false
+}
+
+// seal encrypts the value with a new data key, which is wrapped by the master key
+func (this *Vault) seal(secret *types.ProviderSecret, value string) error {
+	dataKey := randomBytes(KEY_SIZE)
+	additional := []byte(secret.Id)
+	ciphertext, err := encrypt(dataKey, []byte(value), additional)
+	if err != nil {
+		return err
+	}
+	wrapped, err := encrypt(this.master, dataKey, additional)
+	if err != nil {
+		return err
+	}
+	secret.MasterKeyId = this.masterId
+	secret.WrappedKey, secret.Ciphertext = wrapped, ciphertext
+	secret.Fingerprint = this.fingerprint(secret, value)
+	secret.Hint = hint(value)
+	return nil
+}
+
+func (this *Vault) unseal(secret *types.ProviderSecret) (string, error) {
+	master := this.master
+	if secret.MasterKeyId != this.masterId {
+		key, ok := this.previous[secret.MasterKeyId]
+		if !ok {
+			return "", errors.New("Secret " + secret.Id + " is sealed with the unknown master key " + secret.MasterKeyId)
+		}
+		master = key
+	}
+	additional := []byte(secret.Id)
+	dataKey, err := decrypt(master, secret.WrappedKey, additional)
+	if err != nil {
+		return "", errors.New("Secret " + secret.Id + " cannot be unwrapped: " + err.Error())
+	}
+	value, err := decrypt(dataKey, secret.Ciphertext, additional)
+	if err != nil {
+		return "", errors.New("Secret " + secret.Id + " cannot be decrypted: " + err.Error())
+	}
+	return string(value), nil
+}
+
+// reseal seals the secret with the current master key
+func (this *Vault) reseal(secret *types.ProviderSecret) error {
+	value, err := this.unseal(secret)
+	if err != nil {
+		return err
+	}
+	return this.seal(secret, value)
+}
+
+// fingerprint identifies the value of an owner for a provider, keyed by the master key so
+// it does not help guessing the value
+func (this *Vault) fingerprint(secret *types.ProviderSecret, value string) []byte {
+	mac := hmac.New(sha256.New, this.master)
+	mac.Write([]byte(secret.User + "\x00" + secret.Org + "\x00" + secret.Provider + "\x00" + value))
+	return mac.Sum(nil)
+}
+
+// load replaces the secrets with the ones in the file, which the other processes of the
+// vault write as well. A missing file is an empty vault. Called with mtx held.
+func (this *Vault) load() error {
+	data, err := os.ReadFile(this.fileName)
+	if os.IsNotExist(err) {
+		this.secrets = make(map[string]*types.ProviderSecret)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := &types.ProviderSecretList{}
+	err = proto.Unmarshal(data, list)
+	if err != nil {
+		return fmt.Errorf("vault %s is corrupt: %w", this.fileName, err)
+	}
+	this.secrets = make(map[string]*types.ProviderSecret)
+	for _, secret := range list.List {
+		this.secrets[secret.Id] = secret
+	}
+	return nil
+}
+
+// lockAndLoad takes the file lock of the vault and loads it, so a change starts from
+// the secrets the other processes saved. Called with mtx held, it returns the function
+// that releases the file lock.
+func (this *Vault) lockAndLoad() (func(), error) {
+	unlock, err := persist.LockFile(this.fileName)
+	if err != nil {
+		return nil, err
+	}
+	err = this.load()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// save writes the vault, readable by the owner only, called with mtx and the file lock held
+func (this *Vault) save() error {
+	list := &types.ProviderSecretList{List: make([]*types.ProviderSecret, 0, len(this.secrets))}
+	for _, secret := range this.secrets {
+		list.List = append(list.List, secret)
+	}
+	sort.Slice(list.List, func(i, j int) bool {
+		return list.List[i].Id < list.List[j].Id
+	})
+	data, err := proto.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return persist.WriteAtomicMode(this.fileName, data, 0600)
+}
+
+// hint returns the last characters of the value, so the owner can tell the keys apart
+func hint(value string) string {
+	if len(value) <= 12 {
+		return "…"
+	}
+	return "…" + value[len(value)-4:]
+}
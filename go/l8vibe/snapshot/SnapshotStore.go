@@ -0,0 +1,326 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// SnapshotStore keeps immutable snapshots of a project workspace under
+// {data dir}/{user}/{name}.snapshots. File contents are stored once under objects/ by
+// their sha256 and every snapshot is a manifest of path to content hash, tied to
+// the number of project messages at the time it was taken.
+type SnapshotStore struct {
+	user string
+	name string
+	dir  string
+}
+
+// NewSnapshotStore returns the snapshot store of the project
+func NewSnapshotStore(project *types.Project) (*SnapshotStore, error) {
+	// the user and the name are path elements of the store
+	err := persist.CheckProject(project.User, project.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{user: project.User, name: project.Name,
+		dir: filepath.Join(persist.DataDir(), project.User, project.Name+".snapshots")}, nil
+}
+
+// Take records the current content of the workspace as the next snapshot
+func (this *SnapshotStore) Take(workspace *anthropic.Workspace, messageIndex int, prompt string) (*types.ProjectSnapshot, error) {
+	paths, err := workspace.List()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &types.ProjectSnapshot{User: this.user, Name: this.name, MessageIndex: int32(messageIndex),
+		Prompt: prompt, Created: time.Now().Unix(), Files: make(map[string]string)}
+	for _, path := range paths {
+		data, er := workspace.ReadFile(path)
+		if er != nil {
+			return nil, er
+		}
+		hash, er := this.writeObject(data)
+		if er != nil {
+			return nil, er
+		}
+		snapshot.Files[path] = hash
+	}
+	return snapshot, this.write(snapshot)
+}
+
+// Copy records a new snapshot with the files of an existing one, used when rolling back
+// so the latest snapshot always reflects the workspace.
+func (this *SnapshotStore) Copy(from *types.ProjectSnapshot, prompt string) (*types.ProjectSnapshot, error) {
+	snapshot := &types.ProjectSnapshot{User: this.user, Name: this.name, MessageIndex: from.MessageIndex,
+		Prompt: prompt, Created: time.Now().Unix(), Files: from.Files}
+	return snapshot, this.write(snapshot)
+}
+
+func (this *SnapshotStore) write(snapshot *types.ProjectSnapshot) error {
+	err := os.MkdirAll(filepath.Join(this.dir, "objects"), 0755)
+	if err != nil {
+		return err
+	}
+	list, err := this.List()
+	if err != nil {
+		return err
+	}
+	snapshot.Index = int32(len(list))
+	if len(list) > 0 {
+		snapshot.Index = list[len(list)-1].Index + 1
+	}
+	data, err := proto.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	// O_EXCL keeps snapshots immutable, a concurrent writer of the same index fails
+	file, err := os.OpenFile(this.snapshotFile(snapshot.Index), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (this *SnapshotStore) writeObject(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(this.dir, "objects", hash)
+	_, err := os.Stat(path)
+	if err == nil {
+		return hash, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	// a unique temporary file keeps concurrent writers of the same content apart
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if er := tmp.Close(); err == nil {
+		err = er
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), path)
+}
+
+// ReadObject returns the content stored under the hash
+func (this *SnapshotStore) ReadObject(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return nil, errors.New("invalid object hash " + hash)
+	}
+	return os.ReadFile(filepath.Join(this.dir, "objects", hash))
+}
+
+func (this *SnapshotStore) snapshotFile(index int32) string {
+	return filepath.Join(this.dir, strconv.Itoa(int(index))+".snap")
+}
+
+// List returns all the snapshots of the project ordered by index
+func (this *SnapshotStore) List() ([]*types.ProjectSnapshot, error) {
+	entries, err := os.ReadDir(this.dir)
+	if os.IsNotExist(err) {
+		return []*types.ProjectSnapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*types.ProjectSnapshot, 0)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".snap") {
+			continue
+		}
+		index, er := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".snap"))
+		if er != nil {
+			continue
+		}
+		snapshot, er := this.Get(int32(index))
+		if er != nil {
+			return nil, er
+		}
+		list = append(list, snapshot)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Index < list[j].Index
+	})
+	return list, nil
+}
+
+// Get returns the snapshot with the index
+func (this *SnapshotStore) Get(index int32) (*types.ProjectSnapshot, error) {
+	data, err := os.ReadFile(this.snapshotFile(index))
+	if err != nil {
+		return nil, errors.New("snapshot " + strconv.Itoa(int(index)) + " of " + this.name + " does not exist")
+	}
+	snapshot := &types.ProjectSnapshot{}
+	err = proto.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Latest returns the most recent snapshot, nil if there are none
+func (this *SnapshotStore) Latest() (*types.ProjectSnapshot, error) {
+	list, err := this.List()
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[len(list)-1], nil
+}
+
+// Restore replaces the content of the workspace with the files of the snapshot
+func (this *SnapshotStore) Restore(workspace *anthropic.Workspace, snapshot *types.ProjectSnapshot) error {
+	contents := make(map[string][]byte)
+	for path, hash := range snapshot.Files {
+		data, err := this.ReadObject(hash)
+		if err != nil {
+			return err
+		}
+		contents[path] = data
+	}
+	return RestoreFiles(workspace, contents)
+}
+
+// Capture returns the files of the workspace by their path, for RestoreFiles to put back
+func Capture(workspace *anthropic.Workspace) (map[string][]byte, error) {
+	paths, err := workspace.List()
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string][]byte)
+	for _, path := range paths {
+		contents[path], err = workspace.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+// RestoreFiles replaces the content of the workspace with the files by their path
+func RestoreFiles(workspace *anthropic.Workspace, contents map[string][]byte) error {
+	paths, err := workspace.List()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		_, ok := contents[path]
+		if !ok {
+			err = workspace.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for path, data := range contents {
+		err = workspace.WriteFile(path, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes all the snapshots of the project
+func (this *SnapshotStore) Remove() error {
+	return os.RemoveAll(this.dir)
+}
+
+// MoveTo moves the snapshots of the project to the snapshot store of another project
+func (this *SnapshotStore) MoveTo(to *SnapshotStore) error {
+	err := os.MkdirAll(filepath.Dir(to.dir), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(this.dir, to.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Diff returns the per file differences between two snapshots
+func (this *SnapshotStore) Diff(from, to int32) ([]*types.FileDiff, error) {
+	fromSnapshot, err := this.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	toSnapshot, err := this.Get(to)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for path := range fromSnapshot.Files {
+		paths = append(paths, path)
+	}
+	for path := range toSnapshot.Files {
+		_, ok := fromSnapshot.Files[path]
+		if !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	diffs := make([]*types.FileDiff, 0)
+	for _, path := range paths {
+		fromHash, inFrom := fromSnapshot.Files[path]
+		toHash, inTo := toSnapshot.Files[path]
+		if fromHash == toHash {
+			continue
+		}
+		fileDiff := &types.FileDiff{Path: path}
+		var before, after []byte
+		switch {
+		case !inFrom:
+			fileDiff.Status = "added"
+		case !inTo:
+			fileDiff.Status = "deleted"
+		default:
+			fileDiff.Status = "modified"
+		}
+		if inFrom {
+			before, err = this.ReadObject(fromHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if inTo {
+			after, err = this.ReadObject(toHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fileDiff.Diff = UnifiedDiff(path, string(before), string(after))
+		diffs = append(diffs, fileDiff)
+	}
+	return diffs, nil
+}
@@ -0,0 +1,99 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+const (
+	SCOPE_DAILY   = "daily"
+	SCOPE_MONTHLY = "monthly"
+)
+
+// Budget is the spending limit of a user in USD, zero means unlimited
+type Budget struct {
+	Daily   float64
+	Monthly float64
+}
+
+// BudgetExceededError is returned for a turn of a user who spent the budget of the period
+type BudgetExceededError struct {
+	User   string
+	Scope  string
+	Cost   float64
+	Budget float64
+}
+
+func (this *BudgetExceededError) Error() string {
+	return fmt.Sprintf("The %s budget of %s is exhausted, spent $%.2f of $%.2f", this.Scope, this.User,
+		this.Cost, this.Budget)
+}
+
+// Budgets are the default budget and the budgets of specific users
+type Budgets struct {
+	Default Budget
+	Users   map[string]Budget
+}
+
+// Of returns the budget of the user
+func (this *Budgets) Of(user string) Budget {
+	budget, ok := this.Users[user]
+	if ok {
+		return budget
+	}
+	return this.Default
+}
+
+// LoadBudgets reads the budgets from the environment. The default daily and monthly
+// budgets are plain amounts, the user budgets are a list of user=daily:monthly
+// entries separated by commas, e.g. alice=5:100,bob=:20
+func LoadBudgets() (*Budgets, error) {
+	budgets := &Budgets{Users: make(map[string]Budget)}
+	var err error
+	budgets.Default.Daily, err = parseAmount(os.Getenv(consts.USAGE_DAILY_BUDGET_ENV))
+	if err != nil {
+		return nil, err
+	}
+	budgets.Default.Monthly, err = parseAmount(os.Getenv(consts.USAGE_MONTHLY_BUDGET_ENV))
+	if err != nil {
+		return nil, err
+	}
+	users := strings.TrimSpace(os.Getenv(consts.USAGE_USER_BUDGETS_ENV))
+	if users == "" {
+		return budgets, nil
+	}
+	for _, entry := range strings.Split(users, ",") {
+		user, amounts, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid user budget %q", entry)
+		}
+		daily, monthly, _ := strings.Cut(amounts, ":")
+		budget := Budget{}
+		budget.Daily, err = parseAmount(daily)
+		if err != nil {
+			return nil, err
+		}
+		budget.Monthly, err = parseAmount(monthly)
+		if err != nil {
+			return nil, err
+		}
+		budgets.Users[user] = budget
+	}
+	return budgets, nil
+}
+
+func parseAmount(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid budget amount %q", value)
+	}
+	return amount, nil
+}
@@ -0,0 +1,208 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Ledger aggregates the token usage and the estimated cost of the turns per user and
+// day, and enforces the budgets. Monthly figures are the sum of the days of the month.
+// The ledger outlives the projects, so deleting a project does not refund its usage.
+type Ledger struct {
+	fileName string
+	budgets  *Budgets
+	days     map[string]*types.UsageReport
+	mtx      sync.Mutex
+}
+
+// LedgerFile returns the file of the ledger, L8VIBE_USAGE_LEDGER overrides the default
+func LedgerFile() string {
+	fileName := os.Getenv(consts.USAGE_LEDGER_ENV)
+	if fileName == "" {
+		return consts.USAGE_LEDGER_FILE
+	}
+	return fileName
+}
+
+// NewLedger loads the ledger from the file, a missing file is an empty ledger
+func NewLedger(fileName string, budgets *Budgets) (*Ledger, error) {
+	ledger := &Ledger{fileName: fileName, budgets: budgets, days: make(map[string]*types.UsageReport)}
+	err := ledger.load()
+	if err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// load reads the days of the file, which the other processes of the deployment record
+// their turns in as well, called with mtx held or before the ledger is shared
+func (this *Ledger) load() error {
+	data, err := os.ReadFile(this.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := &types.UsageReportList{}
+	err = proto.Unmarshal(data, list)
+	if err != nil {
+		return fmt.Errorf("usage ledger %s is corrupt: %w", this.fileName, err)
+	}
+	days := make(map[string]*types.UsageReport, len(list.List))
+	for _, day := range list.List {
+		days[dayKey(day.User, day.Period)] = day
+	}
+	this.days = days
+	return nil
+}
+
+func dayKey(user, day string) string {
+	return user + "/" + day
+}
+
+// Check returns a BudgetExceededError if the user spent the daily or monthly budget
+func (this *Ledger) Check(user string) error {
+	budget := this.budgets.Of(user)
+	if budget.Daily == 0 && budget.Monthly == 0 {
+		return nil
+	}
+	now := time.Now()
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.load()
+	if err != nil {
+		return err
+	}
+	if budget.Daily > 0 {
+		day, ok := this.days[dayKey(user, now.Format(dayLayout))]
+		if ok && day.Cost >= budget.Daily {
+			return &BudgetExceededError{User: user, Scope: SCOPE_DAILY, Cost: day.Cost, Budget: budget.Daily}
+		}
+	}
+	if budget.Monthly > 0 {
+		month := this.month(user, now.Format(monthLayout))
+		if month.Cost >= budget.Monthly {
+			return &BudgetExceededError{User: user, Scope: SCOPE_MONTHLY, Cost: month.Cost, Budget: budget.Monthly}
+		}
+	}
+	return nil
+}
+
+// Record adds the usage of a turn of the user to the day of the turn and saves the ledger.
+// The ledger is locked and read again first, so the turns other processes recorded since
+// it was read are kept.
+func (this *Ledger) Record(user string, turn *types.TurnUsage) error {
+	created := time.Unix(turn.Created, 0)
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	unlock, err := persist.LockFile(this.fileName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = this.load()
+	if err != nil {
+		return err
+	}
+	key := dayKey(user, created.Format(dayLayout))
+	day, ok := this.days[key]
+	if !ok {
+		day = &types.UsageReport{User: user, Period: created.Format(dayLayout), Scope: SCOPE_DAILY}
+		this.days[key] = day
+	}
+	day.Turns++
+	day.InputTokens += turn.InputTokens
+	day.OutputTokens += turn.OutputTokens
+	day.CacheCreationTokens += turn.CacheCreationTokens
+	day.CacheReadTokens += turn.CacheReadTokens
+	day.Cost += turn.Cost
+	this.prune(created)
+	return this.save()
+}
+
+// Reports returns the daily and the monthly reports of all the users, with their budgets
+func (this *Ledger) Reports() []*types.UsageReport {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.load()
+	if err != nil {
+		fmt.Println("Failed to read the usage ledger: ", err.Error())
+	}
+	reports := make([]*types.UsageReport, 0, len(this.days))
+	months := make(map[string]*types.UsageReport)
+	for _, day := range this.days {
+		report := proto.Clone(day).(*types.UsageReport)
+		report.Budget = this.budgets.Of(day.User).Daily
+		reports = append(reports, report)
+		date, _ := time.Parse(dayLayout, day.Period)
+		key := dayKey(day.User, date.Format(monthLayout))
+		if _, ok := months[key]; !ok {
+			months[key] = this.month(day.User, date.Format(monthLayout))
+		}
+	}
+	for _, month := range months {
+		reports = append(reports, month)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].User != reports[j].User {
+			return reports[i].User < reports[j].User
+		}
+		return reports[i].Period < reports[j].Period
+	})
+	return reports
+}
+
+// month sums the days of the user in the month, called with mtx held
+func (this *Ledger) month(user, month string) *types.UsageReport {
+	report := &types.UsageReport{User: user, Period: month, Scope: SCOPE_MONTHLY,
+		Budget: this.budgets.Of(user).Monthly}
+	for _, day := range this.days {
+		if day.User != user || len(day.Period) < len(month) || day.Period[:len(month)] != month {
+			continue
+		}
+		report.Turns += day.Turns
+		report.InputTokens += day.InputTokens
+		report.OutputTokens += day.OutputTokens
+		report.CacheCreationTokens += day.CacheCreationTokens
+		report.CacheReadTokens += day.CacheReadTokens
+		report.Cost += day.Cost
+	}
+	return report
+}
+
+// prune drops the days older than the ledger retention, called with mtx held
+func (this *Ledger) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -consts.USAGE_LEDGER_RETENTION_DAYS).Format(dayLayout)
+	for key, day := range this.days {
+		if day.Period < oldest {
+			delete(this.days, key)
+		}
+	}
+}
+
+// save writes the ledger, called with mtx and the file lock held
+func (this *Ledger) save() error {
+	list := &types.UsageReportList{List: make([]*types.UsageReport, 0, len(this.days))}
+	for _, day := range this.days {
+		list.List = append(list.List, day)
+	}
+	data, err := proto.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return persist.WriteAtomic(this.fileName, data)
+}
@@ -0,0 +1,64 @@
+package usage
+
+import (
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// Price is the list price of a model in USD per million tokens
+type Price struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64
+	CacheRead  float64
+}
+
+// prices is matched by model prefix, the first match wins
+var prices = []struct {
+	prefix string
+	price  Price
+}{
+	{"claude-opus-4", Price{Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5}},
+	{"claude-sonnet-4", Price{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-3-7-sonnet", Price{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-3-5-sonnet", Price{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-3-5-haiku", Price{Input: 0.8, Output: 4, CacheWrite: 1, CacheRead: 0.08}},
+	{"claude-3-haiku", Price{Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheRead: 0.03}},
+}
+
+// defaultPrice is used for models missing from the table, so unknown models are
+// never free against a budget
+var defaultPrice = Price{Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5}
+
+// PriceOf returns the price of the model
+func PriceOf(model string) Price {
+	for _, entry := range prices {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.price
+		}
+	}
+	return defaultPrice
+}
+
+// EstimateCost returns the estimated cost in USD of a response of the model
+func EstimateCost(model string, usage *types.Usage) float64 {
+	if usage == nil {
+		return 0
+	}
+	price := PriceOf(model)
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheCreationInputTokens)*price.CacheWrite +
+		float64(usage.CacheReadInputTokens)*price.CacheRead) / 1000000
+}
+
+// EstimateSavings returns what prompt caching saved on a response of the model in USD,
+// the reads at the cache price instead of the input price less the premium of the writes
+func EstimateSavings(model string, usage *types.Usage) float64 {
+	if usage == nil {
+		return 0
+	}
+	price := PriceOf(model)
+	return (float64(usage.CacheReadInputTokens)*(price.Input-price.CacheRead) -
+		float64(usage.CacheCreationInputTokens)*(price.CacheWrite-price.Input)) / 1000000
+}
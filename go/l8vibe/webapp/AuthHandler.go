@@ -0,0 +1,123 @@
+package webapp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// AuthHandler logs the users of the web UI in and out.
+// GET reports whether authentication is enabled, whether new users may sign up and whether
+// they can sign in with an identity provider, POST {user, password, signup} returns
+// {token, user, expires, roles}, DELETE revokes the bearer token.
+type AuthHandler struct {
+	authenticator *auth.Authenticator
+	signup        bool
+	sso           bool
+}
+
+type authRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Signup   bool   `json:"signup"`
+}
+
+type authResponse struct {
+	Token   string   `json:"token,omitempty"`
+	User    string   `json:"user,omitempty"`
+	Expires int64    `json:"expires,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Enabled bool     `json:"enabled"`
+	Signup  bool     `json:"signup"`
+	SSO     bool     `json:"sso"`
+}
+
+func (this *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, &authResponse{Enabled: this.authenticator != nil, Signup: this.signup, SSO: this.sso})
+	case http.MethodPost:
+		if this.authenticator == nil {
+			http.Error(w, "authentication is disabled", http.StatusNotFound)
+			return
+		}
+		request := &authRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Signup && !this.signup {
+			http.Error(w, "sign up is disabled", http.StatusForbidden)
+			return
+		}
+		login := this.authenticator.Login
+		if request.Signup {
+			login = this.authenticator.Signup
+		}
+		token, expires, err := login(request.User, request.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		user := auth.NormalizeUser(request.User)
+		writeJSON(w, &authResponse{Token: token, User: user, Expires: expires.Unix(),
+			Roles: this.authenticator.Accounts().Roles(user), Enabled: true, Signup: this.signup, SSO: this.sso})
+	case http.MethodDelete:
+		if this.authenticator != nil {
+			err := this.authenticator.Logout(auth.BearerToken(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorize binds a request to the user of its bearer token, the handlers of the web UI
+// served next to the web services are not behind the authentication of the web server.
+// It returns the authenticated user, empty when authentication is disabled, or writes 401
+// or 403 and returns false if the user may not act on the project with the role.
+func authorize(w http.ResponseWriter, r *http.Request, authenticator *auth.Authenticator,
+	projects *service.ProjectService, user *string, name string, required types.ProjectRole) (string, bool) {
+	identity := ""
+	if authenticator != nil {
+		token := auth.BearerToken(r)
+		if token != "" {
+			var err error
+			identity, err = authenticator.Validate(token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return "", false
+			}
+		}
+	}
+	return identity, access(w, projects, identity, user, name, required)
+}
+
+// access checks the role of the identity in the project and writes the error of a denial
+func access(w http.ResponseWriter, projects *service.ProjectService, identity string, user *string, name string,
+	required types.ProjectRole) bool {
+	err := projects.Access(identity, user, name, required)
+	if err == service.ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(value)
+}
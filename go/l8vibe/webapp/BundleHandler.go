@@ -0,0 +1,69 @@
+package webapp
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/gitrepo"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// BundleHandler exports the git repository of a project as a git bundle.
+// GET ?user=&name= downloads {name}.bundle, which can be cloned with git clone,
+// of a project of the user of the bearer token. Every export is audited.
+type BundleHandler struct {
+	projects      *service.ProjectService
+	authenticator *auth.Authenticator
+}
+
+// NewBundleHandler returns the handler of the bundles of the projects, a nil authenticator
+// serves the requests unauthenticated
+func NewBundleHandler(projects *service.ProjectService, authenticator *auth.Authenticator) *BundleHandler {
+	return &BundleHandler{projects: projects, authenticator: authenticator}
+}
+
+func (this *BundleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	project := &types.Project{User: query.Get("user"), Name: query.Get("name")}
+	record := &types.AuditRecord{Operation: audit.OP_EXPORT}
+	defer func() {
+		record.User, record.Name = project.User, project.Name
+		this.projects.Audit(record)
+	}()
+	identity, ok := authorize(w, r, this.authenticator, this.projects, &project.User, project.Name,
+		types.ProjectRole_ROLE_VIEWER)
+	record.Actor = identity
+	if !ok {
+		record.Outcome = types.AuditOutcome_AUDIT_DENIED
+		return
+	}
+	repo, err := gitrepo.ReadProjectRepo(project)
+	if err == gitrepo.ErrNoHistory {
+		record.Outcome, record.Error = types.AuditOutcome_AUDIT_FAILED, err.Error()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		record.Outcome, record.Error = types.AuditOutcome_AUDIT_FAILED, err.Error()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// bundled in memory first so a failure can still be reported with a status
+	bundle := &bytes.Buffer{}
+	err = repo.Bundle(bundle)
+	if err != nil {
+		record.Outcome, record.Error = types.AuditOutcome_AUDIT_FAILED, err.Error()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+project.Name+".bundle\"")
+	w.Write(bundle.Bytes())
+}
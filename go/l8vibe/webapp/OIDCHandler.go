@@ -0,0 +1,90 @@
+package webapp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// OIDCHandler logs the enterprise users in with their identity provider.
+// GET login redirects the browser to the provider, GET callback completes the login and
+// returns the browser to the web UI with the bearer token in the fragment of the url,
+// the same token a local login returns.
+type OIDCHandler struct {
+	relyingParty  *auth.RelyingParty
+	authenticator *auth.Authenticator
+	afterLogin    string
+}
+
+// Login starts a login at the provider. The state is also kept in a cookie of the
+// browser, so a callback of a login started in another browser is rejected.
+func (this *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	location, state, err := this.relyingParty.AuthCodeURL()
+	if err != nil {
+		fmt.Println("Failed to start the login:", err)
+		http.Error(w, "identity provider is not available", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: consts.OIDC_STATE_COOKIE, Value: state,
+		Path: consts.WEBSITE_PREFIX + consts.OIDC_CALLBACK_PATH, MaxAge: int(consts.OIDC_LOGIN_TTL.Seconds()),
+		HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// Callback completes the login with the authorization code of the provider
+func (this *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: consts.OIDC_STATE_COOKIE, Path: consts.WEBSITE_PREFIX + consts.OIDC_CALLBACK_PATH,
+		MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	if query.Get("error") != "" {
+		this.fail(w, r, query.Get("error")+" "+query.Get("error_description"))
+		return
+	}
+	cookie, err := r.Cookie(consts.OIDC_STATE_COOKIE)
+	if err != nil || cookie.Value != query.Get("state") {
+		this.fail(w, r, "login was started in another browser")
+		return
+	}
+	identity, err := this.relyingParty.Exchange(query.Get("code"), query.Get("state"))
+	if err != nil {
+		this.fail(w, r, err.Error())
+		return
+	}
+	token, expires, err := this.authenticator.Federate(identity)
+	if err != nil {
+		this.fail(w, r, err.Error())
+		return
+	}
+	fmt.Println("Logged in", identity.User, "with", identity.Issuer)
+	fragment := url.Values{}
+	fragment.Set("sso_token", token)
+	fragment.Set("sso_user", identity.User)
+	fragment.Set("sso_expires", strconv.FormatInt(expires.Unix(), 10))
+	this.redirect(w, r, fragment)
+}
+
+// fail returns the browser to the web UI with the reason the login failed
+func (this *OIDCHandler) fail(w http.ResponseWriter, r *http.Request, reason string) {
+	fmt.Println("Failed to log in with the identity provider:", reason)
+	fragment := url.Values{}
+	fragment.Set("sso_error", "Sign in with your identity provider failed")
+	this.redirect(w, r, fragment)
+}
+
+func (this *OIDCHandler) redirect(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, this.afterLogin+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,123 @@
+package webapp
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// PreviewHandler serves the workspace files of a project to the users it is shared with.
+// GET {user}/{name}/{path} serves a file, index.html of a directory.
+// POST {user}/{name}/ opens the preview of the user of the bearer token and sets a cookie
+// of the preview path of the project, as the pages and their assets load in a frame that
+// cannot send the bearer token itself. The cookie holds a short lived token valid only for
+// the preview of that project. The pages of a preview are the code of its users and share
+// the origin of the web UI, so they are sandboxed into an origin of their own.
+type PreviewHandler struct {
+	projects      *service.ProjectService
+	authenticator *auth.Authenticator
+}
+
+// NewPreviewHandler returns the handler of the previews of the projects, a nil authenticator
+// serves the requests unauthenticated
+func NewPreviewHandler(projects *service.ProjectService, authenticator *auth.Authenticator) *PreviewHandler {
+	return &PreviewHandler{projects: projects, authenticator: authenticator}
+}
+
+func (this *PreviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := consts.WEBSITE_PREFIX + consts.PREVIEW_PATH
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, base), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	project := &types.Project{User: parts[0], Name: parts[1]}
+	file := ""
+	if len(parts) == 3 {
+		file = parts[2]
+	}
+
+	// a preview reaches neither the storage nor the cookies of the web UI
+	w.Header().Set("Content-Security-Policy", "sandbox allow-scripts")
+	path := base + parts[0] + "/" + parts[1] + "/"
+	switch r.Method {
+	case http.MethodPost:
+		if _, ok := authorize(w, r, this.authenticator, this.projects, &project.User, project.Name,
+			types.ProjectRole_ROLE_VIEWER); !ok {
+			return
+		}
+		if this.authenticator != nil {
+			token, expires, err := this.authenticator.Scoped(auth.BearerToken(r), path, consts.PREVIEW_TOKEN_TTL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: consts.PREVIEW_COOKIE, Value: token, Path: path,
+				Expires: expires, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		if !this.authorize(w, r, project, path) {
+			return
+		}
+		this.serveFile(w, r, project, file)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorize checks the viewer role of the user of the bearer token, or else of the preview
+// cookie of the path
+func (this *PreviewHandler) authorize(w http.ResponseWriter, r *http.Request, project *types.Project, path string) bool {
+	if this.authenticator == nil || auth.BearerToken(r) != "" {
+		_, ok := authorize(w, r, this.authenticator, this.projects, &project.User, project.Name,
+			types.ProjectRole_ROLE_VIEWER)
+		return ok
+	}
+	identity := ""
+	if cookie, err := r.Cookie(consts.PREVIEW_COOKIE); err == nil {
+		identity, err = this.authenticator.ValidateScoped(cookie.Value, path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return false
+		}
+	}
+	return access(w, this.projects, identity, &project.User, project.Name, types.ProjectRole_ROLE_VIEWER)
+}
+
+// serveFile serves the workspace file at the path, which is confined to the workspace
+func (this *PreviewHandler) serveFile(w http.ResponseWriter, r *http.Request, project *types.Project, path string) {
+	if path == "" || strings.HasSuffix(path, "/") {
+		path += "index.html"
+	}
+	ws, err := anthropic.NewWorkspace(project)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	full, err := ws.Resolve("preview", path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, err := os.Open(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	// a preview reflects the access of the moment, it is not kept by the browser
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,122 @@
+package webapp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/usage"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// StreamHandler serves the streaming variant of the project PATCH as server-sent events.
+// POST with the same body as PATCH /l8vibe/0/proj starts a generation,
+// GET ?user=&name=&offset= resumes rendering an active or last generation from offset.
+// Both act as the user of the bearer token.
+type StreamHandler struct {
+	projects      *service.ProjectService
+	authenticator *auth.Authenticator
+}
+
+// NewStreamHandler returns the handler of the streams of the projects, a nil authenticator
+// serves the requests unauthenticated
+func NewStreamHandler(projects *service.ProjectService, authenticator *auth.Authenticator) *StreamHandler {
+	return &StreamHandler{projects: projects, authenticator: authenticator}
+}
+
+type streamDelta struct {
+	Offset int    `json:"offset"`
+	Text   string `json:"text"`
+}
+
+func (this *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var stream *service.ProjectStream
+	var err error
+	offset := 0
+
+	switch r.Method {
+	case http.MethodPost:
+		data, er := io.ReadAll(r.Body)
+		if er != nil {
+			http.Error(w, er.Error(), http.StatusBadRequest)
+			return
+		}
+		project := &types.Project{}
+		er = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, project)
+		if er != nil {
+			http.Error(w, er.Error(), http.StatusBadRequest)
+			return
+		}
+		identity, ok := authorize(w, r, this.authenticator, this.projects, &project.User, project.Name,
+			types.ProjectRole_ROLE_EDITOR)
+		if !ok {
+			return
+		}
+		stream, err = this.projects.PatchStream(project, identity)
+	case http.MethodGet:
+		query := r.URL.Query()
+		offset, _ = strconv.Atoi(query.Get("offset"))
+		user := query.Get("user")
+		if _, ok := authorize(w, r, this.authenticator, this.projects, &user, query.Get("name"),
+			types.ProjectRole_ROLE_VIEWER); !ok {
+			return
+		}
+		stream, err = this.projects.Stream(user, query.Get("name"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, overBudget := err.(*usage.BudgetExceededError); overBudget {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		text, done, result, er := stream.Next(offset)
+		if text != "" {
+			offset += len(text)
+			delta, _ := json.Marshal(&streamDelta{Offset: offset, Text: text})
+			if writeEvent(w, "delta", delta) != nil {
+				return
+			}
+		}
+		if done {
+			if er != nil {
+				msg, _ := json.Marshal(map[string]string{"error": er.Error()})
+				writeEvent(w, "error", msg)
+			} else {
+				if result == nil {
+					result = &types.Project{}
+				}
+				data, _ := protojson.Marshal(result)
+				writeEvent(w, "done", data)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event string, data []byte) error {
+	_, err := w.Write([]byte("event: " + event + "\ndata: " + string(data) + "\n\n"))
+	return err
+}
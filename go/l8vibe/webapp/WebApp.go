@@ -0,0 +1,149 @@
+package webapp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8types/go/types/l8health"
+	"github.com/saichler/l8types/go/types/l8web"
+	"github.com/saichler/l8web/go/web/server"
+	"github.com/saichler/layer8/go/overlay/health"
+	"github.com/saichler/layer8/go/overlay/protocol"
+	"github.com/saichler/layer8/go/overlay/vnic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	types2 "github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// Config is where the web server listens and the certificate it serves
+type Config struct {
+	Host     string
+	Port     int
+	CertName string
+}
+
+// DefaultConfig returns the configuration of the deployed web server
+func DefaultConfig() *Config {
+	return &Config{Host: protocol.MachineIP, Port: consts.WEBSITE_PORT, CertName: consts.WEBSITE_CERT}
+}
+
+// NewWebServer connects to the vnet, activates the services behind the web UI and
+// returns the web server ready to be started. Unless authentication is disabled in the
+// service configuration, the requests are authenticated by the bearer tokens of the
+// local accounts.
+func NewWebServer(resources ifs.IResources, config *Config) (*server.RestServer, error) {
+	serviceConfig, err := common.LoadServiceConfig()
+	if err != nil {
+		return nil, err
+	}
+	var authenticator *auth.Authenticator
+	if !serviceConfig.Auth.Disabled {
+		authenticator, err = auth.OpenAuthenticator(serviceConfig.Auth.TTL())
+		if err != nil {
+			return nil, err
+		}
+		resources.Set(auth.NewSecurityProvider(resources.Security(), authenticator))
+	} else {
+		fmt.Println("Authentication is disabled, requests act as the user they name")
+	}
+
+	serverConfig := &server.RestServerConfig{
+		Host:           config.Host,
+		Port:           config.Port,
+		Authentication: authenticator != nil,
+		CertName:       config.CertName,
+		Prefix:         consts.WEBSITE_PREFIX,
+	}
+
+	svr, err := server.NewRestServer(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	nic := vnic.NewVirtualNetworkInterface(resources, nil)
+	nic.Resources().SysConfig().KeepAliveIntervalSeconds = 60
+	nic.Start()
+	nic.WaitForConnection()
+
+	RegisterTypes(resources)
+
+	hs, ok := nic.Resources().Services().ServiceHandler(health.ServiceName, 0)
+	if ok {
+		ws := hs.WebService()
+		svr.RegisterWebService(ws, nic)
+	}
+
+	//Activate the webpoints service
+	nic.Resources().Services().RegisterServiceHandlerType(&server.WebService{})
+	_, err = nic.Resources().Services().Activate(server.ServiceTypeName, ifs.WebService,
+		0, nic.Resources(), nic, svr)
+
+	projects, err := service.ActivateServices(resources, nic)
+	if err != nil {
+		return nil, err
+	}
+
+	//Streaming variant of the project patch, served next to the proj web service
+	http.Handle(consts.WEBSITE_PREFIX+consts.STREAM_PATH, NewStreamHandler(projects, authenticator))
+	http.Handle(consts.WEBSITE_PREFIX+consts.BUNDLE_PATH, NewBundleHandler(projects, authenticator))
+	http.Handle(consts.WEBSITE_PREFIX+consts.PREVIEW_PATH, NewPreviewHandler(projects, authenticator))
+	oidc := serviceConfig.Auth.OIDC
+	if authenticator != nil && oidc != nil {
+		client, er := anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: oidc.Issuer, CAFile: oidc.CAFile, Proxy: oidc.Proxy})
+		if er != nil {
+			return nil, er
+		}
+		client.Timeout = 30 * time.Second
+		afterLogin := oidc.AfterLogin
+		if afterLogin == "" {
+			afterLogin = "/"
+		}
+		handler := &OIDCHandler{relyingParty: auth.NewRelyingParty(oidc, client), authenticator: authenticator,
+			afterLogin: afterLogin}
+		http.HandleFunc(consts.WEBSITE_PREFIX+consts.OIDC_LOGIN_PATH, handler.Login)
+		http.HandleFunc(consts.WEBSITE_PREFIX+consts.OIDC_CALLBACK_PATH, handler.Callback)
+	}
+	http.Handle(consts.WEBSITE_PREFIX+consts.AUTH_PATH, &AuthHandler{authenticator: authenticator,
+		signup: serviceConfig.Auth.Signup, sso: authenticator != nil && oidc != nil})
+
+	nic.Resources().Logger().Info("Web Server Started!")
+	resources.Logger().SetLogLevel(ifs.Error_Level)
+
+	common.WebServer = svr.(*server.RestServer)
+	server.Timeout = 600
+	server.Method = ifs.M_Proximity
+	return common.WebServer, nil
+}
+
+// RegisterTypes registers the types the web server serializes
+func RegisterTypes(resources ifs.IResources) {
+	resources.Registry().Register(&l8api.L8Query{})
+	resources.Registry().Register(&l8health.L8Top{})
+	resources.Registry().Register(&l8web.L8Empty{})
+	resources.Registry().Register(&types2.Project{})
+	resources.Registry().Register(&types2.ProjectList{})
+	resources.Registry().Register(&types2.ProjectSnapshot{})
+	resources.Registry().Register(&types2.ProjectSnapshotList{})
+	resources.Registry().Register(&types2.SnapshotDiff{})
+	resources.Registry().Register(&types2.ProjectCommit{})
+	resources.Registry().Register(&types2.ProjectCommitList{})
+	resources.Registry().Register(&types2.CommitDiff{})
+	resources.Registry().Register(&types2.GenerationJob{})
+	resources.Registry().Register(&types2.GenerationJobList{})
+	resources.Registry().Register(&types2.GenerationSettings{})
+	resources.Registry().Register(&types2.PromptTemplate{})
+	resources.Registry().Register(&types2.PromptTemplateList{})
+	resources.Registry().Register(&types2.ProviderSecret{})
+	resources.Registry().Register(&types2.ProviderSecretList{})
+	resources.Registry().Register(&types2.ProjectShare{})
+	resources.Registry().Register(&types2.ProjectShareList{})
+	resources.Registry().Register(&types2.AuditRecord{})
+	resources.Registry().Register(&types2.AuditRecordList{})
+	resources.Introspector().Inspect(&types2.Project{})
+}
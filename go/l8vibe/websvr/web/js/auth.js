@@ -3,12 +3,15 @@ class AuthManager {
     constructor() {
         this.currentUser = null;
         this.isAuthenticated = false;
+        // The bearer token is only kept in memory, a reload signs the user out
+        this.token = null;
     }
 
     // Initialize authentication event listeners
     init() {
         this.bindLoginEvents();
         this.bindLogoutEvents();
+        this.showSSOLogin();
         // Don't check stored auth during init - defer to app initialization
     }
 
@@ -77,36 +80,8 @@ class AuthManager {
         }
 
         try {
-            // Simulate authentication (replace with actual API call)
-            await this.authenticateUser(email, password);
-            
-            // Store authentication
-            this.currentUser = {
-                email: email,
-                name: this.extractNameFromEmail(email),
-                loginTime: new Date().toISOString()
-            };
-            this.isAuthenticated = true;
-            this.storeAuth();
-
-            // Enable Create Project button
-            this.enableCreateProjectButton();
-            
-            // Enable Projects menu
-            this.enableProjectsMenu();
-            
-            // Load user projects immediately after successful login
-            this.loadUserProjectsAfterLogin();
-            
-            // Close login modal
-            this.closeLoginModal();
-            
-            // Update Sign In button to Sign Out
-            this.updateSignInButton();
-            
-            // Show success message but don't navigate anywhere
-            this.showSuccess('Welcome back! You can now create projects.');
-
+            const session = await this.authenticateUser(email, password);
+            this.completeLogin(session.user.email);
         } catch (error) {
             this.showError('Authentication failed. Please check your credentials.');
             console.error('Login error:', error);
@@ -120,9 +95,84 @@ class AuthManager {
         }
     }
 
+    // Sign the user in once the server authenticated it
+    completeLogin(email) {
+        // Store authentication
+        this.currentUser = {
+            email: email,
+            name: this.extractNameFromEmail(email),
+            loginTime: new Date().toISOString()
+        };
+        this.isAuthenticated = true;
+        this.storeAuth();
+
+        // Enable Create Project button
+        this.enableCreateProjectButton();
+
+        // Enable Projects menu
+        this.enableProjectsMenu();
+
+        // Load user projects immediately after successful login
+        this.loadUserProjectsAfterLogin();
+
+        // Close login modal
+        this.closeLoginModal();
+
+        // Update Sign In button to Sign Out
+        this.updateSignInButton();
+
+        // Show success message but don't navigate anywhere
+        this.showSuccess('Welcome back! You can now create projects.');
+    }
+
+    // Show the SSO button if the server has an identity provider
+    async showSSOLogin() {
+        const ssoBtn = document.getElementById('ssoLoginBtn');
+        if (!ssoBtn || window.location.protocol === 'file:') {
+            return;
+        }
+        try {
+            const response = await fetch('/l8vibe/0/auth', { method: 'GET' });
+            const info = response.ok ? await response.json() : {};
+            if (info.sso) {
+                ssoBtn.style.display = '';
+                ssoBtn.addEventListener('click', (e) => {
+                    e.preventDefault();
+                    window.location.href = '/l8vibe/0/oidc/login';
+                });
+            }
+        } catch (error) {
+            console.warn('Could not check the sign in options:', error);
+        }
+    }
+
+    // Complete a login with the identity provider, the server returns the token in the
+    // fragment of the url so it never reaches a server log
+    completeSSOLogin() {
+        const params = new URLSearchParams(window.location.hash.substring(1));
+        if (!params.has('sso_token') && !params.has('sso_error')) {
+            return false;
+        }
+        history.replaceState(null, '', window.location.pathname + window.location.search);
+        if (params.has('sso_error')) {
+            this.showError(params.get('sso_error'));
+            return false;
+        }
+        this.token = params.get('sso_token');
+        this.completeLogin(params.get('sso_user'));
+        return true;
+    }
+
     // Handle logout process
     handleLogout() {
+        // Revoke the token on the server, the user is signed out locally either way
+        if (this.token) {
+            fetch('/l8vibe/0/auth', { method: 'DELETE', headers: this.headers() })
+                .catch(error => console.warn('Logout request failed:', error));
+        }
+
         // Clear authentication
+        this.token = null;
         this.currentUser = null;
         this.isAuthenticated = false;
         this.clearStoredAuth();
@@ -144,18 +194,59 @@ class AuthManager {
         this.showSuccess('You have been signed out');
     }
 
-    // Simulate API authentication
+    // Log in with the local account of the user and keep the bearer token
     async authenticateUser(email, password) {
-        // Simulate network delay
-        await new Promise(resolve => setTimeout(resolve, 1500));
-        
-        // For demo purposes, accept any email/password combination
-        // In production, this would be a real API call
-        if (email && password) {
+        const response = await fetch('/l8vibe/0/auth', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ user: email, password: password })
+        });
+
+        // Authentication is disabled on this deployment, requests act as the user they name
+        if (response.status === 404) {
+            this.token = null;
             return { success: true, user: { email } };
-        } else {
-            throw new Error('Invalid credentials');
         }
+        if (!response.ok) {
+            throw new Error(`Login failed: ${response.status}`);
+        }
+        const session = await response.json();
+        this.token = session.token;
+        return { success: true, user: { email: session.user } };
+    }
+
+    // Headers of an API request, with the bearer token of the signed in user
+    headers(extra = {}) {
+        const headers = { ...extra };
+        if (this.token) {
+            headers['Authorization'] = `Bearer ${this.token}`;
+        }
+        return headers;
+    }
+
+    // Query of the projects of the signed in user. With a bearer token the server only
+    // returns the projects the user owns or that are shared with the user.
+    projectsQuery(email) {
+        if (this.token) {
+            return { text: 'select * from project', rootType: "project", properties: ["*"] };
+        }
+        return {
+            text: `select * from project where user=${email}`,
+            rootType: "project",
+            properties: ["*"],
+            criteria: {
+                condition: {
+                    comparator: {
+                        left: "user",
+                        oper: "=",
+                        right: email
+                    }
+                }
+            },
+            matchCase: true
+        };
     }
 
     // Validate email format
@@ -204,6 +295,9 @@ class AuthManager {
         
         // Always show marketing screen (signed-out state)
         app.showScreen('marketingScreen');
+
+        // Unless the identity provider just signed the user in
+        this.completeSSOLogin();
         console.log('checkStoredAuth complete - always signed out');
     }
 
@@ -70,11 +70,19 @@ class ChatManager {
         if (chatInput) chatInput.disabled = true;
 
         try {
-            // Send message to API via project PATCH
-            const response = await this.sendToProjectAPI(userMessage);
+            // Stream the message to the project API, rendering partial text as it arrives
+            let streamingElement = null;
+            const response = await this.streamToProjectAPI(userMessage, (text) => {
+                if (!streamingElement) {
+                    this.removeTypingIndicator(typingId);
+                    streamingElement = this.createStreamingMessage();
+                }
+                this.updateStreamingMessage(streamingElement, this.stripCodeBlocks(text));
+            });
             
-            // Remove typing indicator
+            // Remove typing indicator and the partial rendering, the final message replaces it
             this.removeTypingIndicator(typingId);
+            if (streamingElement) streamingElement.remove();
             
             // Add AI response from the project response
             console.log('PATCH Response:', response);
@@ -96,6 +104,11 @@ class ChatManager {
                 }
             }
             
+            // Later patches are made from the revision this turn produced
+            if (project && project.revision) {
+                this.currentProject.revision = project.revision;
+            }
+
             console.log('Extracted project:', project);
             console.log('Project messages:', project?.messages);
             
@@ -126,7 +139,11 @@ class ChatManager {
             this.removeTypingIndicator(typingId);
             
             // Show error message
-            this.addMessage("I apologize, but I'm having trouble connecting right now. Please try again in a moment.", 'ai');
+            if (error.status === 409) {
+                this.addMessage("This project was changed in another window. Please reload it before sending another message.", 'ai');
+            } else {
+                this.addMessage("I apologize, but I'm having trouble connecting right now. Please try again in a moment.", 'ai');
+            }
             console.error('Chat API error:', error);
         } finally {
             // Re-enable input
@@ -147,7 +164,7 @@ class ChatManager {
             name: this.currentProject.name,
             description: this.currentProject.description,
             user: this.currentProject.user,
-            apiKey: this.currentProject.apiKey
+            revision: this.currentProject.revision
             // Intentionally omitting messages attribute
         };
 
@@ -161,17 +178,175 @@ class ChatManager {
         // Send PATCH request to /l8vibe/0/proj endpoint
         const response = await fetch('/l8vibe/0/proj', {
             method: 'PATCH',
-            headers: {
+            headers: window.auth.headers({
                 'Content-Type': 'application/json',
-            },
+            }),
             body: JSON.stringify(projectClone)
         });
 
         if (!response.ok) {
-            throw new Error(`API request failed: ${response.status}`);
+            const error = new Error(`API request failed: ${response.status}`);
+            error.status = response.status;
+            throw error;
         }
 
-        return await response.json();
+        // The turn runs as a generation job, poll it until it is done
+        const accepted = await response.json();
+        const job = await this.waitForJob(accepted.jobId);
+        if (job.state !== 'JOB_SUCCEEDED') {
+            throw new Error(job.error || `Generation ${job.state}`);
+        }
+        return job.result;
+    }
+
+    // Poll the generation job until it succeeded, failed or was cancelled
+    async waitForJob(jobId) {
+        const requestBody = {
+            text: `select * from generationjob where id=${jobId}`,
+            rootType: "generationjob",
+            properties: ["*"],
+            criteria: {
+                condition: {
+                    comparator: {
+                        left: "id",
+                        oper: "=",
+                        right: jobId
+                    }
+                }
+            },
+            matchCase: true
+        };
+        const url = new URL('/l8vibe/0/job', window.location.origin);
+        url.searchParams.append('body', JSON.stringify(requestBody));
+        while (true) {
+            const response = await fetch(url, { method: 'GET', headers: window.auth.headers() });
+            if (!response.ok) {
+                throw new Error(`Job request failed: ${response.status}`);
+            }
+            const data = await response.json();
+            const job = (data.list && data.list[0]) || data;
+            if (['JOB_SUCCEEDED', 'JOB_FAILED', 'JOB_CANCELLED'].includes(job.state)) {
+                return job;
+            }
+            await new Promise(resolve => setTimeout(resolve, 2000));
+        }
+    }
+
+    // Send message to L8Vibe Project API via the streaming endpoint.
+    // onText is called with the accumulated assistant text on every delta, if the
+    // connection drops the stream is resumed from the last received offset.
+    async streamToProjectAPI(message, onText) {
+        if (!this.currentProject) {
+            throw new Error('No current project available');
+        }
+
+        const projectClone = {
+            name: this.currentProject.name,
+            description: this.currentProject.description,
+            user: this.currentProject.user,
+            revision: this.currentProject.revision,
+            messages: [{ role: 'user', content: message }]
+        };
+
+        let response = await fetch('/l8vibe/0/stream', {
+            method: 'POST',
+            headers: window.auth.headers({
+                'Content-Type': 'application/json',
+            }),
+            body: JSON.stringify(projectClone)
+        });
+
+        let offset = 0;
+        let text = '';
+        for (let attempt = 0; attempt < 5; attempt++) {
+            if (!response.ok) {
+                const error = new Error(`API request failed: ${response.status}`);
+                error.status = response.status;
+                throw error;
+            }
+            try {
+                const result = await this.readEventStream(response, (delta) => {
+                    offset = delta.offset;
+                    text += delta.text;
+                    onText(text);
+                });
+                if (result) {
+                    return result;
+                }
+            } catch (error) {
+                if (error.fatal) {
+                    throw error;
+                }
+                console.warn('Stream interrupted, resuming from offset', offset, error);
+            }
+
+            const url = new URL('/l8vibe/0/stream', window.location.origin);
+            url.searchParams.set('user', projectClone.user);
+            url.searchParams.set('name', projectClone.name);
+            url.searchParams.set('offset', offset);
+            response = await fetch(url, { method: 'GET', headers: window.auth.headers() });
+        }
+        throw new Error('Stream could not be resumed');
+    }
+
+    // Read server-sent events until the done event, returns null if the stream ended early
+    async readEventStream(response, onDelta) {
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        while (true) {
+            const { value, done } = await reader.read();
+            if (done) {
+                return null;
+            }
+            buffer += decoder.decode(value, { stream: true });
+            let index;
+            while ((index = buffer.indexOf('\n\n')) >= 0) {
+                const raw = buffer.slice(0, index);
+                buffer = buffer.slice(index + 2);
+                let event = 'message';
+                let data = '';
+                raw.split('\n').forEach(line => {
+                    if (line.startsWith('event:')) {
+                        event = line.slice(6).trim();
+                    } else if (line.startsWith('data:')) {
+                        data += line.slice(5).trim();
+                    }
+                });
+                if (event === 'delta') {
+                    onDelta(JSON.parse(data));
+                } else if (event === 'done') {
+                    return JSON.parse(data);
+                } else if (event === 'error') {
+                    const error = new Error(JSON.parse(data).error);
+                    error.fatal = true;
+                    throw error;
+                }
+            }
+        }
+    }
+
+    // Create the chat element partial assistant text is rendered into
+    createStreamingMessage() {
+        const messagesContainer = document.getElementById('chatMessages');
+        const messageElement = document.createElement('div');
+        messageElement.className = 'message ai-message';
+        const messageContent = document.createElement('div');
+        messageContent.className = 'message-content';
+        messageContent.appendChild(document.createElement('p'));
+        messageElement.appendChild(messageContent);
+        if (messagesContainer) {
+            messagesContainer.appendChild(messageElement);
+        }
+        return messageElement;
+    }
+
+    updateStreamingMessage(messageElement, content) {
+        const paragraph = messageElement.querySelector('p');
+        if (paragraph) {
+            paragraph.textContent = content;
+        }
+        this.scrollToBottom();
     }
 
     // Add message to chat display
@@ -298,6 +473,12 @@ class ChatManager {
         const codeBlockRegex = /```[\s\S]*?```/g;
         let strippedContent = content.replace(codeBlockRegex, '');
 
+        // Remove a code block that is still being streamed
+        const openBlock = strippedContent.indexOf('```');
+        if (openBlock >= 0) {
+            strippedContent = strippedContent.slice(0, openBlock);
+        }
+
         // Clean up extra whitespace and empty lines
         strippedContent = strippedContent
             .split('\n')
@@ -409,6 +590,10 @@ class ChatManager {
         
         // Process each message according to the specified logic
         messages.forEach(message => {
+            // Tool calls and tool results carry no text to show
+            if (!message.content) {
+                return;
+            }
             if (message.role === 'user') {
                 // Add user message content directly to chat
                 this.addMessage(message.content, 'user');
@@ -476,7 +661,7 @@ class ChatManager {
         if (window.workspace && this.currentProject) {
             // Force refresh the preview by updating it with the current project path
             if (this.currentProject.user && this.currentProject.name) {
-                const dynamicPath = `./workspace/${this.currentProject.user}/${this.currentProject.name}/index.html`;
+                const dynamicPath = `/l8vibe/0/preview/${this.currentProject.user}/${this.currentProject.name}/index.html`;
                 workspace.updatePreviewWithPath(dynamicPath);
             }
         }
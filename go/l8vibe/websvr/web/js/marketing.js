@@ -521,21 +521,7 @@ class MarketingManager {
             dropdown.innerHTML = '<div class="project-item">Loading...</div>';
 
             // Prepare the request body as specified
-            const requestBody = {
-                text: `select * from project where user=${currentUser.email}`,
-                rootType: "project",
-                properties: ["*"],
-                criteria: {
-                    condition: {
-                        comparator: {
-                            left: "user",
-                            oper: "=",
-                            right: currentUser.email
-                        }
-                    }
-                },
-                matchCase: true
-            };
+            const requestBody = window.auth.projectsQuery(currentUser.email);
 
             // Make API call to fetch projects with body as URL parameter
             const url = new URL('/l8vibe/0/proj', window.location.origin);
@@ -543,9 +529,9 @@ class MarketingManager {
             
             const response = await fetch(url, {
                 method: 'GET',
-                headers: {
+                headers: window.auth.headers({
                     'Content-Type': 'application/json',
-                }
+                })
             });
 
             if (!response.ok) {
@@ -588,7 +574,8 @@ class MarketingManager {
         if (projectList && projectList.length > 0) {
             console.log(`Adding ${projectList.length} projects to dropdown`);
             projectList.forEach(project => {
-                html += `<a href="#" class="project-item" data-project-name="${project.name || ''}">${project.name || 'Unnamed Project'}</a>`;
+                const owner = project.user !== window.auth.getCurrentUser().email ? ` (${project.user})` : '';
+                html += `<a href="#" class="project-item" data-project-name="${project.name || ''}" data-project-user="${project.user || ''}">${project.name || 'Unnamed Project'}${owner}</a>`;
             });
             
             // Add separator
@@ -618,7 +605,7 @@ class MarketingManager {
             item.addEventListener('click', (e) => {
                 e.preventDefault();
                 const projectName = item.getAttribute('data-project-name');
-                this.openProject(projectName);
+                this.openProject(projectName, item.getAttribute('data-project-user'));
                 this.closeProjectsMenu();
             });
         });
@@ -639,11 +626,11 @@ class MarketingManager {
     }
 
     // Open a specific project
-    openProject(projectName) {
+    openProject(projectName, projectUser) {
         console.log('Opening project:', projectName);
         
-        // Find the project data by name
-        const project = this.userProjects.find(p => p.name === projectName);
+        // Find the project data by name and owner, shared projects may have the same name
+        const project = this.userProjects.find(p => p.name === projectName && (!projectUser || p.user === projectUser));
         if (!project) {
             console.error('Project not found:', projectName);
             return;
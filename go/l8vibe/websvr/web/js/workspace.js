@@ -135,9 +135,9 @@ class WorkspaceManager {
             // Make POST request to create project
             const response = await fetch('/l8vibe/0/proj', {
                 method: 'POST',
-                headers: {
+                headers: window.auth.headers({
                     'Content-Type': 'application/json',
-                },
+                }),
                 body: JSON.stringify(requestBody)
             });
 
@@ -250,7 +250,7 @@ class WorkspaceManager {
             
             // Build dynamic path to index.html and display in workspace preview
             if (this.currentProject.user && this.currentProject.name) {
-                const dynamicPath = `./workspace/${this.currentProject.user}/${this.currentProject.name}/index.html`;
+                const dynamicPath = `/l8vibe/0/preview/${this.currentProject.user}/${this.currentProject.name}/index.html`;
                 this.updatePreviewWithPath(dynamicPath);
             }
         }
@@ -317,7 +317,16 @@ class WorkspaceManager {
         
         if (previewFrame) {
             try {
-                // First, check if the file exists by making a HEAD request
+                // Open the preview with the bearer token first, the frame cannot send it itself
+                const directory = path.substring(0, path.lastIndexOf('/') + 1);
+                const opened = await fetch(directory, { method: 'POST', headers: window.auth.headers() });
+                if (!opened.ok) {
+                    console.log(`Preview returned ${opened.status}, showing empty state`);
+                    this.showEmptyState();
+                    return;
+                }
+
+                // Then check if the file exists by making a HEAD request
                 const response = await fetch(path, { method: 'HEAD' });
                 
                 if (!response.ok) {
@@ -563,30 +572,16 @@ class WorkspaceManager {
             dropdown.innerHTML = '<div class="projects-loading">Loading projects...</div>';
 
             // Use the same API call as marketing.js
-            const requestBody = {
-                text: `select * from project where user=${currentUser.email}`,
-                rootType: "project",
-                properties: ["*"],
-                criteria: {
-                    condition: {
-                        comparator: {
-                            left: "user",
-                            oper: "=",
-                            right: currentUser.email
-                        }
-                    }
-                },
-                matchCase: true
-            };
+            const requestBody = window.auth.projectsQuery(currentUser.email);
 
             const url = new URL('/l8vibe/0/proj', window.location.origin);
             url.searchParams.append('body', JSON.stringify(requestBody));
             
             const response = await fetch(url, {
                 method: 'GET',
-                headers: {
+                headers: window.auth.headers({
                     'Content-Type': 'application/json',
-                }
+                })
             });
 
             if (!response.ok) {
@@ -624,7 +619,7 @@ class WorkspaceManager {
                 <div class="workspace-project-item ${isCurrentProject ? 'current' : ''}" 
                      data-project-name="${project.name}" 
                      data-project-user="${project.user}">
-                    <div class="workspace-project-name">${project.name}</div>
+                    <div class="workspace-project-name">${project.name}${project.user !== window.auth.getCurrentUser().email ? ` (${project.user})` : ''}</div>
                     ${project.description ? `<div class="workspace-project-desc">${project.description}</div>` : ''}
                 </div>
             `;
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/webapp"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// sseServer answers every request with the raw event stream
+func sseServer(events string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(events))
+	}))
+}
+
+func streamOf(events string) (*types.ClaudeResponse, string, error) {
+	server := sseServer(events)
+	defer server.Close()
+	text := ""
+	resp, err := anthropic.NewAnthropicClientFor(server.URL).Stream(context.Background(),
+		&types.ClaudeRequest{Model: "test"}, "test-key", func(delta string) { text += delta })
+	return resp, text, err
+}
+
+func TestStreamParsesEvents(t *testing.T) {
+	// event names, comments, keep-alives and crlf line endings around the data lines
+	events := "event: message_start\r\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"test","usage":{"input_tokens":12}}}` + "\r\n\r\n" +
+		": keep-alive\n\n" +
+		"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Writing "}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"the page"}}` + "\n\n" +
+		`data: {"type":"content_block_stop","index":0}` + "\n\n" +
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"tool_1","name":"write_file","input":{}}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":\"index"}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":".html\"}"}}` + "\n\n" +
+		`data: {"type":"content_block_stop","index":1}` + "\n\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}` + "\n\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+	resp, text, err := streamOf(events)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	if text != "Writing the page" {
+		t.Fail()
+		fmt.Println("Unexpected deltas ", text)
+	}
+	if resp.Id != "msg_1" || resp.StopReason != "tool_use" || resp.Usage == nil ||
+		resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 7 {
+		t.Fail()
+		fmt.Println("Unexpected response ", resp)
+	}
+	if len(resp.Content) != 2 || resp.Content[0].Text != "Writing the page" || resp.Content[1].Name != "write_file" ||
+		resp.Content[1].Input != `{"path":"index.html"}` {
+		t.Fail()
+		fmt.Println("Unexpected content ", resp.Content)
+	}
+}
+
+func TestStreamErrorEvents(t *testing.T) {
+	start := `data: {"type":"message_start","message":{"id":"msg_1"}}` + "\n\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n"
+	delta := `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Part"}}` + "\n\n"
+	overloaded := "event: error\n" + `data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n"
+
+	// an error before any text can be retried
+	_, _, err := streamOf(start + overloaded)
+	apiErr, ok := err.(*anthropic.APIError)
+	if !ok || apiErr.Kind != anthropic.ERROR_OVERLOADED || apiErr.Partial {
+		t.Fail()
+		fmt.Println("Expected an overloaded error without partial text ", err)
+	}
+
+	// an error after text was delivered is partial
+	_, text, err := streamOf(start + delta + overloaded)
+	apiErr, ok = err.(*anthropic.APIError)
+	if !ok || apiErr.Kind != anthropic.ERROR_OVERLOADED || !apiErr.Partial || text != "Part" {
+		t.Fail()
+		fmt.Println("Expected a partial overloaded error ", err, text)
+	}
+
+	// a stream cut before message_stop is a network error
+	_, _, err = streamOf(start + delta)
+	apiErr, ok = err.(*anthropic.APIError)
+	if !ok || apiErr.Kind != anthropic.ERROR_NETWORK || !apiErr.Partial {
+		t.Fail()
+		fmt.Println("Expected a network error for the cut stream ", err)
+	}
+
+	// a payload that is not json fails the stream
+	_, _, err = streamOf(start + "data: {not json\n\n")
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected the broken payload to fail the stream")
+	}
+}
+
+func TestStreamResumeByOffset(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv(consts.PROJECT_STORE_PATH_ENV, dir)
+	defer os.Unsetenv(consts.PROJECT_STORE_PATH_ENV)
+	os.MkdirAll(filepath.Join(dir, "user@test.com"), 0755)
+	os.WriteFile(filepath.Join(dir, "user@test.com", "site.stream"), []byte("Hello world"), 0644)
+
+	server := httptest.NewServer(webapp.NewStreamHandler(&service.ProjectService{}, nil))
+	defer server.Close()
+	get := func(query string) (int, string) {
+		response, err := http.Get(server.URL + "?" + query)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+
+	// the last stream of the project is replayed from the offset the client rendered
+	status, body := get("user=user@test.com&name=site&offset=6")
+	if status != http.StatusOK || !strings.Contains(body, `"offset":11,"text":"world"`) ||
+		strings.Contains(body, "Hello") || !strings.Contains(body, "event: done") {
+		t.Fail()
+		fmt.Println("Unexpected resumed stream ", status, body)
+	}
+	// a client that rendered all of it only gets the end of the stream
+	status, body = get("user=user@test.com&name=site&offset=11")
+	if status != http.StatusOK || strings.Contains(body, "event: delta") || !strings.Contains(body, "event: done") {
+		t.Fail()
+		fmt.Println("Unexpected stream at its end ", status, body)
+	}
+	// an offset beyond the text is an error event
+	_, body = get("user=user@test.com&name=site&offset=50")
+	if !strings.Contains(body, "event: error") || !strings.Contains(body, "invalid stream offset") {
+		t.Fail()
+		fmt.Println("Expected an invalid offset error ", body)
+	}
+	// the user and the name cannot lead out of the data directory
+	status, _ = get("user=..&name=site")
+	if status != http.StatusConflict {
+		t.Fail()
+		fmt.Println("Expected the stream outside the data directory to be rejected ", status)
+	}
+}
@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/gitrepo"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/webapp"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func writeAuditLog(t *testing.T) string {
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	log, err := audit.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	for _, operation := range []string{audit.OP_POST, audit.OP_PATCH, audit.OP_TURN} {
+		_, err = log.Append(&types.AuditRecord{Actor: "alice@acme.com", Operation: operation, User: "alice@acme.com",
+			Name: "site", PromptHash: audit.PromptHash("Create a page"), Outcome: types.AuditOutcome_AUDIT_SUCCEEDED,
+			Usage: &types.TurnUsage{InputTokens: 100, OutputTokens: 20, Cost: 0.0012}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fileName
+}
+
+func TestAuditLogChain(t *testing.T) {
+	fileName := writeAuditLog(t)
+	log, err := audit.Open(fileName)
+	if err != nil {
+		t.Fail()
+		fmt.Println("Expected the log to verify ", err)
+		return
+	}
+	records := log.Records()
+	if len(records) != 3 || records[2].Seq != 3 || records[2].PrevHash != records[1].Hash || records[0].PrevHash != "" {
+		t.Fail()
+		fmt.Println("Unexpected chain ", records)
+	}
+	appended, err := log.Append(&types.AuditRecord{Actor: "bob@acme.com", Operation: audit.OP_DELETE})
+	if err != nil || appended.Seq != 4 || appended.PrevHash != records[2].Hash || log.Verify() != nil {
+		t.Fail()
+		fmt.Println("Expected the record to be chained to the last one ", err, appended)
+	}
+	log.Close()
+	data, _ := os.ReadFile(fileName)
+	if strings.Contains(string(data), "Create a page") {
+		t.Fail()
+		fmt.Println("Expected the log to keep the hash of the prompt only")
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	fileName := writeAuditLog(t)
+	data, _ := os.ReadFile(fileName)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	// a changed record
+	os.WriteFile(fileName, []byte(strings.Replace(string(data), "alice@acme.com", "mallory@acme.com", 1)), 0600)
+	_, err := audit.Open(fileName)
+	if chain, ok := err.(*audit.ChainError); !ok || chain.Seq != 1 {
+		t.Fail()
+		fmt.Println("Expected the changed record to be reported ", err)
+	}
+
+	// a removed record
+	os.WriteFile(fileName, []byte(lines[0]+"\n"+lines[2]+"\n"), 0600)
+	log, err := audit.Open(fileName)
+	if chain, ok := err.(*audit.ChainError); !ok || chain.Seq != 2 {
+		t.Fail()
+		fmt.Println("Expected the removed record to be reported ", err)
+	}
+
+	// the log stays writable and keeps reporting the break
+	if _, err = log.Append(&types.AuditRecord{Operation: audit.OP_PUT}); err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+	if _, ok := log.Verify().(*audit.ChainError); !ok {
+		t.Fail()
+		fmt.Println("Expected the break to be reported after an append")
+	}
+	log.Close()
+}
+
+func TestAuditLogSharedFile(t *testing.T) {
+	// the web server and the project node append to the same log
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	web, _ := audit.Open(fileName)
+	defer web.Close()
+	node, _ := audit.Open(fileName)
+	defer node.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			web.Append(&types.AuditRecord{Actor: "alice@acme.com", Operation: audit.OP_GET})
+		}()
+		go func() {
+			defer wg.Done()
+			node.Append(&types.AuditRecord{Actor: "bob@acme.com", Operation: audit.OP_TURN})
+		}()
+	}
+	wg.Wait()
+	if err := web.Verify(); err != nil {
+		t.Fail()
+		fmt.Println("Expected the records of both processes to chain ", err)
+	}
+	if len(web.Records()) != 20 || len(node.Records()) != 20 {
+		t.Fail()
+		fmt.Println("Expected each process to read the records of the other ", len(web.Records()), len(node.Records()))
+	}
+	reopened, err := audit.Open(fileName)
+	if err != nil || len(reopened.Records()) != 20 {
+		t.Fail()
+		fmt.Println("Expected the shared log to verify ", err)
+		return
+	}
+	reopened.Close()
+}
+
+func TestAuditProjectGet(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "alice@acme.com", Name: "site"})
+
+	// reading a project is recorded like changing it, a denied read as well
+	result := projects.Get(filterOf(project.User, &types.Project{User: project.User, Name: project.Name}), nil)
+	if result.Error() != nil {
+		t.Fail()
+		fmt.Println(result.Error())
+	}
+	projects.Get(filterOf("mallory@acme.com", &types.Project{User: project.User, Name: project.Name}), nil)
+	outcomes := map[string]types.AuditOutcome{}
+	for _, record := range projects.AuditRecords("") {
+		if record.Operation == audit.OP_GET {
+			outcomes[record.Actor] = record.Outcome
+		}
+	}
+	if outcomes[project.User] != types.AuditOutcome_AUDIT_SUCCEEDED || outcomes["mallory@acme.com"] != types.AuditOutcome_AUDIT_DENIED {
+		t.Fail()
+		fmt.Println("Expected the reads of the project to be audited ", outcomes)
+	}
+}
+
+func TestAuditLogChainBreak(t *testing.T) {
+	fileName := writeAuditLog(t)
+	web, _ := audit.Open(fileName)
+	defer web.Close()
+	node, _ := audit.Open(fileName)
+	defer node.Close()
+	breaks := func(records []*types.AuditRecord) int {
+		count := 0
+		for _, record := range records {
+			if record.Operation == audit.OP_CHAIN_BREAK {
+				count++
+			}
+		}
+		return count
+	}
+
+	// a corrupt line is recorded as a break once, by the process that reads it first
+	file, _ := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte("not a record\n"))
+	file.Close()
+	if _, err := web.Append(&types.AuditRecord{Actor: "alice@acme.com", Operation: audit.OP_GET}); err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+	records := node.Records()
+	if breaks(records) != 1 || breaks(web.Records()) != 1 || records[len(records)-1].Operation != audit.OP_GET {
+		t.Fail()
+		fmt.Println("Expected the corrupt line to be recorded once ", breaks(records), breaks(web.Records()))
+	}
+
+	// so is a record that does not chain, a replayed record that was changed
+	data, _ := os.ReadFile(fileName)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	file, _ = os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte(strings.Replace(lines[len(lines)-1], "alice@acme.com", "mallory@acme.com", 1) + "\n"))
+	file.Close()
+	records = node.Records()
+	if breaks(records) != 2 || breaks(web.Records()) != 2 || records[len(records)-1].Operation != audit.OP_CHAIN_BREAK ||
+		!strings.Contains(records[len(records)-1].Error, "is numbered") {
+		t.Fail()
+		fmt.Println("Expected the changed record to be recorded ", breaks(records), breaks(web.Records()))
+	}
+}
+
+func TestAuditServices(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "alice@acme.com", Name: "site"})
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html>first</html>"))
+	store, _ := snapshot.NewSnapshotStore(project)
+	store.Take(ws, 0, "Create a page")
+	repo, _ := gitrepo.OpenProjectRepo(project)
+	first, err := repo.Commit("Create a page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vnic := servicesOf(projects)
+
+	// the rollbacks, checkouts, cancels and exports of the owner, and the denied ones of another user
+	for _, user := range []string{"mallory@acme.com", project.User} {
+		(&service.SnapshotService{}).Put(requestOf(user, &types.ProjectSnapshot{User: project.User,
+			Name: project.Name}), vnic)
+		(&service.GitService{}).Put(requestOf(user, &types.ProjectCommit{User: project.User, Name: project.Name,
+			Hash: first.Hash}), vnic)
+	}
+	unlock := projects.Lock(project.User, project.Name)
+	id, _ := submitJob(projects, project.User, project.Name, "Create a page")
+	result := (&service.JobService{}).Delete(requestOf("mallory@acme.com", &types.GenerationJob{Id: id}), vnic)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "was not found") {
+		t.Fail()
+		fmt.Println("Expected the job to be hidden from another user ", result.Error())
+	}
+	(&service.JobService{}).Delete(requestOf(project.User, &types.GenerationJob{Id: id}), vnic)
+	unlock()
+
+	accounts, _ := auth.NewAccounts(filepath.Join(t.TempDir(), "accounts.dat"))
+	authenticator := auth.NewAuthenticator(accounts, auth.NewTokens(newMasterKey(), time.Hour))
+	accounts.Create(project.User, "correct-horse-battery")
+	token, _, _ := authenticator.Login(project.User, "correct-horse-battery")
+	server := httptest.NewServer(webapp.NewBundleHandler(projects, authenticator))
+	defer server.Close()
+	for _, bearer := range []string{"", token} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"?user="+project.User+"&name="+project.Name, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	outcomes := map[string]types.AuditOutcome{}
+	for _, record := range projects.AuditRecords("") {
+		outcomes[record.Operation+" "+record.Actor] = record.Outcome
+	}
+	for _, operation := range []string{audit.OP_ROLLBACK, audit.OP_CHECKOUT, audit.OP_CANCEL, audit.OP_EXPORT} {
+		denied := operation + " mallory@acme.com"
+		if operation == audit.OP_EXPORT {
+			denied = operation + " "
+		}
+		if outcomes[operation+" "+project.User] != types.AuditOutcome_AUDIT_SUCCEEDED ||
+			outcomes[denied] != types.AuditOutcome_AUDIT_DENIED {
+			t.Fail()
+			fmt.Println("Expected the ", operation, " to be audited ", outcomes)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+func newAuthenticator(t *testing.T, ttl time.Duration) (*auth.Authenticator, string) {
+	fileName := filepath.Join(t.TempDir(), "accounts.dat")
+	accounts, err := auth.NewAccounts(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = accounts.Create("Alice@Test.com", "correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.NewAuthenticator(accounts, auth.NewTokens(newMasterKey(), ttl)), fileName
+}
+
+func TestAuthAccounts(t *testing.T) {
+	authenticator, fileName := newAuthenticator(t, time.Hour)
+	accounts := authenticator.Accounts()
+	if accounts.Create("alice@test.com", "another-password") == nil {
+		t.Fail()
+		fmt.Println("Expected a second account of the same user to be rejected")
+	}
+	if accounts.Create("bob@test.com", "short") == nil {
+		t.Fail()
+		fmt.Println("Expected a short password to be rejected")
+	}
+	_, err := accounts.Verify("alice@test.com", "wrong-password-here")
+	if err != auth.ErrInvalidCredentials {
+		t.Fail()
+		fmt.Println("Expected a wrong password to be rejected ", err)
+	}
+	_, err = accounts.Verify("nobody@test.com", "correct-horse-battery")
+	if err != auth.ErrInvalidCredentials {
+		t.Fail()
+		fmt.Println("Expected an unknown user to be rejected ", err)
+	}
+	data, _ := os.ReadFile(fileName)
+	if strings.Contains(string(data), "correct-horse-battery") {
+		t.Fail()
+		fmt.Println("Expected the password to be hashed")
+	}
+
+	reopened, err := auth.NewAccounts(fileName)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	account, err := reopened.Verify(" ALICE@test.com", "correct-horse-battery")
+	if err != nil || account.User != "alice@test.com" {
+		t.Fail()
+		fmt.Println("Expected the account to be reloaded ", err, account)
+	}
+}
+
+func TestAuthAccountsSharedFile(t *testing.T) {
+	// the web server and the project node open the same accounts file
+	fileName := filepath.Join(t.TempDir(), "accounts.dat")
+	web, _ := auth.NewAccounts(fileName)
+	node, _ := auth.NewAccounts(fileName)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			web.Create(fmt.Sprint("web", i, "@test.com"), "correct-horse-battery")
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			node.Create(fmt.Sprint("node", i, "@test.com"), "correct-horse-battery")
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 3; i++ {
+		for _, user := range []string{fmt.Sprint("web", i, "@test.com"), fmt.Sprint("node", i, "@test.com")} {
+			if !web.Exists(user) || !node.Exists(user) {
+				t.Fail()
+				fmt.Println("Expected the accounts of both processes to be kept ", user)
+			}
+		}
+	}
+
+	// an account disabled in one process loses its tokens in the other
+	generation, _ := node.Generation("web0@test.com")
+	err := web.Disable("web0@test.com")
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+	if _, ok := node.Generation("web0@test.com"); ok || generation == 0 {
+		t.Fail()
+		fmt.Println("Expected the account disabled by the other process to be inactive")
+	}
+}
+
+func TestAuthTokens(t *testing.T) {
+	authenticator, _ := newAuthenticator(t, time.Hour)
+	token, expires, err := authenticator.Login("alice@test.com", "correct-horse-battery")
+	if err != nil || time.Until(expires) < 59*time.Minute {
+		t.Fail()
+		fmt.Println("Failed to log in ", err, expires)
+		return
+	}
+	user, err := authenticator.Validate(token)
+	if err != nil || user != "alice@test.com" {
+		t.Fail()
+		fmt.Println("Expected the token to be valid ", err, user)
+	}
+
+	forged := strings.Replace(token, ".", "x.", 1)
+	if _, err = authenticator.Validate(forged); err != auth.ErrInvalidToken {
+		t.Fail()
+		fmt.Println("Expected a tampered token to be rejected ", err)
+	}
+
+	authenticator.Logout(token)
+	if _, err = authenticator.Validate(token); err != auth.ErrInvalidToken {
+		t.Fail()
+		fmt.Println("Expected a revoked token to be rejected ", err)
+	}
+
+	// changing the password invalidates the tokens issued before
+	token, _, _ = authenticator.Login("alice@test.com", "correct-horse-battery")
+	authenticator.Accounts().SetPassword("alice@test.com", "new-password-of-alice")
+	if _, err = authenticator.Validate(token); err != auth.ErrInvalidToken {
+		t.Fail()
+		fmt.Println("Expected a token of the old password to be rejected ", err)
+	}
+
+	expired, _ := newAuthenticator(t, -time.Second)
+	token, _, _ = expired.Login("alice@test.com", "correct-horse-battery")
+	if _, err = expired.Validate(token); err != auth.ErrInvalidToken {
+		t.Fail()
+		fmt.Println("Expected an expired token to be rejected ", err)
+	}
+}
+
+func TestAuthLogoutSharedFile(t *testing.T) {
+	// the web server and the project node validate the tokens against the same accounts
+	fileName := filepath.Join(t.TempDir(), "accounts.dat")
+	key := newMasterKey()
+	web, _ := auth.NewAccounts(fileName)
+	web.Create("alice@test.com", "correct-horse-battery")
+	node, _ := auth.NewAccounts(fileName)
+	webAuth := auth.NewAuthenticator(web, auth.NewTokens(key, time.Hour))
+	nodeAuth := auth.NewAuthenticator(node, auth.NewTokens(key, time.Hour))
+
+	token, _, _ := webAuth.Login("alice@test.com", "correct-horse-battery")
+	other, _, _ := webAuth.Login("alice@test.com", "correct-horse-battery")
+	if _, err := nodeAuth.Validate(token); err != nil {
+		t.Fail()
+		fmt.Println("Expected the token to be valid in the other process ", err)
+	}
+	if err := webAuth.Logout(token); err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+
+	// a logout revokes the tokens of the user in every process and after a restart
+	restarted, _ := auth.NewAccounts(fileName)
+	for _, authenticator := range []*auth.Authenticator{nodeAuth, auth.NewAuthenticator(restarted, auth.NewTokens(key, time.Hour))} {
+		for _, revoked := range []string{token, other} {
+			if _, err := authenticator.Validate(revoked); err != auth.ErrInvalidToken {
+				t.Fail()
+				fmt.Println("Expected the token to be revoked ", err)
+			}
+		}
+	}
+	if err := webAuth.Logout(token); err != auth.ErrInvalidToken {
+		t.Fail()
+		fmt.Println("Expected a revoked token not to log out again ", err)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	authenticator, _ := newAuthenticator(t, time.Hour)
+	for i := 0; i < consts.AUTH_MAX_FAILURES; i++ {
+		authenticator.Login("alice@test.com", "wrong-password-here")
+	}
+	_, _, err := authenticator.Login("alice@test.com", "correct-horse-battery")
+	if err == nil || !strings.Contains(err.Error(), "Too many failed logins") {
+		t.Fail()
+		fmt.Println("Expected the user to be locked out ", err)
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// cassetteRun runs a turn against the provider through the cassette
+func cassetteRun(provider *anthropic.OpenAIProvider, cassette *anthropic.Cassette, text string) (*types.Project, error) {
+	provider.UseCassette(cassette)
+	project := &types.Project{User: "user@test.com", Name: "site",
+		Settings: &types.GenerationSettings{Provider: anthropic.PROVIDER_OPENAI}}
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.RemoveAll()
+	turn := &anthropic.Turn{Context: context.Background(), OnDelta: func(string) {}}
+	return project, anthropic.NewGenerator(provider).Run(turn, text, project)
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	defer workspaceTestDir(t)()
+	requests := make(chan map[string]interface{}, 4)
+	server := openaiTestServer(requests)
+	url := server.URL + "/v1"
+
+	recorder, err := anthropic.NewCassette("cassette.json", anthropic.CASSETTE_RECORD)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	recorded, err := cassetteRun(anthropic.NewOpenAIProvider(url, "secret-key", "local", 0, true), recorder, "Create a page")
+	server.Close()
+	if err != nil || len(recorder.Exchanges()) != 2 {
+		t.Fail()
+		fmt.Println("Recording failed ", err)
+		return
+	}
+	data, _ := os.ReadFile("cassette.json")
+	if strings.Contains(string(data), "secret-key") || !strings.Contains(string(data), anthropic.SCRUBBED) {
+		t.Fail()
+		fmt.Println("Expected the api key to be scrubbed from the cassette")
+	}
+
+	// the server is gone, the turn is answered from the cassette
+	player, err := anthropic.NewCassette("cassette.json", anthropic.CASSETTE_REPLAY)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	replayed, err := cassetteRun(anthropic.NewOpenAIProvider(url, "other-key", "local", 0, true), player, "Create a page")
+	if err != nil || len(replayed.Messages) != len(recorded.Messages) ||
+		replayed.Messages[3].Content != recorded.Messages[3].Content {
+		t.Fail()
+		fmt.Println("Expected the replay to repeat the recorded turn ", err)
+		return
+	}
+
+	// a request that was not recorded fails at once, it is not retried
+	start := time.Now()
+	_, err = cassetteRun(anthropic.NewOpenAIProvider(url, "", "local", 0, true), player, "Something else")
+	apiErr, ok := err.(*anthropic.APIError)
+	if !ok || apiErr.Kind != anthropic.ERROR_INVALID_REQUEST || time.Since(start) > time.Second {
+		t.Fail()
+		fmt.Println("Expected an unrecorded request to fail ", err)
+	}
+}
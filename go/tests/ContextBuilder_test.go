@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// contextTestProject returns a project of turns that each write a large file
+func contextTestProject(turns int) *types.Project {
+	project := &types.Project{User: "user@test.com", Name: "site"}
+	for i := 0; i < turns; i++ {
+		content := strings.Repeat("<p>line "+strconv.Itoa(i)+"</p>\n", 500)
+		input, _ := json.Marshal(&anthropic.FileToolInput{Path: "page" + strconv.Itoa(i) + ".html", Content: content})
+		id := "toolu_" + strconv.Itoa(i)
+		project.Messages = append(project.Messages,
+			&types.Message{Role: "user", Content: "Add page " + strconv.Itoa(i)},
+			&types.Message{Role: "assistant", Blocks: []*types.Content{{Type: "tool_use", Id: id,
+				Name: anthropic.TOOL_WRITE_FILE, Input: string(input)}}},
+			&types.Message{Role: "user", Blocks: []*types.Content{{Type: "tool_result", ToolUseId: id,
+				Content: "Wrote the page"}}},
+			&types.Message{Role: "assistant", Content: "Done"})
+	}
+	return project
+}
+
+func TestBuildContextElidesOlderTurns(t *testing.T) {
+	defer workspaceTestDir(t)()
+	project := contextTestProject(5)
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html></html>"))
+	stored := project.Messages[1].Blocks[0].Input
+
+	policy := &anthropic.ContextPolicy{Window: 200000, KeepTurns: 2, MaxFileTokens: 8000, ElideBytes: 256}
+	messages, report := anthropic.BuildContext(project, policy, 1000)
+	if len(messages) != len(project.Messages) || report.TurnsCompacted != 3 || report.TurnsVerbatim != 2 ||
+		report.ElidedBlocks != 3 || report.WorkspaceFiles != 1 {
+		t.Fail()
+		fmt.Println("Unexpected report ", report)
+		return
+	}
+	if project.Messages[1].Blocks[0].Input != stored {
+		t.Fail()
+		fmt.Println("Expected the stored conversation to stay unchanged")
+	}
+	if len(messages[1].Blocks[0].Input) > 256 || messages[1].Blocks[0].Id != "toolu_0" {
+		t.Fail()
+		fmt.Println("Expected the tool input to be elided ", messages[1].Blocks[0].Input)
+	}
+	if messages[13].Blocks[0].Input != project.Messages[13].Blocks[0].Input {
+		t.Fail()
+		fmt.Println("Expected the latest turns to be verbatim")
+	}
+	// the workspace snapshot leads the verbatim turns, after the compacted ones
+	workspace := messages[12].Blocks[0]
+	if !strings.Contains(workspace.Text, "<file path=\"index.html\">") || !workspace.Cache {
+		t.Fail()
+		fmt.Println("Expected the workspace files as a cached block ", workspace)
+	}
+	if messages[12].Blocks[1].Text != "Add page 3" {
+		t.Fail()
+		fmt.Println("Expected the request after the workspace files ", messages[12].Blocks)
+	}
+	compactedEnd := messages[11].Blocks
+	last := messages[len(messages)-1].Blocks
+	if len(compactedEnd) != 1 || !compactedEnd[0].Cache || len(last) != 1 || !last[0].Cache {
+		t.Fail()
+		fmt.Println("Expected cache breakpoints after the compacted turns and on the last message")
+	}
+	if project.Messages[11].Blocks != nil || project.Messages[19].Blocks != nil {
+		t.Fail()
+		fmt.Println("Expected the stored messages to stay unmarked")
+	}
+}
+
+func TestBuildContextDropsOldestTurns(t *testing.T) {
+	defer workspaceTestDir(t)()
+	project := contextTestProject(6)
+	policy := &anthropic.ContextPolicy{Window: 2000, KeepTurns: 2, MaxFileTokens: 8000, ElideBytes: 256}
+	messages, report := anthropic.BuildContext(project, policy, 0)
+	if report.TurnsDropped != 4 || len(report.Dropped) != int(report.TurnsDropped) || report.Dropped[0] != "Add page 0" {
+		t.Fail()
+		fmt.Println("Unexpected report ", report)
+		return
+	}
+	if messages[0].Role != "user" || !strings.Contains(messages[0].Blocks[0].Text, "- Add page 0") {
+		t.Fail()
+		fmt.Println("Expected the dropped requests to be listed ", messages[0].Blocks)
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func fakeWriteFile(path, content string) *fakeapi.Reply {
+	return &fakeapi.Reply{Text: "Writing " + path, Tools: []*fakeapi.ToolCall{{Name: anthropic.TOOL_WRITE_FILE,
+		Input: map[string]string{"path": path, "content": content}}}}
+}
+
+func TestFakeServerToolTurn(t *testing.T) {
+	defer workspaceTestDir(t)()
+	fake := fakeapi.NewServer()
+	defer fake.Close()
+	fake.Script(fakeWriteFile("index.html", "<html>ünïcode page</html>"), &fakeapi.Reply{Text: "Created the page"})
+
+	for _, streaming := range []bool{false, true} {
+		project := &types.Project{User: "user@test.com", Name: "site", ApiKey: "test-key"}
+		turn := &anthropic.Turn{Context: context.Background()}
+		text := ""
+		if streaming {
+			fake.Script(fakeWriteFile("index.html", "<html>ünïcode page</html>"), &fakeapi.Reply{Text: "Created the page"})
+			turn.OnDelta = func(delta string) { text += delta }
+		}
+		err := anthropic.NewAnthropicClientFor(fake.URL()).Run(turn, "Create a page", project)
+		if err != nil || len(project.Messages) != 4 || project.Messages[3].Content != "Created the page" {
+			t.Fail()
+			fmt.Println("Unexpected turn ", streaming, err, len(project.Messages))
+			return
+		}
+		ws, _ := anthropic.NewWorkspace(project)
+		data, _ := ws.ReadFile("index.html")
+		if string(data) != "<html>ünïcode page</html>" {
+			t.Fail()
+			fmt.Println("Expected the tool call to write the file ", string(data))
+		}
+		if streaming && text != "Writing index.htmlCreated the page" {
+			t.Fail()
+			fmt.Println("Unexpected streamed text ", text)
+		}
+	}
+	requests := fake.Requests()
+	if len(requests) != 4 || requests[0].Header.Get("x-api-key") != "test-key" || requests[0].Stream || !requests[3].Stream {
+		t.Fail()
+		fmt.Println("Unexpected requests ", len(requests))
+	}
+}
+
+func TestFakeServerErrors(t *testing.T) {
+	defer workspaceTestDir(t)()
+	fake := fakeapi.NewServer()
+	defer fake.Close()
+	client := anthropic.NewAnthropicClientFor(fake.URL())
+	project := &types.Project{User: "user@test.com", Name: "site", ApiKey: "test-key"}
+
+	// an overloaded api is retried after the wait it asks for
+	fake.Script(&fakeapi.Reply{Status: 529, ErrorType: "overloaded_error", Message: "Overloaded", RetryAfter: 1},
+		&fakeapi.Reply{Text: "Done"})
+	err := client.Do("Hello", project)
+	if err != nil || len(fake.Requests()) != 2 {
+		t.Fail()
+		fmt.Println("Expected the overloaded call to be retried ", err)
+	}
+
+	// an invalid request is not
+	fake.Script(&fakeapi.Reply{Status: 400, ErrorType: "invalid_request_error", Message: "bad request"})
+	err = client.Do("Hello", project)
+	apiErr, ok := err.(*anthropic.APIError)
+	if !ok || apiErr.Kind != anthropic.ERROR_INVALID_REQUEST || len(fake.Requests()) != 3 {
+		t.Fail()
+		fmt.Println("Expected the invalid request to fail at once ", err)
+	}
+
+	// a stream that fails after text was delivered is not retried either
+	fake.Script(&fakeapi.Reply{Text: "Partial text", StreamError: "overloaded_error", Message: "Overloaded"})
+	turn := &anthropic.Turn{Context: context.Background(), OnDelta: func(string) {}}
+	err = client.Run(turn, "Hello", project)
+	apiErr, ok = err.(*anthropic.APIError)
+	if !ok || !apiErr.Partial || len(fake.Requests()) != 4 {
+		t.Fail()
+		fmt.Println("Expected the partial stream to fail ", err)
+	}
+}
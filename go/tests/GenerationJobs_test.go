@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// submitJob submits the turn of the prompt as a job and returns its id
+func submitJob(projects *service.ProjectService, user, name, prompt string) (string, error) {
+	result := projects.Patch(requestOf(user, &types.Project{User: user, Name: name, ApiKey: "test-key",
+		Messages: []*types.Message{{Role: "user", Content: prompt}}}), nil)
+	if result.Error() != nil {
+		return "", result.Error()
+	}
+	return result.Element().(*types.Project).JobId, nil
+}
+
+// waitJob polls the job until it is in the state, and returns it as it was last seen
+func waitJob(projects *service.ProjectService, id string, state types.JobState) *types.GenerationJob {
+	var job *types.GenerationJob
+	for i := 0; i < 200; i++ {
+		job, _ = projects.Job(id)
+		if job != nil && job.State == state {
+			break
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	return job
+}
+
+func TestGenerationJobStates(t *testing.T) {
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+
+	// a job waits in the queue for the project
+	unlock := projects.Lock(project.User, project.Name)
+	fake.Script(&fakeapi.Reply{Text: "Writing index.html", Delay: 300 * time.Millisecond,
+		Tools: fakeWriteFile("index.html", "<html></html>").Tools}, &fakeapi.Reply{Text: "Created"})
+	id, err := submitJob(projects, project.User, project.Name, "Create a page")
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		unlock()
+		return
+	}
+	if job, _ := projects.Job(id); job == nil || job.State != types.JobState_JOB_QUEUED {
+		t.Fail()
+		fmt.Println("Expected the job to be queued ", job)
+	}
+	// a project has one job at a time
+	if _, err = submitJob(projects, project.User, project.Name, "Again"); err == nil ||
+		!strings.Contains(err.Error(), "already in progress") {
+		t.Fail()
+		fmt.Println("Expected the second job to be rejected ", err)
+	}
+	unlock()
+
+	job := waitJob(projects, id, types.JobState_JOB_RUNNING)
+	if job.State != types.JobState_JOB_RUNNING || job.Started == 0 {
+		t.Fail()
+		fmt.Println("Expected the job to run ", job)
+	}
+	job = waitJob(projects, id, types.JobState_JOB_SUCCEEDED)
+	if job.State != types.JobState_JOB_SUCCEEDED || job.Result == nil || job.Result.Revision != project.Revision+1 ||
+		job.Rounds != 2 || len(job.Files) != 1 {
+		t.Fail()
+		fmt.Println("Expected the job to succeed ", job)
+	}
+
+	// a failed model call fails the job
+	fake.Script(&fakeapi.Reply{Status: 400, ErrorType: "invalid_request_error", Message: "bad request"})
+	id, _ = submitJob(projects, project.User, project.Name, "Break it")
+	job = waitJob(projects, id, types.JobState_JOB_FAILED)
+	if job.State != types.JobState_JOB_FAILED || !strings.Contains(job.Error, "bad request") {
+		t.Fail()
+		fmt.Println("Expected the job to fail ", job)
+	}
+}
+
+func TestGenerationJobFailsUnsavedTurn(t *testing.T) {
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+	// a directory in place of the record fails the save
+	record := filepath.Join(os.Getenv(consts.PROJECT_STORE_PATH_ENV), project.User, project.Name+".dat")
+	os.Remove(record)
+	os.MkdirAll(filepath.Join(record, "blocked"), 0755)
+
+	fake.Script(&fakeapi.Reply{Text: "Done"})
+	id, _ := submitJob(projects, project.User, project.Name, "Create a page")
+	job := waitJob(projects, id, types.JobState_JOB_FAILED)
+	if job.State != types.JobState_JOB_FAILED || !strings.Contains(job.Error, "Failed to save project") {
+		t.Fail()
+		fmt.Println("Expected the unsaved turn to fail the job ", job)
+	}
+	if current := visibleProject(t, projects, project.User, project.User, project.Name); current.Revision != project.Revision {
+		t.Fail()
+		fmt.Println("Expected the cached project to be left alone ", current.Revision)
+	}
+}
+
+func TestGenerationJobCancel(t *testing.T) {
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+
+	// a queued job is cancelled at once and never calls the model
+	unlock := projects.Lock(project.User, project.Name)
+	id, _ := submitJob(projects, project.User, project.Name, "Create a page")
+	job, err := projects.CancelJob(id)
+	unlock()
+	if err != nil || job.State != types.JobState_JOB_CANCELLED {
+		t.Fail()
+		fmt.Println("Expected the queued job to be cancelled ", job, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if job, _ = projects.Job(id); job.State != types.JobState_JOB_CANCELLED || len(fake.Requests()) != 0 {
+		t.Fail()
+		fmt.Println("Expected the cancelled job to stay cancelled ", job, len(fake.Requests()))
+	}
+
+	// a running job is cancelled through the context of its model call
+	fake.Script(&fakeapi.Reply{Text: "Slow", Delay: time.Second})
+	id, _ = submitJob(projects, project.User, project.Name, "Create a page")
+	waitJob(projects, id, types.JobState_JOB_RUNNING)
+	projects.CancelJob(id)
+	job = waitJob(projects, id, types.JobState_JOB_CANCELLED)
+	if job.State != types.JobState_JOB_CANCELLED || job.Error != "generation was cancelled" {
+		t.Fail()
+		fmt.Println("Expected the running job to be cancelled ", job)
+	}
+	if _, err = projects.CancelJob("missing"); err == nil {
+		t.Fail()
+		fmt.Println("Expected an unknown job to fail the cancel")
+	}
+}
+
+func TestGenerationJobPrune(t *testing.T) {
+	t.Setenv(consts.JOB_RETENTION_ENV, "1s")
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+	fake.Script(&fakeapi.Reply{Text: "First"}, &fakeapi.Reply{Text: "Second"})
+	first, _ := submitJob(projects, project.User, project.Name, "First")
+	waitJob(projects, first, types.JobState_JOB_SUCCEEDED)
+	time.Sleep(2 * time.Second)
+
+	// the jobs done for longer than the retention are dropped when the next one is submitted
+	second, _ := submitJob(projects, project.User, project.Name, "Second")
+	if _, ok := projects.Job(first); ok {
+		t.Fail()
+		fmt.Println("Expected the old job to be pruned")
+	}
+	if job := waitJob(projects, second, types.JobState_JOB_SUCCEEDED); job == nil || len(projects.Jobs()) != 1 {
+		t.Fail()
+		fmt.Println("Expected the new job to be kept ", job)
+	}
+}
+
+func TestGenerationJobWithoutPrompt(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+
+	// a patch without a prompt is rejected before a job is submitted
+	result := projects.Patch(requestOf(project.User, &types.Project{User: project.User, Name: project.Name,
+		ApiKey: "test-key"}), nil)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "has no prompt") || len(projects.Jobs()) != 0 {
+		t.Fail()
+		fmt.Println("Expected the patch without a prompt to be rejected ", result.Error())
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+func TestHTTPClientVerifiesTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: server.URL})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	_, err = client.Get(server.URL)
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected a server of an unknown CA to be refused")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	client, err = anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: server.URL, CAFile: caFile})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fail()
+		fmt.Println("Expected the custom CA to be trusted ", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	client, _ := anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: target.URL, Proxy: proxy.URL,
+		NoProxy: "example.com, 10.0.0.0/8"})
+	resp, err := client.Get(target.URL + "/v1/messages")
+	if err != nil || proxied != target.URL+"/v1/messages" {
+		t.Fail()
+		fmt.Println("Expected the request to go through the proxy ", err, proxied)
+		return
+	}
+	resp.Body.Close()
+
+	proxied = ""
+	client, _ = anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: target.URL, Proxy: proxy.URL,
+		NoProxy: "example.com,127.0.0.0/8"})
+	resp, err = client.Get(target.URL)
+	if err != nil || proxied != "" {
+		t.Fail()
+		fmt.Println("Expected a no proxy host to be reached directly ", err, proxied)
+		return
+	}
+	resp.Body.Close()
+}
+
+func TestLoadServiceConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "l8vibe.json")
+	os.WriteFile(file, []byte(`{"anthropic":{"base_url":"https://gateway.internal","ca_file":"/etc/ca.pem"},`+
+		`"openai":{"base_url":"http://llm.internal:8000/v1","model":"local","tools":false}}`), 0600)
+	t.Setenv(consts.ANTHROPIC_BASE_URL_ENV, "")
+	t.Setenv(consts.OPENAI_MODEL_ENV, "override")
+	config, err := common.LoadServiceConfigFile(file)
+	if err != nil || config.Anthropic.BaseURL != "https://gateway.internal" || config.Anthropic.RPM != consts.ANTHROPIC_RPM ||
+		config.OpenAI.Model != "override" || config.OpenAI.ToolsEnabled() {
+		t.Fail()
+		fmt.Println("Unexpected configuration ", err, config)
+		return
+	}
+
+	config, err = common.LoadServiceConfigFile(filepath.Join(dir, "missing.json"))
+	if err != nil || config.Anthropic.BaseURL != consts.ANTHROPIC_BASE_URL || config.OpenAI != nil {
+		t.Fail()
+		fmt.Println("Expected the defaults without a configuration file ", err)
+	}
+
+	for _, invalid := range []string{`{"anthropic":{"proxy":"socks://proxy"}}`, `{"anthropic":{"cert_file":"c.pem"}}`,
+		`{"anthropic":{"base_url":"api.anthropic.com"}}`} {
+		os.WriteFile(file, []byte(invalid), 0600)
+		_, err = common.LoadServiceConfigFile(file)
+		if err == nil {
+			t.Fail()
+			fmt.Println("Expected an invalid configuration to be refused ", invalid)
+		}
+	}
+}
+
+func TestHTTPClientNoProxyWithEnvironment(t *testing.T) {
+	// without a configured proxy the no proxy list still applies over the environment
+	client, err := anthropic.NewHTTPClient(&common.ProviderConfig{BaseURL: "https://api.anthropic.com",
+		NoProxy: "internal.example"})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	request, _ := http.NewRequest(http.MethodGet, "https://api.internal.example/v1/messages", nil)
+	proxyURL, err := client.Transport.(*http.Transport).Proxy(request)
+	if err != nil || proxyURL != nil {
+		t.Fail()
+		fmt.Println("Expected a no proxy host to bypass the environment proxy ", err, proxyURL)
+	}
+}
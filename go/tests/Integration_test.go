@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saichler/layer8/go/overlay/vnet"
+	"github.com/saichler/layer8/go/overlay/vnic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/audit"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/webapp"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+const (
+	integrationVnetPort = uint32(23555)
+	integrationWebPort  = 14443
+)
+
+// bootStack starts the vnet, the project node and the web server in process and returns
+// the url prefix of the web server
+func bootStack(t *testing.T, dir string) string {
+	network := vnet.NewVNet(common.Resources("it-vnet", integrationVnetPort))
+	network.Start()
+
+	resources := common.Resources("it-proj", integrationVnetPort)
+	nic := vnic.NewVirtualNetworkInterface(resources, nil)
+	nic.Start()
+	nic.WaitForConnection()
+	_, err := service.ActivateServices(resources, nic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &webapp.Config{Host: "127.0.0.1", Port: integrationWebPort, CertName: filepath.Join(dir, "l8vibe")}
+	svr, err := webapp.NewWebServer(common.Resources("it-websvr", integrationVnetPort), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Start()
+	address := "127.0.0.1:" + strconv.Itoa(integrationWebPort)
+	for i := 0; i < 50; i++ {
+		conn, er := net.Dial("tcp", address)
+		if er == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "https://" + address + consts.WEBSITE_PREFIX
+}
+
+func TestIntegrationGenerateThroughWebServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the vnet, the project service and the web server")
+	}
+	defer workspaceTestDir(t)()
+	dir, _ := os.Getwd()
+	fake := fakeapi.NewServer()
+	defer fake.Close()
+	t.Setenv(consts.ANTHROPIC_BASE_URL_ENV, fake.URL())
+	t.Setenv(consts.PROJECT_STORE_PATH_ENV, dir)
+	t.Setenv(consts.USAGE_LEDGER_ENV, filepath.Join(dir, "usage.dat"))
+	t.Setenv(consts.SECRETS_FILE_ENV, filepath.Join(dir, "secrets.dat"))
+	t.Setenv(consts.MASTER_KEY_FILE_ENV, filepath.Join(dir, "master.key"))
+	t.Setenv(consts.ACCOUNTS_FILE_ENV, filepath.Join(dir, "accounts.dat"))
+	t.Setenv(consts.TOKEN_KEY_FILE_ENV, filepath.Join(dir, "token.key"))
+	t.Setenv(consts.AUDIT_LOG_ENV, filepath.Join(dir, "audit.log"))
+	t.Setenv(consts.AUTH_ADMIN_USER_ENV, "it@test.com")
+	t.Setenv(consts.AUTH_ADMIN_PASSWORD_ENV, "integration-password")
+	prefix := bootStack(t, dir)
+
+	client := &http.Client{Timeout: time.Minute,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	token := ""
+	post := func(path, body string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, prefix+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// anonymous requests are rejected
+	status, body := post(consts.STREAM_PATH,
+		`{"user":"it@test.com","name":"site","messages":[{"role":"user","content":"Create a page"}]}`)
+	if status != http.StatusUnauthorized {
+		t.Fail()
+		fmt.Println("Expected an anonymous stream to be rejected ", status, body)
+	}
+
+	status, body = post(consts.AUTH_PATH, `{"user":"it@test.com","password":"integration-password"}`)
+	session := map[string]interface{}{}
+	json.Unmarshal([]byte(body), &session)
+	token, _ = session["token"].(string)
+	if status != http.StatusOK || token == "" {
+		t.Fail()
+		fmt.Println("Failed to log in ", status, body)
+		return
+	}
+
+	// the identity cannot act as another user
+	status, body = post(consts.STREAM_PATH,
+		`{"user":"other@test.com","name":"site","messages":[{"role":"user","content":"Create a page"}]}`)
+	if status != http.StatusForbidden {
+		t.Fail()
+		fmt.Println("Expected a stream of another user to be rejected ", status, body)
+	}
+
+	status, body = post("0/"+service.ServiceName, `{"user":"it@test.com","name":"site","apiKey":"test-key"}`)
+	if status != http.StatusOK {
+		t.Fail()
+		fmt.Println("Failed to create the project ", status, body)
+		return
+	}
+
+	if strings.Contains(body, "test-key") || !strings.Contains(body, "secretId") {
+		t.Fail()
+		fmt.Println("Expected the key to be replaced by its secret ", body)
+	}
+
+	// the key of the project is resolved from the vault
+	fake.Script(fakeWriteFile("index.html", "<html>integration</html>"), &fakeapi.Reply{Text: "Created the page"})
+	status, body = post(consts.STREAM_PATH,
+		`{"user":"it@test.com","name":"site","messages":[{"role":"user","content":"Create a page"}]}`)
+	if status != http.StatusOK || !strings.Contains(body, "event: done") || !strings.Contains(body, "Created the page") {
+		t.Fail()
+		fmt.Println("Unexpected stream ", status, body)
+		return
+	}
+
+	ws, _ := anthropic.NewWorkspace(&types.Project{User: "it@test.com", Name: "site"})
+	data, err := ws.ReadFile("index.html")
+	if err != nil || string(data) != "<html>integration</html>" {
+		t.Fail()
+		fmt.Println("Expected the generated file in the workspace ", err)
+	}
+	requests := fake.Requests()
+	if len(requests) != 2 || requests[0].Header.Get(consts.ANTHROPIC_HEADER_API_KEY) != "test-key" ||
+		!strings.Contains(fmt.Sprint(requests[1].Body["messages"]), "tool_result") {
+		t.Fail()
+		fmt.Println("Unexpected requests to the fake api ", len(requests))
+	}
+
+	// a failing api is reported through the stream
+	fake.Script(&fakeapi.Reply{Status: 401, ErrorType: "authentication_error", Message: "invalid x-api-key"})
+	status, body = post(consts.STREAM_PATH,
+		`{"user":"it@test.com","name":"site","apiKey":"bad-key","messages":[{"role":"user","content":"Again"}]}`)
+	if !strings.Contains(body, "event: error") || !strings.Contains(body, "invalid x-api-key") {
+		t.Fail()
+		fmt.Println("Expected the api failure in the stream ", status, body)
+	}
+
+	// the operations are in the audit log, chained and without the prompts
+	log, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fail()
+		fmt.Println("Expected a valid audit log ", err)
+		return
+	}
+	defer log.Close()
+	operations := make([]string, 0)
+	for _, record := range log.Records() {
+		operations = append(operations, record.Operation+":"+record.Outcome.String())
+		if record.Operation == audit.OP_TURN && record.Outcome == types.AuditOutcome_AUDIT_SUCCEEDED &&
+			(record.Actor != "it@test.com" || record.PromptHash != audit.PromptHash("Create a page") ||
+				!strings.Contains(strings.Join(record.Files, ","), "index.html") || record.Usage == nil) {
+			t.Fail()
+			fmt.Println("Unexpected audit record of the turn ", record)
+		}
+	}
+	// a turn may start before the patch that submitted it is recorded
+	sort.Strings(operations)
+	if strings.Join(operations, ",") != "patch:AUDIT_SUCCEEDED,patch:AUDIT_SUCCEEDED,post:AUDIT_SUCCEEDED,"+
+		"turn:AUDIT_FAILED,turn:AUDIT_SUCCEEDED" {
+		t.Fail()
+		fmt.Println("Unexpected audit records ", operations)
+	}
+}
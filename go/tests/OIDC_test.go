@@ -0,0 +1,286 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+)
+
+// testIdP is a stand-in OpenID Connect provider. Its authorization endpoint logs in the
+// configured user at once and redirects back with a code, its token endpoint checks the
+// PKCE verifier of the code and returns an id token signed with its current key.
+type testIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	user     string
+	groups   []string
+	verified interface{}
+	audience string
+	codes    map[string]url.Values
+	jwks     int
+	mtx      sync.Mutex
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	idp := &testIdP{user: "Carol@Corp.com", groups: []string{"eng", "admins"}, verified: true, codes: make(map[string]url.Values)}
+	idp.rotateKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize", "token_endpoint": idp.server.URL + "/token",
+			"jwks_uri": idp.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mtx.Lock()
+		defer idp.mtx.Unlock()
+		idp.jwks++
+		e := big.NewInt(int64(idp.key.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{"kty": "RSA", "kid": idp.kid,
+			"use": "sig", "n": base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(e)}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := fmt.Sprint(time.Now().UnixNano())
+		idp.mtx.Lock()
+		idp.codes[code] = query
+		idp.mtx.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mtx.Lock()
+		login, ok := idp.codes[r.Form.Get("code")]
+		delete(idp.codes, r.Form.Get("code"))
+		idp.mtx.Unlock()
+		challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || login.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(login)})
+	})
+	idp.server = httptest.NewServer(mux)
+	idp.audience = "l8vibe"
+	return idp
+}
+
+func (this *testIdP) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	this.mtx.Lock()
+	this.key = key
+	this.kid = fmt.Sprint("key-", time.Now().UnixNano())
+	this.mtx.Unlock()
+}
+
+func (this *testIdP) sign(login url.Values) string {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": this.kid, "typ": "JWT"})
+	values := map[string]interface{}{"iss": this.server.URL, "sub": "0042", "aud": this.audience,
+		"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(), "nonce": login.Get("nonce"),
+		"email": this.user, "groups": this.groups}
+	if this.verified != nil {
+		values["email_verified"] = this.verified
+	}
+	claims, _ := json.Marshal(values)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, this.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login runs the browser side of a login and returns the code and state of the callback
+func (this *testIdP) login(rp *auth.RelyingParty) (string, string, error) {
+	location, _, err := rp.AuthCodeURL()
+	if err != nil {
+		return "", "", err
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(location)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state"), nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	config := &common.OIDCConfig{Issuer: idp.server.URL, ClientId: "l8vibe",
+		RedirectURL: "https://vibe.test/l8vibe/0/oidc/callback", Roles: map[string]string{"admins": "org:admins", "eng": "team:eng"}}
+	rp := auth.NewRelyingParty(config, http.DefaultClient)
+
+	code, state, err := idp.login(rp)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	identity, err := rp.Exchange(code, state)
+	if err != nil || identity.User != "carol@corp.com" || identity.Subject != "0042" ||
+		strings.Join(identity.Roles, ",") != "org:admins,team:eng" {
+		t.Fail()
+		fmt.Println("Unexpected identity ", err, identity)
+		return
+	}
+	if _, err = rp.Exchange(code, state); err == nil {
+		t.Fail()
+		fmt.Println("Expected a completed login to be rejected")
+	}
+
+	// the identity gets the same kind of token as a local account
+	authenticator, _ := newAuthenticator(t, time.Hour)
+	token, _, err := authenticator.Federate(identity)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	user, err := authenticator.Validate(token)
+	if err != nil || user != "carol@corp.com" || len(authenticator.Accounts().Roles(user)) != 2 {
+		t.Fail()
+		fmt.Println("Expected the token of the identity to be valid ", err, user)
+	}
+	if _, err = authenticator.Accounts().Verify(user, ""); err == nil {
+		t.Fail()
+		fmt.Println("Expected the federated account to have no password")
+	}
+	other := *identity
+	other.Subject = "0043"
+	if _, _, err = authenticator.Federate(&other); err == nil {
+		t.Fail()
+		fmt.Println("Expected another subject with the same email to be rejected")
+	}
+}
+
+func TestOIDCRejectsTokens(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	config := &common.OIDCConfig{Issuer: idp.server.URL, ClientId: "l8vibe", RedirectURL: "https://vibe.test/cb"}
+	rp := auth.NewRelyingParty(config, http.DefaultClient)
+
+	// a token for another client
+	idp.audience = "other"
+	code, state, _ := idp.login(rp)
+	if _, err := rp.Exchange(code, state); err == nil || !strings.Contains(err.Error(), "not for this client") {
+		t.Fail()
+		fmt.Println("Expected a token of another audience to be rejected ", err)
+	}
+	idp.audience = "l8vibe"
+
+	// a state that was never issued
+	code, _, _ = idp.login(rp)
+	if _, err := rp.Exchange(code, "forged"); err == nil {
+		t.Fail()
+		fmt.Println("Expected an unknown state to be rejected")
+	}
+
+	// a wrong PKCE verifier is rejected by the provider, here by a code of another login
+	code, _, _ = idp.login(rp)
+	_, state, _ = idp.login(rp)
+	if _, err := rp.Exchange(code, state); err == nil {
+		t.Fail()
+		fmt.Println("Expected the code of another login to be rejected")
+	}
+
+	// the keys are cached until a token is signed by an unknown key, and fetched again
+	// at most once a minute
+	code, state, _ = idp.login(rp)
+	if _, err := rp.Exchange(code, state); err != nil || idp.jwks != 1 {
+		t.Fail()
+		fmt.Println("Expected a valid login with the cached keys ", err, idp.jwks)
+	}
+	idp.rotateKey(t)
+	code, state, _ = idp.login(rp)
+	if _, err := rp.Exchange(code, state); err == nil || idp.jwks != 1 {
+		t.Fail()
+		fmt.Println("Expected a new key to wait for the refresh interval ", err, idp.jwks)
+	}
+}
+
+func TestOIDCConfig(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.json")
+	os.WriteFile(fileName, []byte(`{"auth":{"oidc":{"issuer":"https://idp.test","client_id":"l8vibe"}}}`), 0600)
+	_, err := common.LoadServiceConfigFile(fileName)
+	if err == nil || !strings.Contains(err.Error(), "redirect_url") {
+		t.Fail()
+		fmt.Println("Expected the missing redirect_url to be reported ", err)
+	}
+	t.Setenv(consts.OIDC_CLIENT_SECRET_ENV, "from-env")
+	os.WriteFile(fileName, []byte(`{"auth":{"oidc":{"issuer":"https://idp.test","client_id":"l8vibe",`+
+		`"redirect_url":"https://vibe.test/cb"}}}`), 0600)
+	config, err := common.LoadServiceConfigFile(fileName)
+	if err != nil || config.Auth.OIDC.ClientSecret != "from-env" {
+		t.Fail()
+		fmt.Println("Expected the client secret of the environment ", err)
+	}
+	os.WriteFile(fileName, []byte(`{"auth":{"oidc":{"issuer":"https://idp.test","client_id":"l8vibe",`+
+		`"redirect_url":"https://vibe.test/cb","roles":{"admins":"admin"}}}}`), 0600)
+	_, err = common.LoadServiceConfigFile(fileName)
+	if err == nil || !strings.Contains(err.Error(), "not an org: or a team:") {
+		t.Fail()
+		fmt.Println("Expected a role that grants no access to be reported ", err)
+	}
+}
+
+func TestOIDCEmailVerified(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	config := &common.OIDCConfig{Issuer: idp.server.URL, ClientId: "l8vibe", RedirectURL: "https://vibe.test/cb"}
+	rp := auth.NewRelyingParty(config, http.DefaultClient)
+
+	// an email the provider did not say it verified is rejected, unless the provider is trusted
+	for _, test := range []struct {
+		verified interface{}
+		trust    bool
+		accepted bool
+	}{{nil, false, false}, {false, false, false}, {"false", true, false}, {nil, true, true}, {"true", false, true}} {
+		idp.verified, config.TrustEmail = test.verified, test.trust
+		code, state, _ := idp.login(rp)
+		identity, err := rp.Exchange(code, state)
+		if (err == nil) != test.accepted || (err == nil && !identity.EmailVerified) {
+			t.Fail()
+			fmt.Println("Unexpected exchange of email_verified ", test.verified, " trusted ", test.trust, ": ", err)
+		}
+	}
+
+	// nor is an account created or linked for an identity without a verified email
+	accounts, _ := auth.NewAccounts(filepath.Join(t.TempDir(), "accounts.dat"))
+	_, err := accounts.Federate(&auth.Identity{User: "carol@corp.com", Issuer: idp.server.URL, Subject: "0042"})
+	if err == nil || accounts.Exists("carol@corp.com") {
+		t.Fail()
+		fmt.Println("Expected the unverified identity to be rejected ", err)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// openaiTestServer streams a write_file call on the first request and a closing text on
+// the next, the bodies of the requests are handed to requests
+func openaiTestServer(requests chan map[string]interface{}) *httptest.Server {
+	calls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body := make(map[string]interface{})
+		json.Unmarshal(data, &body)
+		requests <- body
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls == 1 {
+			fmt.Fprint(w, `data: {"id":"c1","model":"local","choices":[{"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"write_file","arguments":""}}]}}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"id":"c1","model":"local","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":\"index.html\","}}]}}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"id":"c1","model":"local","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"content\":\"<html></html>\"}"}}]},"finish_reason":"tool_calls"}]}`+"\n\n")
+		} else {
+			fmt.Fprint(w, `data: {"id":"c2","model":"local","choices":[{"delta":{"content":"Created "}}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"id":"c2","model":"local","choices":[{"delta":{"content":"the page"},"finish_reason":"stop"}]}`+"\n\n")
+		}
+		fmt.Fprint(w, `data: {"id":"c","model":"local","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":20,"prompt_tokens_details":{"cached_tokens":40}}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestOpenAIProviderRunsTools(t *testing.T) {
+	defer workspaceTestDir(t)()
+	requests := make(chan map[string]interface{}, 4)
+	server := openaiTestServer(requests)
+	defer server.Close()
+
+	provider := anthropic.NewOpenAIProvider(server.URL+"/v1", "", "local", 32000, true)
+	generator := anthropic.NewGenerator(provider)
+	project := &types.Project{User: "user@test.com", Name: "site",
+		Settings: &types.GenerationSettings{Provider: anthropic.PROVIDER_OPENAI}}
+	text := ""
+	var usages []*types.Usage
+	turn := &anthropic.Turn{Context: context.Background(), OnDelta: func(delta string) { text += delta },
+		OnResponse: func(resp *types.ClaudeResponse) { usages = append(usages, resp.Usage) }}
+	err := generator.Run(turn, "Create a page", project)
+	if err != nil {
+		t.Fail()
+		fmt.Println("Run failed ", err)
+		return
+	}
+	if text != "Created the page" || len(project.Messages) != 4 {
+		t.Fail()
+		fmt.Println("Unexpected conversation ", text, len(project.Messages))
+	}
+	ws, _ := anthropic.NewWorkspace(project)
+	data, err := ws.ReadFile("index.html")
+	if err != nil || string(data) != "<html></html>" {
+		t.Fail()
+		fmt.Println("Expected the tool call to write the file ", err)
+	}
+	if len(usages) != 2 || usages[0].InputTokens != 60 || usages[0].CacheReadInputTokens != 40 {
+		t.Fail()
+		fmt.Println("Expected the cached tokens to be counted apart ", usages)
+	}
+
+	first := <-requests
+	if first["model"] != "local" || first["stream"] != true || len(first["tools"].([]interface{})) == 0 {
+		t.Fail()
+		fmt.Println("Unexpected first request ", first)
+	}
+	second := <-requests
+	messages := second["messages"].([]interface{})
+	last := messages[len(messages)-1].(map[string]interface{})
+	if messages[0].(map[string]interface{})["role"] != "system" || last["role"] != "tool" ||
+		last["tool_call_id"] != "call_1" {
+		t.Fail()
+		fmt.Println("Expected the tool result as a tool message ", last)
+	}
+}
+
+func TestGeneratorUnknownProvider(t *testing.T) {
+	generator := anthropic.NewGenerator(anthropic.NewAnthropicClient())
+	project := &types.Project{User: "u", Name: "p",
+		Settings: &types.GenerationSettings{Provider: anthropic.PROVIDER_OPENAI}}
+	if generator.Run(&anthropic.Turn{Context: context.Background()}, "hi", project) == nil {
+		t.Fail()
+		fmt.Println("Expected a provider that is not configured to fail")
+	}
+	if anthropic.ValidateSettings(&types.GenerationSettings{Provider: "other"}) == nil {
+		t.Fail()
+		fmt.Println("Expected an unknown provider to be invalid")
+	}
+}
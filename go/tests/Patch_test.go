@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+)
+
+const patchOriginal = `function add(a, b) {
+    return a + b;
+}
+
+function sub(a, b) {
+    return a - b;
+}
+`
+
+func TestPatchUnifiedDiff(t *testing.T) {
+	diff := `--- a/app.js
++++ b/app.js
+@@ -5,3 +5,4 @@
+ function sub(a, b) {
+-    return a - b;
++    // subtract
++    return a - b;
+ }
+`
+	result, err := anthropic.ApplyPatch(patchOriginal, diff)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	expected := "function add(a, b) {\n    return a + b;\n}\n\nfunction sub(a, b) {\n    // subtract\n    return a - b;\n}\n"
+	if result != expected {
+		t.Fail()
+		fmt.Println("Unexpected result:\n" + result)
+	}
+}
+
+func TestPatchFuzzyContext(t *testing.T) {
+	// wrong line number, tab indentation and a context line that does not exist
+	diff := "@@ -40,4 +40,4 @@\n function add(a, b) {\n-\treturn a + b;\n+\treturn b + a;\n }\n // no such line\n"
+	result, err := anthropic.ApplyPatch(patchOriginal, diff)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	if result[:39] != "function add(a, b) {\n\treturn b + a;\n}\n\n" {
+		t.Fail()
+		fmt.Println("Unexpected result:\n" + result)
+	}
+}
+
+func TestPatchSearchReplace(t *testing.T) {
+	patch := "<<<<<<< SEARCH\n    return a + b;\n=======\n    return a + b + 0;\n>>>>>>> REPLACE\n" +
+		"<<<<<<< SEARCH\nfunction sub(a, b) {\n=======\nfunction subtract(a, b) {\n>>>>>>> REPLACE\n"
+	result, err := anthropic.ApplyPatch(patchOriginal, patch)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	expected := "function add(a, b) {\n    return a + b + 0;\n}\n\nfunction subtract(a, b) {\n    return a - b;\n}\n"
+	if result != expected {
+		t.Fail()
+		fmt.Println("Unexpected result:\n" + result)
+	}
+}
+
+func TestPatchReportsFailedHunks(t *testing.T) {
+	patch := "<<<<<<< SEARCH\n    return a + b;\n=======\n    return 0;\n>>>>>>> REPLACE\n" +
+		"<<<<<<< SEARCH\nfunction mul(a, b) {\n=======\nfunction times(a, b) {\n>>>>>>> REPLACE\n" +
+		"<<<<<<< SEARCH\n}\n=======\n};\n>>>>>>> REPLACE\n"
+	result, err := anthropic.ApplyPatch(patchOriginal, patch)
+	patchErr, ok := err.(*anthropic.PatchError)
+	if !ok {
+		t.Fail()
+		fmt.Println("Expected a patch error, got ", err)
+		return
+	}
+	if result != patchOriginal {
+		t.Fail()
+		fmt.Println("Content should be unchanged when a hunk fails")
+	}
+	if len(patchErr.Failures) != 2 || patchErr.Failures[0].Hunk != 2 || patchErr.Failures[1].Hunk != 3 {
+		t.Fail()
+		fmt.Println(patchErr.Error())
+	}
+}
+
+func TestPatchInsertions(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		patch    string
+		expected string
+		fails    bool
+	}{
+		{"top of a file", "a\nb\n", "@@ -0,0 +1 @@\n+x\n", "x\na\nb\n", false},
+		{"middle of a file", "a\nb\n", "@@ -1,0 +2 @@\n+x\n", "a\nx\nb\n", false},
+		{"end of a file", "a\nb\n", "@@ -2,0 +3 @@\n+x\n", "a\nb\nx\n", false},
+		{"empty file", "", "@@ -0,0 +1 @@\n+x\n", "x\n", false},
+		{"beyond the end of a file", "a\nb\n", "@@ -4,0 +5 @@\n+x\n", "a\nb\n", true},
+	}
+	for _, test := range tests {
+		result, err := anthropic.ApplyPatch(test.original, test.patch)
+		if _, failed := err.(*anthropic.PatchError); failed != test.fails || result != test.expected {
+			t.Fail()
+			fmt.Println("Unexpected insertion at the ", test.name, ": ", err, "\n"+result)
+		}
+	}
+}
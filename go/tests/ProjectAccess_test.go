@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/webapp"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// sharedProject posts the project of alice and shares it with bob as viewer and carol as editor
+func sharedProject(t *testing.T, projects *service.ProjectService) *types.Project {
+	project := postProject(t, projects, &types.Project{User: "alice@test.com", Name: "site"})
+	for principal, role := range map[string]types.ProjectRole{"bob@test.com": types.ProjectRole_ROLE_VIEWER,
+		"carol@test.com": types.ProjectRole_ROLE_EDITOR} {
+		_, err := projects.Share(project.User, project.Name, principal, role, project.User)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return project
+}
+
+func TestProjectAccessRoles(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := sharedProject(t, projects)
+
+	// each user has the roles up to the one the project is shared with
+	roles := []types.ProjectRole{types.ProjectRole_ROLE_VIEWER, types.ProjectRole_ROLE_EDITOR, types.ProjectRole_ROLE_OWNER}
+	granted := map[string]int{"alice@test.com": 3, "carol@test.com": 2, "bob@test.com": 1, "dave@test.com": 0}
+	for identity, count := range granted {
+		for i, role := range roles {
+			user := project.User
+			err := projects.Access(identity, &user, project.Name, role)
+			if (err == nil) != (i < count) {
+				t.Fail()
+				fmt.Println("Unexpected access of ", identity, " as ", role, ": ", err)
+			}
+		}
+		if visible := visibleProject(t, projects, identity, project.User, project.Name) != nil; visible != (count > 0) {
+			t.Fail()
+			fmt.Println("Unexpected visibility of the project to ", identity, ": ", visible)
+		}
+	}
+	user := project.User
+	if err := projects.Access("dave@test.com", &user, project.Name, types.ProjectRole_ROLE_VIEWER); err == nil ||
+		!strings.Contains(err.Error(), "was not found") {
+		t.Fail()
+		fmt.Println("Expected the project to be hidden from a user without a role ", err)
+	}
+
+	// an editor changes the project, a viewer does not
+	current := visibleProject(t, projects, project.User, project.User, project.Name)
+	result := projects.Put(requestOf("carol@test.com", &types.Project{User: project.User, Name: project.Name,
+		Description: "edited", Revision: current.Revision}), nil)
+	if result.Error() != nil {
+		t.Fail()
+		fmt.Println("Expected the editor to change the project ", result.Error())
+	}
+	result = projects.Put(requestOf("bob@test.com", &types.Project{User: project.User, Name: project.Name,
+		Description: "viewed", Revision: current.Revision + 1}), nil)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "the editor role is required") {
+		t.Fail()
+		fmt.Println("Expected the viewer not to change the project ", result.Error())
+	}
+}
+
+func TestProjectAccessGroups(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	projects, _ := activateProjectsWith(t, `{"auth":{"oidc":{"issuer":"`+idp.server.URL+`","client_id":"l8vibe",`+
+		`"redirect_url":"https://vibe.test/cb","roles":{"eng":"team:eng"}}}}`)
+	project := postProject(t, projects, &types.Project{User: "alice@test.com", Name: "site"})
+	if _, err := projects.Share(project.User, project.Name, "team:eng", types.ProjectRole_ROLE_VIEWER, project.User); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := common.LoadServiceConfig()
+	rp := auth.NewRelyingParty(config.Auth.OIDC, http.DefaultClient)
+	accounts, _ := auth.NewAccounts(auth.AccountsFile())
+
+	// the user views the project while a group of the provider maps to the team
+	for _, test := range []struct {
+		groups  []string
+		granted bool
+	}{{[]string{"eng"}, true}, {[]string{"sales"}, false}} {
+		idp.groups = test.groups
+		code, state, _ := idp.login(rp)
+		identity, err := rp.Exchange(code, state)
+		if err == nil {
+			_, err = accounts.Federate(identity)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := project.User
+		if err = projects.Access(identity.User, &user, project.Name, types.ProjectRole_ROLE_VIEWER); (err == nil) != test.granted {
+			t.Fail()
+			fmt.Println("Unexpected access of the groups ", test.groups, ": ", err)
+		}
+	}
+}
+
+func TestProjectShareAsOwner(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := sharedProject(t, projects)
+
+	// a project has one owner, it is handed over with a transfer
+	_, err := projects.Share(project.User, project.Name, "carol@test.com", types.ProjectRole_ROLE_OWNER, project.User)
+	if err == nil || !strings.Contains(err.Error(), "transfer it instead") {
+		t.Fail()
+		fmt.Println("Expected a share as owner to be rejected ", err)
+	}
+	user := project.User
+	if err = projects.Access("carol@test.com", &user, project.Name, types.ProjectRole_ROLE_OWNER); err == nil {
+		t.Fail()
+		fmt.Println("Expected the editor not to become an owner")
+	}
+}
+
+func TestProjectShareUnsaved(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := sharedProject(t, projects)
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html>site</html>"))
+	// a directory in place of a record fails its save
+	block := func(user string) {
+		record := filepath.Join(os.Getenv(consts.PROJECT_STORE_PATH_ENV), user, project.Name+".dat")
+		os.Remove(record)
+		os.MkdirAll(filepath.Join(record, "blocked"), 0755)
+	}
+
+	// a transfer that is not saved leaves the project and its files with the owner
+	block("bob@test.com")
+	if _, err := projects.Transfer(project.User, project.Name, "bob@test.com"); err == nil {
+		t.Fail()
+		fmt.Println("Expected the unsaved transfer to fail")
+	}
+	if data, err := ws.ReadFile("index.html"); err != nil || string(data) != "<html>site</html>" {
+		t.Fail()
+		fmt.Println("Expected the files to be moved back to the owner ", err)
+	}
+	if visibleProject(t, projects, project.User, project.User, project.Name) == nil {
+		t.Fail()
+		fmt.Println("Expected the owner to keep the project")
+	}
+
+	// nor is the owner a new owner, however the name is written
+	if _, err := projects.Transfer(" Alice@Test.com", project.Name, project.User); err == nil ||
+		!strings.Contains(err.Error(), "is invalid") {
+		t.Fail()
+		fmt.Println("Expected the transfer to the owner to be rejected ", err)
+	}
+
+	// a share that is not saved leaves the members as they were
+	block(project.User)
+	if _, err := projects.Share(project.User, project.Name, "dave@test.com", types.ProjectRole_ROLE_VIEWER, project.User); err == nil {
+		t.Fail()
+		fmt.Println("Expected the unsaved share to fail")
+	}
+	user := project.User
+	if err := projects.Access("dave@test.com", &user, project.Name, types.ProjectRole_ROLE_VIEWER); err == nil {
+		t.Fail()
+		fmt.Println("Expected the unsaved member not to get access")
+	}
+}
+
+func TestProjectKeyOwnerOnly(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := sharedProject(t, projects)
+	current := visibleProject(t, projects, project.User, project.User, project.Name)
+	secret, err := projects.Vault().Store(&types.ProviderSecret{Provider: anthropic.PROVIDER_ANTHROPIC,
+		Value: "sk-owner-value-1111"}, project.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an editor can neither store a key in the vault of the owner nor pick a secret of the owner
+	for _, change := range []*types.Project{{ApiKey: "sk-editor-value-2222"}, {SecretId: secret.Id}} {
+		change.User, change.Name, change.Revision = project.User, project.Name, current.Revision
+		result := projects.Put(requestOf("carol@test.com", change), nil)
+		if result.Error() == nil || !strings.Contains(result.Error().Error(), "Only the owner") {
+			t.Fail()
+			fmt.Println("Expected the editor not to change the key ", result.Error())
+		}
+	}
+	if len(projects.Vault().List()) != 1 {
+		t.Fail()
+		fmt.Println("Expected the key of the editor to stay out of the vault ", len(projects.Vault().List()))
+	}
+
+	// the owner can, and the editor keeps the key by not sending one
+	result := projects.Put(requestOf(project.User, &types.Project{User: project.User, Name: project.Name,
+		SecretId: secret.Id, Revision: current.Revision}), nil)
+	if result.Error() != nil {
+		t.Fail()
+		fmt.Println("Expected the owner to change the key ", result.Error())
+		return
+	}
+	result = projects.Put(requestOf("carol@test.com", &types.Project{User: project.User, Name: project.Name,
+		Description: "edited", Revision: current.Revision + 1}), nil)
+	if result.Error() != nil || result.Element().(*types.Project).SecretId != secret.Id {
+		t.Fail()
+		fmt.Println("Expected the editor to keep the key of the project ", result.Error())
+	}
+}
+
+func TestProjectPreview(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := sharedProject(t, projects)
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html>site</html>"))
+	ws.WriteFile("css/site.css", []byte("body {}"))
+	if strings.Contains(filepath.ToSlash(ws.Root()), "/web/") {
+		t.Fail()
+		fmt.Println("Expected the workspace outside of the web UI ", ws.Root())
+	}
+
+	accounts, _ := auth.NewAccounts(filepath.Join(t.TempDir(), "accounts.dat"))
+	authenticator := auth.NewAuthenticator(accounts, auth.NewTokens(newMasterKey(), time.Hour))
+	tokens := map[string]string{}
+	for _, user := range []string{"bob@test.com", "dave@test.com"} {
+		accounts.Create(user, "correct-horse-battery")
+		tokens[user], _, _ = authenticator.Login(user, "correct-horse-battery")
+	}
+	server := httptest.NewServer(webapp.NewPreviewHandler(projects, authenticator))
+	defer server.Close()
+	base := server.URL + consts.WEBSITE_PREFIX + consts.PREVIEW_PATH + project.User + "/" + project.Name + "/"
+	request := func(method, url, token string, cookies []*http.Cookie) (*http.Response, string) {
+		req, _ := http.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	// the preview is not served without a token, nor to a user without a role
+	if resp, _ := request(http.MethodGet, base+"index.html", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fail()
+		fmt.Println("Expected an anonymous preview to be rejected ", resp.StatusCode)
+	}
+	if resp, _ := request(http.MethodPost, base, tokens["dave@test.com"], nil); resp.StatusCode != http.StatusForbidden {
+		t.Fail()
+		fmt.Println("Expected the preview of a user without a role to be rejected ", resp.StatusCode)
+	}
+
+	// a viewer opens the preview and the frame loads the pages with the cookie
+	resp, _ := request(http.MethodPost, base, tokens["bob@test.com"], nil)
+	if resp.StatusCode != http.StatusNoContent || len(resp.Cookies()) != 1 || !resp.Cookies()[0].HttpOnly {
+		t.Fail()
+		fmt.Println("Expected the viewer to open the preview ", resp.StatusCode, resp.Cookies())
+		return
+	}
+	cookies := resp.Cookies()
+	cookie := cookies[0]
+	if cookie.Value == tokens["bob@test.com"] || cookie.SameSite != http.SameSiteStrictMode ||
+		cookie.Path != consts.WEBSITE_PREFIX+consts.PREVIEW_PATH+project.User+"/"+project.Name+"/" ||
+		time.Until(cookie.Expires) > consts.PREVIEW_TOKEN_TTL {
+		t.Fail()
+		fmt.Println("Expected a short lived strict cookie of the preview of the project ", cookie)
+	}
+	resp, body := request(http.MethodGet, base, "", cookies)
+	if resp.StatusCode != http.StatusOK || body != "<html>site</html>" {
+		t.Fail()
+		fmt.Println("Expected the index of the project ", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Security-Policy") != "sandbox allow-scripts" {
+		t.Fail()
+		fmt.Println("Expected the preview to be sandboxed ", resp.Header.Get("Content-Security-Policy"))
+	}
+	if resp, body := request(http.MethodGet, base+"css/site.css", "", cookies); resp.StatusCode != http.StatusOK || body != "body {}" {
+		t.Fail()
+		fmt.Println("Expected the assets of the project ", resp.StatusCode, body)
+	}
+	if resp, _ := request(http.MethodGet, base+"../../../accounts.dat", "", cookies); resp.StatusCode == http.StatusOK {
+		t.Fail()
+		fmt.Println("Expected the preview to stay in the workspace ", resp.StatusCode)
+	}
+	if resp, _ := request(http.MethodGet, base+"missing.html", "", cookies); resp.StatusCode != http.StatusNotFound {
+		t.Fail()
+		fmt.Println("Expected a missing file not to be found ", resp.StatusCode)
+	}
+
+	// the cookie is neither a bearer token of the user nor a cookie of another project
+	if _, err := authenticator.Validate(cookie.Value); err == nil {
+		t.Fail()
+		fmt.Println("Expected the preview token not to be a bearer token")
+	}
+	other := strings.Replace(base, "/"+project.Name+"/", "/other/", 1)
+	if resp, _ := request(http.MethodGet, other, "", cookies); resp.StatusCode != http.StatusUnauthorized {
+		t.Fail()
+		fmt.Println("Expected the cookie not to open another project ", resp.StatusCode)
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestProjectStaleRevision(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site", Description: "first"})
+
+	// a put from the current revision moves the project to the next one
+	result := projects.Put(requestOf(project.User, &types.Project{User: project.User, Name: project.Name,
+		Description: "second", Revision: project.Revision}), nil)
+	if result.Error() != nil || result.Element().(*types.Project).Revision != project.Revision+1 {
+		t.Fail()
+		fmt.Println("Unexpected put from the current revision ", result.Error())
+		return
+	}
+
+	// a put or a turn from the revision before it conflicts
+	result = projects.Put(requestOf(project.User, &types.Project{User: project.User, Name: project.Name,
+		Description: "stale", Revision: project.Revision}), nil)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "was modified by another request") {
+		t.Fail()
+		fmt.Println("Expected the stale put to conflict ", result.Error())
+	}
+	_, err := projects.PatchStream(&types.Project{User: project.User, Name: project.Name, Revision: project.Revision,
+		Messages: []*types.Message{{Role: "user", Content: "Create a page"}}}, project.User)
+	conflict, ok := err.(*service.RevisionConflictError)
+	if !ok || conflict.Revision != project.Revision || conflict.Current != project.Revision+1 {
+		t.Fail()
+		fmt.Println("Expected the stale turn to conflict ", err)
+	}
+	if current := visibleProject(t, projects, project.User, project.User, project.Name); current.Description != "second" {
+		t.Fail()
+		fmt.Println("Expected the stale put to leave the project alone ", current.Description)
+	}
+
+	// the revision the project moved to is the current one for the next put
+	result = projects.Put(requestOf(project.User, &types.Project{User: project.User, Name: project.Name,
+		Description: "third", Revision: project.Revision + 1}), nil)
+	if result.Error() != nil || result.Element().(*types.Project).Revision != project.Revision+2 {
+		t.Fail()
+		fmt.Println("Unexpected put from the next revision ", result.Error())
+	}
+}
+
+func TestProjectLockSerializesTurns(t *testing.T) {
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+
+	// the holders of the lock of a project run one at a time, other projects are not blocked
+	var running, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer projects.Lock(project.User, project.Name)()
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 {
+		t.Fail()
+		fmt.Println("Expected the holders of the lock to run one at a time ", overlaps)
+	}
+	unlock := projects.Lock(project.User, project.Name)
+	other := make(chan struct{})
+	go func() {
+		projects.Lock(project.User, "other")()
+		close(other)
+	}()
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fail()
+		fmt.Println("Expected the lock of another project to be free")
+	}
+
+	// a turn waits for the project to be released, a second turn is rejected meanwhile
+	fake.Script(fakeWriteFile("index.html", "<html></html>"), &fakeapi.Reply{Text: "Created"})
+	done := make(chan error, 1)
+	go func() {
+		_, err := runTurn(projects, project.User, project.Name, "Create a page")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if len(fake.Requests()) != 0 {
+		t.Fail()
+		fmt.Println("Expected the turn to wait for the lock")
+	}
+	if _, err := runTurn(projects, project.User, project.Name, "Again"); err == nil ||
+		!strings.Contains(err.Error(), "already in progress") {
+		t.Fail()
+		fmt.Println("Expected the second turn to be rejected ", err)
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fail()
+			fmt.Println(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fail()
+		fmt.Println("Expected the turn to run once the project was released")
+	}
+	if len(fake.Requests()) != 2 {
+		t.Fail()
+		fmt.Println("Unexpected requests ", len(fake.Requests()))
+	}
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/gitrepo"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestProjectRepoCommitDiffCheckout(t *testing.T) {
+	defer workspaceTestDir(t)()
+	project := &types.Project{User: "user@test.com", Name: "site"}
+	ws, _ := anthropic.NewWorkspace(project)
+	repo, err := gitrepo.OpenProjectRepo(project)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+
+	ws.WriteFile("index.html", []byte("<h1>one</h1>\n"))
+	first, err := repo.Commit("Create a page")
+	if err != nil || first == nil || first.Author != project.User {
+		t.Fail()
+		fmt.Println("Unexpected first commit ", first, err)
+		return
+	}
+	ws.WriteFile("index.html", []byte("<h1>two</h1>\n"))
+	ws.WriteFile("app.js", []byte("run();\n"))
+	second, _ := repo.Commit("Add a script")
+	if none, _ := repo.Commit("Nothing changed"); none != nil {
+		t.Fail()
+		fmt.Println("Expected no commit for an unchanged workspace")
+	}
+
+	log, _ := repo.Log(0)
+	if len(log) != 2 || log[0].Message != "Add a script" {
+		t.Fail()
+		fmt.Println("Unexpected log ", log)
+	}
+	diffs, err := repo.Diff("", second.Hash)
+	if err != nil || len(diffs) != 2 || diffs[0].Status != "added" || !strings.Contains(diffs[1].Diff, "+<h1>two</h1>") {
+		t.Fail()
+		fmt.Println("Unexpected diff ", diffs, err)
+	}
+
+	_, err = repo.Checkout(first.Hash)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	data, _ := ws.ReadFile("index.html")
+	paths, _ := ws.List()
+	if string(data) != "<h1>one</h1>\n" || len(paths) != 1 {
+		t.Fail()
+		fmt.Println("Checkout did not restore the workspace ", paths)
+	}
+	if log, _ = repo.Log(0); len(log) != 3 {
+		t.Fail()
+		fmt.Println("Expected the checkout to be recorded as a commit")
+	}
+
+	bundle := &bytes.Buffer{}
+	err = repo.Bundle(bundle)
+	if err != nil || !strings.HasPrefix(bundle.String(), "# v2 git bundle\n"+log[0].Hash+" refs/heads/") {
+		t.Fail()
+		fmt.Println("Unexpected bundle ", err)
+	}
+}
+
+func TestProjectRepoCheckoutService(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "alice@acme.com", Name: "site"})
+	ws, _ := anthropic.NewWorkspace(project)
+	vnic := servicesOf(projects)
+	commits := &service.GitService{}
+
+	// reading the history of a project without one does not create a repository
+	result := commits.Post(requestOf(project.User, &types.CommitDiff{User: project.User, Name: project.Name}), vnic)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "no history") {
+		t.Fail()
+		fmt.Println("Expected no history ", result.Error())
+	}
+	if _, err := os.Stat(filepath.Join(ws.Root(), ".git")); err == nil {
+		t.Fail()
+		fmt.Println("Expected the read not to create the repository")
+	}
+
+	// the commits are authored with the email of the user
+	repo, _ := gitrepo.OpenProjectRepo(project)
+	ws.WriteFile("index.html", []byte("<h1>one</h1>\n"))
+	first, _ := repo.Commit("Create a page")
+	ws.WriteFile("index.html", []byte("<h1>two</h1>\n"))
+	repo.Commit("Change the page")
+	opened, _ := git.PlainOpen(ws.Root())
+	if commit, err := opened.CommitObject(plumbing.NewHash(first.Hash)); err != nil || commit.Author.Email != project.User {
+		t.Fail()
+		fmt.Println("Expected the email of the user ", err, commit)
+	}
+
+	// a checkout changes the revision of the project
+	result = commits.Put(requestOf(project.User, &types.ProjectCommit{User: project.User, Name: project.Name,
+		Hash: first.Hash}), vnic)
+	data, _ := ws.ReadFile("index.html")
+	current := visibleProject(t, projects, project.User, project.User, project.Name)
+	if result.Error() != nil || string(data) != "<h1>one</h1>\n" || current.Revision != project.Revision+1 {
+		t.Fail()
+		fmt.Println("Expected the checkout of the first commit ", result.Error(), string(data), current.Revision)
+	}
+
+	// a project in the trash is not checked out
+	projects.Trash(project.User, project.Name)
+	result = commits.Put(requestOf(project.User, &types.ProjectCommit{User: project.User, Name: project.Name,
+		Hash: first.Hash}), vnic)
+	if result.Error() == nil || !strings.Contains(result.Error().Error(), "in the trash") {
+		t.Fail()
+		fmt.Println("Expected the checkout of a trashed project to fail ", result.Error())
+	}
+}
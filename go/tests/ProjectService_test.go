@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+// activateProjects activates the project service in process, with its files in a
+// temporary directory and the fake api as the provider
+func activateProjects(t *testing.T) (*service.ProjectService, *fakeapi.Server) {
+	return activateProjectsWith(t, "")
+}
+
+// activateProjectsWith activates the projects with the service config, none for the defaults
+func activateProjectsWith(t *testing.T, config string) (*service.ProjectService, *fakeapi.Server) {
+	restore := workspaceTestDir(t)
+	dir, _ := os.Getwd()
+	if config != "" {
+		os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+	}
+	fake := fakeapi.NewServer()
+	t.Setenv(consts.ANTHROPIC_BASE_URL_ENV, fake.URL())
+	t.Setenv(consts.CONFIG_ENV, filepath.Join(dir, "config.json"))
+	t.Setenv(consts.PROJECT_STORE_PATH_ENV, dir)
+	t.Setenv(consts.USAGE_LEDGER_ENV, filepath.Join(dir, "usage.dat"))
+	t.Setenv(consts.SECRETS_FILE_ENV, filepath.Join(dir, "secrets.dat"))
+	t.Setenv(consts.MASTER_KEY_FILE_ENV, filepath.Join(dir, "master.key"))
+	t.Setenv(consts.ACCOUNTS_FILE_ENV, filepath.Join(dir, "accounts.dat"))
+	t.Setenv(consts.TOKEN_KEY_FILE_ENV, filepath.Join(dir, "token.key"))
+	t.Setenv(consts.AUDIT_LOG_ENV, filepath.Join(dir, "audit.log"))
+
+	projects := &service.ProjectService{}
+	err := projects.Activate(service.ServiceName, service.ServiceArea, common.Resources("test-proj", 0), &cacheListen{})
+	if err != nil {
+		fake.Close()
+		restore()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		projects.DeActivate()
+		fake.Close()
+		restore()
+	})
+	return projects, fake
+}
+
+// userRequest is a request of the authenticated user
+type userRequest struct {
+	ifs.IElements
+	user string
+}
+
+func (this *userRequest) AAAId() string {
+	return this.user
+}
+
+func requestOf(user string, element interface{}) ifs.IElements {
+	return &userRequest{IElements: object.New(nil, element), user: user}
+}
+
+// filterRequest is a request of the user for the project its element names
+type filterRequest struct {
+	userRequest
+}
+
+func (this *filterRequest) IsFilterMode() bool {
+	return true
+}
+
+func filterOf(user string, element interface{}) ifs.IElements {
+	return &filterRequest{userRequest{IElements: object.New(nil, element), user: user}}
+}
+
+// servicesVNic is the vnic the other services reach the project service through
+type servicesVNic struct {
+	ifs.IVNic
+	resources *servicesResources
+}
+
+func (this *servicesVNic) Resources() ifs.IResources {
+	return this.resources
+}
+
+type servicesResources struct {
+	ifs.IResources
+	services *projectServices
+}
+
+func (this *servicesResources) Services() ifs.IServices {
+	return this.services
+}
+
+type projectServices struct {
+	ifs.IServices
+	projects *service.ProjectService
+}
+
+func (this *projectServices) ServiceHandler(name string, area byte) (ifs.IServiceHandler, bool) {
+	return this.projects, name == service.ServiceName && area == service.ServiceArea
+}
+
+func servicesOf(projects *service.ProjectService) ifs.IVNic {
+	return &servicesVNic{resources: &servicesResources{IResources: common.Resources("test-services", 0),
+		services: &projectServices{projects: projects}}}
+}
+
+// postProject creates the project of the user through the service
+func postProject(t *testing.T, projects *service.ProjectService, project *types.Project) *types.Project {
+	result := projects.Post(requestOf(project.User, project), nil)
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	posted, ok := result.Element().(*types.Project)
+	if !ok {
+		t.Fatal(fmt.Sprint("Unexpected result of the post ", result.Element()))
+	}
+	return posted
+}
+
+// visibleProject returns the project of the user as the viewer sees it, nil if it is not visible
+func visibleProject(t *testing.T, projects *service.ProjectService, viewer, user, name string) *types.Project {
+	resources := common.Resources("test-query", 0)
+	resources.Introspector().Inspect(&types.Project{})
+	query, err := object.NewQuery("select * from project", resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range projects.GetQuery(query, viewer) {
+		project, ok := elem.(*types.Project)
+		if ok && project.User == user && project.Name == name {
+			return project
+		}
+	}
+	return nil
+}
+
+// runTurn streams the turn of the prompt on the project and waits for it to end
+func runTurn(projects *service.ProjectService, user, name, prompt string) (*types.Project, error) {
+	stream, err := projects.PatchStream(&types.Project{User: user, Name: name, ApiKey: "test-key",
+		Messages: []*types.Message{{Role: "user", Content: prompt}}}, user)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	for {
+		text, done, result, er := stream.Next(offset)
+		offset += len(text)
+		if done {
+			return result, er
+		}
+	}
+}
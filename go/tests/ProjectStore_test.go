@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/persist"
+	"github.com/saichler/vibe.with.layer8/go/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func testProjectStore(t *testing.T, store persist.ProjectStore) {
+	project := &types.Project{User: "user@test.com", Name: "site", Description: "a site",
+		Messages: []*types.Message{{Role: "user", Content: "make a site"}}}
+	err := store.Save(project)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	project.Description = "updated"
+	store.Save(project)
+	store.Save(&types.Project{User: "user@test.com", Name: "other"})
+	store.Delete("user@test.com", "other")
+
+	projects, err := store.Load()
+	if err != nil || len(projects) != 1 || projects[0].Description != "updated" || len(projects[0].Messages) != 1 {
+		t.Fail()
+		fmt.Println("Unexpected load ", projects, err)
+	}
+	if store.Delete("user@test.com", "missing") != nil {
+		t.Fail()
+		fmt.Println("Expected deleting a missing project to succeed")
+	}
+	// the user and the name are part of the key and cannot leave the store
+	for _, bad := range []*types.Project{{User: "user@test.com", Name: "../escape"}, {User: "../..", Name: "site"}} {
+		if _, isName := store.Save(bad).(*persist.InvalidNameError); !isName {
+			t.Fail()
+			fmt.Println("Expected an invalid name error for ", bad.User, "/", bad.Name)
+		}
+	}
+	if _, isName := store.Delete("user@test.com", "../site").(*persist.InvalidNameError); !isName {
+		t.Fail()
+		fmt.Println("Expected deleting an invalid name to fail")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store := persist.NewFileStore(dir)
+	testProjectStore(t, store)
+
+	// a record written before checksums is still readable
+	legacy, _ := proto.Marshal(&types.Project{User: "user@test.com", Name: "legacy"})
+	os.WriteFile(filepath.Join(dir, "user@test.com", "legacy.dat"), legacy, 0644)
+	// a damaged record is reported instead of skipped
+	record, _ := os.ReadFile(filepath.Join(dir, "user@test.com", "site.dat"))
+	record[len(record)-1] ^= 0xff
+	os.WriteFile(filepath.Join(dir, "user@test.com", "broken.dat"), record, 0644)
+
+	projects, err := store.Load()
+	corrupt, ok := err.(persist.CorruptRecords)
+	if !ok || len(corrupt) != 1 || len(projects) != 2 {
+		t.Fail()
+		fmt.Println("Expected one corrupt record and two projects, got ", len(projects), err)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := persist.NewBoltStore(filepath.Join(t.TempDir(), "projects.db"))
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	defer store.Close()
+	testProjectStore(t, store)
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/common"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/project/service"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestProjectTrashRestore(t *testing.T) {
+	projects, _ := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html></html>"))
+	cached := visibleProject(t, projects, project.User, project.User, project.Name)
+
+	trashed, err := projects.Trash(project.User, project.Name)
+	if err != nil || trashed.DeletedAt == 0 || trashed.Revision != project.Revision+1 {
+		t.Fail()
+		fmt.Println("Unexpected trashed project ", trashed, err)
+		return
+	}
+	// the cached project is replaced, not changed in place
+	if cached == nil || cached.DeletedAt != 0 {
+		t.Fail()
+		fmt.Println("Expected the project the caller holds to be left alone ", cached)
+	}
+	if visibleProject(t, projects, project.User, project.User, project.Name) != nil {
+		t.Fail()
+		fmt.Println("Expected the trashed project to be hidden")
+	}
+	result := projects.Post(requestOf(project.User, &types.Project{User: project.User, Name: project.Name}), nil)
+	if result.Error() == nil {
+		t.Fail()
+		fmt.Println("Expected a post over a trashed project to fail")
+	}
+
+	restored, err := projects.Restore(project.User, project.Name)
+	if err != nil || restored.DeletedAt != 0 || restored.Revision != project.Revision+2 {
+		t.Fail()
+		fmt.Println("Unexpected restored project ", restored, err)
+	}
+	if visibleProject(t, projects, project.User, project.User, project.Name) == nil {
+		t.Fail()
+		fmt.Println("Expected the restored project to be visible")
+	}
+	if data, er := ws.ReadFile("index.html"); er != nil || string(data) != "<html></html>" {
+		t.Fail()
+		fmt.Println("Expected the workspace to survive the trash ", er)
+	}
+	if _, err = projects.Restore(project.User, project.Name); err == nil {
+		t.Fail()
+		fmt.Println("Expected a project that is not in the trash to fail the restore")
+	}
+}
+
+func TestProjectTrashPurge(t *testing.T) {
+	projects, _ := activateProjects(t)
+	dir := os.Getenv(consts.PROJECT_STORE_PATH_ENV)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+	ws, _ := anthropic.NewWorkspace(project)
+	ws.WriteFile("index.html", []byte("<html></html>"))
+	store, _ := snapshot.NewSnapshotStore(project)
+	store.Take(ws, 0, "")
+	os.WriteFile(filepath.Join(dir, project.User, project.Name+".stream"), []byte("Created"), 0644)
+
+	projects.Trash(project.User, project.Name)
+	// deleting a project in the trash deletes it for good
+	_, err := projects.Trash(project.User, project.Name)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	for _, path := range []string{filepath.Join(dir, project.User, project.Name+".dat"),
+		filepath.Join(dir, project.User, project.Name+".stream"),
+		filepath.Join(dir, project.User, project.Name+".snapshots"), ws.Root()} {
+		if _, er := os.Stat(path); !os.IsNotExist(er) {
+			t.Fail()
+			fmt.Println("Expected the purge to remove ", path)
+		}
+	}
+	if _, err = projects.Restore(project.User, project.Name); err == nil {
+		t.Fail()
+		fmt.Println("Expected a purged project to be gone")
+	}
+}
+
+func TestProjectTrashRetention(t *testing.T) {
+	t.Setenv(consts.TRASH_RETENTION_ENV, "1s")
+	projects, _ := activateProjects(t)
+	dir := os.Getenv(consts.PROJECT_STORE_PATH_ENV)
+	expired := postProject(t, projects, &types.Project{User: "user@test.com", Name: "old"})
+	postProject(t, projects, &types.Project{User: "user@test.com", Name: "kept"})
+	projects.Trash(expired.User, expired.Name)
+	time.Sleep(2 * time.Second)
+	trashed := postProject(t, projects, &types.Project{User: "user@test.com", Name: "recent"})
+	projects.Trash(trashed.User, trashed.Name)
+
+	// the projects that expired while the service was down are purged when it starts
+	restarted := &service.ProjectService{}
+	err := restarted.Activate(service.ServiceName, service.ServiceArea, common.Resources("test-restart", 0), &cacheListen{})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	defer restarted.DeActivate()
+	if _, er := os.Stat(filepath.Join(dir, expired.User, expired.Name+".dat")); !os.IsNotExist(er) {
+		t.Fail()
+		fmt.Println("Expected the expired project to be purged")
+	}
+	if _, err = restarted.Restore(trashed.User, trashed.Name); err != nil {
+		t.Fail()
+		fmt.Println("Expected the project within the retention to be restorable ", err)
+	}
+	if visibleProject(t, restarted, "user@test.com", "user@test.com", "kept") == nil {
+		t.Fail()
+		fmt.Println("Expected the project out of the trash to be kept")
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/fakeapi"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func fakePatchFile(path, patch string) *fakeapi.Reply {
+	return &fakeapi.Reply{Text: "Patching " + path, Tools: []*fakeapi.ToolCall{{Name: anthropic.TOOL_PATCH_FILE,
+		Input: map[string]string{"path": path, "patch": patch}}}}
+}
+
+func TestTurnAppliesToolCallsOnce(t *testing.T) {
+	projects, fake := activateProjects(t)
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site"})
+	ws, _ := anthropic.NewWorkspace(project)
+	expected := "let count = 1;\nlet total = 2;\n"
+	// the file is not written by a turn and the patch matches its own result again,
+	// replaying the turns over the workspace would apply it twice
+	ws.WriteFile("app.js", []byte("let count = 1;\n"))
+	patch := "<<<<<<< SEARCH\nlet count = 1;\n=======\nlet count = 1;\nlet total = 2;\n>>>>>>> REPLACE\n"
+	fake.Script(fakePatchFile("app.js", patch), &fakeapi.Reply{Text: "Patched"},
+		fakeWriteFile("index.html", "<html></html>"), &fakeapi.Reply{Text: "Created"})
+	for _, prompt := range []string{"Add a total", "Create a page"} {
+		_, err := runTurn(projects, project.User, project.Name, prompt)
+		if err != nil {
+			t.Fail()
+			fmt.Println(err)
+			return
+		}
+	}
+	data, _ := ws.ReadFile("app.js")
+	if string(data) != expected {
+		t.Fail()
+		fmt.Println("Expected the patch to be applied once after the turn:\n" + string(data))
+	}
+
+	// reading the projects does not replay the turns over the workspace
+	visibleProject(t, projects, project.User, project.User, project.Name)
+	data, _ = ws.ReadFile("app.js")
+	if string(data) != expected {
+		t.Fail()
+		fmt.Println("Expected the workspace to be left alone by a read:\n" + string(data))
+	}
+
+	// an empty workspace is materialized from the latest snapshot
+	os.RemoveAll(ws.Root())
+	visibleProject(t, projects, project.User, project.User, project.Name)
+	data, _ = ws.ReadFile("app.js")
+	if string(data) != expected {
+		t.Fail()
+		fmt.Println("Expected the workspace to be materialized from the snapshot:\n" + string(data))
+	}
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestComposeSystemPrompt(t *testing.T) {
+	settings := &types.GenerationSettings{Template: anthropic.TEMPLATE_STATIC_SITE, System: "Use a dark theme."}
+	system, err := anthropic.ComposeSystemPrompt(settings)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	if !strings.Contains(system, "index.html") || !strings.Contains(system, "static web site") ||
+		!strings.HasSuffix(system, "Use a dark theme.") {
+		t.Fail()
+		fmt.Println("Unexpected system prompt:\n" + system)
+	}
+	_, err = anthropic.ComposeSystemPrompt(&types.GenerationSettings{Template: "unknown"})
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected an unknown template to fail")
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	high := 1.5
+	valid := 0.0
+	invalid := []*types.GenerationSettings{
+		{Template: "unknown"},
+		{Temperature: &high},
+		{TopP: &high},
+		{MaxTokens: -1},
+		{StopSequences: []string{" "}},
+		{StopSequences: []string{"a", "b", "c", "d", "e"}},
+	}
+	for i, settings := range invalid {
+		if anthropic.ValidateSettings(settings) == nil {
+			t.Fail()
+			fmt.Println("Expected settings", i, "to be invalid")
+		}
+	}
+	err := anthropic.ValidateSettings(&types.GenerationSettings{Template: anthropic.TEMPLATE_LAYER8_SERVICE,
+		Temperature: &valid, StopSequences: []string{"</html>"}})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+}
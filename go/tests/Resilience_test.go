@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := anthropic.NewCircuitBreaker(2, 50*time.Millisecond)
+	overloaded := &anthropic.APIError{Kind: anthropic.ERROR_OVERLOADED}
+	breaker.Record(overloaded)
+	if breaker.Allow() != nil {
+		t.Fail()
+		fmt.Println("Expected the breaker to stay closed below the threshold")
+	}
+	breaker.Record(&anthropic.APIError{Kind: anthropic.ERROR_INVALID_REQUEST})
+	breaker.Record(overloaded)
+	if breaker.Allow() != nil {
+		t.Fail()
+		fmt.Println("Expected a client error to reset the failures")
+	}
+	breaker.Record(overloaded)
+	err, open := breaker.Allow().(*anthropic.APIError)
+	if !open || err.Kind != anthropic.ERROR_CIRCUIT_OPEN {
+		t.Fail()
+		fmt.Println("Expected the breaker to open")
+		return
+	}
+	time.Sleep(60 * time.Millisecond)
+	if breaker.Allow() != nil {
+		t.Fail()
+		fmt.Println("Expected a trial call after the cooldown")
+	}
+	if breaker.Allow() == nil {
+		t.Fail()
+		fmt.Println("Expected a single trial call")
+	}
+	breaker.Record(nil)
+	if breaker.Allow() != nil {
+		t.Fail()
+		fmt.Println("Expected a successful trial to close the breaker")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := anthropic.NewRateLimiter(600, 2)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Wait(ctx)
+	}
+	// the burst is free, the third request waits for a token at 10 per second
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fail()
+		fmt.Println("Expected the limiter to wait, waited ", elapsed)
+	}
+	limiter.Hold(time.Now().Add(time.Hour))
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if limiter.Wait(ctx) == nil {
+		t.Fail()
+		fmt.Println("Expected the held limiter to block until the context is done")
+	}
+}
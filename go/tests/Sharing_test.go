@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/auth"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestSharingDirectory(t *testing.T) {
+	directory := auth.NewDirectory(map[string][]string{"Acme": {"Alice@Acme.com", "bob@acme.com"}},
+		map[string][]string{"web": {"alice@acme.com"}})
+	principals := strings.Join(directory.Principals("ALICE@acme.com"), ",")
+	if principals != "alice@acme.com,org:acme,team:web" {
+		t.Fail()
+		fmt.Println("Unexpected principals of alice ", principals)
+	}
+	principals = strings.Join(directory.Principals("carol@corp.com"), ",")
+	if principals != "carol@corp.com" {
+		t.Fail()
+		fmt.Println("Unexpected principals of carol ", principals)
+	}
+
+	for _, principal := range []string{"Bob@Acme.com", "org:ACME", "team:web"} {
+		if _, err := directory.NormalizePrincipal(principal); err != nil {
+			t.Fail()
+			fmt.Println("Expected ", principal, " to be a principal ", err)
+		}
+	}
+	for _, principal := range []string{"", "org:other", "team:", "group:acme"} {
+		if _, err := directory.NormalizePrincipal(principal); err == nil {
+			t.Fail()
+			fmt.Println("Expected ", principal, " to be rejected")
+		}
+	}
+}
+
+func TestSharingMovesWorkspace(t *testing.T) {
+	defer workspaceTestDir(t)()
+	from, _ := anthropic.NewWorkspace(&types.Project{User: "alice@acme.com", Name: "site"})
+	to, _ := anthropic.NewWorkspace(&types.Project{User: "bob@acme.com", Name: "site"})
+	from.WriteFile("index.html", []byte("<html></html>"))
+	err := from.MoveTo(to)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	data, err := to.ReadFile("index.html")
+	if err != nil || string(data) != "<html></html>" {
+		t.Fail()
+		fmt.Println("Expected the file in the workspace of the new owner ", err)
+	}
+	if _, err = from.ReadFile("index.html"); err == nil {
+		t.Fail()
+		fmt.Println("Expected the file to be moved")
+	}
+
+	// the workspace of a project with the same name is never overwritten
+	other, _ := anthropic.NewWorkspace(&types.Project{User: "carol@corp.com", Name: "site"})
+	other.WriteFile("index.html", []byte("<html>carol</html>"))
+	if err = to.MoveTo(other); err == nil {
+		t.Fail()
+		fmt.Println("Expected an existing workspace to be rejected")
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/consts"
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/snapshot"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestSnapshotDiffAppliesAsPatch(t *testing.T) {
+	after := "// math helpers\nfunction add(a, b) {\n    return a + b;\n}\n\nfunction sub(a, b) {\n    return b - a;\n}\n"
+	diff := snapshot.UnifiedDiff("app.js", patchOriginal, after)
+	if diff == "" {
+		t.Fail()
+		fmt.Println("Expected a diff")
+		return
+	}
+	result, err := anthropic.ApplyPatch(patchOriginal, diff)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	if result != after {
+		t.Fail()
+		fmt.Println("Diff did not reproduce the snapshot:\n" + diff)
+	}
+	if snapshot.UnifiedDiff("app.js", after, after) != "" {
+		t.Fail()
+		fmt.Println("Expected no diff for equal content")
+	}
+}
+
+func TestSnapshotTakeListRestore(t *testing.T) {
+	defer workspaceTestDir(t)()
+	dir, _ := os.Getwd()
+	t.Setenv(consts.PROJECT_STORE_PATH_ENV, filepath.Join(dir, "data"))
+	project := &types.Project{User: "user@test.com", Name: "site"}
+	ws, _ := anthropic.NewWorkspace(project)
+	store, err := snapshot.NewSnapshotStore(project)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+
+	ws.WriteFile("index.html", []byte("<html>first</html>"))
+	ws.WriteFile("app.js", []byte("let a = 1;"))
+	first, err := store.Take(ws, 2, "Create a page")
+	if err != nil || first.Index != 0 || len(first.Files) != 2 {
+		t.Fail()
+		fmt.Println("Unexpected first snapshot ", first, err)
+		return
+	}
+	ws.WriteFile("index.html", []byte("<html>second</html>"))
+	ws.Remove("app.js")
+	ws.WriteFile("style.css", []byte("body {}"))
+	second, err := store.Take(ws, 4, "Change the page")
+	if err != nil || second.Index != 1 || second.MessageIndex != 4 {
+		t.Fail()
+		fmt.Println("Unexpected second snapshot ", second, err)
+		return
+	}
+
+	// the snapshots are kept in the configured data directory, the objects without temp files
+	list, err := store.List()
+	if err != nil || len(list) != 2 || list[0].Prompt != "Create a page" || list[1].Prompt != "Change the page" {
+		t.Fail()
+		fmt.Println("Unexpected snapshots ", list, err)
+	}
+	objects, _ := os.ReadDir(filepath.Join(dir, "data", "user@test.com", "site.snapshots", "objects"))
+	for _, object := range objects {
+		if strings.HasSuffix(object.Name(), ".tmp") {
+			t.Fail()
+			fmt.Println("Unexpected temp file ", object.Name())
+		}
+	}
+	if len(objects) != 4 {
+		t.Fail()
+		fmt.Println("Expected an object per distinct content ", len(objects))
+	}
+
+	err = store.Restore(ws, first)
+	paths, _ := ws.List()
+	data, _ := ws.ReadFile("index.html")
+	if err != nil || len(paths) != 2 || string(data) != "<html>first</html>" {
+		t.Fail()
+		fmt.Println("Expected the workspace of the first snapshot ", paths, string(data), err)
+	}
+
+	if _, err = snapshot.NewSnapshotStore(&types.Project{User: "..", Name: "site"}); err == nil {
+		t.Fail()
+		fmt.Println("Expected the snapshots outside the data directory to be rejected")
+	}
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	projects, _ := activateProjects(t)
+	messages := []*types.Message{{Role: "user", Content: "Create a page"}, {Role: "assistant", Content: "Created"},
+		{Role: "user", Content: "Change the page"}, {Role: "assistant", Content: "Changed"}}
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site", Messages: messages})
+	ws, _ := anthropic.NewWorkspace(project)
+	store, _ := snapshot.NewSnapshotStore(project)
+	ws.WriteFile("index.html", []byte("<html>first</html>"))
+	store.Take(ws, 2, "Create a page")
+	ws.WriteFile("index.html", []byte("<html>second</html>"))
+	store.Take(ws, 4, "Change the page")
+
+	// a snapshot that is not there leaves the project alone
+	if _, err := projects.Rollback(project.User, project.Name, 7); err == nil {
+		t.Fail()
+		fmt.Println("Expected the missing snapshot to fail the rollback")
+	}
+
+	copied, err := projects.Rollback(project.User, project.Name, 0)
+	if err != nil || copied.Index != 2 || copied.MessageIndex != 2 {
+		t.Fail()
+		fmt.Println("Unexpected rollback ", copied, err)
+		return
+	}
+	data, _ := ws.ReadFile("index.html")
+	if string(data) != "<html>first</html>" {
+		t.Fail()
+		fmt.Println("Expected the workspace of the snapshot ", string(data))
+	}
+	// the project returned by the post is not changed, the cached project is replaced
+	if len(project.Messages) != 4 {
+		t.Fail()
+		fmt.Println("Expected the rollback to leave the posted project alone ", len(project.Messages))
+	}
+	rolled := visibleProject(t, projects, project.User, project.User, project.Name)
+	if rolled == nil || len(rolled.Messages) != 2 || rolled.Revision != project.Revision+1 {
+		t.Fail()
+		fmt.Println("Expected the history to be truncated ", rolled)
+	}
+
+	// a project in the trash is not rolled back
+	projects.Trash(project.User, project.Name)
+	if _, err = projects.Rollback(project.User, project.Name, 1); err == nil {
+		t.Fail()
+		fmt.Println("Expected the rollback of a trashed project to fail")
+	}
+}
+
+func TestSnapshotRollbackUnsaved(t *testing.T) {
+	projects, _ := activateProjects(t)
+	messages := []*types.Message{{Role: "user", Content: "Create a page"}, {Role: "assistant", Content: "Created"}}
+	project := postProject(t, projects, &types.Project{User: "user@test.com", Name: "site", Messages: messages})
+	ws, _ := anthropic.NewWorkspace(project)
+	store, _ := snapshot.NewSnapshotStore(project)
+	ws.WriteFile("index.html", []byte("<html>first</html>"))
+	store.Take(ws, 2, "Create a page")
+	ws.WriteFile("index.html", []byte("<html>second</html>"))
+	ws.WriteFile("app.js", []byte("run();"))
+
+	// a rollback that is not saved leaves the workspace as it was
+	record := filepath.Join(os.Getenv(consts.PROJECT_STORE_PATH_ENV), project.User, project.Name+".dat")
+	os.Remove(record)
+	os.MkdirAll(filepath.Join(record, "blocked"), 0755)
+	if _, err := projects.Rollback(project.User, project.Name, 0); err == nil {
+		t.Fail()
+		fmt.Println("Expected the unsaved rollback to fail")
+	}
+	index, _ := ws.ReadFile("index.html")
+	script, err := ws.ReadFile("app.js")
+	if string(index) != "<html>second</html>" || err != nil || string(script) != "run();" {
+		t.Fail()
+		fmt.Println("Expected the workspace to be put back ", string(index), err)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/usage"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func TestEstimateCost(t *testing.T) {
+	cost := usage.EstimateCost("claude-sonnet-4-20250514", &types.Usage{InputTokens: 1000000, OutputTokens: 100000,
+		CacheReadInputTokens: 1000000})
+	if cost < 4.799 || cost > 4.801 {
+		t.Fail()
+		fmt.Println("Unexpected cost ", cost)
+	}
+}
+
+func TestUsageLedger(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "usage.dat")
+	budgets := &usage.Budgets{Default: usage.Budget{Daily: 1, Monthly: 10},
+		Users: map[string]usage.Budget{"free@test.com": {}}}
+	ledger, err := usage.NewLedger(fileName, budgets)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	now := time.Now().Unix()
+	ledger.Record("user@test.com", &types.TurnUsage{InputTokens: 100, OutputTokens: 10, Cost: 0.6, Created: now})
+	if ledger.Check("user@test.com") != nil {
+		t.Fail()
+		fmt.Println("Expected the user to be within the budget")
+	}
+	ledger.Record("user@test.com", &types.TurnUsage{InputTokens: 100, OutputTokens: 10, Cost: 0.6, Created: now})
+	ledger.Record("free@test.com", &types.TurnUsage{InputTokens: 100, OutputTokens: 10, Cost: 50, Created: now})
+	_, exceeded := ledger.Check("user@test.com").(*usage.BudgetExceededError)
+	if !exceeded {
+		t.Fail()
+		fmt.Println("Expected the daily budget to be exhausted")
+	}
+	if ledger.Check("free@test.com") != nil {
+		t.Fail()
+		fmt.Println("Expected a user without a budget to be unlimited")
+	}
+
+	reloaded, err := usage.NewLedger(fileName, budgets)
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	reports := reloaded.Reports()
+	monthly := 0
+	for _, report := range reports {
+		if report.User == "user@test.com" && report.Scope == usage.SCOPE_MONTHLY {
+			monthly++
+			if report.Turns != 2 || report.InputTokens != 200 || report.Budget != 10 {
+				t.Fail()
+				fmt.Println("Unexpected monthly report ", report)
+			}
+		}
+	}
+	if len(reports) != 4 || monthly != 1 {
+		t.Fail()
+		fmt.Println("Unexpected reports ", reports)
+	}
+}
+
+func TestUsageLedgerSharedFile(t *testing.T) {
+	// the web server and the project node keep a ledger of the same file
+	fileName := filepath.Join(t.TempDir(), "usage.dat")
+	budgets := &usage.Budgets{Default: usage.Budget{Daily: 5}}
+	first, _ := usage.NewLedger(fileName, budgets)
+	second, _ := usage.NewLedger(fileName, budgets)
+	now := time.Now().Unix()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(ledger *usage.Ledger) {
+			defer wg.Done()
+			ledger.Record("user@test.com", &types.TurnUsage{InputTokens: 10, Cost: 0.6, Created: now})
+		}([]*usage.Ledger{first, second}[i%2])
+	}
+	wg.Wait()
+
+	for _, ledger := range []*usage.Ledger{first, second} {
+		turns := int64(0)
+		for _, report := range ledger.Reports() {
+			if report.Scope == usage.SCOPE_DAILY {
+				turns += report.Turns
+			}
+		}
+		if turns != 10 {
+			t.Fail()
+			fmt.Println("Expected the turns of both ledgers ", turns)
+		}
+		// the budget counts the turns the other process recorded
+		if _, exceeded := ledger.Check("user@test.com").(*usage.BudgetExceededError); !exceeded {
+			t.Fail()
+			fmt.Println("Expected the budget to be exhausted by the turns of both ledgers")
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/secrets"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func newMasterKey() []byte {
+	key := make([]byte, secrets.KEY_SIZE)
+	rand.Read(key)
+	return key
+}
+
+func TestVaultStoreAndResolve(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "secrets.dat")
+	vault, err := secrets.NewVault(fileName, newMasterKey())
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	vault.SetOrgs(map[string][]string{"acme": {"bob@test.com"}})
+	value := "sk-ant-REDACTED"
+	stored, err := vault.Store(&types.ProviderSecret{Provider: "anthropic", Name: "site", Value: value}, "alice@test.com")
+	if err != nil || stored.Value != "" || stored.Ciphertext != nil || stored.Hint != "…cdef" || stored.Version != 1 {
+		t.Fail()
+		fmt.Println("Unexpected stored secret ", err, stored)
+		return
+	}
+	data, _ := os.ReadFile(fileName)
+	if bytes.Contains(data, []byte(value)) {
+		t.Fail()
+		fmt.Println("Expected the vault file to be encrypted")
+	}
+	again, _ := vault.Store(&types.ProviderSecret{Provider: "anthropic", Value: value}, "alice@test.com")
+	if again.Id != stored.Id || len(vault.List()) != 1 {
+		t.Fail()
+		fmt.Println("Expected the same key to be stored once")
+	}
+
+	resolved, err := vault.Resolve(stored.Id, "alice@test.com")
+	if err != nil || resolved != value {
+		t.Fail()
+		fmt.Println("Expected the owner to resolve the key ", err)
+	}
+	_, err = vault.Resolve(stored.Id, "bob@test.com")
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected another user not to resolve the key")
+	}
+
+	shared, err := vault.Store(&types.ProviderSecret{Org: "acme", Provider: "openai", Value: "sk-org-key-123456"}, "bob@test.com")
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	_, err = vault.Store(&types.ProviderSecret{Org: "acme", Provider: "openai", Value: "sk-other-123456"}, "alice@test.com")
+	if err == nil || vault.Authorize(shared.Id, "alice@test.com") == nil || vault.Authorize(shared.Id, "bob@test.com") != nil {
+		t.Fail()
+		fmt.Println("Expected the organization key to be limited to its members ", err)
+	}
+}
+
+func TestVaultRotateAndRevoke(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "secrets.dat")
+	master := newMasterKey()
+	vault, _ := secrets.NewVault(fileName, master)
+	stored, _ := vault.Store(&types.ProviderSecret{Provider: "anthropic", Value: "sk-first-value-1111"}, "alice@test.com")
+
+	rotated, err := vault.Rotate(stored.Id, "sk-second-value-2222", "alice@test.com")
+	resolved, _ := vault.Resolve(stored.Id, "alice@test.com")
+	if err != nil || rotated.Version != 2 || rotated.Rotated == 0 || resolved != "sk-second-value-2222" {
+		t.Fail()
+		fmt.Println("Unexpected rotation ", err, rotated, resolved)
+		return
+	}
+
+	// a new master key unseals the previous secrets only with the previous key
+	next := newMasterKey()
+	reopened, _ := secrets.NewVault(fileName, next)
+	_, err = reopened.Resolve(stored.Id, "alice@test.com")
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected the secret to need the previous master key")
+	}
+	reopened, err = secrets.NewVault(fileName, next, master)
+	resolved, _ = reopened.Resolve(stored.Id, "alice@test.com")
+	if err != nil || resolved != "sk-second-value-2222" || reopened.List()[0].MasterKeyId != secrets.KeyId(next) {
+		t.Fail()
+		fmt.Println("Expected the secret to be sealed with the new master key ", err)
+		return
+	}
+	reopened, _ = secrets.NewVault(fileName, next)
+	resolved, err = reopened.Resolve(stored.Id, "alice@test.com")
+	if err != nil || resolved != "sk-second-value-2222" {
+		t.Fail()
+		fmt.Println("Expected the new master key alone to unseal the secret ", err)
+	}
+
+	revoked, err := reopened.Revoke(stored.Id, "alice@test.com")
+	if err != nil || revoked.Revoked == 0 {
+		t.Fail()
+		fmt.Println("Unexpected revocation ", err)
+	}
+	_, err = reopened.Resolve(stored.Id, "alice@test.com")
+	if err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fail()
+		fmt.Println("Expected a revoked secret not to resolve ", err)
+	}
+	_, err = reopened.Rotate(stored.Id, "sk-third-value-3333", "alice@test.com")
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected a revoked secret not to rotate")
+	}
+}
+
+func TestVaultSharedFile(t *testing.T) {
+	// the web server and the project node open the same vault file
+	fileName := filepath.Join(t.TempDir(), "secrets.dat")
+	master := newMasterKey()
+	web, _ := secrets.NewVault(fileName, master)
+	node, _ := secrets.NewVault(fileName, master)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			web.Store(&types.ProviderSecret{Provider: "anthropic", Value: fmt.Sprint("sk-web-value-", i)}, "alice@test.com")
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			node.Store(&types.ProviderSecret{Provider: "anthropic", Value: fmt.Sprint("sk-node-value-", i)}, "alice@test.com")
+		}(i)
+	}
+	wg.Wait()
+	if len(web.List()) != 10 || len(node.List()) != 10 {
+		t.Fail()
+		fmt.Println("Expected the secrets of both processes to be kept ", len(web.List()), len(node.List()))
+		return
+	}
+
+	// a key revoked in one process does not resolve in the other
+	id := web.List()[0].Id
+	_, err := node.Revoke(id, "alice@test.com")
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+	_, err = web.Resolve(id, "alice@test.com")
+	if err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fail()
+		fmt.Println("Expected the revocation of the other process to apply ", err)
+	}
+}
+
+func TestMaskKeys(t *testing.T) {
+	masked := secrets.Mask(`Incorrect API key provided: sk-proj-abcdefghijkl1234, "x-api-key": "sk9secret" Bearer abcdefghijklmnop`)
+	if strings.Contains(masked, "abcdefghijkl1234") || strings.Contains(masked, "sk9secret") ||
+		strings.Contains(masked, "abcdefghijklmnop") || !strings.Contains(masked, "Incorrect API key provided") {
+		t.Fail()
+		fmt.Println("Unexpected masked text ", masked)
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/saichler/vibe.with.layer8/go/l8vibe/anthropic"
+	"github.com/saichler/vibe.with.layer8/go/types"
+)
+
+func workspaceTestDir(t *testing.T) func() {
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	return func() { os.Chdir(cwd) }
+}
+
+func TestWorkspaceRejectsEscapes(t *testing.T) {
+	defer workspaceTestDir(t)()
+	ws, err := anthropic.NewWorkspace(&types.Project{User: "user@test.com", Name: "site"})
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+		return
+	}
+	for _, path := range []string{"../../../etc/x.sh", "/etc/passwd", "a/../../b", ".git/config", "CON.txt", "a\\..\\..\\b", ""} {
+		err = ws.WriteFile(path, []byte("x"))
+		if _, ok := err.(*anthropic.WorkspaceError); !ok {
+			t.Fail()
+			fmt.Println("Expected ", path, " to be rejected, got ", err)
+		}
+	}
+	err = ws.WriteFile("js/app.js", []byte("console.log(1)"))
+	if err != nil {
+		t.Fail()
+		fmt.Println(err)
+	}
+}
+
+func TestWorkspaceRejectsInvalidProject(t *testing.T) {
+	defer workspaceTestDir(t)()
+	for _, project := range []*types.Project{{User: "u", Name: ".."}, {User: "../u", Name: "p"}, {User: "u", Name: ""}} {
+		_, err := anthropic.NewWorkspace(project)
+		if err == nil {
+			t.Fail()
+			fmt.Println("Expected project ", project.User, "/", project.Name, " to be rejected")
+		}
+	}
+}
+
+func TestWorkspaceRejectsSymlinksAndLargeFiles(t *testing.T) {
+	defer workspaceTestDir(t)()
+	ws, _ := anthropic.NewWorkspace(&types.Project{User: "u", Name: "p"})
+	ws.WriteFile("index.html", []byte("<html></html>"))
+	os.Symlink("/etc", ws.Root()+"/etc")
+	_, err := ws.ReadFile("etc/passwd")
+	if err == nil || !strings.Contains(err.Error(), "symbolic") {
+		t.Fail()
+		fmt.Println("Expected symlink to be rejected, got ", err)
+	}
+	err = ws.WriteFile("big.js", make([]byte, 3*1024*1024))
+	if err == nil {
+		t.Fail()
+		fmt.Println("Expected large file to be rejected")
+	}
+}
+
+func TestParseMessageSurfacesRejectedFiles(t *testing.T) {
+	defer workspaceTestDir(t)()
+	project := &types.Project{User: "u", Name: "p"}
+	text := "## Script (../../evil.sh)\n```sh\necho owned and more\n```\n"
+	lines, err := anthropic.ParseMessage(text, project)
+	if _, ok := err.(anthropic.WorkspaceErrors); !ok {
+		t.Fail()
+		fmt.Println("Expected workspace errors, got ", err, lines)
+		return
+	}
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "Rejected file") {
+		t.Fail()
+		fmt.Println(lines)
+	}
+}
+
+func TestParseMessageRefusesUnclosedFence(t *testing.T) {
+	defer workspaceTestDir(t)()
+	project := &types.Project{User: "user@test.com", Name: "site"}
+	text := "## style.css\n```css\nbody { color: red; }\n```\n\n## index.html\n```html\n<html>\n<body>\n"
+	_, err := anthropic.ParseMessage(text, project)
+	rejected, ok := err.(anthropic.WorkspaceErrors)
+	if !ok || len(rejected) != 1 || rejected[0].Path != "## index.html" {
+		t.Fail()
+		fmt.Println("Expected the unclosed file to be rejected ", err)
+	}
+	ws, _ := anthropic.NewWorkspace(project)
+	if _, er := ws.ReadFile("style.css"); er != nil {
+		t.Fail()
+		fmt.Println("Expected the closed file to be written ", er)
+	}
+	if _, er := ws.ReadFile("index.html"); er == nil {
+		t.Fail()
+		fmt.Println("Expected the unclosed file not to be written")
+	}
+}